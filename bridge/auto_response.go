@@ -0,0 +1,105 @@
+package bridge
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	irc "github.com/qaisjp/go-ircevent"
+)
+
+// autoResponseCooldown is how long after being sent the auto-response someone gets the short reply instead
+var autoResponseCooldown = time.Hour
+
+// The auto-responses used if the config doesn't have its own.
+// {irc_server}, {listener} and {prefix} are replaced with the IRC server, the listener's nick and the command prefix.
+const (
+	defaultAutoResponseDiscord = "I'm the bridge between this Discord server and IRC ({irc_server}). " +
+		"Messages in bridged channels are relayed both ways.\n" +
+		"• To message someone on IRC privately, send me `nick, your message`.\n" +
+		"• To link your IRC nick to your Discord account, send me `!link`.\n" +
+		"• To stop your messages being relayed to IRC, use `/bridge optout` in the server."
+
+	defaultAutoResponseIRC = "I'm the bridge between this network and Discord. Messages in bridged channels are relayed both ways.\n" +
+		"To link your nick to your Discord account, send \"!link\" to the bridge bot on Discord, then send me the code it gives you.\n" +
+		"To stop your messages being relayed to Discord, send me \"optout\". Send me \"help\" for my other commands."
+)
+
+// The replies sent instead of the auto-response while it is cooling down
+const (
+	shortAutoResponseDiscord = "Don't know who that is. Can't PM. Try 'name, message here'"
+	shortAutoResponseIRC     = "Private messaging Discord users is not supported, but I support commands! Type 'help'."
+)
+
+// autoResponses remembers when people were last sent the auto-response.
+type autoResponses struct {
+	mu   sync.Mutex
+	sent map[string]time.Time // keyed by karma key
+}
+
+// due returns true, and remembers that it is being sent, if the auto-response can be sent to someone.
+func (r *autoResponses) due(key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for k, sent := range r.sent {
+		if now.Sub(sent) >= autoResponseCooldown {
+			delete(r.sent, k)
+		}
+	}
+	if _, ok := r.sent[key]; ok {
+		return false
+	}
+	if r.sent == nil {
+		r.sent = make(map[string]time.Time)
+	}
+	r.sent[key] = now
+	return true
+}
+
+// autoResponse fills in the placeholders of an auto-response, or the default one if it isn't set.
+func (b *Bridge) autoResponse(template, fallback string) string {
+	if template == "" {
+		template = fallback
+	}
+
+	server := b.Config.IRCServer
+	if host, _, err := net.SplitHostPort(server); err == nil {
+		server = host
+	}
+
+	return strings.NewReplacer(
+		"{irc_server}", server,
+		"{listener}", b.Config.IRCListenerName,
+		"{prefix}", b.Config.CommandPrefix,
+	).Replace(template)
+}
+
+// autoRespondDiscord replies to a DM to the bot that couldn't be relayed.
+func (d *discordBot) autoRespondDiscord(m *discordgo.Message) {
+	reply := shortAutoResponseDiscord
+	if d.bridge.autoReplies.due(karmaKeyDiscord(m.Author.ID)) {
+		reply = d.bridge.autoResponse(d.bridge.Config.AutoResponseDiscord, defaultAutoResponseDiscord)
+	}
+
+	if _, err := d.ChannelMessageSend(m.ChannelID, reply); err != nil {
+		d.bridge.handleError(err, nil, "could not send auto-response")
+	}
+}
+
+// autoRespondIRC replies to a private message to the listener that isn't a command.
+func (i *ircListener) autoRespondIRC(e *irc.Event) {
+	if !i.bridge.autoReplies.due(i.bridge.karmaKeyNick(e.Nick)) {
+		i.Privmsg(e.Nick, shortAutoResponseIRC)
+		return
+	}
+
+	for _, line := range strings.Split(i.bridge.autoResponse(i.bridge.Config.AutoResponseIRC, defaultAutoResponseIRC), "\n") {
+		if strings.TrimSpace(line) != "" {
+			i.Privmsg(e.Nick, line)
+		}
+	}
+}
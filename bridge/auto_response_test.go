@@ -0,0 +1,32 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAutoResponseIRC(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.AutoResponseIRC = "Hi! I bridge {irc_server} and Discord.\nSend \"optout\" to {listener} to opt out."
+	})
+	defer tb.Close()
+
+	tb.ircd.SendTo("listener", ":alice!a@host PRIVMSG listener :hello?")
+	waitFor(t, "auto-response", func() bool {
+		return tb.ircd.HasReceived("listener", `PRIVMSG alice :Send "optout" to listener to opt out.`)
+	})
+	assert.Contains(t, tb.ircd.Received("listener"), "PRIVMSG alice :Hi! I bridge 127.0.0.1 and Discord.")
+
+	// It isn't repeated
+	tb.ircd.SendTo("listener", ":alice!a@host PRIVMSG listener :hello again")
+	waitFor(t, "short reply", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG alice :"+shortAutoResponseIRC)
+	})
+}
+
+func TestAutoResponseDefault(t *testing.T) {
+	b := &Bridge{Config: &Config{IRCServer: "irc.example.com:6697"}}
+	assert.Contains(t, b.autoResponse("", defaultAutoResponseDiscord), "IRC (irc.example.com)")
+	assert.Equal(t, "custom", b.autoResponse("custom", defaultAutoResponseDiscord))
+}
@@ -0,0 +1,124 @@
+package bridge
+
+import (
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// identiconSize is the width and height of the avatars served at /avatars/, in pixels.
+// It should be a multiple of 12, so the pattern is centred.
+var identiconSize = 120
+
+func init() {
+	http.HandleFunc("/avatars/", serveIdenticon)
+}
+
+// nickHash is a hash of an IRC nick, used to pick its avatar.
+func nickHash(nick string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(strings.ToLower(nick)))
+	return h.Sum32()
+}
+
+// nickRGB returns a colour for an IRC nick, which is always the same for the same nick.
+func nickRGB(nick string) color.RGBA {
+	return hsvToRGB(float64(nickHash(nick)%360), 0.55, 0.8)
+}
+
+// fallbackAvatar returns the avatar URL for an IRC user that doesn't have a Discord avatar,
+// or "" to use the webhook's avatar.
+func (b *Bridge) fallbackAvatar(nick string) string {
+	if b.Config.AvatarURL == "" {
+		return ""
+	}
+
+	c := nickRGB(nick)
+	return strings.NewReplacer(
+		"{nick}", url.PathEscape(nick),
+		"{color}", fmt.Sprintf("%02x%02x%02x", c.R, c.G, c.B),
+	).Replace(b.Config.AvatarURL)
+}
+
+// identicon draws a symmetric 5x5 pattern in the nick's colour, like GitHub's default avatars.
+func identicon(nick string) image.Image {
+	hash := nickHash(nick)
+	fg := nickRGB(nick)
+	bg := color.RGBA{0xF0, 0xF0, 0xF0, 0xFF}
+
+	const cells = 5
+	cell := identiconSize / (cells + 1)
+	margin := (identiconSize - cell*cells) / 2
+
+	img := image.NewRGBA(image.Rect(0, 0, identiconSize, identiconSize))
+	for y := 0; y < identiconSize; y++ {
+		for x := 0; x < identiconSize; x++ {
+			img.Set(x, y, bg)
+		}
+	}
+
+	for row := 0; row < cells; row++ {
+		for col := 0; col < (cells+1)/2; col++ {
+			if hash&(1<<uint(row*3+col)) == 0 {
+				continue
+			}
+			for _, c := range []int{col, cells - 1 - col} {
+				for y := 0; y < cell; y++ {
+					for x := 0; x < cell; x++ {
+						img.Set(margin+c*cell+x, margin+row*cell+y, fg)
+					}
+				}
+			}
+		}
+	}
+	return img
+}
+
+// serveIdenticon serves /avatars/<nick>.png
+func serveIdenticon(w http.ResponseWriter, r *http.Request) {
+	nick := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/avatars/"), ".png")
+	if nick == "" || strings.Contains(nick, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	if err := png.Encode(w, identicon(nick)); err != nil {
+		log.WithField("error", err).Warnln("could not serve avatar")
+	}
+}
+
+// hsvToRGB converts a hue (0-360), saturation and value (0-1) to a colour.
+func hsvToRGB(hue, saturation, value float64) color.RGBA {
+	c := value * saturation
+	h := hue / 60
+	x := c * (1 - math.Abs(math.Mod(h, 2)-1))
+
+	var r, g, b float64
+	switch int(h) {
+	case 0:
+		r, g = c, x
+	case 1:
+		r, g = x, c
+	case 2:
+		g, b = c, x
+	case 3:
+		g, b = x, c
+	case 4:
+		r, b = x, c
+	default:
+		r, b = c, x
+	}
+
+	m := value - c
+	return color.RGBA{uint8((r + m) * 255), uint8((g + m) * 255), uint8((b + m) * 255), 0xFF}
+}
@@ -0,0 +1,45 @@
+package bridge
+
+import (
+	"image/png"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFallbackAvatar(t *testing.T) {
+	b := &Bridge{Config: &Config{}}
+	assert.Equal(t, "", b.fallbackAvatar("alice"))
+
+	b.Config.AvatarURL = "https://example.com/{nick}.png?bg={color}"
+	url := b.fallbackAvatar("al[ice]")
+	assert.Regexp(t, `^https://example.com/al%5Bice%5D.png\?bg=[0-9a-f]{6}$`, url)
+	assert.Equal(t, url, b.fallbackAvatar("al[ice]"))
+	assert.NotEqual(t, nickRGB("alice"), nickRGB("bob"))
+}
+
+func TestServeIdenticon(t *testing.T) {
+	w := httptest.NewRecorder()
+	serveIdenticon(w, httptest.NewRequest("GET", "/avatars/alice.png", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+
+	img, err := png.Decode(w.Body)
+	if assert.NoError(t, err) {
+		assert.Equal(t, identiconSize, img.Bounds().Dx())
+
+		// The pattern is mirrored
+		for y := 0; y < identiconSize; y++ {
+			for x := 0; x < identiconSize/2; x++ {
+				if img.At(x, y) != img.At(identiconSize-1-x, y) {
+					t.Fatalf("identicon is not symmetric at %d, %d", x, y)
+				}
+			}
+		}
+	}
+
+	w = httptest.NewRecorder()
+	serveIdenticon(w, httptest.NewRequest("GET", "/avatars/", nil))
+	assert.Equal(t, 404, w.Code)
+}
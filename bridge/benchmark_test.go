@@ -0,0 +1,112 @@
+package bridge
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Formatting is on the hot path for every relayed message. Over either corpus, formatting
+// a message should take less than 10µs on average, with 50 puppets, whether messages are
+// formatted one at a time or in parallel:
+//
+//	go test ./bridge -run '^$' -bench Format
+
+// discordCorpus is a mix of what people send on Discord, from chatter to code.
+var discordCorpus = []*discordgo.Message{
+	{Content: "lol"},
+	{Content: "has anyone got the slides from yesterday's lecture? I missed the second half"},
+	{Content: "hey <@100>, can you look at this when you get a chance? <@!200> said you'd know"},
+	{Content: "<@&4000> the printer in the lab is out of toner again", MentionRoles: []string{"4000"}},
+	{Content: "moved to <#2000>, see you there <:pog:123456789> <a:spin:987654321>"},
+	{Content: "_waves_"},
+	{Content: "here's the error:\n```\npanic: runtime error: index out of range [3] with length 3\n\ngoroutine 1 [running]:\nmain.main()\n\t/tmp/x.go:8 +0x1d\n```\nany ideas?"},
+	{Content: "**important**: the meeting is *moved* to __Thursday__, ~~not Wednesday~~ ||sorry||"},
+	{Content: "https://github.com/qaisjp/go-discord-irc/pull/123 and https://example.com/a/long/path?with=query&and=more"},
+	{Content: "line one\r\nline two\rline three"},
+}
+
+// ircCorpus is a mix of what people send on IRC, with the formatting codes clients add.
+var ircCorpus = []struct {
+	code, text string
+}{
+	{"PRIVMSG", "lol"},
+	{"PRIVMSG", "has anyone got the slides from yesterday's lecture? I missed the second half"},
+	{"PRIVMSG", "puppet7_d: can you look at this when you get a chance? puppet23_d said you'd know"},
+	{"PRIVMSG", "\x02important\x02: the meeting is \x1Dmoved\x1D to \x1FThursday\x1F"},
+	{"PRIVMSG", "\x0304,01red on black\x03 and \x0312blue\x03 and \x0301,01a spoiler\x0F done"},
+	{"CTCP_ACTION", "waves at everyone"},
+	{"NOTICE", "Server maintenance in 10 minutes"},
+	{"PRIVMSG", "nice :pog: :unknown: at 12:30:45"},
+	{"PRIVMSG", "https://github.com/qaisjp/go-discord-irc/pull/123 and https://example.com/a/long/path?with=query&and=more"},
+	{"PRIVMSG", "use `:pog:` to get :pog: in `code`"},
+}
+
+// newBenchmarkBridge returns listener and bot with 50 puppets and a few custom emoji,
+// like a small community's bridge.
+func newBenchmarkBridge(b *testing.B) (*ircListener, *discordBot) {
+	d := newFuzzDiscord(b)
+	d.bridge.ircListener.bridge = d.bridge
+	d.State.ChannelAdd(&discordgo.Channel{ID: "2000", GuildID: testGuildID, Name: "lab"})
+	d.State.EmojisAdd(testGuildID, []*discordgo.Emoji{
+		{ID: "123456789", Name: "pog", Available: true},
+		{ID: "987654321", Name: "spin", Available: true, Animated: true},
+	})
+
+	for n := 0; n < 50; n++ {
+		id := fmt.Sprint(1000 + n)
+		d.bridge.ircManager.ircConnections[id] = &ircConnection{
+			discord: DiscordUser{ID: id, Username: fmt.Sprint("puppet", n)},
+			nick:    fmt.Sprint("puppet", n, "_d"),
+		}
+	}
+	for _, m := range discordCorpus {
+		m.GuildID = testGuildID
+		m.ChannelID = testChannelID
+		m.Mentions = []*discordgo.User{{ID: "100", Username: "bob"}, {ID: "200", Username: "carol"}}
+	}
+	return d.bridge.ircListener, d
+}
+
+// BenchmarkFormatToIRC formats Discord messages for IRC, as publishMessage does.
+func BenchmarkFormatToIRC(b *testing.B) {
+	_, d := newBenchmarkBridge(b)
+	format := func(n int) {
+		m := discordCorpus[n%len(discordCorpus)]
+		text, _ := parseAction(d.ParseText(m))
+		d.bridge.formatToIRC(testChannel, text)
+	}
+	benchmarkFormat(b, format)
+}
+
+// BenchmarkFormatToDiscord formats IRC messages for Discord, as OnPrivateMessage does.
+func BenchmarkFormatToDiscord(b *testing.B) {
+	i, _ := newBenchmarkBridge(b)
+	format := func(n int) {
+		msg := ircCorpus[n%len(ircCorpus)]
+		i.formatMessage(testChannel, msg.code, msg.text)
+	}
+	benchmarkFormat(b, format)
+}
+
+// benchmarkFormat runs format over a corpus one message at a time, as the relay does,
+// and in parallel, as the listener and bot handle events concurrently.
+func benchmarkFormat(b *testing.B, format func(n int)) {
+	b.Run("serial", func(b *testing.B) {
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			format(n)
+		}
+	})
+	b.Run("parallel", func(b *testing.B) {
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			n := 0
+			for pb.Next() {
+				format(n)
+				n++
+			}
+		})
+	})
+}
@@ -0,0 +1,105 @@
+package bridge
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	irc "github.com/qaisjp/go-ircevent"
+	log "github.com/sirupsen/logrus"
+)
+
+// botStatusInterval is how often the bot's status is checked for changes
+var botStatusInterval = 15 * time.Second
+
+// ircStaleAfter is how long the listener can go without hearing from the server before IRC counts as down.
+// go-ircevent pings the server after four minutes without anything from it.
+var ircStaleAfter = 6 * time.Minute
+
+// ircHealth tracks whether the listener is connected to IRC.
+type ircHealth struct {
+	mu         sync.Mutex
+	registered bool      // has the server welcomed the listener, since it last said goodbye?
+	lastLine   time.Time // when the server last sent something
+}
+
+// setupHealth tracks the listener's connection, and updates the bot's status when it changes.
+func (i *ircListener) setupHealth() {
+	i.AddCallback("*", func(e *irc.Event) {
+		i.health.mu.Lock()
+		i.health.lastLine = time.Now()
+		i.health.mu.Unlock()
+	})
+
+	track := func(registered bool) func(e *irc.Event) {
+		return func(e *irc.Event) {
+			i.health.mu.Lock()
+			i.health.registered = registered
+			i.health.mu.Unlock()
+			go i.bridge.updateBotStatus(false)
+		}
+	}
+	i.AddCallback("001", track(true))
+	i.AddCallback("ERROR", track(false))
+}
+
+// Healthy returns true if the listener is connected and registered to the IRC server.
+func (i *ircListener) Healthy() bool {
+	i.health.mu.Lock()
+	defer i.health.mu.Unlock()
+	return i.health.registered && i.Connected() && time.Since(i.health.lastLine) < ircStaleAfter
+}
+
+// botStatus remembers the status last given to the bot, so that it is only changed when it needs to be.
+type botStatus struct {
+	mu   sync.Mutex
+	last string
+}
+
+// botStatusText returns the bot's status, like "Bridging 12 channels | IRC OK",
+// and whether it is online, or do not disturb while IRC is down.
+func (b *Bridge) botStatusText() (string, discordgo.Status) {
+	if !b.ircListener.Healthy() {
+		return "IRC DISCONNECTED", discordgo.StatusDoNotDisturb
+	}
+
+	n := len(b.channelMappings())
+	channels := "channels"
+	if n == 1 {
+		channels = "channel"
+	}
+	return fmt.Sprintf("Bridging %d %s | IRC OK", n, channels), discordgo.StatusOnline
+}
+
+// updateBotStatus sets the bot's Discord status to the health of the bridge, if it has changed or force is set.
+func (b *Bridge) updateBotStatus(force bool) {
+	if !b.Config.BotStatus || b.Config.Shadow || !b.isLeader() {
+		return
+	}
+
+	text, status := b.botStatusText()
+	b.status.mu.Lock()
+	defer b.status.mu.Unlock()
+	if text == b.status.last && !force {
+		return
+	}
+
+	err := b.discord.UpdateStatusComplex(discordgo.UpdateStatusData{
+		Status: string(status),
+		Activities: []*discordgo.Activity{{
+			Name:  "Custom Status",
+			Type:  discordgo.ActivityTypeCustom,
+			State: text,
+		}},
+	})
+	if err != nil {
+		// Try again next time
+		log.WithField("error", err).Debugln("could not update bot status")
+		b.status.last = ""
+		return
+	}
+
+	log.WithField("status", text).Debugln("Updated bot status.")
+	b.status.last = text
+}
@@ -0,0 +1,26 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBotStatusText(t *testing.T) {
+	tb := newTestBridge(t, nil)
+	defer tb.Close()
+
+	waitFor(t, "irc ok", func() bool {
+		text, _ := tb.Bridge.botStatusText()
+		return text == "Bridging 1 channel | IRC OK"
+	})
+	_, status := tb.Bridge.botStatusText()
+	assert.Equal(t, discordgo.StatusOnline, status)
+
+	tb.ircd.SendTo("listener", "ERROR :Closing Link: listener (Ping timeout)")
+	waitFor(t, "irc disconnected", func() bool {
+		text, status := tb.Bridge.botStatusText()
+		return text == "IRC DISCONNECTED" && status == discordgo.StatusDoNotDisturb
+	})
+}
@@ -4,8 +4,12 @@ import (
 	"crypto/tls"
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/bwmarrin/discordgo"
 	"github.com/pkg/errors"
+	"github.com/qaisjp/go-discord-irc/store"
 	irc "github.com/qaisjp/go-ircevent"
 	log "github.com/sirupsen/logrus"
 )
@@ -14,9 +18,18 @@ import (
 type Config struct {
 	DiscordBotToken, GuildID string
 
+	// SecondaryBotToken, if set, is the token of another bot in the guild, which the bridge
+	// switches to when it hasn't been able to connect to Discord for DiscordFailoverAfter.
+	// It switches back the same way.
+	SecondaryBotToken    string
+	DiscordFailoverAfter time.Duration
+
 	// Map from Discord to IRC
 	ChannelMappings map[string]string
 
+	// ChannelOptions are extra settings for mappings, keyed by IRC channel (without the key)
+	ChannelOptions map[string]ChannelOptions
+
 	IRCServer        string
 	IRCServerPass    string
 	IRCListenerName  string // i.e, "DiscordBot", required to listen for messages in all cases
@@ -34,16 +47,310 @@ type Config struct {
 	// This should be used only for testing.
 	InsecureSkipVerify bool
 
+	// PuppetMetadata is how puppets are described in WHOIS: "metadata" for
+	// IRCv3 METADATA, "swhois" for SWHOIS (the listener must be an oper),
+	// or empty to not describe them.
+	PuppetMetadata string
+
+	// Services is the services package ("atheme" or "anope") used to group puppet
+	// nicks under the ServicesAccount. Puppets aren't registered if this is empty.
+	Services         string
+	ServicesAccount  string
+	ServicesPassword string
+
+	// ServicesVhost is the vhost requested from HostServ for each puppet.
+	// "{id}" and "{username}" are replaced with the Discord user's ID and username.
+	ServicesVhost string
+
+	// OperName lets the listener become an IRC operator, using OperPassword,
+	// or CHALLENGE with the RSA private key in OperKeyFile.
+	OperName     string
+	OperPassword string
+	OperKeyFile  string
+
+	// OperSnomask is the server notice mask the listener sets once it is an oper,
+	// and OperDiscordChannel is the Discord channel server notices are relayed to.
+	OperSnomask        string
+	OperDiscordChannel string
+
+	// OperPuppetHost is the host (cloak) the listener gives each puppet when it is an oper.
+	// "{id}" and "{username}" are replaced with the Discord user's ID and username.
+	OperPuppetHost string
+
 	// SimpleMode, when enabled, will ensure that IRCManager not spawn
 	// an IRC connection for each of the online Discord users.
 	SimpleMode bool
 
+	// Shadow mode connects and processes everything as usual, but logs what would be relayed
+	// instead of relaying it, and sends nothing to Discord or IRC. It uses simple mode.
+	Shadow bool
+
+	// RecordEvents, if set, is a file that the Discord and IRC messages the bridge receives are
+	// appended to, so that they can be replayed with Replay.
+	RecordEvents string
+
+	// AutoResponseDiscord and AutoResponseIRC are sent to people who message the bot on Discord,
+	// or the listener on IRC, with something it can't do anything with. They are sent at most
+	// once an hour to each person, and the terse reply otherwise. {irc_server}, {listener} and
+	// {prefix} are replaced. If unset, a built-in explanation of the bridge is used.
+	AutoResponseDiscord string
+	AutoResponseIRC     string
+
+	// BotStatus sets the bot's Discord status to the health of the bridge,
+	// like "Bridging 12 channels | IRC OK", or "IRC DISCONNECTED" while IRC is down.
+	BotStatus bool
+
+	// OperatorPrefix, if set, like "!!", lets operators send commands for emergency repairs in a
+	// DM to the bot or a private message to the listener: "!!raw <line>" sends a raw line to IRC,
+	// and "!!discord <method> <path> [json]" calls the Discord API. Operators are the Discord users
+	// in OperatorDiscordIDs, and the IRC users logged in to OperatorIRCAccounts. Every command is
+	// logged, and posted to the audit and report channels.
+	OperatorPrefix      string
+	OperatorDiscordIDs  []string
+	OperatorIRCAccounts []string
+
+	// MaxRelayAge, if set, is how old a message can be and still be relayed, for messages
+	// that arrive late after reconnects, from the outbox, or played back by a bouncer.
+	// LateMessages is what happens to older ones: "summarize" (the default) posts how many
+	// there were, like "12 messages during downtime, see Discord", and "drop" drops them.
+	MaxRelayAge  time.Duration
+	LateMessages string
+
 	// WebhookPrefix is prefixed to each webhook created by the Discord bot.
 	WebhookPrefix string
 
 	// WebhookLimit is the max number of webhooks to create
 	WebhookLimit int
 
+	// ProvenanceFooter adds an embed footer with the sender's hostmask
+	// and channel to messages relayed from IRC, for moderation.
+	ProvenanceFooter bool
+
+	// IgnoredDiscordIDs are Discord user and webhook IDs of other relay bots.
+	// Messages from these are not relayed to IRC, to prevent relay loops.
+	IgnoredDiscordIDs []string
+
+	// IgnoredIRCNicks are the nicks of other relay bots on IRC.
+	// Messages from these are not relayed to Discord, to prevent relay loops.
+	IgnoredIRCNicks []string
+
+	// StorePath is the file used to persist bridge state, such as identity links.
+	// If empty, state is only kept in memory.
+	StorePath string
+
+	// SystemMessages enables or disables relaying of Discord system messages
+	// by kind ("pin", "join", "boost", "follow", "thread").
+	// Kinds that are missing from the map are relayed.
+	SystemMessages map[string]bool
+
+	// DedupWindow is how long content relayed in one direction is remembered,
+	// so that bots echoing it back aren't relayed in the other direction.
+	// Deduplication is disabled if this is zero.
+	DedupWindow time.Duration
+
+	// EditWindow is how long after a Discord message was sent that edits of it
+	// are relayed to IRC. Edits are always relayed if this is zero.
+	EditWindow time.Duration
+
+	// WatchdogTimeout is how long the bridge loop can be stuck on one message
+	// before it is restarted. The watchdog is disabled if this is zero.
+	WatchdogTimeout time.Duration
+
+	// PanicReporter, if set, is called with the value and stack trace
+	// of panics recovered in event handlers, e.g. to report them to Sentry.
+	PanicReporter func(recovered interface{}, stack []byte)
+
+	// DigestInterval is how often an activity digest is posted, e.g. daily or weekly.
+	// Digests are disabled if this is zero.
+	DigestInterval time.Duration
+
+	// DigestDiscordChannel and DigestIRCChannel are where digests are posted.
+	DigestDiscordChannel string
+	DigestIRCChannel     string
+
+	// StatusIRCChannel, if set, is the IRC channel the bridge announces problems in, and when they are over:
+	// the Discord gateway disconnecting, relaying to Discord failing, and queues overflowing.
+	StatusIRCChannel string
+
+	// StageIRCChannel, if set, is the bridged IRC channel Discord stages going live are announced in,
+	// with their topic changes. Stages in bridged stage channels are announced in their own IRC channel.
+	StageIRCChannel string
+
+	// AuditIRCChannel is the IRC channel Discord moderation activity
+	// (bans, kicks, timeouts, role and channel changes) is relayed to.
+	AuditIRCChannel string
+
+	// JoinAnnounceChannel, if set, is the IRC channel new Discord members are announced in,
+	// using JoinAnnounceTemplate. It can use {name} and {count}, the number of members.
+	// Joins in quick succession are announced together.
+	JoinAnnounceChannel  string
+	JoinAnnounceTemplate string
+
+	// RenameNotices tells bridged IRC channels when a Discord member changes their name,
+	// like "* bob is now known as bobby".
+	RenameNotices bool
+
+	// BotMarker, like "[bot]", is added to the names of Discord bots on IRC:
+	// after their name in messages relayed by the listener, and before the suffix of their puppet's nick.
+	BotMarker string
+
+	// RaidThreshold, if set, is how many new Discord accounts joining or talking within
+	// RaidWindow counts as a raid. During a raid, relaying to IRC is stricter, and
+	// moderators are told in the audit and report channels. It ends after RaidCooldown
+	// without any activity from new accounts.
+	RaidThreshold int
+	RaidWindow    time.Duration
+	RaidCooldown  time.Duration
+
+	// RequireScreening only relays messages from Discord members who have completed
+	// the guild's membership screening (its rules screen) and onboarding.
+	RequireScreening bool
+
+	// ReportDiscordChannel is the Discord channel that !report posts reports to.
+	// Reporting is disabled if this is empty.
+	ReportDiscordChannel string
+
+	// ReportThreads opens a thread on each report, for moderators to discuss it.
+	ReportThreads bool
+
+	// ReactionActions maps emoji to what happens when a Discord moderator reacts
+	// with it to a message relayed from IRC: "quiet" quiets the IRC user in the channel,
+	// and "ignore" stops relaying their messages.
+	ReactionActions map[string]string
+
+	// RelayIRCNotices relays NOTICEs sent to bridged IRC channels to Discord, as quotes.
+	RelayIRCNotices bool
+
+	// OptOutMarker relays "[message withheld]" in place of messages from people who have
+	// opted out of relaying, so the other side knows something was said.
+	OptOutMarker bool
+
+	// PasteURL is a paste service that accepts text as the body of a POST request
+	// and responds with its URL. It is used for the rest of truncated messages.
+	PasteURL string
+
+	// DCCHost, if set, is the IP address IRC users connect to to fetch Discord attachments
+	// over DCC, with the !get command.
+	DCCHost string
+
+	// ImageDescriptions relays the alt text of images posted on Discord alongside their URL,
+	// and CaptionURL is a service that describes images without alt text.
+	ImageDescriptions bool
+	CaptionURL        string
+
+	// LinkTitlesToDiscord and LinkTitlesToIRC add the titles of linked pages to relayed messages,
+	// for links to LinkTitleDomains and their subdomains.
+	LinkTitlesToDiscord bool
+	LinkTitlesToIRC     bool
+	LinkTitleDomains    []string
+
+	// Formatter is the name of the formatter that converts between IRC formatting and Discord markdown,
+	// "markdown" by default. If CanaryFormatter is set, messages are also formatted with it,
+	// and any difference from what was relayed is logged, to try out a formatter before switching to it.
+	Formatter       string
+	CanaryFormatter string
+
+	// StripIRCFormatting drops the formatting codes the formatter produced from messages
+	// relayed to IRC, for networks that don't allow formatting codes.
+	StripIRCFormatting bool
+
+	// LanguageDetector guesses the language of messages for channels with a LanguagePolicy.
+	// It is for programs embedding the bridge; if it is nil, a detector for a few
+	// European languages is used.
+	LanguageDetector LanguageDetector
+
+	// FailureFeedback tells people when their message could not be relayed: IRC users
+	// get a private NOTICE, and Discord messages are reacted to with ❌ and replied to.
+	FailureFeedback bool
+
+	// AvatarURL is the avatar of IRC users without a Discord avatar. {nick} is replaced
+	// with their nick, and {color} with a hex colour picked from it. If it is empty,
+	// the webhook's own avatar is used.
+	AvatarURL string
+
+	// PuppetNickTemplate, if set, is used to make puppet nicks, e.g. "{nick}[d]".
+	// It can use {nick}, {username}, {discriminator} and {id}. The Suffix is added after it.
+	PuppetNickTemplate string
+
+	// PuppetNickMaxLength is the longest a puppet nick can be, including the suffix,
+	// before the fallback nick is used. It defaults to 30.
+	PuppetNickMaxLength int
+
+	// LatencyProbeChannel, if set, is a bridged IRC channel that latency probes are
+	// sent through, in both directions, every LatencyProbeInterval.
+	LatencyProbeChannel  string
+	LatencyProbeInterval time.Duration
+
+	// HealthCheckInterval, if set, is how often each mapping is checked to make sure it
+	// would relay in both directions. Broken mappings are announced in StatusIRCChannel.
+	HealthCheckInterval time.Duration
+
+	// OverflowLimit is how many messages for IRC each puppet holds in memory when the IRC
+	// server can't keep up. Any more are spilled to a file in OverflowPath, if set, of up
+	// to OverflowMaxBytes, and the rest are dropped. Zero means the default for each.
+	OverflowLimit    int
+	OverflowPath     string
+	OverflowMaxBytes int64
+
+	// DiscordEvents, if set, are the only classes of Discord events the bridge processes, like
+	// "reactions" and "voice", so Discord doesn't send the others. Relaying messages needs no class.
+	DiscordEvents []string
+
+	// PresenceFallback, if set, is for guilds where the bot doesn't have the presence intent.
+	// Discord users are counted as online for this long after they last sent a message or typed,
+	// so puppets still leave IRC when their users go quiet.
+	PresenceFallback time.Duration
+
+	// OTLPEndpoint, if set, is an OpenTelemetry collector that spans covering how each message
+	// was relayed, and the metrics, are sent to over OTLP/HTTP, like "http://localhost:4318".
+	// OTLPHeaders are sent with each export, for collectors that need an API key.
+	OTLPEndpoint string
+	OTLPHeaders  map[string]string
+
+	// CommandPrefix starts bridge commands, like "!karma", sent to bridged channels.
+	CommandPrefix string
+
+	// Commands changes where and by whom bridge commands can be used, keyed by command name.
+	Commands map[string]CommandOptions
+
+	// Karma counts "nick++" on IRC, and "@user++" and KarmaEmoji reactions on Discord,
+	// against the same person if their identities are linked.
+	Karma      bool
+	KarmaEmoji string
+
+	// Retention is how long data is kept, keyed by dataset: a store bucket, or "messages"
+	// for the relayed messages remembered in memory. "default" applies to datasets
+	// without their own retention. Zero, or no retention, keeps data forever.
+	Retention map[string]time.Duration
+
+	// HAMode, if "file", runs the bridge as one of several instances, of which only the one
+	// holding the lease in HALeaseFile relays. The leader renews the lease, which lasts HALeaseTTL.
+	// The others stand by until it lapses, connected to Discord if HAStandby is "warm"
+	// (the default), or not at all if it is "cold". HAInstance names this instance in the lease.
+	HAMode      string
+	HALeaseFile string
+	HALeaseTTL  time.Duration
+	HAStandby   string
+	HAInstance  string
+
+	// RegisterIRCChannels registers bridged IRC channels with ChanServ when the listener creates them,
+	// by being the first to join, and sets their topic from the Discord channel's, and their modes to
+	// RegisterIRCModes, like "+nt". The listener must identify with NickServIdentify.
+	RegisterIRCChannels bool
+	RegisterIRCModes    string
+
+	// TrustedInviters are the IRC nicks, like ChanServ, whose invites to bridged channels
+	// the listener and puppets accept. Invites from the listener are always accepted.
+	TrustedInviters []string
+
+	// AllowIRCPins lets IRC channel operators pin the Discord counterpart
+	// of a relayed IRC message using the !pin command.
+	AllowIRCPins bool
+
+	// AllowIRCSeen lets IRC users check when a relayed IRC message was posted
+	// on Discord, and get a link to it, using the !seen command.
+	AllowIRCSeen bool
+
 	Suffix    string // Suffix is the suffix to append to IRC puppets
 	Separator string // Separator is used in IRC puppets' username, in fallback situations, between the discriminator and username.
 
@@ -58,9 +365,125 @@ type Bridge struct {
 	ircListener *ircListener
 	ircManager  *IRCManager
 
-	mappings []*Mapping
+	// mappings are replaced, not changed, when SetChannelMappings is called.
+	// Use channelMappings to read them.
+	mappingsMu sync.RWMutex
+	mappings   mappingList
+
+	// messages remembers what has recently been relayed
+	messages *messageMap
+
+	store     *store.Store
+	linkCodes linkCodes
+	links     linkIndex
+
+	// policies maps lowercase IRC channels to how messages are relayed to them
+	policiesMu sync.Mutex
+	policies   map[string]relayPolicy
+
+	// relayedToDiscord and relayedToIRC are fingerprints of recently relayed content
+	relayedToDiscord *fingerprints
+	relayedToIRC     *fingerprints
+
+	// quiet maps lowercase IRC channels to their quiet hours,
+	// and messages held back during them are queued here
+	quiet           map[string]*quietHours
+	quietMu         sync.Mutex
+	queuedToDiscord map[string][]IRCMessage
+	queuedToIRC     map[string][]*DiscordMessage
+
+	// delayed are the messages from Discord waiting out a relay delay
+	delayed delayedRelays
+
+	// unmapped remembers which unbridged channels messages have come from
+	unmapped unmappedChannels
+
+	// raid watches for raids by new Discord accounts
+	raid *raidDetector
+
+	// recorder records events for replaying, if RecordEvents is set,
+	// and replay is where what would have been relayed goes while replaying
+	recorder *eventRecorder
+	replay   *replayOutput
+
+	// announcer remembers the problems announced in the IRC status channel
+	announcer statusAnnouncer
+
+	// stages remembers the topics of live Discord stages
+	stages stageTopics
+
+	// traces is the pipeline history of recent messages, for !trace
+	traces traceBuffer
+
+	// stats counts what happened to each person's messages, for !stats
+	stats relayStats
+
+	// health is the state of each mapping's health checks
+	health mappingHealth
+
+	// notifyPatterns are the compiled patterns for !notify keywords
+	notifyPatterns keywordPatterns
+
+	// relayedIDs are the messages relayed recently, in high availability mode
+	relayedIDs relayedIDs
+
+	// identities are the IRC connections for mappings with their own ListenerNick
+	identities listenerIdentities
+
+	// status is the bot's Discord status
+	status botStatus
+
+	// mentions caches the IRC nicks Discord users are mentioned as, and how puppets are mentioned on Discord
+	mentions mentionCache
+
+	// late counts the messages too old to relay, for summaries
+	late lateMessages
+
+	// autoReplies remembers who has been sent the auto-response
+	autoReplies autoResponses
 
-	done chan bool
+	// joins batches announcements of new Discord members
+	joins joinAnnouncer
+
+	// failures remembers what was relayed to IRC, to blame errors on
+	failures relayFailures
+
+	// budgets limits how much each Discord user relays to each channel
+	budgets relayBudgets
+
+	// dccFiles are the recent Discord attachments that can be fetched over DCC
+	dccFiles dccFiles
+
+	// titles are the titles of linked pages
+	titles linkTitles
+
+	// relayOrder keeps messages in order while link titles are fetched for some of them
+	relayOrder relayOrder
+
+	// probes times messages sent through the bridge to measure its latency
+	probes *latencyProbes
+
+	// karmaMu serialises karma updates, which read and then write the store
+	karmaMu sync.Mutex
+
+	// activity is what has been relayed since the last digest
+	activity *activity
+
+	// lease, if high availability is set up, decides whether this instance relays
+	lease      *leaseFile
+	leadership leadership
+
+	// failed receives the first fatal error, see Failed
+	failed   chan error
+	failOnce sync.Once
+
+	// loopWatchdog restarts the loop if it gets stuck
+	loopWatchdog *watchdog
+	stopWatchdog chan struct{}
+
+	// done stops the loop, once
+	done      chan bool
+	closeOnce sync.Once
 
 	discordMessagesChan      chan IRCMessage
 	discordMessageEventsChan chan *DiscordMessage
@@ -68,10 +491,12 @@ type Bridge struct {
 	removeUserChan           chan string // user id
 }
 
-// Close the Bridge
+// Close the Bridge. It is safe to call more than once, and from any goroutine.
 func (b *Bridge) Close() {
-	b.done <- true
-	<-b.done
+	b.closeOnce.Do(func() {
+		b.done <- true
+		<-b.done
+	})
 }
 
 // TODO: Use errors package
@@ -84,6 +509,113 @@ func (b *Bridge) load(opts *Config) error {
 		return errors.New("missing webhook prefix")
 	}
 
+	switch opts.PuppetMetadata {
+	case metadataNone, metadataIRCv3, metadataSWHOIS:
+	default:
+		return errors.Errorf("unknown puppet metadata method %q", opts.PuppetMetadata)
+	}
+
+	switch opts.Services {
+	case servicesNone:
+	case servicesAtheme, servicesAnope:
+		if opts.ServicesAccount == "" || opts.ServicesPassword == "" {
+			return errors.New("services account and password are required to register puppets")
+		}
+	default:
+		return errors.Errorf("unknown services package %q", opts.Services)
+	}
+
+	if err := validateNickTemplate(opts.PuppetNickTemplate); err != nil {
+		return err
+	}
+
+	if err := validateCommands(opts.Commands); err != nil {
+		return err
+	}
+
+	if err := validateRetention(opts.Retention); err != nil {
+		return err
+	}
+
+	if err := validateHA(opts); err != nil {
+		return err
+	}
+
+	if err := validateDCCHost(opts.DCCHost); err != nil {
+		return err
+	}
+
+	if err := validateFormatters(opts); err != nil {
+		return err
+	}
+
+	if err := validateMaxRelayAge(opts); err != nil {
+		return err
+	}
+
+	if err := validateRelayDelay(opts); err != nil {
+		return err
+	}
+
+	if err := validateOverflow(opts); err != nil {
+		return err
+	}
+
+	if err := validateListenerIdentities(opts); err != nil {
+		return err
+	}
+
+	if err := validateOperators(opts); err != nil {
+		return err
+	}
+
+	if err := validateStageChannel(opts); err != nil {
+		return err
+	}
+
+	if err := validateFailover(opts); err != nil {
+		return err
+	}
+
+	if err := validateChannelRegistration(opts); err != nil {
+		return err
+	}
+
+	if err := validateDiscordEvents(opts); err != nil {
+		return err
+	}
+
+	if err := validateOTLP(opts); err != nil {
+		return err
+	}
+	b.traces.mu.Lock()
+	b.traces.export = opts.OTLPEndpoint != ""
+	b.traces.mu.Unlock()
+
+	for emoji, action := range opts.ReactionActions {
+		if action != reactionQuiet && action != reactionIgnore {
+			return errors.Errorf("unknown action %q for reaction %s", action, emoji)
+		}
+	}
+
+	b.quiet = make(map[string]*quietHours)
+	for channel, channelOpts := range opts.ChannelOptions {
+		if err := validateNickColors(channelOpts.NickColors); err != nil {
+			return errors.Wrapf(err, "channel options for %s", channel)
+		}
+		if err := validateLanguagePolicy(channelOpts); err != nil {
+			return errors.Wrapf(err, "channel options for %s", channel)
+		}
+
+		q, err := parseQuietHours(channelOpts)
+		if err != nil {
+			return errors.Wrapf(err, "channel options for %s", channel)
+		}
+		if q != nil {
+			b.quiet[strings.ToLower(channel)] = q
+		}
+	}
+
 	if err := b.SetChannelMappings(opts.ChannelMappings); err != nil {
 		return errors.Wrap(err, "channel mappings could not be set")
 	}
@@ -100,94 +632,29 @@ func (b *Bridge) load(opts *Config) error {
 // Calling this function whilst the bot is running will
 // add or remove IRC bots accordingly.
 func (b *Bridge) SetChannelMappings(inMappings map[string]string) error {
-	mappings := []*Mapping{}
-	for irc, discord := range inMappings {
-		mappings = append(mappings, &Mapping{
-			DiscordChannel: discord,
-			IRCChannel:     irc,
-		})
-	}
-
-	// Check for duplicate channels
-	for i, mapping := range mappings {
-		for j, check := range mappings {
-			if (mapping.DiscordChannel == check.DiscordChannel) || (mapping.IRCChannel == check.IRCChannel) {
-				if i != j {
-					return errors.New("channel_mappings contains duplicate entries")
-				}
-			}
-		}
+	mappings, err := parseMappings(inMappings)
+	if err != nil {
+		return withCategory(err, errConfig)
 	}
 
+	b.mappingsMu.Lock()
 	oldMappings := b.mappings
 	b.mappings = mappings
+	b.mappingsMu.Unlock()
 
 	// If doing some changes mid-bot
-	if oldMappings != nil {
-		newMappings := []*Mapping{}
-		removedMappings := []*Mapping{}
-
-		// Find positive difference
-		// These are the items in the new mappings list, but not the oldMappings
-		for _, mapping := range mappings {
-			found := false
-			for _, curr := range oldMappings {
-				if *curr == *mapping {
-					found = true
-					break
-				}
-			}
-
-			if !found {
-				newMappings = append(newMappings, mapping)
-			}
-		}
-
-		// Find negative difference
-		// These are the items in the oldMappings, but not the new one
-		for _, mapping := range oldMappings {
-			found := false
-			for _, curr := range mappings {
-				if *curr == *mapping {
-					found = true
-					break
-				}
-			}
-
-			if !found {
-				removedMappings = append(removedMappings, mapping)
-			}
-		}
-
-		// The bots needs to leave the remove mappings
-		rmChannels := []string{}
-		for _, mapping := range removedMappings {
-			// Looking for the irc channel to remove
-			// inside our list of newly added channels.
-			//
-			// This will prevent swaps from joinquitting the bots.
-			found := false
-			for _, curr := range newMappings {
-				if curr.IRCChannel == mapping.IRCChannel {
-					found = true
-				}
-			}
-
-			// If we've not found this channel to remove in the new channels
-			// actually part the channel
-			if !found {
-				rmChannels = append(rmChannels, mapping.IRCChannel)
-			}
-		}
+	if oldMappings != nil && !b.standingBy() {
+		// The bots needs to leave the removed mappings
+		rmChannels := mappings.parted(oldMappings)
 
 		b.ircListener.SendRaw("PART " + strings.Join(rmChannels, ","))
-		for _, conn := range b.ircManager.ircConnections {
+		for _, conn := range b.ircManager.connections() {
 			conn.innerCon.SendRaw("PART " + strings.Join(rmChannels, ","))
 		}
 
 		// The bots needs to join the new mappings
 		b.ircListener.JoinChannels()
-		for _, conn := range b.ircManager.ircConnections {
+		for _, conn := range b.ircManager.connections() {
 			conn.JoinChannels()
 		}
 	}
@@ -197,9 +664,27 @@ func (b *Bridge) SetChannelMappings(inMappings map[string]string) error {
 
 // New Bridge
 func New(conf *Config) (*Bridge, error) {
+	// Puppets would be seen on IRC
+	if conf.Shadow {
+		conf.SimpleMode = true
+	}
+
 	dib := &Bridge{
-		Config: conf,
-		done:   make(chan bool),
+		Config:    conf,
+		messages:  newMessageMap(),
+		linkCodes: linkCodes{codes: make(map[string]pendingLink)},
+		policies:  make(map[string]relayPolicy),
+		done:      make(chan bool),
+
+		leadership:      leadership{lost: make(chan struct{})},
+		failed:          make(chan error, 1),
+		probes:          newLatencyProbes(),
+		raid:            newRaidDetector(),
+		unmapped:        unmappedChannels{seen: make(map[string]bool)},
+		failures:        relayFailures{last: make(map[string]sentToIRC)},
+		budgets:         relayBudgets{sent: make(map[string][]budgetSpend)},
+		queuedToDiscord: make(map[string][]IRCMessage),
+		queuedToIRC:     make(map[string][]*DiscordMessage),
 
 		discordMessagesChan:      make(chan IRCMessage),
 		discordMessageEventsChan: make(chan *DiscordMessage),
@@ -207,12 +692,33 @@ func New(conf *Config) (*Bridge, error) {
 		removeUserChan:           make(chan string),
 	}
 
+	dib.traces.stats = &dib.stats
+	dib.activity = newActivity()
+	dib.relayedToDiscord = newFingerprints(conf.DedupWindow)
+	dib.relayedToIRC = newFingerprints(conf.DedupWindow)
+
 	if err := dib.load(conf); err != nil {
-		return nil, errors.Wrap(err, "configuration invalid")
+		return nil, errors.Wrap(withCategory(err, errConfig), "configuration invalid")
 	}
 
 	var err error
 
+	dib.store, err = store.Open(conf.StorePath)
+	if err != nil {
+		return nil, errors.Wrap(withCategory(err, errConfig), "could not open store")
+	}
+
+	if conf.HAMode == haModeFile {
+		dib.lease = &leaseFile{path: conf.HALeaseFile, holder: conf.HAInstance, ttl: conf.HALeaseTTL}
+	}
+
+	if conf.RecordEvents != "" {
+		dib.recorder, err = newEventRecorder(conf.RecordEvents)
+		if err != nil {
+			return nil, withCategory(err, errConfig)
+		}
+	}
+
 	dib.discord, err = newDiscord(dib, conf.DiscordBotToken, conf.GuildID)
 	if err != nil {
 		return nil, errors.Wrap(err, "Could not create discord bot")
@@ -221,7 +727,15 @@ func New(conf *Config) (*Bridge, error) {
 	dib.ircListener = newIRCListener(dib, conf.WebIRCPass)
 	dib.ircManager = newIRCManager(dib)
 
-	go dib.loop()
+	dib.loopWatchdog = newWatchdog(conf.WatchdogTimeout, dib.onLoopStall)
+	dib.stopWatchdog = make(chan struct{})
+	if conf.WatchdogTimeout > 0 {
+		go dib.loopWatchdog.run(dib.stopWatchdog)
+	}
+
+	// The loop removes the hook when the bridge closes
+	activityHooks.add(dib.activity)
+	go dib.loop(0)
 
 	return dib, nil
 }
@@ -229,7 +743,9 @@ func New(conf *Config) (*Bridge, error) {
 // SetIRCListenerName changes the username of the listener bot.
 func (b *Bridge) SetIRCListenerName(name string) {
 	b.Config.IRCListenerName = name
-	b.ircListener.Nick(name)
+	if !b.standingBy() {
+		b.ircListener.Nick(name)
+	}
 }
 
 // SetDebugMode allows you to control debug logging.
@@ -237,13 +753,19 @@ func (b *Bridge) SetDebugMode(debug bool) {
 	b.Config.Debug = debug
 	b.ircListener.SetDebugMode(debug)
 
-	for _, conn := range b.ircManager.ircConnections {
+	for _, conn := range b.ircManager.connections() {
 		conn.innerCon.Debug = debug
 	}
 }
 
 // Open all the connections required to run the bridge
+//
+// If high availability is set up, this returns once the instance is standing by,
+// and it takes over relaying when it gets the lease.
 func (b *Bridge) Open() (err error) {
+	if b.lease != nil {
+		return b.openStandby()
+	}
 
 	// Open a websocket connection to Discord and begin listening.
 	err = b.discord.Open()
@@ -251,7 +773,12 @@ func (b *Bridge) Open() (err error) {
 		return errors.Wrap(err, "can't open discord")
 	}
 
-	err = b.ircListener.Connect(b.Config.IRCServer)
+	return b.openIRC()
+}
+
+// openIRC connects the listener to IRC.
+func (b *Bridge) openIRC() error {
+	err := b.ircListener.Connect(b.Config.IRCServer)
 	if err != nil {
 		return errors.Wrap(err, "can't open irc connection")
 	}
@@ -259,7 +786,9 @@ func (b *Bridge) Open() (err error) {
 	// run listener loop
 	go b.ircListener.Loop()
 
-	return
+	go b.logDiagnostics()
+
+	return nil
 }
 
 func rejoinIRC(con *irc.Connection, event *irc.Event) {
@@ -303,10 +832,10 @@ func (b *Bridge) GetJoinCommand() string {
 // GetIRCChannels returns a list of irc channels in no particular order.
 func (b *Bridge) GetIRCChannels() map[string]string {
 	channels := make(map[string]string)
-	for _, mapping := range b.mappings {
+	for _, mapping := range b.channelMappings() {
 		pair := strings.Split(mapping.IRCChannel, " ")
 		c := pair[0]
-		p := ""
+		p := b.channelOptions(c).Key
 		if len(pair) > 1 {
 			p = pair[1]
 		}
@@ -319,31 +848,156 @@ func (b *Bridge) GetIRCChannels() map[string]string {
 // GetMappingByIRC returns a Mapping for a given IRC channel.
 // Returns nil if a Mapping does not exist.
 func (b *Bridge) GetMappingByIRC(channel string) *Mapping {
-	for _, mapping := range b.mappings {
-		if strings.Split(mapping.IRCChannel, " ")[0] == channel {
-			return mapping
+	return b.channelMappings().byIRC(channel)
+}
+
+// channelMappings returns the current mappings, which must not be changed.
+func (b *Bridge) channelMappings() mappingList {
+	b.mappingsMu.RLock()
+	defer b.mappingsMu.RUnlock()
+	return b.mappings
+}
+
+// channelOptions returns the options for the mapping with the given IRC channel.
+func (b *Bridge) channelOptions(ircChannel string) ChannelOptions {
+	ircChannel = strings.Split(ircChannel, " ")[0]
+	for channel, opts := range b.Config.ChannelOptions {
+		if strings.EqualFold(channel, ircChannel) {
+			return opts
 		}
 	}
-	return nil
+	return ChannelOptions{}
 }
 
 // GetMappingByDiscord returns a Mapping for a given Discord channel.
 // Returns nil if a Mapping does not exist.
 func (b *Bridge) GetMappingByDiscord(channel string) *Mapping {
-	for _, mapping := range b.mappings {
-		if mapping.DiscordChannel == channel {
-			return mapping
+	return b.channelMappings().byDiscord(channel)
+}
+
+// relayMarker is appended to every message we send to Discord, so that other
+// instances of this bridge sharing a channel know not to relay it back to IRC.
+//
+// U+2063 INVISIBLE SEPARATOR is used because it isn't trimmed like whitespace.
+const relayMarker = "\u2063"
+
+// sanitiseDiscordContent makes content from IRC safe to send to Discord.
+func sanitiseDiscordContent(content string) string {
+	// No content = zero width space
+	if content == "" {
+		content = "\u200B"
+	}
+
+	// Replace everyone and here - https://git.io/Je1yi
+	content = strings.ReplaceAll(content, "@everyone", "@\u200beveryone")
+	content = strings.ReplaceAll(content, "@here", "@\u200bhere")
+
+	return content + relayMarker
+}
+
+// isRelayBotDiscord returns true if a Discord user or webhook is a known relay bot.
+func (b *Bridge) isRelayBotDiscord(ids ...string) bool {
+	for _, id := range ids {
+		if id == "" {
+			continue
+		}
+		for _, ignored := range b.Config.IgnoredDiscordIDs {
+			if id == ignored {
+				return true
+			}
 		}
 	}
-	return nil
+	return false
 }
 
-func (b *Bridge) loop() {
+// isRelayBotIRC returns true if the nick belongs to a known relay bot.
+func (b *Bridge) isRelayBotIRC(nick string) bool {
+	for _, ignored := range b.Config.IgnoredIRCNicks {
+		if strings.EqualFold(nick, ignored) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Bridge) loop(generation int) {
+	var digest <-chan time.Time
+	if b.Config.DigestInterval > 0 {
+		digest = time.After(b.nextDigest())
+	}
+
+	var quietCheck <-chan time.Time
+	if len(b.quiet) > 0 {
+		ticker := time.NewTicker(quietCheckInterval)
+		defer ticker.Stop()
+		quietCheck = ticker.C
+	}
+
+	var probe <-chan time.Time
+	if b.Config.LatencyProbeChannel != "" && b.Config.LatencyProbeInterval > 0 {
+		ticker := time.NewTicker(b.Config.LatencyProbeInterval)
+		defer ticker.Stop()
+		probe = ticker.C
+	}
+
+	outbox := time.NewTicker(outboxInterval)
+	defer outbox.Stop()
+
+	var status <-chan time.Time
+	if b.Config.BotStatus {
+		ticker := time.NewTicker(botStatusInterval)
+		defer ticker.Stop()
+		status = ticker.C
+	}
+
+	var failover <-chan time.Time
+	if b.Config.SecondaryBotToken != "" {
+		ticker := time.NewTicker(failoverCheckInterval)
+		defer ticker.Stop()
+		failover = ticker.C
+	}
+
+	var presenceCheck <-chan time.Time
+	if b.Config.PresenceFallback > 0 && !b.Config.SimpleMode {
+		ticker := time.NewTicker(presenceCheckInterval)
+		defer ticker.Stop()
+		presenceCheck = ticker.C
+	}
+
+	var healthCheck <-chan time.Time
+	if b.Config.HealthCheckInterval > 0 {
+		ticker := time.NewTicker(b.Config.HealthCheckInterval)
+		defer ticker.Stop()
+		healthCheck = ticker.C
+	}
+
+	var otlp <-chan time.Time
+	if b.Config.OTLPEndpoint != "" {
+		ticker := time.NewTicker(otlpInterval)
+		defer ticker.Stop()
+		otlp = ticker.C
+	}
+
+	janitor := time.NewTicker(retentionInterval)
+	defer janitor.Stop()
+	if b.isLeader() {
+		go b.expireData()
+	}
+
 	for {
+		// Stop if the watchdog has replaced this loop
+		if !b.loopWatchdog.Idle(generation) {
+			return
+		}
+
 		select {
 
 		// Messages from IRC to Discord
 		case msg := <-b.discordMessagesChan:
+			b.loopWatchdog.Busy(loopToDiscord)
+			if !b.isLeader() {
+				continue
+			}
 			mapping := b.GetMappingByIRC(msg.IRCChannel)
 
 			if mapping == nil {
@@ -351,10 +1005,26 @@ func (b *Bridge) loop() {
 				continue
 			}
 
-			avatar := b.discord.GetAvatar(b.Config.GuildID, msg.Username)
+			if b.holdToDiscord(msg) {
+				b.traces.Step(msg.TraceID, traceQueue, "held for quiet hours in %s", msg.IRCChannel)
+				continue
+			}
+
+			if msg.Probe == "" && !msg.Held && b.tooLate(msg.IRCChannel, "discord", mapping.DiscordChannel, msg.Time) {
+				b.traces.Step(msg.TraceID, traceFilter, "dropped: too old to relay")
+				continue
+			}
+
+			avatar := ""
+			if link := b.linkByIRC(msg.Username, msg.Account); link != nil {
+				avatar = b.discord.GetAvatarByID(link.DiscordID)
+			}
 			if avatar == "" {
-				// If we don't have a Discord avatar, generate an adorable avatar
-				avatar = "https://api.adorable.io/avatars/128/" + msg.Username
+				avatar = b.discord.GetAvatar(b.Config.GuildID, msg.Username)
+			}
+			if avatar == "" {
+				// If we don't have a Discord avatar, use one generated from their nick
+				avatar = b.fallbackAvatar(msg.Username)
 			}
 
 			username := msg.Username
@@ -364,38 +1034,75 @@ func (b *Bridge) loop() {
 				username += `.` // <- zero width space in here, ayylmao
 			}
 
-			content := msg.Message
+			if msg.Away {
+				username += " (away)"
+			}
+
+			content := sanitiseDiscordContent(msg.Message)
 
-			// No content = zero width space
-			if content == "" {
-				content = "\u200B"
+			var embeds []*discordgo.MessageEmbed
+			if b.Config.ProvenanceFooter {
+				embeds = append(embeds, &discordgo.MessageEmbed{
+					Footer: &discordgo.MessageEmbedFooter{
+						Text: fmt.Sprintf("%s in %s", msg.Hostmask, msg.IRCChannel),
+					},
+				})
+			}
+
+			if b.replay != nil {
+				b.replayRelay("discord", msg.IRCChannel, username, content)
+				b.traces.Step(msg.TraceID, traceSend, "written to the replay output")
+				continue
+			} else if b.Config.Shadow {
+				shadowed(log.Fields{"channel": mapping.DiscordChannel, "username": username, "content": content}, "relayed to Discord")
+				b.traces.Step(msg.TraceID, traceSend, "not sent: shadow mode")
+				continue
 			}
 
-			// Replace everyone and here - https://git.io/Je1yi
-			content = strings.ReplaceAll(content, "@everyone", "@\u200beveryone")
-			content = strings.ReplaceAll(content, "@here", "@\u200bhere")
+			if msg.Probe == "" && !b.relayOnce(ircRelayKey(msg)) {
+				b.traces.Step(msg.TraceID, traceFilter, "dropped: already relayed by another instance")
+				continue
+			}
 
 			go func() {
-				err := b.discord.transmitter.Message(
-					mapping.DiscordChannel,
-					username,
-					avatar,
-					content,
-				)
+				b.summarizeLateToDiscord(mapping.DiscordChannel)
 
+				sent, err := b.transmit(mapping.DiscordChannel, username, avatar, content, embeds)
 				if err != nil {
-					log.WithFields(log.Fields{
-						"error":        err,
-						"msg.channel":  mapping.DiscordChannel,
-						"msg.username": username,
-						"msg.avatar":   avatar,
-						"msg.content":  content,
-					}).Errorln("could not transmit message to discord")
+					// It may be sent again from the outbox
+					b.forgetRelayed(ircRelayKey(msg))
+					b.sendFailed(msg, err)
+					return
+				}
+				b.traces.Step(msg.TraceID, traceSend, "sent to Discord as message %s", sent.ID)
+
+				// Probes are timed when Discord sends them back to us
+				if msg.Probe != "" {
+					return
 				}
+
+				b.activity.RelayedToDiscord(msg.IRCChannel, msg.Username)
+				b.relayedToDiscord.Add(msg.Username, msg.Message)
+				b.messages.Add(&relayedMessage{
+					DiscordChannel:   mapping.DiscordChannel,
+					DiscordID:        sent.ID,
+					DiscordWebhookID: sent.WebhookID,
+					IRCChannel:       msg.IRCChannel,
+					IRCNick:          msg.Username,
+					IRCAccount:       msg.Account,
+					IRCMsgID:         msg.MsgID,
+					IRCHostmask:      msg.Hostmask,
+					Content:          msg.Message,
+					Time:             time.Now(),
+				})
 			}()
 
 		// Messages from Discord to IRC
 		case msg := <-b.discordMessageEventsChan:
+			b.loopWatchdog.Busy(loopToIRC)
+			if !b.isLeader() {
+				continue
+			}
 			mapping := b.GetMappingByDiscord(msg.ChannelID)
 
 			// Do not do anything if we do not have a mapping for the PUBLIC channel
@@ -407,22 +1114,149 @@ func (b *Bridge) loop() {
 			target := msg.PmTarget
 			if target == "" {
 				target = mapping.IRCChannel
+
+				if b.delayToIRC(target, msg) {
+					b.traces.Step(msg.TraceID, traceQueue, "delayed by %s in case it is deleted", b.channelOptions(target).RelayDelay)
+					continue
+				}
+
+				if !b.discord.hasRequiredRole(msg.Message, b.channelOptions(target).DiscordRoles) {
+					b.traces.Step(msg.TraceID, traceFilter, "dropped: the author doesn't have a role required in %s", target)
+					continue
+				}
+
+				if msg.Probe == "" && !b.raidFilter(msg) {
+					b.traces.Step(msg.TraceID, traceFilter, "dropped by the raid filter")
+					continue
+				}
+
+				if b.holdToIRC(target, msg) {
+					b.traces.Step(msg.TraceID, traceQueue, "held for quiet hours in %s", target)
+					continue
+				}
+
+				ircChannel := strings.Split(target, " ")[0]
+				if msg.Probe == "" && !msg.Held && b.tooLate(target, "irc", ircChannel, discordSentAt(msg.Message)) {
+					b.traces.Step(msg.TraceID, traceFilter, "dropped: too old to relay")
+					continue
+				}
+
+				if msg.Probe == "" && !b.Config.Shadow {
+					b.notifySubscribers(target, msg)
+				}
+			}
+
+			if b.replay != nil {
+				b.replayRelay("irc", target, msg.Author.Username, msg.Content)
+				b.traces.Step(msg.TraceID, traceSend, "written to the replay output")
+				continue
+			} else if b.Config.Shadow {
+				shadowed(log.Fields{"target": target, "author": msg.Author.ID, "content": msg.Content}, "relayed to IRC")
+				b.traces.Step(msg.TraceID, traceSend, "not sent: shadow mode")
+				continue
 			}
 
+			if msg.Probe == "" && !b.relayOnce(discordRelayKey(msg)) {
+				b.traces.Step(msg.TraceID, traceFilter, "dropped: already relayed by another instance")
+				continue
+			}
+
+			if msg.PmTarget == "" && msg.Probe == "" {
+				b.activity.RelayedToIRC(target, msg.Author.ID, msg.Author.Username)
+				b.relayedToIRC.Add(msg.Author.Username, msg.Content)
+			}
+			if msg.Probe == "" && b.Config.FailureFeedback {
+				b.failures.Sent(strings.Split(target, " ")[0], msg.Message)
+			}
+			if msg.PmTarget == "" {
+				b.summarizeLateToIRC(strings.Split(target, " ")[0])
+			}
 			b.ircManager.SendMessage(target, msg)
+			b.traces.Step(msg.TraceID, traceSend, "sent to %s: %q", target, msg.Content)
+
+			if msg.Probe != "" {
+				go b.probeRelayed(msg.Probe)
+			}
 
 		// Notification to potentially update, or create, a user
 		// We should not receive anything on this channel if we're in Simple Mode
 		case user := <-b.updateUserChan:
+			b.loopWatchdog.Busy(loopUpdatePuppet)
+			if !b.isLeader() {
+				continue
+			}
+			if b.optedOut(karmaKeyDiscord(user.ID)) {
+				b.ircManager.DisconnectUser(user.ID)
+				continue
+			}
 			b.ircManager.HandleUser(user)
 
 		case userID := <-b.removeUserChan:
+			b.loopWatchdog.Busy(loopRemovePuppet)
 			b.ircManager.DisconnectUser(userID)
 
+		// Standbys leave all of these to the leader
+		case <-quietCheck:
+			if b.isLeader() {
+				b.flushQuietQueues()
+			}
+
+		case <-outbox.C:
+			if b.isLeader() && !b.Config.Shadow {
+				b.flushOutbox()
+				go b.flushLateSummaries()
+			}
+
+		case <-status:
+			go b.updateBotStatus(false)
+
+		case <-presenceCheck:
+			if b.isLeader() {
+				// Marking people offline goes through the loop
+				go b.discord.expireActivity()
+			}
+
+		// Standbys need a working gateway connection too
+		case <-failover:
+			go b.discord.checkFailover()
+
+		// Standbys only have metrics to export
+		case <-otlp:
+			go b.exportOTLP()
+
+		case <-janitor.C:
+			if b.isLeader() {
+				go b.expireData()
+			}
+
+		case <-probe:
+			if b.isLeader() && !b.Config.Shadow {
+				go b.sendProbes()
+			}
+
+		case <-healthCheck:
+			if b.isLeader() {
+				go b.checkHealth()
+			}
+
+		case <-digest:
+			if b.isLeader() && !b.Config.Shadow {
+				go b.postDigest()
+			}
+			digest = time.After(b.Config.DigestInterval)
+
 		// Done!
 		case <-b.done:
+			b.announcer.stop()
+			b.joins.stop()
+			close(b.stopWatchdog)
 			b.discord.Close()
-			b.ircListener.Quit()
+			if !b.standingBy() && b.replay == nil {
+				b.ircListener.Quit()
+			}
+			b.closeIdentities()
+			activityHooks.remove(b.activity)
+			b.recorder.Close()
 			b.ircManager.Close()
 			close(b.done)
 
@@ -0,0 +1,36 @@
+package bridge
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Broadcast sends a notice from the bridge to every mapped IRC and Discord channel,
+// e.g. "bridge restarting in 5 minutes". It returns how many channels it was sent to,
+// and the last error if some Discord channels could not be sent to.
+func (b *Bridge) Broadcast(message string) (int, error) {
+	message = strings.Join(strings.Fields(message), " ")
+	if message == "" {
+		return 0, errors.New("broadcast message is empty")
+	}
+
+	log.WithField("message", message).Infoln("Broadcasting to all bridged channels.")
+
+	sent := 0
+	var lastErr error
+	for _, mapping := range b.channelMappings() {
+		b.ircListener.Notice(strings.Split(mapping.IRCChannel, " ")[0], "[bridge] "+message)
+		sent++
+
+		if _, err := b.discord.ChannelMessageSend(mapping.DiscordChannel, "**[bridge]** "+sanitiseDiscordContent(message)); err != nil {
+			b.handleError(err, log.Fields{"channel": mapping.DiscordChannel}, "could not broadcast to discord")
+			lastErr = err
+			continue
+		}
+		sent++
+	}
+
+	return sent, lastErr
+}
@@ -0,0 +1,26 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBroadcast(t *testing.T) {
+	tb := newTestBridge(t, nil)
+	defer tb.Close()
+
+	sent, err := tb.Broadcast("bridge restarting\nin 5 minutes @everyone")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, sent)
+
+	waitFor(t, "broadcast on irc", func() bool {
+		return tb.ircd.HasReceived("listener", "NOTICE "+testChannel+" :[bridge] bridge restarting in 5 minutes @everyone")
+	})
+	msg, ok := tb.discord.Find("**[bridge]** bridge restarting in 5 minutes @\u200Beveryone" + relayMarker)
+	assert.True(t, ok)
+	assert.Equal(t, testChannelID, msg.ChannelID)
+
+	_, err = tb.Broadcast("  ")
+	assert.Error(t, err)
+}
@@ -0,0 +1,103 @@
+package bridge
+
+import (
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// budgetWindow is how long characters relayed count against someone's MaxCharsPerMinute
+const budgetWindow = time.Minute
+
+// relayBudgets remembers how much each Discord user has recently relayed to each IRC channel,
+// so that walls of text don't take over a channel.
+type relayBudgets struct {
+	mu   sync.Mutex
+	sent map[string][]budgetSpend // keyed by lowercase IRC channel and user ID
+}
+
+type budgetSpend struct {
+	at    time.Time
+	chars int
+}
+
+// remaining returns how many characters someone can still relay to a channel this minute.
+func (r *relayBudgets) remaining(key string, max int, now time.Time) int {
+	kept := r.sent[key][:0]
+	for _, spend := range r.sent[key] {
+		if now.Sub(spend.at) < budgetWindow {
+			kept = append(kept, spend)
+			max -= spend.chars
+		}
+	}
+	r.sent[key] = kept
+
+	if max < 0 {
+		return 0
+	}
+	return max
+}
+
+// truncateRunes shortens s to at most n characters.
+func truncateRunes(s string, n int) string {
+	for i := range s {
+		if n == 0 {
+			return s[:i]
+		}
+		n--
+	}
+	return s
+}
+
+// applyBudget truncates a Discord message to the channel's MaxLines, and to what
+// the author has left of MaxCharsPerMinute. The rest is replaced with a link to a paste
+// of the whole message, if a paste service is set up.
+func (b *Bridge) applyBudget(channel, userID, content string) string {
+	opts := b.channelOptions(channel)
+	if opts.MaxLines <= 0 && opts.MaxCharsPerMinute <= 0 {
+		return content
+	}
+
+	kept := content
+	truncated := false
+
+	if lines := strings.Split(kept, "\n"); opts.MaxLines > 0 && len(lines) > opts.MaxLines {
+		kept = strings.Join(lines[:opts.MaxLines], "\n")
+		truncated = true
+	}
+
+	if opts.MaxCharsPerMinute > 0 {
+		key := strings.ToLower(strings.Split(channel, " ")[0]) + " " + userID
+		now := time.Now()
+
+		b.budgets.mu.Lock()
+		if remaining := b.budgets.remaining(key, opts.MaxCharsPerMinute, now); utf8.RuneCountInString(kept) > remaining {
+			kept = truncateRunes(kept, remaining)
+			truncated = true
+		}
+		b.budgets.sent[key] = append(b.budgets.sent[key], budgetSpend{at: now, chars: utf8.RuneCountInString(kept)})
+		b.budgets.mu.Unlock()
+	}
+
+	if !truncated {
+		return content
+	}
+
+	note := "[message truncated]"
+	if b.Config.PasteURL != "" {
+		if url, err := b.paste(content); err != nil {
+			log.WithField("error", err).Warnln("could not paste a truncated message")
+		} else {
+			note = "[full message: " + url + "]"
+		}
+	}
+
+	kept = strings.TrimRight(kept, " \n")
+	if kept != "" {
+		kept += " "
+	}
+	return kept + note
+}
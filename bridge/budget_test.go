@@ -0,0 +1,48 @@
+package bridge
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyBudget(t *testing.T) {
+	var pasted string
+	paste := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		pasted = string(body)
+		w.Write([]byte("https://paste.example/abc\n"))
+	}))
+	defer paste.Close()
+
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.PasteURL = paste.URL
+		conf.ChannelOptions = map[string]ChannelOptions{
+			testChannel: {MaxLines: 2, MaxCharsPerMinute: 20},
+		}
+	})
+	defer tb.Close()
+	b := tb.Bridge
+
+	assert.Equal(t, "one\ntwo [full message: https://paste.example/abc]", b.applyBudget(testChannel, "100", "one\ntwo\nthree"))
+	assert.Equal(t, "one\ntwo\nthree", pasted)
+
+	// Seven characters were relayed, so thirteen are left this minute
+	assert.Equal(t, "thirteen more [full message: https://paste.example/abc]", b.applyBudget(testChannel, "100", "thirteen more and more"))
+	assert.Equal(t, "[full message: https://paste.example/abc]", b.applyBudget(testChannel, "100", "anything"))
+
+	// Budgets are per person, and messages within them are untouched
+	assert.Equal(t, "hello", b.applyBudget(testChannel, "200", "hello"))
+
+	b.Config.PasteURL = ""
+	assert.Equal(t, "a\nb [message truncated]", b.applyBudget(testChannel, "300", "a\nb\nc"))
+}
+
+func TestTruncateRunes(t *testing.T) {
+	assert.Equal(t, "hé", truncateRunes("héllo", 2))
+	assert.Equal(t, "", truncateRunes("héllo", 0))
+	assert.Equal(t, "héllo", truncateRunes("héllo", 10))
+}
@@ -0,0 +1,207 @@
+package bridge
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	irc "github.com/qaisjp/go-ircevent"
+	log "github.com/sirupsen/logrus"
+)
+
+// commandPermission is who may run a bridge command.
+type commandPermission int
+
+const (
+	permissionEveryone  commandPermission = iota
+	permissionModerator                   // IRC halfops and above, Discord members who can manage messages
+	permissionAdmin                       // IRC ops and above, Discord administrators
+)
+
+var commandPermissions = map[string]commandPermission{
+	"everyone":  permissionEveryone,
+	"moderator": permissionModerator,
+	"admin":     permissionAdmin,
+}
+
+// discordMention matches a Discord user mention given as a command argument
+var discordMention = regexp.MustCompile(`^<@!?(\d+)>$`)
+
+// chatCommand is a command, like "!karma", that can be sent to a bridged channel.
+type chatCommand struct {
+	Name string
+
+	// Permission is who may run the command, unless overridden in the config.
+	Permission commandPermission
+
+	// Enabled returns whether the module providing the command is turned on. Nil means always.
+	Enabled func(b *Bridge) bool
+
+	// IRC and Discord run the command on each side. Nil if the command isn't supported there.
+	IRC     func(i *ircListener, e *irc.Event, args []string)
+	Discord func(d *discordBot, m *discordgo.Message, args []string)
+}
+
+// chatCommands is the registry of bridge commands, keyed by name.
+var chatCommands = map[string]*chatCommand{}
+
+// registerChatCommand adds a command to the registry. It should be called from init.
+func registerChatCommand(c *chatCommand) {
+	if _, ok := chatCommands[c.Name]; ok {
+		panic("bridge command registered twice: " + c.Name)
+	}
+	chatCommands[c.Name] = c
+}
+
+// validateCommands checks the command options in the config.
+func validateCommands(opts map[string]CommandOptions) error {
+	for name, opt := range opts {
+		if _, ok := chatCommands[name]; !ok {
+			return errors.Errorf("unknown command %q", name)
+		}
+		if _, ok := commandPermissions[opt.Permission]; opt.Permission != "" && !ok {
+			return errors.Errorf("unknown permission %q for command %s", opt.Permission, name)
+		}
+	}
+	return nil
+}
+
+// findCommand parses a command sent to a bridged IRC channel, returning nil if the message isn't
+// a command that can be run in the channel.
+func (b *Bridge) findCommand(ircChannel, message string) (*chatCommand, []string) {
+	prefix := b.Config.CommandPrefix
+	if prefix == "" || !strings.HasPrefix(message, prefix) {
+		return nil, nil
+	}
+
+	fields := strings.Fields(strings.TrimPrefix(message, prefix))
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	cmd, ok := chatCommands[strings.ToLower(fields[0])]
+	if !ok || (cmd.Enabled != nil && !cmd.Enabled(b)) {
+		return nil, nil
+	}
+
+	opt := b.Config.Commands[cmd.Name]
+	if opt.Disabled {
+		return nil, nil
+	}
+	if len(opt.Channels) > 0 {
+		allowed := false
+		for _, channel := range opt.Channels {
+			allowed = allowed || strings.EqualFold(channel, ircChannel)
+		}
+		if !allowed {
+			return nil, nil
+		}
+	}
+
+	return cmd, fields[1:]
+}
+
+// commandPermission returns who may run a command.
+func (b *Bridge) commandPermission(cmd *chatCommand) commandPermission {
+	if perm, ok := commandPermissions[b.Config.Commands[cmd.Name].Permission]; ok {
+		return perm
+	}
+	return cmd.Permission
+}
+
+// runCommand runs a bridge command sent to an IRC channel, returning false if the message isn't a command.
+func (i *ircListener) runCommand(e *irc.Event) bool {
+	if e.Code != "PRIVMSG" {
+		return false
+	}
+
+	channel := e.Arguments[0]
+	cmd, args := i.bridge.findCommand(channel, e.Message())
+	if cmd == nil || cmd.IRC == nil {
+		return false
+	}
+
+	prefixes, _ := i.users.Prefixes(channel, e.Nick)
+	allowed := true
+	switch i.bridge.commandPermission(cmd) {
+	case permissionModerator:
+		allowed = i.users.HasPrefixAtLeast(prefixes, "%")
+	case permissionAdmin:
+		allowed = i.users.HasPrefixAtLeast(prefixes, "@")
+	}
+	if !allowed {
+		i.Noticef(e.Nick, "You don't have permission to use %s%s here.", i.bridge.Config.CommandPrefix, cmd.Name)
+		return true
+	}
+
+	if i.bridge.Config.Shadow {
+		shadowed(log.Fields{"nick": e.Nick, "command": cmd.Name}, "run a command")
+		return true
+	}
+	cmd.IRC(i, e, args)
+	return true
+}
+
+// runCommand runs a bridge command sent to a bridged Discord channel, returning false if the message isn't a command.
+func (d *discordBot) runCommand(m *discordgo.Message) bool {
+	mapping := d.bridge.GetMappingByDiscord(m.ChannelID)
+	if mapping == nil || m.Author == nil || m.Author.Bot || m.WebhookID != "" {
+		return false
+	}
+
+	cmd, args := d.bridge.findCommand(mapping.IRCChannel, m.Content)
+	if cmd == nil || cmd.Discord == nil {
+		return false
+	}
+
+	allowed := true
+	if perm := d.bridge.commandPermission(cmd); perm != permissionEveryone {
+		perms, err := d.State.UserChannelPermissions(m.Author.ID, m.ChannelID)
+		switch {
+		case err != nil:
+			allowed = false
+		case perm == permissionModerator:
+			allowed = perms&(discordgo.PermissionManageMessages|discordgo.PermissionAdministrator) != 0
+		case perm == permissionAdmin:
+			allowed = perms&discordgo.PermissionAdministrator != 0
+		}
+	}
+	if !allowed {
+		d.reply(m, "You don't have permission to use "+d.bridge.Config.CommandPrefix+cmd.Name+" here.")
+		return true
+	}
+
+	if d.bridge.Config.Shadow {
+		shadowed(log.Fields{"author": m.Author.ID, "command": cmd.Name}, "run a command")
+		return true
+	}
+	cmd.Discord(d, m, args)
+	return true
+}
+
+// reply replies to a Discord message, without mentioning anyone.
+func (d *discordBot) reply(m *discordgo.Message, content string) {
+	_, err := d.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{
+		Content:         content,
+		Reference:       m.Reference(),
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	})
+	if err != nil {
+		d.bridge.handleError(err, nil, "could not reply to bridge command on discord")
+	}
+}
+
+func init() {
+	registerChatCommand(&chatCommand{
+		Name: "status",
+		IRC: func(i *ircListener, e *irc.Event, args []string) {
+			for _, line := range i.bridge.statusLines() {
+				i.Notice(e.Nick, line)
+			}
+		},
+		Discord: func(d *discordBot, m *discordgo.Message, args []string) {
+			d.reply(m, strings.Join(d.bridge.statusLines(), "\n"))
+		},
+	})
+}
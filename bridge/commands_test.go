@@ -0,0 +1,70 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommands(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.CommandPrefix = "."
+		conf.Commands = map[string]CommandOptions{
+			"status": {Permission: "admin"},
+			"whois":  {Channels: []string{"#elsewhere"}},
+		}
+	})
+	defer tb.Close()
+
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :.ping")
+	waitFor(t, "pong on irc", func() bool {
+		for _, line := range tb.ircd.Received("listener") {
+			if strings.HasPrefix(line, "NOTICE "+testChannel+" :Pong! IRC server: ") {
+				return true
+			}
+		}
+		return false
+	})
+
+	// Messages without the prefix, and commands that aren't allowed in the channel, are relayed
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :!ping")
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :.whois bob")
+	waitFor(t, "relayed messages", func() bool {
+		_, ping := tb.discord.Find("!ping" + relayMarker)
+		_, whois := tb.discord.Find(".whois bob" + relayMarker)
+		return ping && whois
+	})
+
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :.status")
+	waitFor(t, "permission denied", func() bool {
+		return tb.ircd.HasReceived("listener", "NOTICE alice :You don't have permission to use .status here.")
+	})
+
+	tb.ircd.Inject("ChanServ!cs@services", testChannel, "MODE "+testChannel+" +o alice")
+	waitFor(t, "alice opped", func() bool {
+		return tb.ircListener.isChannelOp(testChannel, "alice")
+	})
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :.status")
+	waitFor(t, "status", func() bool {
+		return tb.ircd.HasReceived("listener", "NOTICE alice :Bridging 1 channels, with 0 Discord users connected to IRC.")
+	})
+
+	bob := tb.discordMember("100", "bob", "")
+	tb.discordSay(bob, ".ping")
+	waitFor(t, "pong on discord", func() bool {
+		for _, msg := range tb.discord.Sent() {
+			if msg.ChannelID == testChannelID && strings.HasPrefix(msg.Content, "Pong!") {
+				return true
+			}
+		}
+		return false
+	})
+	assert.False(t, tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<b\u200Bob#0001> .ping"))
+}
+
+func TestValidateCommands(t *testing.T) {
+	assert.NoError(t, validateCommands(map[string]CommandOptions{"karma": {Permission: "moderator"}}))
+	assert.Error(t, validateCommands(map[string]CommandOptions{"nope": {}}))
+	assert.Error(t, validateCommands(map[string]CommandOptions{"ping": {Permission: "root"}}))
+}
@@ -0,0 +1,229 @@
+package bridge
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	irc "github.com/qaisjp/go-ircevent"
+	log "github.com/sirupsen/logrus"
+)
+
+// dccKeep is how many of the most recent Discord attachments in each channel can be fetched with !get
+const dccKeep = 50
+
+// dccTimeout is how long an IRC user has to accept a DCC offer
+var dccTimeout = 2 * time.Minute
+
+// dccOffersPerNick and dccOffers are the most DCC offers waiting or sending at once,
+// for each IRC user and overall. Each holds a listening port and a download from Discord.
+const (
+	dccOffersPerNick = 2
+	dccOffers        = 10
+)
+
+// dccClient downloads attachments from Discord to send over DCC
+var dccClient = &http.Client{Timeout: 10 * time.Minute}
+
+// dccFile is a Discord attachment that can be sent over DCC.
+type dccFile struct {
+	Name string
+	URL  string
+	Size int
+}
+
+// dccFiles are the most recent Discord attachments, numbered in the order they were relayed
+// to each IRC channel, and the DCC offers in progress.
+type dccFiles struct {
+	mu    sync.Mutex
+	files map[string]map[int]dccFile // keyed by lowercase channel
+	last  map[string]int
+
+	offers map[string]int // keyed by lowercase nick
+	total  int
+}
+
+// add remembers an attachment relayed to an IRC channel, and returns the number it can be fetched with.
+func (f *dccFiles) add(channel string, file dccFile) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	channel = strings.ToLower(channel)
+	if f.files == nil {
+		f.files = make(map[string]map[int]dccFile)
+		f.last = make(map[string]int)
+	}
+	if f.files[channel] == nil {
+		f.files[channel] = make(map[int]dccFile)
+	}
+	f.last[channel]++
+	n := f.last[channel]
+	f.files[channel][n] = file
+	delete(f.files[channel], n-dccKeep)
+	return n
+}
+
+func (f *dccFiles) get(channel string, n int) (dccFile, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, ok := f.files[strings.ToLower(channel)][n]
+	return file, ok
+}
+
+// startOffer returns true if the nick can be offered another file, counting the offer until endOffer.
+func (f *dccFiles) startOffer(nick string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	nick = strings.ToLower(nick)
+	if f.total >= dccOffers || f.offers[nick] >= dccOffersPerNick {
+		return false
+	}
+	if f.offers == nil {
+		f.offers = make(map[string]int)
+	}
+	f.offers[nick]++
+	f.total++
+	return true
+}
+
+func (f *dccFiles) endOffer(nick string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	nick = strings.ToLower(nick)
+	f.total--
+	if f.offers[nick]--; f.offers[nick] <= 0 {
+		delete(f.offers, nick)
+	}
+}
+
+func validateDCCHost(host string) error {
+	if host != "" && net.ParseIP(host) == nil {
+		return errors.Errorf("dcc host %q must be an IP address", host)
+	}
+	return nil
+}
+
+// dccAttachment remembers an attachment relayed to an IRC channel, and returns the text relayed
+// for it: its URL, and how to fetch it over DCC if that is turned on.
+func (d *discordBot) dccAttachment(attachment *discordgo.MessageAttachment, discordChannel, pmTarget string) string {
+	b := d.bridge
+	if b.Config.DCCHost == "" || b.Config.CommandPrefix == "" || pmTarget != "" {
+		return attachment.URL
+	}
+	mapping := b.GetMappingByDiscord(discordChannel)
+	if mapping == nil {
+		return attachment.URL
+	}
+
+	n := b.dccFiles.add(mapping.IRCName(), dccFile{Name: attachment.Filename, URL: attachment.URL, Size: attachment.Size})
+	return fmt.Sprintf("%s (%sget %d)", attachment.URL, b.Config.CommandPrefix, n)
+}
+
+// dccAddress is the address in a DCC offer: a decimal number for IPv4,
+// and the usual form for IPv6.
+func dccAddress(host string) string {
+	ip := net.ParseIP(host)
+	if ip4 := ip.To4(); ip4 != nil {
+		return strconv.FormatUint(uint64(binary.BigEndian.Uint32(ip4)), 10)
+	}
+	return ip.String()
+}
+
+// offerDCC offers a file to an IRC user with DCC SEND, and sends it if they accept within dccTimeout.
+// The file is downloaded from Discord as it is sent. The offer must have been started with startOffer.
+func (i *ircListener) offerDCC(nick string, file dccFile) error {
+	ln, err := net.Listen("tcp", ":0")
+	if err != nil {
+		i.bridge.dccFiles.endOffer(nick)
+		return errors.Wrap(err, "could not listen for dcc")
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	// Most clients don't understand quoted file names
+	name := strings.Replace(file.Name, " ", "_", -1)
+	i.Privmsgf(nick, "\x01DCC SEND %s %s %d %d\x01", name, dccAddress(i.bridge.Config.DCCHost), port, file.Size)
+
+	go func() {
+		defer i.bridge.dccFiles.endOffer(nick)
+		defer ln.Close()
+		fields := log.Fields{"nick": nick, "file": file.Name}
+
+		ln.(*net.TCPListener).SetDeadline(time.Now().Add(dccTimeout))
+		conn, err := ln.Accept()
+		if err != nil {
+			log.WithFields(fields).Infoln("DCC offer was not accepted.")
+			return
+		}
+		defer conn.Close()
+
+		resp, err := dccClient.Get(file.URL)
+		if err != nil {
+			i.bridge.handleError(err, fields, "could not download attachment for dcc")
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			log.WithFields(fields).WithField("status", resp.Status).Warnln("could not download attachment for dcc")
+			return
+		}
+
+		// Receivers acknowledge what they have received, which we don't need
+		go io.Copy(ioutil.Discard, conn)
+
+		if _, err := io.Copy(conn, resp.Body); err != nil {
+			log.WithFields(fields).WithField("error", err).Warnln("could not send file over dcc")
+			return
+		}
+		log.WithFields(fields).Infoln("Sent file over DCC.")
+	}()
+
+	return nil
+}
+
+func init() {
+	registerChatCommand(&chatCommand{
+		Name:    "get",
+		Enabled: func(b *Bridge) bool { return b.Config.DCCHost != "" },
+		IRC: func(i *ircListener, e *irc.Event, args []string) {
+			if len(args) == 0 {
+				i.Noticef(e.Nick, "Usage: %sget <number>", i.bridge.Config.CommandPrefix)
+				return
+			}
+
+			// Files are numbered in each channel, and only given to people in it,
+			// not to anyone sending to it from outside
+			channel := e.Arguments[0]
+			n, err := strconv.Atoi(strings.TrimPrefix(args[0], "#"))
+			file, ok := i.bridge.dccFiles.get(channel, n)
+			if _, in := i.users.Prefixes(channel, e.Nick); !in {
+				ok = false
+			}
+			if err != nil || !ok {
+				i.Noticef(e.Nick, "There is no file %s in %s. Only the last %d files from Discord in each channel can be fetched.", args[0], channel, dccKeep)
+				return
+			}
+
+			if !i.bridge.dccFiles.startOffer(e.Nick) {
+				i.Notice(e.Nick, "Too many files are being offered right now. Please try again once they've been accepted.")
+				return
+			}
+			if err := i.offerDCC(e.Nick, file); err != nil {
+				i.bridge.handleError(err, log.Fields{"nick": e.Nick}, "could not offer file over dcc")
+				i.Notice(e.Nick, "Something went wrong, sorry. Please try again later.")
+				return
+			}
+			i.Noticef(e.Nick, "Offering %s over DCC. Accept it within %s.", file.Name, dccTimeout)
+		},
+	})
+}
@@ -0,0 +1,108 @@
+package bridge
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDCC(t *testing.T) {
+	files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "file contents")
+	}))
+	defer files.Close()
+
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.DCCHost = "127.0.0.1"
+	})
+	defer tb.Close()
+
+	bob := tb.discordMember("100", "bob", "")
+	d := tb.Bridge.discord
+	d.publishMessage(d.Session, &discordgo.Message{
+		ID:          tb.discord.id(),
+		ChannelID:   testChannelID,
+		GuildID:     testGuildID,
+		Author:      bob,
+		Type:        discordgo.MessageTypeDefault,
+		Attachments: []*discordgo.MessageAttachment{{Filename: "notes final.txt", URL: files.URL + "/notes.txt", Size: 13}},
+	}, false)
+	waitFor(t, "attachment on irc", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<b\u200Bob#0001> "+files.URL+"/notes.txt (!get 1)")
+	})
+
+	// Only people in the channel can fetch its files, not anyone sending to it from outside
+	tb.ircd.mu.Lock()
+	tb.ircd.broadcast(testChannel, "", ":mallory!m@example.com PRIVMSG %s :!get 1", testChannel)
+	tb.ircd.mu.Unlock()
+	waitFor(t, "not in the channel", func() bool {
+		return tb.ircd.HasReceived("listener", "NOTICE mallory :There is no file 1 in "+testChannel+". Only the last 50 files from Discord in each channel can be fetched.")
+	})
+
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :!get 2")
+	waitFor(t, "no such file", func() bool {
+		return tb.ircd.HasReceived("listener", "NOTICE alice :There is no file 2 in "+testChannel+". Only the last 50 files from Discord in each channel can be fetched.")
+	})
+
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :!get 1")
+	var offer string
+	waitFor(t, "dcc offer", func() bool {
+		for _, line := range tb.ircd.Received("listener") {
+			if strings.HasPrefix(line, "PRIVMSG alice :\x01DCC SEND notes_final.txt 2130706433 ") {
+				offer = line
+				return true
+			}
+		}
+		return false
+	})
+
+	fields := strings.Fields(strings.Trim(offer, "\x01"))
+	assert.Equal(t, "13", fields[len(fields)-1])
+	conn, err := net.Dial("tcp", "127.0.0.1:"+fields[len(fields)-2])
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	received, err := ioutil.ReadAll(conn)
+	assert.NoError(t, err)
+	assert.Equal(t, "file contents", string(received))
+}
+
+func TestDCCAddress(t *testing.T) {
+	assert.Equal(t, "3232235777", dccAddress("192.168.1.1"))
+	assert.Equal(t, "fd00::1", dccAddress("fd00::1"))
+	assert.Error(t, validateDCCHost("bridge.example.com"))
+	assert.NoError(t, validateDCCHost(""))
+}
+
+func TestDCCFiles(t *testing.T) {
+	var files dccFiles
+	assert.Equal(t, 1, files.add("#a", dccFile{Name: "a1"}))
+	assert.Equal(t, 1, files.add("#B", dccFile{Name: "b1"}))
+	assert.Equal(t, 2, files.add("#a", dccFile{Name: "a2"}))
+
+	file, ok := files.get("#b", 1)
+	assert.True(t, ok)
+	assert.Equal(t, "b1", file.Name)
+	_, ok = files.get("#b", 2)
+	assert.False(t, ok)
+
+	// Offers are limited for each nick and overall
+	for n := 0; n < dccOffersPerNick; n++ {
+		assert.True(t, files.startOffer("alice"))
+	}
+	assert.False(t, files.startOffer("Alice"))
+	files.endOffer("alice")
+	assert.True(t, files.startOffer("alice"))
+
+	for n := dccOffersPerNick; n < dccOffers; n++ {
+		assert.True(t, files.startOffer(fmt.Sprint("nick", n)))
+	}
+	assert.False(t, files.startOffer("bob"))
+}
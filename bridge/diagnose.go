@@ -0,0 +1,168 @@
+package bridge
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	irc "github.com/qaisjp/go-ircevent"
+	log "github.com/sirupsen/logrus"
+)
+
+// diagnoseDelay is how long to wait after starting before logging the diagnostics,
+// so that the listener has had a chance to join its channels.
+var diagnoseDelay = time.Second * 30
+
+// requiredPermissions are the Discord permissions the bot needs in every mapped channel
+var requiredPermissions = []struct {
+	permission int64
+	name       string
+}{
+	{discordgo.PermissionViewChannel, "View Channel"},
+	{discordgo.PermissionSendMessages, "Send Messages"},
+	{discordgo.PermissionManageWebhooks, "Manage Webhooks"},
+	{discordgo.PermissionReadMessageHistory, "Read Message History"},
+}
+
+// Application flags telling us which privileged intents are enabled for the bot.
+// See https://discord.com/developers/docs/resources/application#application-object-application-flags
+const (
+	appFlagGatewayPresence              = 1 << 12
+	appFlagGatewayPresenceLimited       = 1 << 13
+	appFlagGatewayGuildMembers          = 1 << 14
+	appFlagGatewayGuildMembersLimited   = 1 << 15
+	appFlagGatewayMessageContent        = 1 << 18
+	appFlagGatewayMessageContentLimited = 1 << 19
+)
+
+// diagnose checks that the bridge can do its job in every mapped channel,
+// and returns a report with one line per problem found.
+func (b *Bridge) diagnose() []string {
+	problems := b.discord.diagnoseIntents()
+
+	current := b.channelMappings()
+	mappings := make([]*Mapping, len(current))
+	copy(mappings, current)
+	sort.Slice(mappings, func(i, j int) bool {
+		return mappings[i].IRCChannel < mappings[j].IRCChannel
+	})
+
+	for _, mapping := range mappings {
+		ircChannel := strings.Split(mapping.IRCChannel, " ")[0]
+
+		for _, problem := range b.discord.diagnoseChannel(mapping.DiscordChannel) {
+			problems = append(problems, fmt.Sprintf("%s: Discord channel %s: %s", ircChannel, mapping.DiscordChannel, problem))
+		}
+
+		if problem := b.ircListener.diagnoseChannel(ircChannel); problem != "" {
+			problems = append(problems, fmt.Sprintf("%s: IRC listener %s", ircChannel, problem))
+		}
+	}
+
+	if len(problems) == 0 {
+		return []string{fmt.Sprintf("All %d mapped channels look good.", len(mappings))}
+	}
+	return problems
+}
+
+// logDiagnostics logs the diagnostics report, once the bridge has had time to connect.
+func (b *Bridge) logDiagnostics() {
+	time.Sleep(diagnoseDelay)
+
+	problems := b.diagnose()
+	if len(problems) == 1 && strings.HasPrefix(problems[0], "All ") {
+		log.Infoln(problems[0])
+		return
+	}
+
+	for _, problem := range problems {
+		log.WithField("category", errPermission.String()).Warnln("diagnostics: " + problem)
+	}
+}
+
+// diagnoseIntents checks that the privileged intents used by the bridge are enabled.
+func (d *discordBot) diagnoseIntents() []string {
+	app, err := d.Application("@me")
+	if err != nil {
+		return []string{fmt.Sprintf("could not check privileged intents: %s", err)}
+	}
+
+	problems := []string{}
+	check := func(flags int, intent string) {
+		if app.Flags&flags == 0 {
+			problems = append(problems, fmt.Sprintf("the %s privileged intent is not enabled in the developer portal", intent))
+		}
+	}
+
+	check(appFlagGatewayGuildMembers|appFlagGatewayGuildMembersLimited, "Server Members")
+	if handlesEvents(d.bridge.Config, "presences") {
+		check(appFlagGatewayPresence|appFlagGatewayPresenceLimited, "Presence")
+	}
+	check(appFlagGatewayMessageContent|appFlagGatewayMessageContentLimited, "Message Content")
+
+	return problems
+}
+
+// diagnoseChannel returns the problems the bot has in a Discord channel.
+func (d *discordBot) diagnoseChannel(channelID string) []string {
+	if _, err := d.State.Channel(channelID); err != nil {
+		return []string{"channel does not exist, or the bot can't see it"}
+	}
+
+	perms, err := d.State.UserChannelPermissions(d.State.User.ID, channelID)
+	if err != nil {
+		return []string{fmt.Sprintf("could not work out permissions: %s", err)}
+	}
+
+	if perms&discordgo.PermissionAdministrator != 0 {
+		return nil
+	}
+
+	missing := []string{}
+	for _, p := range requiredPermissions {
+		if perms&p.permission == 0 {
+			missing = append(missing, p.name)
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+	return []string{"missing the " + strings.Join(missing, ", ") + " permission"}
+}
+
+// diagnoseChannel returns the reason the listener is not in an IRC channel,
+// or an empty string if it is.
+func (i *ircListener) diagnoseChannel(channel string) string {
+	if _, ok := i.users.Prefixes(channel, i.GetNick()); ok {
+		return ""
+	}
+
+	i.joinErrorsMu.Lock()
+	defer i.joinErrorsMu.Unlock()
+
+	if reason, ok := i.joinErrors[strings.ToLower(channel)]; ok {
+		return "could not join: " + reason
+	}
+	return "has not joined"
+}
+
+// OnJoinError records why the listener could not join a channel, for diagnostics.
+// "<nick> <channel> :<reason>"
+func (i *ircListener) OnJoinError(e *irc.Event) {
+	if len(e.Arguments) < 2 {
+		return
+	}
+
+	reason := e.Message()
+	log.WithFields(log.Fields{
+		"channel": e.Arguments[1],
+		"reason":  reason,
+	}).Warnln("Listener could not join IRC channel.")
+
+	i.joinErrorsMu.Lock()
+	i.joinErrors[strings.ToLower(e.Arguments[1])] = reason
+	i.joinErrorsMu.Unlock()
+}
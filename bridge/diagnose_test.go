@@ -0,0 +1,35 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiagnoseChannel(t *testing.T) {
+	d := newFuzzDiscord(t)
+	d.State.User = &discordgo.User{ID: testBotID}
+
+	guild, _ := d.State.Guild(testGuildID)
+	guild.Roles = append(guild.Roles, &discordgo.Role{
+		ID:          testGuildID, // @everyone
+		Permissions: discordgo.PermissionViewChannel | discordgo.PermissionSendMessages,
+	})
+	assert.NoError(t, d.State.MemberAdd(&discordgo.Member{GuildID: testGuildID, User: d.State.User}))
+
+	assert.Equal(t, []string{"missing the Manage Webhooks, Read Message History permission"}, d.diagnoseChannel(testChannelID))
+	assert.Equal(t, []string{"channel does not exist, or the bot can't see it"}, d.diagnoseChannel("404"))
+
+	guild.Roles = append(guild.Roles, &discordgo.Role{ID: "4001", Permissions: discordgo.PermissionAdministrator})
+	assert.NoError(t, d.State.MemberAdd(&discordgo.Member{GuildID: testGuildID, User: d.State.User, Roles: []string{"4001"}}))
+	assert.Empty(t, d.diagnoseChannel(testChannelID))
+}
+
+func TestDiagnoseListener(t *testing.T) {
+	tb := newTestBridge(t, nil)
+	defer tb.Close()
+
+	assert.Equal(t, "", tb.ircListener.diagnoseChannel(testChannel))
+	assert.Equal(t, "has not joined", tb.ircListener.diagnoseChannel("#elsewhere"))
+}
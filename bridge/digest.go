@@ -0,0 +1,220 @@
+package bridge
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// digestBucket is the store bucket used to remember when the last digest was posted
+const digestBucket = "digest"
+
+// digestTopUsers is the number of most active users listed in a digest
+var digestTopUsers = 5
+
+// activity counts what the bridge has relayed since the last digest.
+//
+// Errors logged anywhere are counted too, through activityHooks. It is safe for concurrent use.
+type activity struct {
+	mu sync.Mutex
+
+	since     time.Time
+	toDiscord map[string]int    // keyed by IRC channel
+	toIRC     map[string]int    // keyed by IRC channel
+	users     map[string]int    // keyed like karma, by nick on IRC and ID on Discord
+	names     map[string]string // the latest name of each user, e.g. "bob (Discord)"
+	errors    int
+	panics    int
+}
+
+// activityHooks are the activities of the open bridges. Hooks can't be removed from
+// logrus, so it has this one hook, which passes errors on to each of them.
+var activityHooks = &activityHook{activities: make(map[*activity]bool)}
+
+type activityHook struct {
+	mu         sync.Mutex
+	once       sync.Once
+	activities map[*activity]bool
+}
+
+// add starts counting logged errors in an activity.
+func (h *activityHook) add(a *activity) {
+	h.once.Do(func() { log.AddHook(h) })
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.activities[a] = true
+}
+
+// remove stops counting logged errors in an activity, when its bridge closes.
+func (h *activityHook) remove(a *activity) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.activities, a)
+}
+
+// Levels implements logrus.Hook
+func (h *activityHook) Levels() []log.Level {
+	return []log.Level{log.PanicLevel, log.FatalLevel, log.ErrorLevel}
+}
+
+// Fire implements logrus.Hook
+func (h *activityHook) Fire(e *log.Entry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for a := range h.activities {
+		a.Fire(e)
+	}
+	return nil
+}
+
+func newActivity() *activity {
+	a := &activity{}
+	a.reset()
+	return a
+}
+
+func (a *activity) reset() {
+	a.since = time.Now()
+	a.toDiscord = make(map[string]int)
+	a.toIRC = make(map[string]int)
+	a.users = make(map[string]int)
+	a.names = make(map[string]string)
+	a.errors = 0
+	a.panics = 0
+}
+
+// RelayedToDiscord counts a message relayed from IRC.
+func (a *activity) RelayedToDiscord(ircChannel, nick string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.toDiscord[ircChannel]++
+	a.users["irc:"+nick]++
+	a.names["irc:"+nick] = nick + " (IRC)"
+}
+
+// RelayedToIRC counts a message relayed from Discord. Discord users are counted by ID,
+// so their messages are counted together if they change their name.
+func (a *activity) RelayedToIRC(ircChannel, id, username string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.toIRC[strings.Split(ircChannel, " ")[0]]++
+	a.users[karmaKeyDiscord(id)]++
+	a.names[karmaKeyDiscord(id)] = username + " (Discord)"
+}
+
+// Panicked counts a panic recovered in an event handler.
+func (a *activity) Panicked() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.panics++
+}
+
+// Fire counts a logged error.
+func (a *activity) Fire(*log.Entry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.errors++
+	return nil
+}
+
+// Digest returns a summary of the activity, and starts counting again.
+func (a *activity) Digest() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	lines := []string{
+		fmt.Sprintf("Bridge activity since %s:", a.since.UTC().Format("2006-01-02 15:04 MST")),
+	}
+
+	channels := map[string]bool{}
+	for channel := range a.toDiscord {
+		channels[channel] = true
+	}
+	for channel := range a.toIRC {
+		channels[channel] = true
+	}
+	names := make([]string, 0, len(channels))
+	for channel := range channels {
+		names = append(names, channel)
+	}
+	sort.Strings(names)
+
+	if len(names) == 0 {
+		lines = append(lines, "No messages were relayed.")
+	}
+	for _, channel := range names {
+		lines = append(lines, fmt.Sprintf("%s: %d from IRC, %d from Discord", channel, a.toDiscord[channel], a.toIRC[channel]))
+	}
+
+	users := make([]string, 0, len(a.users))
+	for user := range a.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if a.users[users[i]] != a.users[users[j]] {
+			return a.users[users[i]] > a.users[users[j]]
+		}
+		return a.names[users[i]] < a.names[users[j]]
+	})
+	if len(users) > digestTopUsers {
+		users = users[:digestTopUsers]
+	}
+	for i, user := range users {
+		users[i] = fmt.Sprintf("%s %d", a.names[user], a.users[user])
+	}
+	if len(users) > 0 {
+		lines = append(lines, "Most active: "+strings.Join(users, ", "))
+	}
+
+	errors := fmt.Sprintf("Errors: %d", a.errors)
+	if a.panics > 0 {
+		errors += fmt.Sprintf(", including %d panics", a.panics)
+	}
+	lines = append(lines, errors)
+
+	a.reset()
+	return lines
+}
+
+// nextDigest returns how long to wait until the next digest should be posted.
+func (b *Bridge) nextDigest() time.Duration {
+	var last time.Time
+	if _, err := b.store.Get(digestBucket, "last", &last); err != nil {
+		log.WithField("error", err).Warnln("could not read time of last digest")
+	}
+
+	if last.IsZero() {
+		return b.Config.DigestInterval
+	}
+
+	wait := b.Config.DigestInterval - time.Since(last)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// postDigest posts the activity digest to the configured Discord and IRC channels.
+func (b *Bridge) postDigest() {
+	lines := b.activity.Digest()
+
+	if err := b.store.Put(digestBucket, "last", time.Now()); err != nil {
+		log.WithField("error", err).Warnln("could not save time of last digest")
+	}
+
+	if channel := b.Config.DigestDiscordChannel; channel != "" {
+		if _, err := b.discord.ChannelMessageSend(channel, strings.Join(lines, "\n")); err != nil {
+			b.handleError(err, nil, "could not post digest to discord")
+		}
+	}
+
+	if channel := b.Config.DigestIRCChannel; channel != "" {
+		for _, line := range lines {
+			b.ircListener.Notice(channel, line)
+		}
+	}
+}
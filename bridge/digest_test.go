@@ -0,0 +1,50 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActivityDigest(t *testing.T) {
+	a := newActivity()
+	a.RelayedToDiscord("#chan", "alice")
+	a.RelayedToDiscord("#chan", "alice")
+	a.RelayedToIRC("#chan key", "100", "bobby")
+	a.RelayedToIRC("#other", "100", "bob")
+	a.RelayedToIRC("#other", "100", "bob")
+	assert.NoError(t, a.Fire(nil))
+
+	lines := a.Digest()
+	assert.Equal(t, []string{
+		"#chan: 2 from IRC, 1 from Discord",
+		"#other: 0 from IRC, 2 from Discord",
+		"Most active: bob (Discord) 3, alice (IRC) 2",
+		"Errors: 1",
+	}, lines[1:])
+
+	lines = a.Digest()
+	assert.Equal(t, []string{"No messages were relayed.", "Errors: 0"}, lines[1:])
+}
+
+func TestActivityDigestPanics(t *testing.T) {
+	a := newActivity()
+	a.Panicked()
+	assert.NoError(t, a.Fire(nil))
+
+	lines := a.Digest()
+	assert.Equal(t, "Errors: 1, including 1 panics", lines[len(lines)-1])
+}
+
+func TestActivityHookRemovedOnClose(t *testing.T) {
+	tb := newTestBridge(t, nil)
+	a := tb.Bridge.activity
+	activityHooks.mu.Lock()
+	assert.True(t, activityHooks.activities[a])
+	activityHooks.mu.Unlock()
+
+	tb.Close()
+	activityHooks.mu.Lock()
+	assert.False(t, activityHooks.activities[a])
+	activityHooks.mu.Unlock()
+}
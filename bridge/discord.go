@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/hashicorp/go-multierror"
 	ircnick "github.com/qaisjp/go-discord-irc/irc/nick"
@@ -20,7 +22,18 @@ type discordBot struct {
 
 	guildID string
 
-	transmitter *transmitter.Transmitter
+	// transmitter is replaced when failing over, so it is read with getTransmitter
+	transmitterMu sync.RWMutex
+	transmitter   *transmitter.Transmitter
+
+	// failover is which bot token is in use, and whether it is time to try the other
+	failover tokenFailover
+
+	// recent is when Discord users were last active, if their presence is inferred from it
+	recent recentActivity
+
+	// emoji is the guild's custom emoji, for shortcodes typed on IRC
+	emoji emojiCatalog
 }
 
 func newDiscord(bridge *Bridge, botToken, guildID string) (*discordBot, error) {
@@ -31,6 +44,9 @@ func newDiscord(bridge *Bridge, botToken, guildID string) (*discordBot, error) {
 		return nil, errors.Wrap(err, "discord, could not create new session")
 	}
 	session.StateEnabled = true
+	if bridge.Config.Shadow {
+		session.Client.Transport = shadowTransport{next: session.Client.Transport}
+	}
 
 	discord := &discordBot{
 		Session: session,
@@ -38,53 +54,154 @@ func newDiscord(bridge *Bridge, botToken, guildID string) (*discordBot, error) {
 
 		guildID: guildID,
 	}
-
-	// These events are all fired in separate goroutines
-	discord.AddHandler(discord.OnReady)
-	discord.AddHandler(discord.onMessageCreate)
-	discord.AddHandler(discord.onMessageUpdate)
+	discord.filterDiscordEvents()
+
+	// These events are all fired in separate goroutines,
+	// and a panic in any of them is recovered.
+	// Standbys register commands and fail over to the other bot token too, but ignore everything else.
+	discord.AddHandler(discord.recoverHandler(discord.OnReady))
+	discord.AddHandler(discord.recoverHandler(discord.onFailoverConnect))
+	discord.AddHandler(discord.recoverHandler(discord.onFailoverDisconnect))
+	if bridge.recorder != nil {
+		discord.AddHandler(discord.recordDiscord)
+	}
+	discord.addHandler(discord.onGatewayConnect)
+	discord.addHandler(discord.onGatewayDisconnect)
+	discord.addHandler(discord.onMessageCreate)
+	discord.addHandler(discord.onMessageUpdate)
+	discord.addHandler(discord.onMessageDelete)
+	discord.addHandler(discord.onMessageDeleteBulk)
+	discord.addHandler(discord.onInteractionCreate)
+	discord.addHandler(discord.onAuditLogEntry)
+	discord.addHandler(discord.onMemberJoin)
+	discord.addHandler(discord.onChannelUpdate)
+	discord.addHandler(discord.onChannelPinsUpdate)
+	discord.addHandler(discord.onStageStart)
+	discord.addHandler(discord.onStageUpdate)
+	discord.addHandler(discord.onStageEnd)
+	discord.addHandler(discord.onEmojisUpdate)
+	discord.addHandler(discord.onReactionAdd)
+	discord.addHandler(discord.onReactionRemove)
+	discord.addHandler(discord.onKarmaReactionAdd)
+	discord.addHandler(discord.onKarmaReactionRemove)
 
 	if !bridge.Config.SimpleMode {
-		discord.AddHandler(discord.onMemberListChunk)
-		discord.AddHandler(discord.onMemberUpdate)
-		discord.AddHandler(discord.onMemberLeave)
-		discord.AddHandler(discord.OnPresencesReplace)
-		discord.AddHandler(discord.OnPresenceUpdate)
-		discord.AddHandler(discord.OnTypingStart)
+		discord.addHandler(discord.onMemberListChunk)
+		discord.addHandler(discord.onMemberUpdate)
+		discord.addHandler(discord.onMemberLeave)
+		discord.addHandler(discord.OnPresencesReplace)
+		discord.addHandler(discord.OnPresenceUpdate)
+		discord.addHandler(discord.OnTypingStart)
 	}
 
 	return discord, nil
 }
 
 func (d *discordBot) Open() error {
-	err := d.Session.Open()
+	err := d.openSession()
 	if err != nil {
 		return errors.Wrap(err, "discord, could not open session")
 	}
 
-	d.transmitter, err = transmitter.New(d.Session, d.guildID, d.bridge.Config.WebhookPrefix, d.bridge.Config.WebhookLimit)
+	return d.openTransmitter()
+}
+
+// openTransmitter takes over the bridge's webhooks, deleting any left behind by another instance.
+// The transmitter it replaces, if any, is closed.
+func (d *discordBot) openTransmitter() error {
+	t, err := transmitter.New(d.Session, d.guildID, d.bridge.Config.WebhookPrefix, d.bridge.Config.WebhookLimit)
 	if err != nil {
 		return errors.Wrap(err, "could not create transmitter")
 	}
 
+	d.transmitterMu.Lock()
+	old := d.transmitter
+	d.transmitter = t
+	d.transmitterMu.Unlock()
+
+	// Its webhook is usually gone already, as New deletes them all
+	if old != nil {
+		if err := old.Close(); err != nil {
+			log.WithError(err).Debugln("Could not close the previous transmitter.")
+		}
+	}
 	return nil
 }
 
+// getTransmitter returns the transmitter in use, or nil on a standby.
+func (d *discordBot) getTransmitter() *transmitter.Transmitter {
+	d.transmitterMu.RLock()
+	defer d.transmitterMu.RUnlock()
+	return d.transmitter
+}
+
 func (d *discordBot) Close() error {
+	// Standbys never took over the webhooks
+	t := d.getTransmitter()
+	if t == nil {
+		return d.Session.Close()
+	}
+
 	return multierror.Append(
-		d.transmitter.Close(),
+		t.Close(),
 		d.Session.Close(),
 	).ErrorOrNil()
 }
 
 func (d *discordBot) onMessageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
+	// Messages relayed from IRC are recorded when they are sent
+	fromBridge := m.Author != nil && (m.Author.ID == d.getTransmitter().GetID() || (s.State.User != nil && m.Author.ID == s.State.User.ID))
+
+	if m.Author != nil && !fromBridge {
+		d.bridge.messages.Add(&relayedMessage{
+			DiscordChannel:  m.ChannelID,
+			DiscordID:       m.ID,
+			DiscordAuthorID: m.Author.ID,
+			DiscordAuthor:   m.Author.Username,
+			Content:         m.Content,
+			Time:            time.Now(),
+		})
+
+		if m.GuildID == d.guildID && !m.Author.Bot && m.WebhookID == "" {
+			d.markActive(m.Author.ID)
+		}
+	}
+
 	d.publishMessage(s, m.Message, false)
 }
 
 func (d *discordBot) onMessageUpdate(s *discordgo.Session, m *discordgo.MessageUpdate) {
+	if !d.contentChanged(m) {
+		return
+	}
+
 	d.publishMessage(s, m.Message, true)
 }
 
+// contentChanged returns true if the text of a message has changed, so
+// updates that only add embeds (link previews) or remove attachments are not relayed as edits.
+func (d *discordBot) contentChanged(m *discordgo.MessageUpdate) bool {
+	previous := d.bridge.messages.ByDiscordID(m.ID)
+	if previous == nil {
+		if m.BeforeUpdate == nil {
+			// We don't know what it said before, so assume it changed
+			return true
+		}
+		return m.BeforeUpdate.Content != m.Content
+	}
+
+	// Updates without content don't change the content
+	if m.Content == "" || m.Content == previous.Content {
+		return false
+	}
+
+	d.bridge.messages.SetContent(previous, m.Content)
+	return true
+}
+
+// editMarker is put in front of edits relayed to IRC
+const editMarker = "[edit]: "
+
 func (d *discordBot) publishMessage(s *discordgo.Session, m *discordgo.Message, wasEdit bool) {
 	// Fix crash if these fields don't exist
 	if m.Author == nil || s.State.User == nil {
@@ -92,25 +209,119 @@ func (d *discordBot) publishMessage(s *discordgo.Session, m *discordgo.Message,
 		return
 	}
 
+	// Latency probes come back from Discord to be timed
+	if !wasEdit && d.probeArrived(m) {
+		return
+	}
+
 	// Ignore all messages created by the bot itself
 	if m.Author.ID == s.State.User.ID {
 		return
 	}
 
 	// Ignore messages sent from our webhooks
-	if d.transmitter.GetID() == m.Author.ID {
+	if d.getTransmitter().GetID() == m.Author.ID {
 		return
 	}
 
-	// If the message is "ping" reply with "Pong!"
-	if m.Content == "ping" {
-		_, err := s.ChannelMessageSend(m.ChannelID, "Pong!")
-		if err != nil {
-			log.Warningln("Could not respond to Discord ping message", err.Error())
+	// Fetching link titles takes a while, so later messages in the channel wait for this one
+	place := d.bridge.relayOrder.next(m.ChannelID)
+	defer place.done()
+
+	// Ignore messages from other relay bots, including messages
+	// relayed by other instances of this bridge
+	if d.bridge.isRelayBotDiscord(m.Author.ID, m.WebhookID) || strings.HasSuffix(m.Content, relayMarker) {
+		return
+	}
+
+	// Avoid parsing messages that have nowhere to go. DMs are handled below.
+	if m.GuildID != "" && d.bridge.GetMappingByDiscord(m.ChannelID) == nil {
+		d.bridge.unmappedMessage("discord", m.ChannelID)
+		return
+	}
+
+	where := "a DM"
+	if m.GuildID != "" {
+		where = "#" + d.channelName(m.ChannelID)
+	}
+	trace := d.bridge.traces.Start("discord", karmaKeyDiscord(m.Author.ID), m.Author.Username+" in "+where, m.ID)
+	if wasEdit {
+		d.bridge.traces.Step(trace, traceReceive, "an edit of message %s", m.ID)
+	}
+
+	if wasEdit && !d.relayEdit(m) {
+		d.bridge.traces.Step(trace, traceFilter, "dropped: edits aren't relayed")
+		return
+	}
+
+	if m.WebhookID != "" && !d.allowWebhook(m) {
+		d.bridge.traces.Step(trace, traceFilter, "dropped: the webhook isn't allowed")
+		return
+	}
+
+	if d.dropBot(m) {
+		d.bridge.traces.Step(trace, traceFilter, "dropped: sent by a bot")
+		return
+	}
+
+	if d.unscreened(m) {
+		d.bridge.traces.Step(trace, traceFilter, "dropped: %s hasn't completed membership screening", m.Author.Username)
+		return
+	}
+
+	// Bots echoing what we relayed from IRC would cause duplicates on IRC
+	if (m.Author.Bot || m.WebhookID != "") && d.bridge.relayedToDiscord.Seen(m.Content) {
+		d.bridge.traces.Step(trace, traceFilter, "dropped: echo of a message relayed from IRC")
+		return
+	}
+
+	// System messages (pins, joins, boosts) are rendered separately
+	if isSystemMessage(m) {
+		if !wasEdit {
+			d.publishSystemMessage(s, m)
+		}
+		d.bridge.traces.Step(trace, traceSend, "relayed as a system message")
+		return
+	}
+
+	d.topicFromMarker(m)
+	if d.bridge.Config.Karma && !wasEdit {
+		d.countKarma(m)
+	}
+
+	// Neither are operator commands and bridge commands
+	if !wasEdit && (d.runOperator(m) || d.runCommand(m)) {
+		d.bridge.traces.Step(trace, traceFilter, "ran as a bridge command")
+		return
+	}
+
+	// People can opt out of being relayed
+	if d.bridge.optedOut(karmaKeyDiscord(m.Author.ID)) {
+		if mapping := d.bridge.GetMappingByDiscord(m.ChannelID); mapping != nil && !wasEdit {
+			d.bridge.withheld(mapping, true)
 		}
+		d.bridge.traces.Step(trace, traceFilter, "dropped: %s opted out", m.Author.Username)
+		return
 	}
 
-	content := d.ParseText(m)
+	channel := "" // DMs aren't in a bridged channel
+	if mapping := d.bridge.GetMappingByDiscord(m.ChannelID); mapping != nil {
+		channel = mapping.IRCName()
+	}
+	// Actions are found before formatting, which would turn them into italics
+	text, isAction := parseAction(d.ParseText(m))
+	content := d.bridge.formatToIRC(channel, text)
+
+	// Third-party webhooks (GitHub, CI) usually only send embeds
+	if m.WebhookID != "" {
+		if embeds := flattenEmbeds(m.Embeds); embeds != "" {
+			if content == "" {
+				content = embeds
+			} else {
+				content += "\n" + embeds
+			}
+		}
+	}
 
 	// Special Mee6 behaviour
 	if m.Author.ID == "159985870458322944" {
@@ -122,14 +333,16 @@ func (d *discordBot) publishMessage(s *discordgo.Session, m *discordgo.Message,
 		)
 	}
 
-	// The content is an action if it matches "_(.+)_"
-	isAction := len(content) > 2 &&
-		m.Content[0] == '_' &&
-		m.Content[len(content)-1] == '_'
+	if source := d.crosspostSource(m); source != "" {
+		content = fmt.Sprintf("[announcement from %s] %s", source, content)
+	}
 
-	// If it is an action, remove the enclosing underscores
-	if isAction {
-		content = content[1 : len(m.Content)-1]
+	if prefix := d.interactionPrefix(m); prefix != "" {
+		if content == "" {
+			content = prefix
+		} else {
+			content = prefix + ": " + content
+		}
 	}
 
 	if wasEdit {
@@ -137,7 +350,14 @@ func (d *discordBot) publishMessage(s *discordgo.Session, m *discordgo.Message,
 			content = "/me " + content
 		}
 
-		content = "[edit]: " + content
+		content = editMarker + content
+	}
+
+	// Identity links are requested in a DM to the bot
+	if m.GuildID == "" && strings.TrimSpace(m.Content) == "!link" {
+		d.handleLink(m)
+		d.bridge.traces.Step(trace, traceFilter, "ran as a link request")
+		return
 	}
 
 	pmTarget := ""
@@ -145,28 +365,59 @@ func (d *discordBot) publishMessage(s *discordgo.Session, m *discordgo.Message,
 		if channel.ID == m.ChannelID {
 			pmTarget, content = pmTargetFromContent(content)
 
-			// if the target could not be deduced. tell them this.
+			// if the target could not be deduced, explain how the bridge works
 			if pmTarget == "" {
-				d.ChannelMessageSend(m.ChannelID, "Don't know who that is. Can't PM. Try 'name, message here'")
+				d.autoRespondDiscord(m)
+				d.bridge.traces.Step(trace, traceFilter, "dropped: the DM doesn't say who it is for")
 				return
 			}
 			break
 		}
 	}
 
+	// Redirected messages are relayed as if they were sent in the channel for their language
+	relayed := m
+	if pmTarget == "" {
+		relayed, content = d.applyLanguagePolicy(m, content)
+	}
+
+	if d.bridge.Config.LinkTitlesToIRC && pmTarget == "" {
+		content = d.appendLinkTitles(m, content)
+	}
+
+	// Long messages are truncated before relaying, so that the paste is uploaded here
+	// rather than holding up the relay loop
+	if mapping := d.bridge.GetMappingByDiscord(relayed.ChannelID); mapping != nil && pmTarget == "" {
+		if budgeted := d.bridge.applyBudget(mapping.IRCChannel, m.Author.ID, content); budgeted != content {
+			d.bridge.traces.Step(trace, traceRateLimit, "cut short by the limits in %s", mapping.IRCChannel)
+			content = budgeted
+		}
+	}
+	d.bridge.traces.Step(trace, traceFormat, "formatted: %q", content)
+
+	place.wait()
 	d.bridge.discordMessageEventsChan <- &DiscordMessage{
-		Message:  m,
+		Message:  relayed,
 		Content:  content,
 		IsAction: isAction,
 		PmTarget: pmTarget,
+		IsEdit:   wasEdit,
+		TraceID:  trace,
 	}
 
+	descriptions := d.describeImages(m)
 	for _, attachment := range m.Attachments {
+		content := d.dccAttachment(attachment, relayed.ChannelID, pmTarget)
+		if description := descriptions[attachment.ID]; description != "" {
+			content = "[image: " + description + "] " + content
+		}
+
 		d.bridge.discordMessageEventsChan <- &DiscordMessage{
-			Message:  m,
-			Content:  attachment.URL,
+			Message:  relayed,
+			Content:  content,
 			IsAction: isAction,
 			PmTarget: pmTarget,
+			TraceID:  trace,
 		}
 	}
 }
@@ -178,7 +429,11 @@ func (d *discordBot) publishReaction(s *discordgo.Session, r *discordgo.MessageR
 
 	user, err := s.User(r.UserID)
 	if err != nil {
-		log.Errorln(err)
+		d.bridge.handleError(err, nil, "could not get user who reacted")
+		return
+	}
+
+	if d.bridge.optedOut(karmaKeyDiscord(user.ID)) {
 		return
 	}
 
@@ -215,63 +470,133 @@ func (d *discordBot) publishReaction(s *discordgo.Session, r *discordgo.MessageR
 	}
 }
 
+// ircNick returns the nick a Discord user has (or would have) on IRC
+func (d *discordBot) ircNick(user *discordgo.User) string {
+	if nick, ok := d.bridge.mentions.Get(user.ID); ok {
+		return nick
+	}
+
+	// Find the irc username with the discord ID in irc connections
+	username := ""
+	if con, ok := d.bridge.ircManager.connection(user.ID); ok {
+		username = con.Nick()
+	}
+
+	if username != "" {
+		log.WithFields(log.Fields{
+			"discord-username": user.Username,
+			"irc-username":     username,
+			"discord-id":       user.ID,
+		}).Debugln("Converted mention using existing IRC connection")
+		d.bridge.mentions.Put(user.ID, username)
+		return username
+	}
+
+	// Nickname is their username by default
+	nick := user.Username
+
+	// If we can get their member + nick, set nick to the real nick
+	member, err := d.State.Member(d.guildID, user.ID)
+	if err == nil && member.Nick != "" {
+		nick = member.Nick
+	}
+
+	username = d.bridge.ircManager.generateNickname(DiscordUser{
+		ID:            user.ID,
+		Username:      user.Username,
+		Discriminator: user.Discriminator,
+		Nick:          nick,
+		Bot:           user.Bot,
+		Online:        false,
+	})
+
+	log.WithFields(log.Fields{
+		"discord-username": user.Username,
+		"irc-username":     username,
+		"discord-id":       user.ID,
+	}).Debugln("Could not convert mention using existing IRC connection")
+
+	// Nicks can't be generated before the guild is in the state
+	if username != "" {
+		d.bridge.mentions.Put(user.ID, username)
+	}
+	return username
+}
+
+// interactionPrefix describes the application command that created a message,
+// e.g. "alice used /roll". Returns an empty string for regular messages.
+func (d *discordBot) interactionPrefix(m *discordgo.Message) string {
+	if i := m.Interaction; i != nil && i.User != nil {
+		name := d.ircNick(i.User)
+
+		// Chat input commands are invoked with a slash, context menu commands are not
+		if m.Type == discordgo.MessageTypeContextMenuCommand {
+			return fmt.Sprintf("%s used %s", name, i.Name)
+		}
+		return fmt.Sprintf("%s used /%s", name, i.Name)
+	}
+
+	if i := m.InteractionMetadata; i != nil && i.User != nil {
+		return fmt.Sprintf("%s used a command", d.ircNick(i.User))
+	}
+
+	return ""
+}
+
+// crosspostSource returns the guild and channel name that a crossposted
+// (followed announcement channel) message came from.
+// Returns an empty string if the message was not crossposted.
+func (d *discordBot) crosspostSource(m *discordgo.Message) string {
+	if m.Flags&discordgo.MessageFlagsIsCrossPosted == 0 {
+		return ""
+	}
+
+	// The webhook used for crossposts is named "Guild #channel"
+	source := m.Author.Username
+
+	ref := m.MessageReference
+	if ref == nil {
+		return source
+	}
+
+	// We are not usually in the source guild, so these may fail
+	guild, err := d.Guild(ref.GuildID)
+	if err != nil {
+		return source
+	}
+
+	channel, err := d.Channel(ref.ChannelID)
+	if err != nil {
+		return guild.Name
+	}
+
+	return guild.Name + " #" + channel.Name
+}
+
 // Up to date as of https://git.io/v5kJg
 var channelMention = regexp.MustCompile(`<#(\d+)>`)
 var roleMention = regexp.MustCompile(`<@&(\d+)>`)
 
-var patternChannels = regexp.MustCompile("<#[^>]*>")
 var emoteRegex = regexp.MustCompile(`<a?(:\w+:)\d+>`)
 
+// newlines breaks down malformed newlines, replacing CRLF and CR with LF
+var newlines = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+
 // Up to date as of https://git.io/v5kJg
+//
+// Mentions are replaced in a single pass, and the patterns are only matched if there is any
+// markup in the message, as this runs for every message relayed to IRC.
 func (d *discordBot) ParseText(m *discordgo.Message) string {
-	// Replace @user mentions with name~d mentions
-	content := m.Content
+	content := newlines.Replace(m.Content)
+	if !strings.Contains(content, "<") {
+		return content
+	}
 
+	// Replace @user mentions with name~d mentions
+	replacements := make([]string, 0, 4*len(m.Mentions)+2*len(m.MentionRoles))
 	for _, user := range m.Mentions {
-		// Find the irc username with the discord ID in irc connections
-		username := ""
-		for _, u := range d.bridge.ircManager.ircConnections {
-			if u.discord.ID == user.ID {
-				username = u.nick
-			}
-		}
-
-		if username == "" {
-			// Nickname is their username by default
-			nick := user.Username
-
-			// If we can get their member + nick, set nick to the real nick
-			member, err := d.State.Member(d.guildID, user.ID)
-			if err == nil && member.Nick != "" {
-				nick = member.Nick
-			}
-
-			username = d.bridge.ircManager.generateNickname(DiscordUser{
-				ID:            user.ID,
-				Username:      user.Username,
-				Discriminator: user.Discriminator,
-				Nick:          nick,
-				Bot:           user.Bot,
-				Online:        false,
-			})
-
-			log.WithFields(log.Fields{
-				"discord-username": user.Username,
-				"irc-username":     username,
-				"discord-id":       user.ID,
-			}).Infoln("Could not convert mention using existing IRC connection")
-		} else {
-			log.WithFields(log.Fields{
-				"discord-username": user.Username,
-				"irc-username":     username,
-				"discord-id":       user.ID,
-			}).Infoln("Converted mention using existing IRC connection")
-		}
-
-		content = strings.NewReplacer(
-			"<@"+user.ID+">", username,
-			"<@!"+user.ID+">", username,
-		).Replace(content)
+		username := d.ircNick(user)
+		replacements = append(replacements, "<@"+user.ID+">", username, "<@!"+user.ID+">", username)
 	}
 
 	// Copied from message.go ContentWithMoreMentionsReplaced(s)
@@ -280,23 +605,12 @@ func (d *discordBot) ParseText(m *discordgo.Message) string {
 		if err != nil || !role.Mentionable {
 			continue
 		}
-
-		content = strings.Replace(content, "<&"+role.ID+">", "@"+role.Name, -1)
+		replacements = append(replacements, "<&"+role.ID+">", "@"+role.Name)
 	}
 
-	// Also copied from message.go ContentWithMoreMentionsReplaced(s)
-	content = patternChannels.ReplaceAllStringFunc(content, func(mention string) string {
-		channel, err := d.State.Channel(mention[2 : len(mention)-1])
-		if err != nil || channel.Type == discordgo.ChannelTypeGuildVoice {
-			return mention
-		}
-
-		return "#" + channel.Name
-	})
-
-	// Break down malformed newlines
-	content = strings.Replace(content, "\r\n", "\n", -1) // replace CRLF with LF
-	content = strings.Replace(content, "\r", "\n", -1)   // replace CR with LF
+	if len(replacements) > 0 {
+		content = strings.NewReplacer(replacements...).Replace(content)
+	}
 
 	// Replace <#xxxxx> channel mentions
 	content = channelMention.ReplaceAllStringFunc(content, func(str string) string {
@@ -310,7 +624,8 @@ func (d *discordBot) ParseText(m *discordgo.Message) string {
 			return "#deleted-channel"
 		}
 
-		panic(errors.Wrap(err, "Channel mention failed for "+str))
+		log.WithField("error", err).Warnln("channel mention failed for " + str)
+		return str
 	})
 
 	// Replace <@&xxxxx> role mentions
@@ -325,7 +640,8 @@ func (d *discordBot) ParseText(m *discordgo.Message) string {
 			return "@deleted-role"
 		}
 
-		panic(errors.Wrap(err, "Channel mention failed for "+str))
+		log.WithField("error", err).Warnln("role mention failed for " + str)
+		return str
 	})
 
 	// Replace emotes
@@ -341,11 +657,32 @@ func (d *discordBot) onMemberListChunk(s *discordgo.Session, m *discordgo.GuildM
 }
 
 func (d *discordBot) onMemberUpdate(s *discordgo.Session, m *discordgo.GuildMemberUpdate) {
+	if m.BeforeUpdate != nil && m.BeforeUpdate.User != nil {
+		d.announceRename(m.BeforeUpdate, m.Member)
+	}
 	d.handleMemberUpdate(m.Member, false)
 }
 
+// announceRename tells bridged IRC channels that a Discord member is now known by a different name,
+// if rename notices are turned on.
+func (d *discordBot) announceRename(before, after *discordgo.Member) {
+	if !d.bridge.Config.RenameNotices || after.User == nil || after.User.Bot {
+		return
+	}
+
+	oldName, newName := GetMemberNick(before), GetMemberNick(after)
+	if oldName == newName || d.bridge.optedOut(karmaKeyDiscord(after.User.ID)) {
+		return
+	}
+
+	for _, mapping := range d.bridge.channelMappings() {
+		d.bridge.ircListener.Notice(mapping.IRCName(), fmt.Sprintf("* %s is now known as %s", oldName, newName))
+	}
+}
+
 // onMemberLeave is triggered when a user is removed from a guild (leave/kick/ban).
 func (d *discordBot) onMemberLeave(s *discordgo.Session, m *discordgo.GuildMemberRemove) {
+	d.bridge.mentions.Invalidate(m.User.ID)
 	d.bridge.removeUserChan <- m.User.ID
 }
 
@@ -385,6 +722,13 @@ func (d *discordBot) handlePresenceUpdate(uid string, status discordgo.Status, f
 }
 
 func (d *discordBot) OnTypingStart(s *discordgo.Session, m *discordgo.TypingStart) {
+	if d.bridge.Config.PresenceFallback > 0 {
+		if m.GuildID == d.guildID {
+			d.markActive(m.UserID)
+		}
+		return
+	}
+
 	status := discordgo.StatusOffline
 
 	p, err := d.State.Presence(d.guildID, m.UserID)
@@ -400,7 +744,12 @@ func (d *discordBot) OnTypingStart(s *discordgo.Session, m *discordgo.TypingStar
 }
 
 func (d *discordBot) OnReady(s *discordgo.Session, m *discordgo.Ready) {
-	err := d.RequestGuildMembers(d.guildID, "", 0)
+	d.registerCommands()
+
+	// Reconnecting resets the bot's status
+	d.bridge.updateBotStatus(true)
+
+	err := d.RequestGuildMembers(d.guildID, "", 0, "", handlesEvents(d.bridge.Config, "presences"))
 	if err != nil {
 		log.Warningln(errors.Wrap(err, "could not request guild members").Error())
 		return
@@ -408,9 +757,15 @@ func (d *discordBot) OnReady(s *discordgo.Session, m *discordgo.Ready) {
 }
 
 func (d *discordBot) handleMemberUpdate(m *discordgo.Member, forceOnline bool) {
+	d.bridge.mentions.Invalidate(m.User.ID)
 	status := discordgo.StatusOnline
 
-	if !forceOnline {
+	if !forceOnline && d.bridge.Config.PresenceFallback > 0 {
+		// Without presences, people who haven't been active are offline
+		if !d.isActive(m.User.ID) {
+			return
+		}
+	} else if !forceOnline {
 		presence, err := d.State.Presence(d.guildID, m.User.ID)
 		if err != nil {
 			// This error is usually triggered on first run because it represents offline
@@ -486,6 +841,62 @@ func (d *discordBot) GetAvatar(guildID, username string) (_ string) {
 	return discordgo.EndpointUserAvatar(foundMember.User.ID, foundMember.User.Avatar)
 }
 
+// GetAvatarByID returns the avatar URL of a guild member, or an empty string if they are not in the guild.
+func (d *discordBot) GetAvatarByID(userID string) string {
+	member, err := d.State.Member(d.guildID, userID)
+	if err != nil {
+		return ""
+	}
+
+	return discordgo.EndpointUserAvatar(member.User.ID, member.User.Avatar)
+}
+
+// handleLink gives a Discord user a code to confirm an identity link on IRC.
+func (d *discordBot) handleLink(m *discordgo.Message) {
+	code, err := d.bridge.startLink(m.Author.ID)
+	if err != nil {
+		log.WithField("error", err).Errorln("could not create link code")
+		return
+	}
+
+	_, err = d.ChannelMessageSend(m.ChannelID, fmt.Sprintf(
+		"To link your IRC identity, send `/msg %s link %s` on IRC within %s. "+
+			"If you are identified with services, your account will be linked instead of your nick.",
+		d.bridge.Config.IRCListenerName, code, linkCodeExpiry,
+	))
+	if err != nil {
+		d.bridge.handleError(err, nil, "could not send link code")
+	}
+}
+
+// hasRequiredRole returns true if the author of a message has at least one of the roles.
+// If no roles are given, everyone is allowed.
+func (d *discordBot) hasRequiredRole(m *discordgo.Message, roles []string) bool {
+	if len(roles) == 0 {
+		return true
+	}
+
+	// Messages from the gateway include the member, but other events don't
+	member := m.Member
+	if member == nil {
+		var err error
+		member, err = d.State.Member(d.guildID, m.Author.ID)
+		if err != nil {
+			return false
+		}
+	}
+
+	for _, have := range member.Roles {
+		for _, want := range roles {
+			if have == want {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
 // GetMemberNick returns the real display name for a Discord GuildMember
 func GetMemberNick(m *discordgo.Member) string {
 	if m.Nick == "" {
@@ -495,6 +906,34 @@ func GetMemberNick(m *discordgo.Member) string {
 	return m.Nick
 }
 
+// relayEdit returns true if an edited message should be relayed to IRC.
+//
+// Updates without an edited timestamp are not edits (e.g. a link preview being added),
+// and edits made after the edit window has passed are not relayed.
+// Both times come from Discord, so the bridge's clock doesn't matter.
+func (d *discordBot) relayEdit(m *discordgo.Message) bool {
+	if m.EditedTimestamp == nil {
+		return false
+	}
+
+	window := d.bridge.Config.EditWindow
+	if window <= 0 {
+		return true
+	}
+
+	// MessageUpdate events don't always include the original timestamp
+	created := m.Timestamp
+	if created.IsZero() {
+		var err error
+		created, err = discordgo.SnowflakeTimestamp(m.ID)
+		if err != nil {
+			return true
+		}
+	}
+
+	return m.EditedTimestamp.Sub(created) <= window
+}
+
 // pmTargetFromContent returns an irc nick given a message sent to an IRC user via Discord
 //
 // Returns empty string if the nick could not be deduced.
@@ -520,3 +959,12 @@ func pmTargetFromContent(content string) (nick, newContent string) {
 
 	return
 }
+
+// parseAction returns true if the content is an action, which matches "_(.+)_",
+// and the content without the enclosing underscores.
+func parseAction(content string) (string, bool) {
+	if len(content) > 2 && content[0] == '_' && content[len(content)-1] == '_' {
+		return content[1 : len(content)-1], true
+	}
+	return content, false
+}
@@ -0,0 +1,161 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// onAuditLogEntry relays Discord moderation activity to the IRC audit channel.
+// The bot needs the View Audit Log permission to receive these.
+func (d *discordBot) onAuditLogEntry(s *discordgo.Session, e *discordgo.GuildAuditLogEntryCreate) {
+	channel := d.bridge.Config.AuditIRCChannel
+	if channel == "" || e.GuildID != d.guildID || e.AuditLogEntry == nil {
+		return
+	}
+
+	summary := d.auditSummary(e.AuditLogEntry)
+	if summary == "" {
+		return
+	}
+
+	d.bridge.ircListener.Notice(channel, "[Discord] "+summary)
+}
+
+// auditSummary describes an audit log entry in one line,
+// or returns an empty string for actions that aren't relayed.
+func (d *discordBot) auditSummary(entry *discordgo.AuditLogEntry) string {
+	if entry.ActionType == nil {
+		return ""
+	}
+
+	actor := d.memberName(entry.UserID)
+	target := d.memberName(entry.TargetID)
+
+	var summary string
+	switch *entry.ActionType {
+	case discordgo.AuditLogActionMemberBanAdd:
+		summary = fmt.Sprintf("%s banned %s", actor, target)
+	case discordgo.AuditLogActionMemberBanRemove:
+		summary = fmt.Sprintf("%s unbanned %s", actor, target)
+	case discordgo.AuditLogActionMemberKick:
+		summary = fmt.Sprintf("%s kicked %s", actor, target)
+	case discordgo.AuditLogActionMemberUpdate:
+		change := auditChange(entry, discordgo.AuditLogChangeKeyCommunicationDisabledUntil)
+		if change == nil {
+			return ""
+		}
+		if until, ok := change.NewValue.(string); ok && until != "" {
+			summary = fmt.Sprintf("%s timed out %s", actor, target)
+			if t, err := time.Parse(time.RFC3339, until); err == nil {
+				summary += " until " + t.UTC().Format("2006-01-02 15:04 MST")
+			}
+		} else {
+			summary = fmt.Sprintf("%s removed the timeout of %s", actor, target)
+		}
+	case discordgo.AuditLogActionMemberRoleUpdate:
+		changes := []string{}
+		if roles := auditRoleNames(auditChange(entry, discordgo.AuditLogChangeKeyRoleAdd)); roles != "" {
+			changes = append(changes, "gave "+target+" "+roles)
+		}
+		if roles := auditRoleNames(auditChange(entry, discordgo.AuditLogChangeKeyRoleRemove)); roles != "" {
+			changes = append(changes, "removed "+roles+" from "+target)
+		}
+		if len(changes) == 0 {
+			return ""
+		}
+		summary = actor + " " + strings.Join(changes, " and ")
+	case discordgo.AuditLogActionChannelCreate:
+		summary = fmt.Sprintf("%s created channel #%s", actor, d.auditName(entry, d.channelName))
+	case discordgo.AuditLogActionChannelUpdate:
+		summary = fmt.Sprintf("%s updated channel #%s", actor, d.auditName(entry, d.channelName))
+	case discordgo.AuditLogActionChannelDelete:
+		summary = fmt.Sprintf("%s deleted channel #%s", actor, d.auditName(entry, d.channelName))
+	case discordgo.AuditLogActionRoleCreate:
+		summary = fmt.Sprintf("%s created role %s", actor, d.auditName(entry, d.roleName))
+	case discordgo.AuditLogActionRoleUpdate:
+		summary = fmt.Sprintf("%s updated role %s", actor, d.auditName(entry, d.roleName))
+	case discordgo.AuditLogActionRoleDelete:
+		summary = fmt.Sprintf("%s deleted role %s", actor, d.auditName(entry, d.roleName))
+	default:
+		return ""
+	}
+
+	if entry.Reason != "" {
+		summary += ": " + entry.Reason
+	}
+	return summary
+}
+
+// auditChange returns the change to the given key, if the entry has one.
+func auditChange(entry *discordgo.AuditLogEntry, key discordgo.AuditLogChangeKey) *discordgo.AuditLogChange {
+	for _, change := range entry.Changes {
+		if change.Key != nil && *change.Key == key {
+			return change
+		}
+	}
+	return nil
+}
+
+// auditRoleNames lists the roles in a $add or $remove change, like "the Mod and Helper roles".
+func auditRoleNames(change *discordgo.AuditLogChange) string {
+	if change == nil {
+		return ""
+	}
+
+	roles, _ := change.NewValue.([]interface{})
+	names := []string{}
+	for _, role := range roles {
+		if role, ok := role.(map[string]interface{}); ok {
+			if name, ok := role["name"].(string); ok {
+				names = append(names, name)
+			}
+		}
+	}
+
+	switch len(names) {
+	case 0:
+		return ""
+	case 1:
+		return "the " + names[0] + " role"
+	}
+	return "the " + strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1] + " roles"
+}
+
+// auditName returns the name of the entry's target. For deleted targets, and new targets
+// that aren't in the state yet, the name is taken from the entry's changes.
+func (d *discordBot) auditName(entry *discordgo.AuditLogEntry, lookup func(id string) string) string {
+	if change := auditChange(entry, discordgo.AuditLogChangeKeyName); change != nil {
+		if name, ok := change.NewValue.(string); ok && name != "" {
+			return name
+		}
+		if name, ok := change.OldValue.(string); ok && name != "" {
+			return name
+		}
+	}
+	return lookup(entry.TargetID)
+}
+
+// memberName returns the display name of a guild member, or the ID if they aren't in the guild.
+func (d *discordBot) memberName(id string) string {
+	if member, err := d.State.Member(d.guildID, id); err == nil {
+		return GetMemberNick(member)
+	}
+	return id
+}
+
+func (d *discordBot) channelName(id string) string {
+	if channel, err := d.State.Channel(id); err == nil {
+		return channel.Name
+	}
+	return id
+}
+
+func (d *discordBot) roleName(id string) string {
+	if role, err := d.State.Role(d.guildID, id); err == nil {
+		return role.Name
+	}
+	return id
+}
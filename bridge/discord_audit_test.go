@@ -0,0 +1,71 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditSummary(t *testing.T) {
+	d := newFuzzDiscord(t)
+	assert.NoError(t, d.State.MemberAdd(&discordgo.Member{GuildID: testGuildID, User: &discordgo.User{ID: "1", Username: "mod"}, Nick: "Moderator"}))
+	assert.NoError(t, d.State.MemberAdd(&discordgo.Member{GuildID: testGuildID, User: &discordgo.User{ID: "2", Username: "bob"}}))
+
+	action := func(a discordgo.AuditLogAction) *discordgo.AuditLogAction { return &a }
+	key := func(k discordgo.AuditLogChangeKey) *discordgo.AuditLogChangeKey { return &k }
+
+	tests := []struct {
+		entry    discordgo.AuditLogEntry
+		expected string
+	}{
+		{
+			discordgo.AuditLogEntry{UserID: "1", TargetID: "2", ActionType: action(discordgo.AuditLogActionMemberBanAdd), Reason: "spam"},
+			"Moderator banned bob: spam",
+		},
+		{
+			// Users who left the guild are shown by ID
+			discordgo.AuditLogEntry{UserID: "1", TargetID: "3", ActionType: action(discordgo.AuditLogActionMemberKick)},
+			"Moderator kicked 3",
+		},
+		{
+			discordgo.AuditLogEntry{UserID: "1", TargetID: "2", ActionType: action(discordgo.AuditLogActionMemberUpdate), Changes: []*discordgo.AuditLogChange{
+				{Key: key(discordgo.AuditLogChangeKeyCommunicationDisabledUntil), NewValue: "2026-10-15T12:00:00+00:00"},
+			}},
+			"Moderator timed out bob until 2026-10-15 12:00 UTC",
+		},
+		{
+			discordgo.AuditLogEntry{UserID: "1", TargetID: "2", ActionType: action(discordgo.AuditLogActionMemberRoleUpdate), Changes: []*discordgo.AuditLogChange{
+				{Key: key(discordgo.AuditLogChangeKeyRoleAdd), NewValue: []interface{}{
+					map[string]interface{}{"id": "10", "name": "Mod"},
+					map[string]interface{}{"id": "11", "name": "Helper"},
+				}},
+				{Key: key(discordgo.AuditLogChangeKeyRoleRemove), NewValue: []interface{}{
+					map[string]interface{}{"id": "12", "name": "New"},
+				}},
+			}},
+			"Moderator gave bob the Mod and Helper roles and removed the New role from bob",
+		},
+		{
+			discordgo.AuditLogEntry{UserID: "1", TargetID: "20", ActionType: action(discordgo.AuditLogActionChannelDelete), Changes: []*discordgo.AuditLogChange{
+				{Key: key(discordgo.AuditLogChangeKeyName), OldValue: "old-stuff"},
+			}},
+			"Moderator deleted channel #old-stuff",
+		},
+		{
+			// Nick changes aren't moderation
+			discordgo.AuditLogEntry{UserID: "1", TargetID: "2", ActionType: action(discordgo.AuditLogActionMemberUpdate), Changes: []*discordgo.AuditLogChange{
+				{Key: key(discordgo.AuditLogChangeKeyNick), NewValue: "bobby"},
+			}},
+			"",
+		},
+		{
+			discordgo.AuditLogEntry{UserID: "1", ActionType: action(discordgo.AuditLogActionMessagePin)},
+			"",
+		},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, d.auditSummary(&tt.entry))
+	}
+}
@@ -0,0 +1,32 @@
+package bridge
+
+import (
+	"github.com/bwmarrin/discordgo"
+)
+
+// isBotMessage returns true if a Discord message was written by a bot account.
+// Webhooks are left to DenyWebhooks and AllowedWebhooks.
+func isBotMessage(m *discordgo.Message) bool {
+	return m.Author.Bot && m.WebhookID == ""
+}
+
+// dropBot returns true if messages from Discord bots aren't relayed from this message's channel.
+func (d *discordBot) dropBot(m *discordgo.Message) bool {
+	mapping := d.bridge.GetMappingByDiscord(m.ChannelID)
+	return mapping != nil && isBotMessage(m) && d.bridge.channelOptions(mapping.IRCChannel).DropBots
+}
+
+// botMarker returns the marker added to the puppet nicks of Discord bots, if any.
+func (m *IRCManager) botMarker(discord DiscordUser) string {
+	if !discord.Bot || m.bridge.Config.BotMarker == "" {
+		return ""
+	}
+	return sanitiseNickname(m.bridge.Config.BotMarker)
+}
+
+// BotMode returns the user mode for bots, if the server has one, going by ISUPPORT.
+func (i *ircListener) BotMode() string {
+	i.topicMu.Lock()
+	defer i.topicMu.Unlock()
+	return i.botMode
+}
@@ -0,0 +1,45 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBotMarker(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.BotMarker = "[bot]"
+	})
+	defer tb.Close()
+
+	helper := tb.discordMember("100", "helper", "")
+	helper.Bot = true
+	tb.discordSay(helper, "beep")
+	waitFor(t, "bot message on irc", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<h\u200Belper#0001 [bot]> beep")
+	})
+
+	m := tb.Bridge.ircManager
+	assert.Equal(t, "helper[bot]_d", m.generateNickname(DiscordUser{ID: "100", Username: "helper", Discriminator: "0001", Nick: "helper", Bot: true}))
+	assert.Equal(t, "bob_d", m.generateNickname(DiscordUser{ID: "200", Username: "bob", Discriminator: "0001", Nick: "bob"}))
+}
+
+func TestDropBots(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.ChannelOptions = map[string]ChannelOptions{testChannel: {DropBots: true}}
+	})
+	defer tb.Close()
+
+	helper := tb.discordMember("100", "helper", "")
+	helper.Bot = true
+	bob := tb.discordMember("200", "bob", "")
+
+	tb.discordSay(helper, "beep")
+	tb.discordSay(bob, "hello")
+	waitFor(t, "message on irc", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<b\u200Bob#0001> hello")
+	})
+	for _, line := range tb.ircd.Received("listener") {
+		assert.NotContains(t, line, "beep")
+	}
+}
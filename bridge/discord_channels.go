@@ -0,0 +1,73 @@
+package bridge
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// onChannelUpdate tells IRC when a bridged Discord channel is locked or unlocked,
+// or its slowmode changes, so that IRC users know why Discord has gone quiet.
+func (d *discordBot) onChannelUpdate(s *discordgo.Session, c *discordgo.ChannelUpdate) {
+	// Without the channel as it was, there is nothing to compare with
+	if c.Channel == nil || c.BeforeUpdate == nil {
+		return
+	}
+
+	mapping := d.bridge.GetMappingByDiscord(c.ID)
+	if mapping == nil {
+		return
+	}
+
+	for _, change := range channelChanges(c.BeforeUpdate, c.Channel, d.guildID) {
+		d.bridge.ircListener.Notice(mapping.IRCName(), "[Discord] "+change)
+	}
+}
+
+// channelLocked returns true if everyone is denied sending messages in the channel.
+// The @everyone role has the same ID as the guild.
+func channelLocked(channel *discordgo.Channel, guildID string) bool {
+	for _, overwrite := range channel.PermissionOverwrites {
+		if overwrite.Type == discordgo.PermissionOverwriteTypeRole && overwrite.ID == guildID {
+			return overwrite.Deny&discordgo.PermissionSendMessages != 0
+		}
+	}
+	return false
+}
+
+// channelChanges describes the changes to a channel that affect who can talk, and how often.
+func channelChanges(before, after *discordgo.Channel, guildID string) []string {
+	var changes []string
+
+	if locked := channelLocked(after, guildID); locked != channelLocked(before, guildID) {
+		if locked {
+			changes = append(changes, "The Discord channel has been locked. Only moderators can send messages there.")
+		} else {
+			changes = append(changes, "The Discord channel has been unlocked.")
+		}
+	}
+
+	if after.RateLimitPerUser != before.RateLimitPerUser {
+		if after.RateLimitPerUser == 0 {
+			changes = append(changes, "Slowmode is now off in the Discord channel.")
+		} else {
+			interval := time.Duration(after.RateLimitPerUser) * time.Second
+			changes = append(changes, fmt.Sprintf("Slowmode is now on in the Discord channel: people can send a message every %s.", formatSlowmode(interval)))
+		}
+	}
+
+	return changes
+}
+
+// formatSlowmode formats a slowmode interval, which is a whole number of seconds, minutes or hours.
+func formatSlowmode(interval time.Duration) string {
+	switch {
+	case interval%time.Hour == 0:
+		return fmt.Sprintf("%dh", interval/time.Hour)
+	case interval%time.Minute == 0:
+		return fmt.Sprintf("%dm", interval/time.Minute)
+	default:
+		return fmt.Sprintf("%ds", interval/time.Second)
+	}
+}
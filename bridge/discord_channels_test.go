@@ -0,0 +1,44 @@
+package bridge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannelUpdate(t *testing.T) {
+	tb := newTestBridge(t, nil)
+	defer tb.Close()
+	d := tb.Bridge.discord
+
+	before := &discordgo.Channel{ID: testChannelID, GuildID: testGuildID}
+	after := &discordgo.Channel{
+		ID:               testChannelID,
+		GuildID:          testGuildID,
+		RateLimitPerUser: 30,
+		PermissionOverwrites: []*discordgo.PermissionOverwrite{{
+			ID:   testGuildID,
+			Type: discordgo.PermissionOverwriteTypeRole,
+			Deny: discordgo.PermissionSendMessages,
+		}},
+	}
+	d.onChannelUpdate(d.Session, &discordgo.ChannelUpdate{Channel: after, BeforeUpdate: before})
+
+	waitFor(t, "lock notice", func() bool {
+		return tb.ircd.HasReceived("listener", "NOTICE "+testChannel+" :[Discord] The Discord channel has been locked. Only moderators can send messages there.")
+	})
+	waitFor(t, "slowmode notice", func() bool {
+		return tb.ircd.HasReceived("listener", "NOTICE "+testChannel+" :[Discord] Slowmode is now on in the Discord channel: people can send a message every 30s.")
+	})
+
+	assert.Equal(t, []string{"The Discord channel has been unlocked.", "Slowmode is now off in the Discord channel."}, channelChanges(after, before, testGuildID))
+	assert.Empty(t, channelChanges(before, before, testGuildID))
+}
+
+func TestFormatSlowmode(t *testing.T) {
+	assert.Equal(t, "5s", formatSlowmode(5*time.Second))
+	assert.Equal(t, "2m", formatSlowmode(2*time.Minute))
+	assert.Equal(t, "6h", formatSlowmode(6*time.Hour))
+}
@@ -0,0 +1,199 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+// bridgeCommand is the slash command used to manage the bridge on Discord.
+var bridgeCommand = &discordgo.ApplicationCommand{
+	Name:        "bridge",
+	Description: "Manage the IRC bridge",
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "diagnose",
+			Description: "Check that the bridge has the permissions it needs",
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "broadcast",
+			Description: "Send a notice to every bridged IRC and Discord channel",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "message",
+					Description: "The notice, e.g. \"bridge restarting in 5 minutes\"",
+					Required:    true,
+				},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "purge",
+			Description: "Delete everything the bridge stores about someone",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionUser,
+					Name:        "discord_user",
+					Description: "The Discord user",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "irc_account",
+					Description: "The IRC services account",
+				},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "unignore",
+			Description: "Relay an IRC user ignored by a reaction again",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "host",
+					Description: "The host (or hostmask) of the IRC user",
+					Required:    true,
+				},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "optout",
+			Description: "Stop your messages and presence being relayed to IRC",
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "optin",
+			Description: "Relay your messages and presence to IRC again",
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "identity",
+			Description: "Show how the bridge shows you on IRC",
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "stats",
+			Description: "Show how many of your messages were relayed to IRC, and why any weren't",
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "profile",
+			Description: "Show what the IRC server says about an IRC user",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "nick",
+					Description: "The IRC nick",
+					Required:    true,
+				},
+			},
+		},
+	},
+}
+
+// bridgeAdminCommands are the /bridge subcommands that need the Manage Server permission.
+// Everyone can opt out, see their own identity and statistics, and look up IRC users.
+var bridgeAdminCommands = map[string]bool{
+	"diagnose":  true,
+	"broadcast": true,
+	"purge":     true,
+}
+
+// registerCommands creates the bridge's slash commands in the guild.
+func (d *discordBot) registerCommands() {
+	// Quoting pulls content into IRC, which moderators might not want from every channel
+	quotePerms := int64(discordgo.PermissionManageMessages)
+	quoteCommand.DefaultMemberPermissions = &quotePerms
+
+	commands := []*discordgo.ApplicationCommand{bridgeCommand, quoteCommand}
+	if d.bridge.Config.Karma {
+		commands = append(commands, karmaCommand)
+	}
+
+	for _, command := range commands {
+		if _, err := d.ApplicationCommandCreate(d.State.User.ID, d.guildID, command); err != nil {
+			d.bridge.handleError(err, log.Fields{"command": command.Name}, "could not register application command")
+		}
+	}
+}
+
+func (d *discordBot) onInteractionCreate(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand:
+		switch i.ApplicationCommandData().Name {
+		case quoteCommand.Name:
+			d.handleQuoteCommand(s, i)
+			return
+		case karmaCommand.Name:
+			d.handleKarmaCommand(s, i)
+			return
+		}
+	case discordgo.InteractionMessageComponent:
+		if strings.HasPrefix(i.MessageComponentData().CustomID, quoteSelectPrefix) {
+			d.handleQuoteSelect(s, i)
+		}
+		return
+	default:
+		return
+	}
+
+	data := i.ApplicationCommandData()
+	if data.Name != bridgeCommand.Name || len(data.Options) == 0 {
+		return
+	}
+
+	sub := data.Options[0]
+	if i.Member == nil {
+		return
+	}
+
+	var content string
+	switch {
+	case bridgeAdminCommands[sub.Name] && i.Member.Permissions&discordgo.PermissionManageServer == 0:
+		content = "You need the Manage Server permission to use /bridge " + sub.Name + "."
+	case sub.Name == "unignore" && i.Member.Permissions&discordgo.PermissionManageMessages == 0:
+		content = "You need the Manage Messages permission to use /bridge unignore."
+	case sub.Name == "unignore":
+		content = d.handleUnignore(i.Member.User, sub.Options)
+	case sub.Name == "diagnose":
+		content = strings.Join(d.bridge.diagnose(), "\n")
+	case sub.Name == "purge":
+		content = d.handlePurge(sub.Options)
+	case sub.Name == "optout", sub.Name == "optin":
+		content = d.optOutDiscord(i.Member.User, sub.Name == "optout")
+	case sub.Name == "identity":
+		content = strings.Join(d.bridge.identityDiscord(i.Member.User), "\n")
+	case sub.Name == "profile":
+		content = d.handleProfile(sub.Options)
+	case sub.Name == "stats":
+		content = strings.Join(d.bridge.statsLines(karmaKeyDiscord(i.Member.User.ID), nil), "\n")
+	case sub.Name == "broadcast":
+		if len(sub.Options) == 0 {
+			return
+		}
+		sent, err := d.bridge.Broadcast(sub.Options[0].StringValue())
+		content = fmt.Sprintf("Broadcast sent to %d channels.", sent)
+		if err != nil {
+			content += " Some channels could not be sent to, check the logs."
+		}
+	default:
+		return
+	}
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: TruncateString(1900, content),
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		d.bridge.handleError(err, nil, "could not respond to /bridge "+sub.Name)
+	}
+}
@@ -0,0 +1,105 @@
+package bridge
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// emojiShortcode matches shortcodes like :party_parrot: typed on IRC
+var emojiShortcode = regexp.MustCompile(`:([A-Za-z0-9_]{2,32}):`)
+
+// emojiCatalog caches the guild's custom emoji by name, so IRC users can use them with shortcodes.
+// It is rebuilt from the state after the guild's emoji change.
+//
+// It is safe for concurrent use.
+type emojiCatalog struct {
+	mu     sync.Mutex
+	loaded bool
+	byName map[string]*discordgo.Emoji
+	byFold map[string]*discordgo.Emoji // by lowercase name, for shortcodes typed in the wrong case
+}
+
+// Invalidate makes the catalog be rebuilt when it is next used.
+func (c *emojiCatalog) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.loaded = false
+}
+
+// onEmojisUpdate is called after emoji are added to the guild, renamed or removed.
+func (d *discordBot) onEmojisUpdate(s *discordgo.Session, e *discordgo.GuildEmojisUpdate) {
+	if e.GuildID == d.guildID {
+		d.emoji.Invalidate()
+	}
+}
+
+// guildEmoji returns the custom emoji with a name, or nil if the guild has none that IRC users can use.
+func (d *discordBot) guildEmoji(name string) *discordgo.Emoji {
+	d.emoji.mu.Lock()
+	defer d.emoji.mu.Unlock()
+
+	if !d.emoji.loaded {
+		d.emoji.byName = make(map[string]*discordgo.Emoji)
+		d.emoji.byFold = make(map[string]*discordgo.Emoji)
+		if guild, err := d.State.Guild(d.guildID); err == nil {
+			d.State.RLock()
+			for _, emoji := range guild.Emojis {
+				// Emoji limited to some roles can't be used by the webhooks
+				if emoji.ID == "" || !emoji.Available || len(emoji.Roles) > 0 {
+					continue
+				}
+				d.emoji.byName[emoji.Name] = emoji
+				if _, ok := d.emoji.byFold[strings.ToLower(emoji.Name)]; !ok {
+					d.emoji.byFold[strings.ToLower(emoji.Name)] = emoji
+				}
+			}
+			d.State.RUnlock()
+			d.emoji.loaded = true
+		}
+	}
+
+	if emoji, ok := d.emoji.byName[name]; ok {
+		return emoji
+	}
+	return d.emoji.byFold[strings.ToLower(name)]
+}
+
+// expandEmoji replaces the shortcodes of the guild's custom emoji in a message relayed from IRC,
+// like :party_parrot:, with the emoji. Shortcodes in inline code aren't replaced.
+func (d *discordBot) expandEmoji(text string) string {
+	if !strings.Contains(text, ":") {
+		return text
+	}
+
+	parts := strings.Split(text, "`")
+	for n := 0; n < len(parts); n += 2 {
+		parts[n] = d.expandEmojiText(parts[n])
+	}
+	return strings.Join(parts, "`")
+}
+
+func (d *discordBot) expandEmojiText(text string) string {
+	var out strings.Builder
+	last := 0
+	for _, match := range emojiShortcode.FindAllStringSubmatchIndex(text, -1) {
+		start, end := match[0], match[1]
+
+		// Emoji that are already Discord markup, like <:name:123>, are left alone
+		if start > 0 && (text[start-1] == '<' || text[start-1] == 'a' && start > 1 && text[start-2] == '<') {
+			continue
+		}
+
+		emoji := d.guildEmoji(text[match[2]:match[3]])
+		if emoji == nil {
+			continue
+		}
+		out.WriteString(text[last:start])
+		out.WriteString(emoji.MessageFormat())
+		last = end
+	}
+	out.WriteString(text[last:])
+	return out.String()
+}
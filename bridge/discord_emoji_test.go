@@ -0,0 +1,36 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandEmoji(t *testing.T) {
+	tb := newTestBridge(t, nil)
+	defer tb.Close()
+	d := tb.Bridge.discord
+
+	d.State.EmojisAdd(testGuildID, []*discordgo.Emoji{
+		{ID: "1", Name: "party_parrot", Available: true, Animated: true},
+		{ID: "2", Name: "ocf", Available: true},
+		{ID: "3", Name: "mods_only", Available: true, Roles: []string{"10"}},
+	})
+
+	assert.Equal(t, "hi <a:party_parrot:1> <:ocf:2>", d.expandEmoji("hi :party_parrot: :OCF:"))
+	assert.Equal(t, ":mods_only: :unknown: 12:30:45", d.expandEmoji(":mods_only: :unknown: 12:30:45"))
+	assert.Equal(t, "<:ocf:2> `:ocf:` <:ocf:2>", d.expandEmoji(":ocf: `:ocf:` <:ocf:2>"))
+
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :nice :party_parrot:")
+	waitFor(t, "emoji relayed to discord", func() bool {
+		_, ok := tb.discord.Find("nice <a:party_parrot:1>" + relayMarker)
+		return ok
+	})
+
+	// The catalog is rebuilt when the guild's emoji change
+	d.State.EmojiAdd(testGuildID, &discordgo.Emoji{ID: "4", Name: "new", Available: true})
+	assert.Equal(t, ":new:", d.expandEmoji(":new:"))
+	d.onEmojisUpdate(d.Session, &discordgo.GuildEmojisUpdate{GuildID: testGuildID})
+	assert.Equal(t, "<:new:4>", d.expandEmoji(":new:"))
+}
@@ -0,0 +1,86 @@
+package bridge
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+)
+
+// discordEventClasses are the kinds of Discord events that can be listed in DiscordEvents,
+// and the gateway intents they need.
+var discordEventClasses = map[string]discordgo.Intent{
+	"reactions":        discordgo.IntentsGuildMessageReactions | discordgo.IntentsDirectMessageReactions,
+	"typing":           discordgo.IntentsGuildMessageTyping | discordgo.IntentsDirectMessageTyping,
+	"presences":        discordgo.IntentsGuildPresences,
+	"voice":            discordgo.IntentsGuildVoiceStates, // including soundboard sounds played and voice channel activities
+	"expressions":      discordgo.IntentsGuildEmojis,      // emoji, stickers and soundboard sounds being changed
+	"moderation":       discordgo.IntentsGuildBans,        // bans and audit log entries
+	"scheduled_events": discordgo.IntentsGuildScheduledEvents,
+	"invites":          discordgo.IntentsGuildInvites,
+	"webhooks":         discordgo.IntentsGuildWebhooks,
+	"integrations":     discordgo.IntentsGuildIntegrations,
+	"automod":          discordgo.IntentAutoModerationConfiguration | discordgo.IntentAutoModerationExecution,
+}
+
+// discordBaseIntents are needed to relay at all, so they are always asked for.
+// Members and message content are privileged intents, and must be enabled for the bot in the developer portal.
+const discordBaseIntents = discordgo.IntentsGuilds |
+	discordgo.IntentsGuildMembers |
+	discordgo.IntentsGuildMessages |
+	discordgo.IntentsDirectMessages |
+	discordgo.IntentsMessageContent
+
+func validateDiscordEvents(opts *Config) error {
+	for _, class := range opts.DiscordEvents {
+		if _, ok := discordEventClasses[class]; !ok {
+			classes := make([]string, 0, len(discordEventClasses))
+			for class := range discordEventClasses {
+				classes = append(classes, class)
+			}
+			sort.Strings(classes)
+			return errors.Errorf("unknown discord_events class %q, must be one of %s", class, strings.Join(classes, ", "))
+		}
+	}
+
+	// Puppets follow their users' presence, and would never come online
+	if !opts.SimpleMode && opts.PresenceFallback <= 0 && !handlesEvents(opts, "presences") {
+		return errors.New("discord_events must include presences, unless presence_fallback is set")
+	}
+	return nil
+}
+
+// handlesEvents returns true if the bridge processes a class of Discord events.
+// Without DiscordEvents, it processes all of them.
+func handlesEvents(opts *Config, class string) bool {
+	if class == "presences" && opts.PresenceFallback > 0 {
+		return false
+	}
+	if len(opts.DiscordEvents) == 0 {
+		return true
+	}
+	for _, handled := range opts.DiscordEvents {
+		if handled == class {
+			return true
+		}
+	}
+	return false
+}
+
+// filterDiscordEvents asks Discord only for the events the bridge processes, and stops the state
+// keeping track of what the others would update.
+func (d *discordBot) filterDiscordEvents() {
+	intents := discordBaseIntents
+	for class, classIntents := range discordEventClasses {
+		if handlesEvents(d.bridge.Config, class) {
+			intents |= classIntents
+		}
+	}
+	d.Identify.Intents = intents
+
+	d.State.TrackPresences = handlesEvents(d.bridge.Config, "presences")
+	d.State.TrackVoice = handlesEvents(d.bridge.Config, "voice")
+	d.State.TrackEmojis = handlesEvents(d.bridge.Config, "expressions")
+	d.State.TrackStickers = handlesEvents(d.bridge.Config, "expressions")
+}
@@ -0,0 +1,34 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateDiscordEvents(t *testing.T) {
+	assert.NoError(t, validateDiscordEvents(&Config{}))
+	assert.NoError(t, validateDiscordEvents(&Config{DiscordEvents: []string{"presences", "reactions"}}))
+	assert.NoError(t, validateDiscordEvents(&Config{DiscordEvents: []string{"reactions"}, SimpleMode: true}))
+	assert.EqualError(t, validateDiscordEvents(&Config{DiscordEvents: []string{"presences", "soundboard"}}),
+		`unknown discord_events class "soundboard", must be one of automod, expressions, integrations, invites, moderation, presences, reactions, scheduled_events, typing, voice, webhooks`)
+	assert.Error(t, validateDiscordEvents(&Config{DiscordEvents: []string{"reactions"}}), "puppets need presences")
+}
+
+func TestFilterDiscordEvents(t *testing.T) {
+	tb := newTestBridge(t, nil)
+	defer tb.Close()
+	d := tb.Bridge.discord
+
+	all := discordgo.IntentsAllWithoutPrivileged | discordgo.IntentsGuildMembers | discordgo.IntentsGuildPresences | discordgo.IntentsMessageContent
+	assert.Equal(t, all, d.Identify.Intents)
+	assert.True(t, d.State.TrackVoice)
+
+	tb.Config.DiscordEvents = []string{"presences", "reactions"}
+	d.filterDiscordEvents()
+	assert.Equal(t, discordBaseIntents|discordgo.IntentsGuildPresences|discordgo.IntentsGuildMessageReactions|discordgo.IntentsDirectMessageReactions, d.Identify.Intents)
+	assert.False(t, d.State.TrackVoice)
+	assert.False(t, d.State.TrackEmojis)
+	assert.True(t, d.State.TrackPresences)
+}
@@ -0,0 +1,150 @@
+package bridge
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// failoverCheckInterval is how often the gateway connection is checked, to see if it is time to fail over
+const failoverCheckInterval = 30 * time.Second
+
+func validateFailover(opts *Config) error {
+	if opts.SecondaryBotToken == "" {
+		return nil
+	}
+	if opts.SecondaryBotToken == opts.DiscordBotToken {
+		return errors.New("discord_token_secondary must be a different bot's token")
+	}
+	if opts.DiscordFailoverAfter <= 0 {
+		return errors.New("discord_failover_after must be positive")
+	}
+	return nil
+}
+
+// tokenFailover tracks how long the bot hasn't been connected to the Discord gateway,
+// and which of the bot tokens is in use.
+type tokenFailover struct {
+	mu sync.Mutex
+
+	// down is when the gateway connection was lost, or zero while connected
+	down time.Time
+
+	secondary bool // the secondary token is in use
+	switching bool
+}
+
+// onFailoverDisconnect and onFailoverConnect track the gateway connection.
+// They run on standbys too, which fail over like the leader.
+func (d *discordBot) onFailoverDisconnect(s *discordgo.Session, e *discordgo.Disconnect) {
+	d.failover.mu.Lock()
+	defer d.failover.mu.Unlock()
+	if d.failover.down.IsZero() {
+		d.failover.down = time.Now()
+	}
+}
+
+func (d *discordBot) onFailoverConnect(s *discordgo.Session, e *discordgo.Connect) {
+	d.failover.mu.Lock()
+	defer d.failover.mu.Unlock()
+	d.failover.down = time.Time{}
+}
+
+// openSession opens the gateway connection, trying the other bot token if the first can't connect.
+func (d *discordBot) openSession() error {
+	err := d.Session.Open()
+	if err == nil || d.bridge.Config.SecondaryBotToken == "" {
+		return err
+	}
+
+	log.WithField("error", err).Warnln("Could not connect to the Discord gateway, trying the secondary bot token.")
+	d.useToken(true)
+	if err := d.Session.Open(); err != nil {
+		return errors.Wrap(err, "the secondary bot token could not connect either")
+	}
+	d.bridge.statusProblem("failover", "The primary Discord bot token could not connect, so the secondary bot is being used.")
+	return nil
+}
+
+// useToken switches the session to the primary or secondary bot token.
+// The session must not be open.
+func (d *discordBot) useToken(secondary bool) {
+	token := d.bridge.Config.DiscordBotToken
+	if secondary {
+		token = d.bridge.Config.SecondaryBotToken
+	}
+
+	d.Session.Lock()
+	d.Session.Token = "Bot " + token
+	d.Session.Unlock()
+
+	d.failover.mu.Lock()
+	d.failover.secondary = secondary
+	d.failover.mu.Unlock()
+}
+
+// checkFailover switches to the other bot token if the gateway connection has been down for
+// DiscordFailoverAfter. Failing over again, after another DiscordFailoverAfter, goes back to the first.
+func (d *discordBot) checkFailover() {
+	after := d.bridge.Config.DiscordFailoverAfter
+	if d.bridge.Config.SecondaryBotToken == "" || after <= 0 {
+		return
+	}
+
+	d.failover.mu.Lock()
+	due := !d.failover.down.IsZero() && time.Since(d.failover.down) >= after && !d.failover.switching
+	secondary := !d.failover.secondary
+	if due {
+		d.failover.switching = true
+	}
+	d.failover.mu.Unlock()
+	if !due {
+		return
+	}
+
+	defer func() {
+		d.failover.mu.Lock()
+		d.failover.switching = false
+		d.failover.mu.Unlock()
+	}()
+
+	which := "primary"
+	if secondary {
+		which = "secondary"
+	}
+	log.WithFields(log.Fields{
+		"down": after,
+		"to":   which,
+	}).Warnln("The Discord gateway has been disconnected for too long, failing over to the other bot token.")
+
+	if err := d.Session.Close(); err != nil {
+		log.WithField("error", err).Debugln("Could not close the Discord session before failing over.")
+	}
+	d.useToken(secondary)
+
+	if err := d.Session.Open(); err != nil {
+		d.bridge.handleError(err, log.Fields{"token": which}, "could not fail over to the other discord bot token")
+
+		// Give this token as long as the last one before trying the other again
+		d.failover.mu.Lock()
+		d.failover.down = time.Now()
+		d.failover.mu.Unlock()
+		return
+	}
+
+	// The webhooks belong to the bot that made them, so the new bot takes them over
+	if d.getTransmitter() != nil {
+		if err := d.openTransmitter(); err != nil {
+			d.bridge.handleError(err, nil, "could not take over webhooks after failing over")
+		}
+	}
+
+	if secondary {
+		d.bridge.statusProblem("failover", "Could not connect to Discord for "+after.String()+", so the bridge switched to the secondary bot.")
+	} else {
+		d.bridge.statusResolved("failover", "The bridge switched back to the primary Discord bot.")
+	}
+}
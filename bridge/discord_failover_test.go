@@ -0,0 +1,76 @@
+package bridge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFailoverConfig(t *testing.T) {
+	assert.NoError(t, validateFailover(&Config{DiscordBotToken: "a"}))
+	assert.NoError(t, validateFailover(&Config{DiscordBotToken: "a", SecondaryBotToken: "b", DiscordFailoverAfter: time.Minute}))
+	assert.Error(t, validateFailover(&Config{DiscordBotToken: "a", SecondaryBotToken: "a", DiscordFailoverAfter: time.Minute}))
+	assert.Error(t, validateFailover(&Config{DiscordBotToken: "a", SecondaryBotToken: "b"}))
+}
+
+func TestFailoverSwitchesToken(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.SecondaryBotToken = "secondary"
+		conf.DiscordFailoverAfter = time.Minute
+	})
+	defer tb.Close()
+	d := tb.Bridge.discord
+
+	// Not down for long enough
+	d.onFailoverDisconnect(d.Session, nil)
+	d.checkFailover()
+	assert.Equal(t, "Bot token", d.Session.Token)
+
+	// The fake Discord has no gateway, so the secondary can't connect either, and gets as long before switching back
+	d.failover.mu.Lock()
+	d.failover.down = time.Now().Add(-2 * time.Minute)
+	d.failover.mu.Unlock()
+	d.checkFailover()
+	assert.Equal(t, "Bot secondary", d.Session.Token)
+	d.checkFailover()
+	assert.Equal(t, "Bot secondary", d.Session.Token)
+
+	d.onFailoverConnect(d.Session, nil)
+	d.failover.mu.Lock()
+	assert.True(t, d.failover.down.IsZero())
+	assert.True(t, d.failover.secondary)
+	d.failover.mu.Unlock()
+}
+
+func TestFailoverReplacesTransmitter(t *testing.T) {
+	tb := newTestBridge(t, nil)
+	defer tb.Close()
+	d := tb.Bridge.discord
+
+	old := d.getTransmitter()
+	_, err := old.Message(testChannelID, "alice", "", "before")
+	assert.NoError(t, err)
+
+	// Messages can be relayed while the webhooks are taken over
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			d.getTransmitter().GetID()
+		}
+	}()
+	assert.NoError(t, d.openTransmitter())
+	<-done
+
+	replaced := d.getTransmitter()
+	assert.True(t, replaced != old)
+	tb.discord.mu.Lock()
+	assert.Empty(t, tb.discord.webhooks)
+	tb.discord.mu.Unlock()
+
+	_, err = replaced.Message(testChannelID, "alice", "", "after")
+	assert.NoError(t, err)
+	_, ok := tb.discord.Find("after")
+	assert.True(t, ok)
+}
@@ -0,0 +1,124 @@
+package bridge
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// defaultJoinBatchWindow is how long after an announced join further joins are
+// collected into a single announcement, so that raids don't flood IRC
+const defaultJoinBatchWindow = 10 * time.Second
+
+// joinBatchNames is the most names listed in a batched announcement
+const joinBatchNames = 5
+
+// joinAnnouncer announces new Discord members to IRC. The first join is
+// announced straight away, and any more within the batch window are batched.
+type joinAnnouncer struct {
+	mu      sync.Mutex
+	window  time.Duration // defaultJoinBatchWindow if zero
+	timer   *time.Timer
+	pending []string
+	stopped bool
+}
+
+// batchWindow returns how long joins are batched for. a.mu must be held.
+func (a *joinAnnouncer) batchWindow() time.Duration {
+	if a.window > 0 {
+		return a.window
+	}
+	return defaultJoinBatchWindow
+}
+
+// stop stops batching, dropping any joins not announced yet.
+func (a *joinAnnouncer) stop() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.stopped = true
+	if a.timer != nil {
+		a.timer.Stop()
+		a.timer = nil
+	}
+	a.pending = nil
+}
+
+// onMemberJoin announces a new member of the guild to the IRC join channel.
+func (d *discordBot) onMemberJoin(s *discordgo.Session, m *discordgo.GuildMemberAdd) {
+	if m.Member == nil || m.User == nil || m.GuildID != d.guildID || m.User.Bot {
+		return
+	}
+	d.bridge.raidActivity(m.User, m.Member)
+
+	if d.bridge.Config.JoinAnnounceChannel == "" {
+		return
+	}
+
+	name := GetMemberNick(m.Member)
+
+	a := &d.bridge.joins
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.stopped {
+		return
+	}
+	if a.timer != nil {
+		a.pending = append(a.pending, name)
+		return
+	}
+
+	d.announceJoins([]string{name})
+	a.timer = time.AfterFunc(a.batchWindow(), d.flushJoins)
+}
+
+// flushJoins announces the joins collected since the last announcement.
+// Batching continues for as long as people keep joining.
+func (d *discordBot) flushJoins() {
+	a := &d.bridge.joins
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.stopped || len(a.pending) == 0 {
+		a.timer = nil
+		return
+	}
+
+	d.announceJoins(a.pending)
+	a.pending = nil
+	a.timer = time.AfterFunc(a.batchWindow(), d.flushJoins)
+}
+
+// announceJoins sends one announcement for the given new members.
+func (d *discordBot) announceJoins(names []string) {
+	count := 0
+	if guild, err := d.State.Guild(d.guildID); err == nil {
+		count = guild.MemberCount
+	}
+
+	var line string
+	if len(names) == 1 {
+		line = strings.NewReplacer(
+			"{name}", names[0],
+			"{count}", strconv.Itoa(count),
+		).Replace(d.bridge.Config.JoinAnnounceTemplate)
+	} else {
+		listed := names
+		if len(listed) > joinBatchNames {
+			listed = listed[:joinBatchNames]
+		}
+		line = fmt.Sprintf("* %d people joined the Discord: %s", len(names), strings.Join(listed, ", "))
+		if others := len(names) - len(listed); others > 0 {
+			line += fmt.Sprintf(" and %d others", others)
+		}
+		if count > 0 {
+			line += fmt.Sprintf(" (now %d members)", count)
+		}
+	}
+
+	d.bridge.ircListener.Notice(d.bridge.Config.JoinAnnounceChannel, line)
+}
@@ -0,0 +1,53 @@
+package bridge
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJoinAnnouncements(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.JoinAnnounceChannel = "#lobby"
+		conf.JoinAnnounceTemplate = "* {name} joined the Discord (member #{count})"
+	})
+	defer tb.Close()
+	tb.Bridge.joins.mu.Lock()
+	tb.Bridge.joins.window = 100 * time.Millisecond
+	tb.Bridge.joins.mu.Unlock()
+	waitFor(t, "listener to join the announce channel", func() bool {
+		return tb.ircd.InChannel("#lobby", "listener")
+	})
+
+	d := tb.Bridge.discord
+	guild, _ := d.State.Guild(testGuildID)
+	guild.MemberCount = 1233
+
+	join := func(id, name string, bot bool) {
+		guild.MemberCount++
+		d.onMemberJoin(d.Session, &discordgo.GuildMemberAdd{Member: &discordgo.Member{
+			GuildID: testGuildID,
+			User:    &discordgo.User{ID: id, Username: name, Bot: bot},
+		}})
+	}
+
+	join("100", "bob", false)
+	waitFor(t, "join announcement", func() bool {
+		return tb.ircd.HasReceived("listener", "NOTICE #lobby :* bob joined the Discord (member #1234)")
+	})
+
+	// Bots aren't announced, and a burst of joins is announced together
+	join("101", "robot", true)
+	for i := 0; i < 7; i++ {
+		join(fmt.Sprint(200+i), fmt.Sprintf("raider%d", i), false)
+	}
+	waitFor(t, "batched announcement", func() bool {
+		return tb.ircd.HasReceived("listener", "NOTICE #lobby :* 7 people joined the Discord: raider0, raider1, raider2, raider3, raider4 and 2 others (now 1242 members)")
+	})
+	for _, line := range tb.ircd.Received("listener") {
+		assert.NotContains(t, line, "robot")
+	}
+}
@@ -0,0 +1,143 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// quoteExcerptLength is the longest excerpt quoted to IRC
+var quoteExcerptLength = 300
+
+// quoteCommand is the message context menu command used to quote a Discord message to IRC.
+var quoteCommand = &discordgo.ApplicationCommand{
+	Name: "Quote to IRC",
+	Type: discordgo.MessageApplicationCommand,
+}
+
+// quoteSelectPrefix starts the custom ID of the menu for choosing which IRC channel to quote to.
+// The rest of the ID is the channel ID and message ID of the quoted message.
+const quoteSelectPrefix = "quote:"
+
+// interactionUser returns the user who triggered an interaction.
+func interactionUser(i *discordgo.InteractionCreate) *discordgo.User {
+	if i.Member != nil && i.Member.User != nil {
+		return i.Member.User
+	}
+	return i.User
+}
+
+// handleQuoteCommand asks which IRC channel to quote a message to,
+// or quotes it straight away if only one channel is bridged.
+func (d *discordBot) handleQuoteCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	data := i.ApplicationCommandData()
+	if data.Resolved == nil || data.Resolved.Messages[data.TargetID] == nil {
+		return
+	}
+	m := data.Resolved.Messages[data.TargetID]
+
+	channels := []string{}
+	for _, mapping := range d.bridge.channelMappings() {
+		channels = append(channels, strings.Split(mapping.IRCChannel, " ")[0])
+	}
+
+	if len(channels) == 1 {
+		d.respondToQuote(s, i, discordgo.InteractionResponseChannelMessageWithSource, d.quoteToIRC(m, interactionUser(i), channels[0]))
+		return
+	}
+
+	// A select menu can only have 25 options
+	if len(channels) > 25 {
+		channels = channels[:25]
+	}
+	options := make([]discordgo.SelectMenuOption, len(channels))
+	for i, channel := range channels {
+		options[i] = discordgo.SelectMenuOption{Label: channel, Value: channel}
+	}
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: "Which IRC channel should this be quoted to?",
+			Flags:   discordgo.MessageFlagsEphemeral,
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+					discordgo.SelectMenu{
+						CustomID:    quoteSelectPrefix + m.ChannelID + ":" + m.ID,
+						Placeholder: "IRC channel",
+						Options:     options,
+					},
+				}},
+			},
+		},
+	})
+	if err != nil {
+		d.bridge.handleError(err, nil, "could not respond to quote command")
+	}
+}
+
+// handleQuoteSelect quotes a message to the IRC channel chosen from the menu.
+func (d *discordBot) handleQuoteSelect(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	data := i.MessageComponentData()
+	ids := strings.Split(strings.TrimPrefix(data.CustomID, quoteSelectPrefix), ":")
+	if len(ids) != 2 || len(data.Values) == 0 {
+		return
+	}
+
+	channel := data.Values[0]
+	if d.bridge.GetMappingByIRC(channel) == nil {
+		d.respondToQuote(s, i, discordgo.InteractionResponseUpdateMessage, channel+" is not bridged.")
+		return
+	}
+
+	m, err := s.ChannelMessage(ids[0], ids[1])
+	if err != nil {
+		d.bridge.handleError(err, nil, "could not get message to quote")
+		d.respondToQuote(s, i, discordgo.InteractionResponseUpdateMessage, "Could not find that message.")
+		return
+	}
+
+	d.respondToQuote(s, i, discordgo.InteractionResponseUpdateMessage, d.quoteToIRC(m, interactionUser(i), channel))
+}
+
+// quoteToIRC relays an excerpt of a message to an IRC channel, on behalf of the user quoting it.
+func (d *discordBot) quoteToIRC(m *discordgo.Message, quoter *discordgo.User, channel string) string {
+	if quoter == nil || m.Author == nil {
+		return "Could not quote that message."
+	}
+
+	excerpt := TruncateString(quoteExcerptLength, strings.Join(strings.Fields(d.ParseText(m)), " "))
+	if excerpt == "" {
+		excerpt = "(no text)"
+	}
+
+	guildID := m.GuildID
+	if guildID == "" {
+		guildID = d.guildID
+	}
+	link := fmt.Sprintf("https://discord.com/channels/%s/%s/%s", guildID, m.ChannelID, m.ID)
+
+	d.bridge.ircManager.sendViaListener(channel, DiscordUser{
+		ID:            quoter.ID,
+		Username:      quoter.Username,
+		Discriminator: quoter.Discriminator,
+	}, fmt.Sprintf("quoted %s: \"%s\" %s", m.Author.Username, excerpt, link))
+
+	return "Quoted to " + channel + "."
+}
+
+// respondToQuote tells the user quoting a message what happened, removing the channel menu.
+func (d *discordBot) respondToQuote(s *discordgo.Session, i *discordgo.InteractionCreate, kind discordgo.InteractionResponseType, content string) {
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: kind,
+		Data: &discordgo.InteractionResponseData{
+			Content:    content,
+			Flags:      discordgo.MessageFlagsEphemeral,
+			Components: []discordgo.MessageComponent{},
+		},
+	})
+	if err != nil {
+		d.bridge.handleError(err, nil, "could not respond to quote")
+	}
+}
@@ -0,0 +1,64 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuoteToIRC(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.ChannelMappings["#other"] = "2001"
+	})
+	defer tb.Close()
+
+	d := tb.Bridge.discord
+	bob := tb.discordMember("100", "bob", "")
+	carol := tb.discordMember("200", "carol", "")
+
+	// The quoted message is in a channel that isn't bridged
+	quoted := &discordgo.Message{ID: "7000", ChannelID: "2500", GuildID: testGuildID, Author: carol, Content: "the answer\nis 42"}
+	tb.discord.mu.Lock()
+	tb.discord.messages[quoted.ID] = quoted
+	tb.discord.mu.Unlock()
+
+	d.onInteractionCreate(d.Session, &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		ID:     "8000",
+		Token:  "token",
+		Type:   discordgo.InteractionApplicationCommand,
+		Member: &discordgo.Member{User: bob},
+		Data: discordgo.ApplicationCommandInteractionData{
+			Name:        quoteCommand.Name,
+			CommandType: discordgo.MessageApplicationCommand,
+			TargetID:    quoted.ID,
+			Resolved:    &discordgo.ApplicationCommandInteractionDataResolved{Messages: map[string]*discordgo.Message{quoted.ID: quoted}},
+		},
+	}})
+
+	responses := tb.discord.Responses()
+	if assert.Len(t, responses, 1) {
+		assert.Equal(t, "Which IRC channel should this be quoted to?", responses[0].Data.Content)
+	}
+
+	d.onInteractionCreate(d.Session, &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		ID:     "8001",
+		Token:  "token",
+		Type:   discordgo.InteractionMessageComponent,
+		Member: &discordgo.Member{User: bob},
+		Data: discordgo.MessageComponentInteractionData{
+			CustomID: quoteSelectPrefix + "2500:7000",
+			Values:   []string{testChannel},
+		},
+	}})
+
+	waitFor(t, "quote on irc", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<b\u200Bob#0001> quoted carol: \"the answer is 42\" https://discord.com/channels/"+testGuildID+"/2500/7000")
+	})
+
+	responses = tb.discord.Responses()
+	if assert.Len(t, responses, 2) {
+		assert.Equal(t, discordgo.InteractionResponseUpdateMessage, responses[1].Type)
+		assert.Equal(t, "Quoted to "+testChannel+".", responses[1].Data.Content)
+	}
+}
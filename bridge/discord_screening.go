@@ -0,0 +1,37 @@
+package bridge
+
+import (
+	"github.com/bwmarrin/discordgo"
+)
+
+// unscreened returns true if RequireScreening is on and the author of a message in the guild
+// hasn't finished membership screening (the rules screen) or onboarding yet.
+// Messages from authors who can't be looked up are treated as unscreened.
+func (d *discordBot) unscreened(m *discordgo.Message) bool {
+	if !d.bridge.Config.RequireScreening || m.GuildID == "" || m.WebhookID != "" {
+		return false
+	}
+
+	// Messages from the gateway include the member, but other events don't
+	member := m.Member
+	if member == nil {
+		var err error
+		member, err = d.State.Member(d.guildID, m.Author.ID)
+		if err != nil {
+			return true
+		}
+	}
+	return !screened(member)
+}
+
+// screened returns true if a member has got past the guild's membership screening and onboarding.
+// Members who joined before onboarding was set up have never started it, and count as onboarded.
+func screened(member *discordgo.Member) bool {
+	if member.Flags&discordgo.MemberFlagBypassesVerification != 0 {
+		return true
+	}
+	if member.Pending {
+		return false
+	}
+	return member.Flags&discordgo.MemberFlagStartedOnboarding == 0 || member.Flags&discordgo.MemberFlagCompletedOnboarding != 0
+}
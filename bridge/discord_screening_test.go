@@ -0,0 +1,34 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScreened(t *testing.T) {
+	assert.True(t, screened(&discordgo.Member{}))
+	assert.False(t, screened(&discordgo.Member{Pending: true}))
+	assert.False(t, screened(&discordgo.Member{Flags: discordgo.MemberFlagStartedOnboarding}))
+	assert.True(t, screened(&discordgo.Member{Flags: discordgo.MemberFlagStartedOnboarding | discordgo.MemberFlagCompletedOnboarding}))
+	assert.True(t, screened(&discordgo.Member{Pending: true, Flags: discordgo.MemberFlagBypassesVerification}))
+}
+
+func TestRequireScreening(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.RequireScreening = true
+	})
+	defer tb.Close()
+
+	bob := tb.discordMember("100", "bob", "")
+	newbie := &discordgo.User{ID: "101", Username: "newbie", Discriminator: "0001"}
+	tb.Bridge.discord.State.MemberAdd(&discordgo.Member{GuildID: testGuildID, User: newbie, Pending: true})
+
+	tb.discordSay(newbie, "hello irc")
+	tb.discordSay(bob, "hello")
+	waitFor(t, "screened member relayed", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<b\u200Bob#0001> hello")
+	})
+	assert.False(t, tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<n\u200Bewbie#0001> hello irc"))
+}
@@ -0,0 +1,128 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+)
+
+func validateStageChannel(opts *Config) error {
+	if opts.StageIRCChannel == "" {
+		return nil
+	}
+	for channel := range opts.ChannelMappings {
+		if strings.EqualFold(channel, opts.StageIRCChannel) {
+			return nil
+		}
+	}
+	return errors.Errorf("stage_irc_channel %s must be a bridged IRC channel", opts.StageIRCChannel)
+}
+
+// stageTopics remembers the topic of each live stage, keyed by stage channel ID,
+// since Discord doesn't say what the topic was when it changes.
+type stageTopics struct {
+	mu     sync.Mutex
+	topics map[string]string
+}
+
+// stageIRCChannel returns the IRC channel a stage is announced in: the one the stage channel
+// is bridged to, or StageIRCChannel. It returns "" if the stage isn't announced.
+func (d *discordBot) stageIRCChannel(stage *discordgo.StageInstance) string {
+	if stage == nil || stage.GuildID != d.guildID {
+		return ""
+	}
+	if mapping := d.bridge.GetMappingByDiscord(stage.ChannelID); mapping != nil {
+		return mapping.IRCName()
+	}
+	return d.bridge.Config.StageIRCChannel
+}
+
+// stageSpeakers returns the names of the people who can speak on a stage.
+// Audience members are suppressed.
+func (d *discordBot) stageSpeakers(channelID string) []string {
+	guild, err := d.State.Guild(d.guildID)
+	if err != nil {
+		return nil
+	}
+
+	d.State.RLock()
+	ids := []string{}
+	for _, state := range guild.VoiceStates {
+		if state.ChannelID == channelID && !state.Suppress {
+			ids = append(ids, state.UserID)
+		}
+	}
+	d.State.RUnlock()
+
+	speakers := make([]string, 0, len(ids))
+	for _, id := range ids {
+		speakers = append(speakers, d.memberName(id))
+	}
+	return speakers
+}
+
+// stageTopic makes a stage topic safe to put in an IRC notice.
+func stageTopic(topic string) string {
+	return strings.Join(strings.Fields(topic), " ")
+}
+
+// onStageStart announces a stage going live on IRC, with its topic and speakers.
+func (d *discordBot) onStageStart(s *discordgo.Session, e *discordgo.StageInstanceEventCreate) {
+	channel := d.stageIRCChannel(e.StageInstance)
+	if channel == "" {
+		return
+	}
+
+	d.bridge.stages.mu.Lock()
+	if d.bridge.stages.topics == nil {
+		d.bridge.stages.topics = make(map[string]string)
+	}
+	d.bridge.stages.topics[e.ChannelID] = e.Topic
+	d.bridge.stages.mu.Unlock()
+
+	notice := fmt.Sprintf("A stage is live in #%s: %s", d.channelName(e.ChannelID), stageTopic(e.Topic))
+	if speakers := d.stageSpeakers(e.ChannelID); len(speakers) > 0 {
+		notice += ". Speakers: " + strings.Join(speakers, ", ")
+	}
+	notice += fmt.Sprintf(". Listen at https://discord.com/channels/%s/%s", d.guildID, e.ChannelID)
+	d.bridge.ircListener.Notice(channel, "[Discord] "+notice)
+}
+
+// onStageUpdate relays stage topic changes to IRC.
+func (d *discordBot) onStageUpdate(s *discordgo.Session, e *discordgo.StageInstanceEventUpdate) {
+	channel := d.stageIRCChannel(e.StageInstance)
+	if channel == "" {
+		return
+	}
+
+	d.bridge.stages.mu.Lock()
+	if d.bridge.stages.topics == nil {
+		d.bridge.stages.topics = make(map[string]string)
+	}
+	before, known := d.bridge.stages.topics[e.ChannelID]
+	d.bridge.stages.topics[e.ChannelID] = e.Topic
+	d.bridge.stages.mu.Unlock()
+
+	// Other settings, like the privacy level, can change too
+	if known && before == e.Topic {
+		return
+	}
+	d.bridge.ircListener.Notice(channel, fmt.Sprintf("[Discord] The stage in #%s is now about: %s", d.channelName(e.ChannelID), stageTopic(e.Topic)))
+}
+
+// onStageEnd tells IRC that a stage has ended.
+func (d *discordBot) onStageEnd(s *discordgo.Session, e *discordgo.StageInstanceEventDelete) {
+	channel := d.stageIRCChannel(e.StageInstance)
+	if channel == "" {
+		return
+	}
+
+	d.bridge.stages.mu.Lock()
+	delete(d.bridge.stages.topics, e.ChannelID)
+	d.bridge.stages.mu.Unlock()
+
+	d.bridge.ircListener.Notice(channel, fmt.Sprintf("[Discord] The stage in #%s has ended.", d.channelName(e.ChannelID)))
+}
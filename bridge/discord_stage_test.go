@@ -0,0 +1,55 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStageAnnouncements(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.StageIRCChannel = testChannel
+	})
+	defer tb.Close()
+
+	d := tb.Bridge.discord
+	tb.discordMember("100", "alice", "")
+	tb.discordMember("200", "bob", "")
+	assert.NoError(t, d.State.ChannelAdd(&discordgo.Channel{ID: "3000", GuildID: testGuildID, Name: "talks", Type: discordgo.ChannelTypeGuildStageVoice}))
+	assert.NoError(t, d.State.OnInterface(d.Session, &discordgo.VoiceStateUpdate{VoiceState: &discordgo.VoiceState{GuildID: testGuildID, ChannelID: "3000", UserID: "100"}}))
+	assert.NoError(t, d.State.OnInterface(d.Session, &discordgo.VoiceStateUpdate{VoiceState: &discordgo.VoiceState{GuildID: testGuildID, ChannelID: "3000", UserID: "200", Suppress: true}}))
+
+	stage := &discordgo.StageInstance{ID: "9000", GuildID: testGuildID, ChannelID: "3000", Topic: "Writing\nbridges"}
+	d.onStageStart(d.Session, &discordgo.StageInstanceEventCreate{StageInstance: stage})
+	waitFor(t, "stage announced", func() bool {
+		return tb.ircd.HasReceived("listener", "NOTICE "+testChannel+" :[Discord] A stage is live in #talks: Writing bridges. Speakers: alice. Listen at https://discord.com/channels/"+testGuildID+"/3000")
+	})
+
+	// Only topic changes are relayed
+	d.onStageUpdate(d.Session, &discordgo.StageInstanceEventUpdate{StageInstance: &discordgo.StageInstance{ID: "9000", GuildID: testGuildID, ChannelID: "3000", Topic: "Writing\nbridges", PrivacyLevel: 2}})
+	d.onStageUpdate(d.Session, &discordgo.StageInstanceEventUpdate{StageInstance: &discordgo.StageInstance{ID: "9000", GuildID: testGuildID, ChannelID: "3000", Topic: "Q&A"}})
+	d.onStageEnd(d.Session, &discordgo.StageInstanceEventDelete{StageInstance: &discordgo.StageInstance{ID: "9000", GuildID: testGuildID, ChannelID: "3000", Topic: "Q&A"}})
+	waitFor(t, "stage ended", func() bool {
+		return tb.ircd.HasReceived("listener", "NOTICE "+testChannel+" :[Discord] The stage in #talks has ended.")
+	})
+
+	notices := []string{}
+	for _, line := range tb.ircd.Received("listener") {
+		if strings.HasPrefix(line, "NOTICE ") {
+			notices = append(notices, line)
+		}
+	}
+	assert.Equal(t, []string{
+		"NOTICE " + testChannel + " :[Discord] A stage is live in #talks: Writing bridges. Speakers: alice. Listen at https://discord.com/channels/" + testGuildID + "/3000",
+		"NOTICE " + testChannel + " :[Discord] The stage in #talks is now about: Q&A",
+		"NOTICE " + testChannel + " :[Discord] The stage in #talks has ended.",
+	}, notices)
+}
+
+func TestStageChannelMustBeBridged(t *testing.T) {
+	err := validateStageChannel(&Config{StageIRCChannel: "#talks", ChannelMappings: map[string]string{"#Test": "2000"}})
+	assert.EqualError(t, err, "stage_irc_channel #talks must be a bridged IRC channel")
+	assert.NoError(t, validateStageChannel(&Config{StageIRCChannel: "#test", ChannelMappings: map[string]string{"#Test": "2000"}}))
+}
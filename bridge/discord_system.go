@@ -0,0 +1,103 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// systemMessageKinds maps Discord system message types to the
+// name used to enable or disable them in the config.
+var systemMessageKinds = map[discordgo.MessageType]string{
+	discordgo.MessageTypeChannelPinnedMessage:                  "pin",
+	discordgo.MessageTypeGuildMemberJoin:                       "join",
+	discordgo.MessageTypeUserPremiumGuildSubscription:          "boost",
+	discordgo.MessageTypeUserPremiumGuildSubscriptionTierOne:   "boost",
+	discordgo.MessageTypeUserPremiumGuildSubscriptionTierTwo:   "boost",
+	discordgo.MessageTypeUserPremiumGuildSubscriptionTierThree: "boost",
+	discordgo.MessageTypeChannelFollowAdd:                      "follow",
+	discordgo.MessageTypeThreadCreated:                         "thread",
+}
+
+// isSystemMessage returns true if the message was sent by Discord
+// (pins, joins, boosts) rather than written by a user.
+func isSystemMessage(m *discordgo.Message) bool {
+	switch m.Type {
+	case discordgo.MessageTypeDefault,
+		discordgo.MessageTypeReply,
+		discordgo.MessageTypeChatInputCommand,
+		discordgo.MessageTypeContextMenuCommand:
+		return false
+	}
+	return true
+}
+
+// systemMessageEnabled returns whether system messages of the given kind should be relayed.
+// Kinds that are not configured are relayed.
+func (d *discordBot) systemMessageEnabled(kind string) bool {
+	enabled, ok := d.bridge.Config.SystemMessages[kind]
+	return !ok || enabled
+}
+
+// publishSystemMessage relays a Discord system message to IRC as an action by its author.
+//
+// Unknown system messages (and those disabled in the config) are dropped.
+func (d *discordBot) publishSystemMessage(s *discordgo.Session, m *discordgo.Message) {
+	kind, ok := systemMessageKinds[m.Type]
+	if !ok || !d.systemMessageEnabled(kind) {
+		return
+	}
+
+	content := ""
+	switch m.Type {
+	case discordgo.MessageTypeChannelPinnedMessage:
+		d.publishPin(s, m)
+		return
+	case discordgo.MessageTypeGuildMemberJoin:
+		content = "joined the server"
+	case discordgo.MessageTypeUserPremiumGuildSubscription:
+		content = "boosted the server"
+	case discordgo.MessageTypeUserPremiumGuildSubscriptionTierOne:
+		content = "boosted the server, which has achieved Level 1"
+	case discordgo.MessageTypeUserPremiumGuildSubscriptionTierTwo:
+		content = "boosted the server, which has achieved Level 2"
+	case discordgo.MessageTypeUserPremiumGuildSubscriptionTierThree:
+		content = "boosted the server, which has achieved Level 3"
+	case discordgo.MessageTypeChannelFollowAdd:
+		// The content is the name of the followed channel
+		content = fmt.Sprintf("followed %s in this channel", m.Content)
+	case discordgo.MessageTypeThreadCreated:
+		// The content is the name of the thread
+		content = fmt.Sprintf("started a thread: %s", m.Content)
+	}
+
+	d.bridge.discordMessageEventsChan <- &DiscordMessage{
+		Message:  m,
+		Content:  content,
+		IsAction: true,
+		PmTarget: "",
+	}
+}
+
+// publishPin relays a "pinned a message" system message to IRC
+func (d *discordBot) publishPin(s *discordgo.Session, m *discordgo.Message) {
+	if m.MessageReference == nil {
+		return
+	}
+
+	pinned, err := s.ChannelMessage(m.ChannelID, m.MessageReference.MessageID)
+	if err != nil {
+		d.bridge.handleError(err, nil, "could not get pinned message")
+		return
+	}
+
+	content := strings.Replace(d.ParseText(pinned), "\n", " ", -1)
+
+	d.bridge.discordMessageEventsChan <- &DiscordMessage{
+		Message:  m,
+		Content:  fmt.Sprintf("pinned: \"%s\"", TruncateString(80, content)),
+		IsAction: true,
+		PmTarget: "",
+	}
+}
@@ -0,0 +1,90 @@
+package bridge
+
+import (
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// embedDescriptionLength is how much of an embed description is relayed to IRC
+var embedDescriptionLength = 300
+
+// allowWebhook returns true if messages from a third-party webhook (e.g. GitHub or CI)
+// should be relayed from the given Discord channel.
+func (d *discordBot) allowWebhook(m *discordgo.Message) bool {
+	mapping := d.bridge.GetMappingByDiscord(m.ChannelID)
+	if mapping == nil {
+		return true
+	}
+
+	opts := d.bridge.channelOptions(mapping.IRCChannel)
+	if opts.DenyWebhooks {
+		return false
+	}
+
+	if len(opts.AllowedWebhooks) == 0 {
+		return true
+	}
+
+	for _, allowed := range opts.AllowedWebhooks {
+		if allowed == m.WebhookID || strings.EqualFold(allowed, m.Author.Username) {
+			return true
+		}
+	}
+	return false
+}
+
+// flattenEmbeds turns embeds into plain text lines for IRC.
+//
+// Each embed becomes its author and title (with the link), followed by the
+// description and fields, e.g.
+//
+//	[octocat] New issue: Fix the thing — https://github.com/...
+//	It is broken
+//	Labels: bug
+func flattenEmbeds(embeds []*discordgo.MessageEmbed) string {
+	lines := []string{}
+
+	for _, embed := range embeds {
+		// Link previews repeat a URL that is already in the message
+		if embed.Type == discordgo.EmbedTypeLink || embed.Type == discordgo.EmbedTypeImage || embed.Type == discordgo.EmbedTypeVideo || embed.Type == discordgo.EmbedTypeGifv {
+			continue
+		}
+
+		heading := []string{}
+		if embed.Author != nil && embed.Author.Name != "" {
+			heading = append(heading, "["+embed.Author.Name+"]")
+		}
+		if embed.Title != "" {
+			heading = append(heading, embed.Title)
+		}
+		if embed.URL != "" {
+			if len(heading) > 0 {
+				heading = append(heading, "—")
+			}
+			heading = append(heading, embed.URL)
+		}
+		if len(heading) > 0 {
+			lines = append(lines, strings.Join(heading, " "))
+		}
+
+		if embed.Description != "" {
+			for _, line := range strings.Split(TruncateString(embedDescriptionLength, embed.Description), "\n") {
+				if strings.TrimSpace(line) != "" {
+					lines = append(lines, line)
+				}
+			}
+		}
+
+		for _, field := range embed.Fields {
+			value := strings.Join(strings.Fields(field.Value), " ")
+			lines = append(lines, field.Name+": "+value)
+		}
+
+		if embed.Image != nil && embed.Image.URL != "" {
+			lines = append(lines, embed.Image.URL)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
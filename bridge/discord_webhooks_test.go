@@ -0,0 +1,60 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlattenEmbeds(t *testing.T) {
+	embeds := []*discordgo.MessageEmbed{
+		{
+			Type:        discordgo.EmbedTypeRich,
+			Author:      &discordgo.MessageEmbedAuthor{Name: "octocat"},
+			Title:       "New issue: Fix the thing",
+			URL:         "https://github.com/ocf/discordbridge/issues/1",
+			Description: "It is broken\n\nvery broken",
+			Fields:      []*discordgo.MessageEmbedField{{Name: "Labels", Value: "bug\nhelp wanted"}},
+		},
+		{Type: discordgo.EmbedTypeLink, URL: "https://example.com", Title: "Example"},
+		{Type: discordgo.EmbedTypeRich, Description: "Build passed"},
+	}
+
+	assert.Equal(t, "[octocat] New issue: Fix the thing — https://github.com/ocf/discordbridge/issues/1\n"+
+		"It is broken\nvery broken\n"+
+		"Labels: bug help wanted\n"+
+		"Build passed", flattenEmbeds(embeds))
+
+	assert.Equal(t, "", flattenEmbeds(nil))
+}
+
+func TestRelayWebhookMessages(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.ChannelOptions = map[string]ChannelOptions{
+			testChannel: {AllowedWebhooks: []string{"GitHub"}},
+		}
+	})
+	defer tb.Close()
+
+	webhook := func(id, name string, embed *discordgo.MessageEmbed) {
+		tb.Bridge.discord.publishMessage(tb.Bridge.discord.Session, &discordgo.Message{
+			ID:        tb.discord.id(),
+			ChannelID: testChannelID,
+			GuildID:   testGuildID,
+			WebhookID: id,
+			Author:    &discordgo.User{ID: id, Username: name, Discriminator: "0000", Bot: true},
+			Embeds:    []*discordgo.MessageEmbed{embed},
+		}, false)
+	}
+
+	webhook("7000", "Jenkins", &discordgo.MessageEmbed{Type: discordgo.EmbedTypeRich, Title: "Build failed"})
+	webhook("7001", "GitHub", &discordgo.MessageEmbed{Type: discordgo.EmbedTypeRich, Title: "Build passed"})
+
+	waitFor(t, "webhook message on irc", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<G\u200BitHub> Build passed")
+	})
+	for _, line := range tb.ircd.Received("listener") {
+		assert.NotContains(t, line, "Build failed")
+	}
+}
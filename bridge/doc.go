@@ -0,0 +1,18 @@
+// Package bridge relays messages between IRC channels and Discord channels.
+//
+// The bridge can be embedded in another program:
+//
+//	b, err := bridge.New(conf)
+//	if err != nil {
+//		return err
+//	}
+//	go b.Run(ctx)
+//	...
+//	err = b.SendToIRC("#general", "Deploy finished")
+//
+// New returns a Bridge that is ready to be opened, with either Open or Run, once.
+// While it is running, Close, SendToIRC, SendToDiscord and Broadcast can be called from
+// any goroutine. Close can be called more than once, and Run closes the bridge itself when
+// its context is cancelled. SetChannelMappings, SetIRCListenerName and SetDebugMode are for
+// reloading the config, and must not be called at the same time as each other.
+package bridge
@@ -0,0 +1,79 @@
+package bridge
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrLeadershipLost is returned by Run when another instance takes over relaying.
+var ErrLeadershipLost = errors.New("another instance has taken over relaying")
+
+// Run opens the bridge, and relays until the context is cancelled, then closes it.
+// It returns ErrLeadershipLost if high availability is set up and another instance took over,
+// after which the bridge should be made again so that it can stand by, and the fatal error
+// if the bridge can't continue (see Failed).
+func (b *Bridge) Run(ctx context.Context) error {
+	if err := b.Open(); err != nil {
+		b.Close()
+		return err
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case <-b.LeadershipLost():
+		err = ErrLeadershipLost
+	case err = <-b.Failed():
+	}
+
+	b.Close()
+	return err
+}
+
+// errNotRelaying is returned when the bridge can't send a message because another instance is relaying,
+// or because it is in shadow mode.
+var errNotRelaying = errors.New("this instance is not relaying")
+
+// SendToIRC sends a message to an IRC channel, or a nick, as the listener. Each line is sent
+// as a separate message. The listener must be in the channel, like a bridged or status channel.
+// It returns an error if the listener is not connected.
+func (b *Bridge) SendToIRC(target, message string) error {
+	if target == "" || strings.ContainsAny(target, " \r\n") {
+		return errors.Errorf("invalid IRC target %q", target)
+	}
+	if !b.isLeader() || b.Config.Shadow {
+		return errNotRelaying
+	}
+	// Sending before the listener has connected would block forever
+	if !b.ircListener.Healthy() {
+		return errors.New("the listener is not connected to IRC")
+	}
+
+	for _, line := range strings.Split(message, "\n") {
+		if line = strings.TrimRight(line, "\r"); line != "" {
+			b.ircListener.Privmsg(target, line)
+		}
+	}
+	return nil
+}
+
+// SendToDiscord sends a message to a Discord channel as the bot, returning the ID of the message.
+// @everyone and @here don't ping anyone.
+func (b *Bridge) SendToDiscord(channelID, message string) (string, error) {
+	if channelID == "" {
+		return "", errors.New("missing Discord channel")
+	}
+	if !b.isLeader() || b.Config.Shadow {
+		return "", errNotRelaying
+	}
+
+	sent, err := b.discord.ChannelMessageSend(channelID, sanitiseDiscordContent(message))
+	if err != nil {
+		b.handleError(err, log.Fields{"channel": channelID}, "could not send message to discord")
+		return "", errors.Wrap(err, "could not send message to discord")
+	}
+	return sent.ID, nil
+}
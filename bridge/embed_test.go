@@ -0,0 +1,31 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendToIRCAndDiscord(t *testing.T) {
+	tb := newTestBridge(t, nil)
+	defer tb.Close()
+	waitFor(t, "listener to be healthy", tb.Bridge.ircListener.Healthy)
+
+	assert.NoError(t, tb.Bridge.SendToIRC(testChannel, "Deploy finished\r\nAll good"))
+	waitFor(t, "message on irc", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :Deploy finished") &&
+			tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :All good")
+	})
+	assert.Error(t, tb.Bridge.SendToIRC("#a #b", "hi"))
+
+	id, err := tb.Bridge.SendToDiscord(testChannelID, "Deploy finished @everyone")
+	assert.NoError(t, err)
+	sent, ok := tb.discord.Find("Deploy finished @\u200Beveryone" + relayMarker)
+	if assert.True(t, ok) {
+		assert.Equal(t, sent.ID, id)
+	}
+
+	// Closing more than once is fine
+	tb.Bridge.Close()
+	tb.Bridge.Close()
+}
@@ -0,0 +1,177 @@
+package bridge
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// errorCategory is the kind of failure an error represents,
+// which decides how the bridge handles it.
+type errorCategory int
+
+const (
+	// errTransient is a network hiccup, rate limit or server error. The action can be retried.
+	errTransient errorCategory = iota
+
+	// errPermission means the bot is missing a Discord permission or IRC privilege.
+	// The bridge keeps running without the feature that needs it.
+	errPermission
+
+	// errConfig means the configuration is invalid. The operator needs to fix it.
+	errConfig
+
+	// errFatal means the bridge cannot continue, e.g. the bot token was revoked.
+	errFatal
+
+	// errRejected means Discord refused the request itself, e.g. it was malformed or was for
+	// something that has been deleted. Retrying it won't help.
+	errRejected
+)
+
+func (c errorCategory) String() string {
+	switch c {
+	case errTransient:
+		return "transient"
+	case errPermission:
+		return "permission"
+	case errConfig:
+		return "config"
+	case errFatal:
+		return "fatal"
+	case errRejected:
+		return "rejected"
+	}
+	return "unknown"
+}
+
+// categorisedError is an error with a known category.
+type categorisedError struct {
+	category errorCategory
+	error
+}
+
+// Cause is used by github.com/pkg/errors
+func (e *categorisedError) Cause() error {
+	return e.error
+}
+
+// withCategory records the category of an error.
+func withCategory(err error, category errorCategory) error {
+	if err == nil {
+		return nil
+	}
+	return &categorisedError{category, err}
+}
+
+// categorise works out the category of an error, from the category recorded
+// by withCategory, or from the Discord or network error that caused it.
+func categorise(err error) errorCategory {
+	for err != nil {
+		switch e := err.(type) {
+		case *categorisedError:
+			return e.category
+		case *discordgo.RESTError:
+			return categoriseREST(e)
+		case net.Error:
+			return errTransient
+		}
+
+		// The gateway closes the connection with 4004 if the token is invalid
+		if strings.Contains(err.Error(), "websocket: close 4004") {
+			return errFatal
+		}
+
+		cause, ok := err.(interface{ Cause() error })
+		if !ok {
+			break
+		}
+		err = cause.Cause()
+	}
+
+	return errTransient
+}
+
+func categoriseREST(err *discordgo.RESTError) errorCategory {
+	if err.Message != nil {
+		switch err.Message.Code {
+		case discordgo.ErrCodeMissingPermissions, discordgo.ErrCodeMissingAccess:
+			return errPermission
+		case discordgo.ErrCodeUnknownChannel, discordgo.ErrCodeUnknownGuild:
+			return errConfig
+		}
+	}
+
+	if err.Response == nil {
+		return errTransient
+	}
+
+	switch code := err.Response.StatusCode; {
+	case code == http.StatusUnauthorized:
+		return errFatal
+	case code == http.StatusForbidden:
+		return errPermission
+	case code >= 400 && code < 500 && code != http.StatusTooManyRequests:
+		return errRejected
+	}
+
+	// Rate limits and server errors
+	return errTransient
+}
+
+// ErrorCategory returns the category of an error returned by the bridge:
+// "transient", "permission", "config", "fatal" or "rejected".
+func ErrorCategory(err error) string {
+	return categorise(err).String()
+}
+
+// handleError logs an error according to its category, and returns the category
+// so the caller can decide whether to retry.
+//
+// Transient errors are warnings, as they usually fix themselves.
+// Permission and rejected errors mean a feature is degraded or a message was lost.
+// Config errors stop the bridge from starting, or a reload from being applied, and when they
+// happen while running they are marked with "alert" so that monitoring can page someone.
+// Fatal errors shut the bridge down, see Failed.
+func (b *Bridge) handleError(err error, fields log.Fields, message string) errorCategory {
+	category := categorise(err)
+
+	entry := log.WithFields(fields).WithFields(log.Fields{
+		"error":    err,
+		"category": category.String(),
+	})
+
+	switch category {
+	case errTransient:
+		entry.Warnln(message)
+	case errPermission, errRejected:
+		entry.Errorln(message)
+	case errConfig:
+		entry.WithField("alert", true).Errorln(message)
+	case errFatal:
+		entry.WithField("alert", true).Errorln(message + ", shutting down")
+		b.fail(errors.Wrap(err, message))
+	}
+
+	return category
+}
+
+// Failed receives the error the bridge can't continue after, e.g. the bot token was revoked.
+// The bridge should then be closed, and the program exit with an error so that someone looks at it.
+func (b *Bridge) Failed() <-chan error {
+	return b.failed
+}
+
+// fail tells whoever is running the bridge that it can't continue.
+func (b *Bridge) fail(err error) {
+	b.failOnce.Do(func() {
+		select {
+		case b.failed <- err:
+		default:
+		}
+	})
+}
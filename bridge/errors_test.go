@@ -0,0 +1,57 @@
+package bridge
+
+import (
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func restError(status int, code int) error {
+	return &discordgo.RESTError{
+		Response: &http.Response{StatusCode: status},
+		Message:  &discordgo.APIErrorMessage{Code: code},
+	}
+}
+
+func TestCategorise(t *testing.T) {
+	assert.Equal(t, errFatal, categorise(errors.Wrap(restError(http.StatusUnauthorized, 0), "could not send")))
+	assert.Equal(t, errPermission, categorise(restError(http.StatusForbidden, discordgo.ErrCodeMissingPermissions)))
+	assert.Equal(t, errConfig, categorise(restError(http.StatusNotFound, discordgo.ErrCodeUnknownChannel)))
+	assert.Equal(t, errTransient, categorise(restError(http.StatusBadGateway, 0)))
+	assert.Equal(t, errTransient, categorise(restError(http.StatusTooManyRequests, 0)))
+	assert.Equal(t, errRejected, categorise(restError(http.StatusBadRequest, 50035)))
+	assert.Equal(t, errRejected, categorise(restError(http.StatusNotFound, 0)))
+	assert.Equal(t, errRejected, categorise(restError(http.StatusRequestEntityTooLarge, 0)))
+	assert.Equal(t, errTransient, categorise(&net.OpError{Op: "dial", Err: errors.New("refused")}))
+
+	// Categories recorded by the bridge win over what caused them
+	err := errors.Wrap(withCategory(restError(http.StatusForbidden, 0), errConfig), "configuration invalid")
+	assert.Equal(t, errConfig, categorise(err))
+	assert.Equal(t, "config", ErrorCategory(err))
+
+	assert.Equal(t, errFatal, categorise(errors.New("websocket: close 4004: Authentication failed.")))
+	assert.Equal(t, errTransient, categorise(errors.New("something else")))
+}
+
+func TestHandleErrorFatal(t *testing.T) {
+	b := &Bridge{failed: make(chan error, 1)}
+
+	// Only fatal errors shut the bridge down
+	assert.Equal(t, errTransient, b.handleError(restError(http.StatusBadGateway, 0), nil, "could not send"))
+	assert.Equal(t, errConfig, b.handleError(restError(http.StatusNotFound, discordgo.ErrCodeUnknownChannel), nil, "could not send"))
+	assert.Len(t, b.Failed(), 0)
+
+	assert.Equal(t, errFatal, b.handleError(restError(http.StatusUnauthorized, 0), nil, "could not send"))
+	assert.Equal(t, errFatal, b.handleError(restError(http.StatusUnauthorized, 0), nil, "could not send again"))
+	err := <-b.Failed()
+	assert.Contains(t, err.Error(), "could not send: ")
+	assert.Equal(t, errFatal, categorise(err))
+	assert.Len(t, b.Failed(), 0)
+
+	// Bridges made without New don't block
+	(&Bridge{}).handleError(restError(http.StatusUnauthorized, 0), nil, "could not send")
+}
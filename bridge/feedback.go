@@ -0,0 +1,127 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	irc "github.com/qaisjp/go-ircevent"
+	log "github.com/sirupsen/logrus"
+)
+
+// failureEmoji is the reaction added to Discord messages that could not be relayed
+const failureEmoji = "❌"
+
+// failureWindow is how long after a Discord message is relayed
+// an IRC error about its target is blamed on it
+var failureWindow = 30 * time.Second
+
+// relayFailures remembers the last Discord message relayed to each IRC target,
+// so that the sender can be told if IRC refuses it.
+type relayFailures struct {
+	mu   sync.Mutex
+	last map[string]sentToIRC // keyed by lowercase channel or nick
+}
+
+type sentToIRC struct {
+	msg  *discordgo.Message
+	time time.Time
+}
+
+// Sent records a Discord message relayed to an IRC channel or nick.
+func (f *relayFailures) Sent(target string, msg *discordgo.Message) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.last[strings.ToLower(target)] = sentToIRC{msg, time.Now()}
+}
+
+// Failed returns, and forgets, the message recently relayed to target, if any.
+func (f *relayFailures) Failed(target string) *discordgo.Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	sent, ok := f.last[strings.ToLower(target)]
+	delete(f.last, strings.ToLower(target))
+	if !ok || time.Since(sent.time) > failureWindow {
+		return nil
+	}
+	return sent.msg
+}
+
+// failureReason describes why Discord refused a message, for the person who sent it.
+func failureReason(err error) string {
+	for e := err; e != nil; {
+		if rest, ok := e.(*discordgo.RESTError); ok && rest.Message != nil && rest.Message.Message != "" {
+			return rest.Message.Message
+		}
+		cause, ok := e.(interface{ Cause() error })
+		if !ok {
+			break
+		}
+		e = cause.Cause()
+	}
+
+	switch categorise(err) {
+	case errPermission:
+		return "the bridge is missing a Discord permission"
+	case errConfig:
+		return "the Discord channel could not be found"
+	case errFatal:
+		return "the bridge could not log in to Discord"
+	case errRejected:
+		return "Discord refused the message"
+	}
+	return "Discord is having problems, try again later"
+}
+
+// relayFailedToDiscord tells an IRC user, in a private NOTICE, that their message was not relayed.
+func (b *Bridge) relayFailedToDiscord(msg IRCMessage, err error) {
+	if !b.Config.FailureFeedback || msg.Probe != "" {
+		return
+	}
+	b.ircListener.Notice(msg.Username, fmt.Sprintf("Your message to %s was not relayed to Discord: %s", msg.IRCChannel, failureReason(err)))
+}
+
+// relayFailedToIRC reacts to the Discord message recently relayed to target,
+// and replies with the reason IRC gave for refusing it.
+func (b *Bridge) relayFailedToIRC(target, reason string) {
+	if !b.Config.FailureFeedback {
+		return
+	}
+
+	msg := b.failures.Failed(target)
+	if msg == nil {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"target": target,
+		"reason": reason,
+	}).Warnln("IRC refused a message relayed from Discord.")
+
+	if err := b.discord.MessageReactionAdd(msg.ChannelID, msg.ID, failureEmoji); err != nil {
+		b.handleError(err, nil, "could not react to a message that failed to relay")
+	}
+	b.discord.reply(msg, fmt.Sprintf("Your message was not relayed to %s: %s", target, reason))
+}
+
+// OnCannotSend handles ERR_CANNOTSENDTOCHAN, when the listener can't speak in a channel.
+// "<nick> <channel> :<reason>"
+func (i *ircListener) OnCannotSend(e *irc.Event) {
+	if len(e.Arguments) < 2 {
+		return
+	}
+	i.bridge.health.refusedToSend(e.Arguments[1], e.Message())
+	i.bridge.relayFailedToIRC(e.Arguments[1], e.Message())
+}
+
+// OnNoSuchNick handles ERR_NOSUCHNICK, when a PM from a puppet could not be delivered.
+// "<nick> <target> :<reason>"
+func (i *ircConnection) OnNoSuchNick(e *irc.Event) {
+	if len(e.Arguments) < 2 {
+		return
+	}
+	i.manager.bridge.relayFailedToIRC(e.Arguments[1], e.Message())
+}
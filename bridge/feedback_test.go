@@ -0,0 +1,74 @@
+package bridge
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFailureFeedbackToIRC(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.FailureFeedback = true
+	})
+	defer tb.Close()
+
+	tb.discord.RefuseWebhooks(http.StatusForbidden, "Missing Permissions")
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :hello")
+
+	waitFor(t, "notice to alice", func() bool {
+		return tb.ircd.HasReceived("listener", "NOTICE alice :Your message to "+testChannel+" was not relayed to Discord: Missing Permissions")
+	})
+}
+
+func TestFailureFeedbackToDiscord(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.FailureFeedback = true
+	})
+	defer tb.Close()
+
+	bob := tb.discordMember("100", "bob", "")
+	d := tb.Bridge.discord
+	msg := &discordgo.Message{
+		ID:        tb.discord.id(),
+		ChannelID: testChannelID,
+		GuildID:   testGuildID,
+		Author:    bob,
+		Content:   "can you hear me",
+		Timestamp: time.Now(),
+	}
+	d.onMessageCreate(d.Session, &discordgo.MessageCreate{Message: msg})
+	waitFor(t, "message on irc", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<b\u200Bob#0001> can you hear me")
+	})
+
+	tb.ircd.SendTo("listener", ":irc.example.com 404 listener %s :Cannot send to channel", testChannel)
+	waitFor(t, "failure reaction", func() bool {
+		return len(tb.discord.Reactions(msg.ID)) > 0
+	})
+	assert.Equal(t, []string{failureEmoji}, tb.discord.Reactions(msg.ID))
+
+	waitFor(t, "failure reply", func() bool {
+		_, ok := tb.discord.Find("Your message was not relayed to " + testChannel + ": Cannot send to channel")
+		return ok
+	})
+
+	// A message is only reported once
+	tb.ircd.SendTo("listener", ":irc.example.com 404 listener %s :Cannot send to channel", testChannel)
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :done")
+	waitFor(t, "later message on discord", func() bool {
+		_, ok := tb.discord.Find("done" + relayMarker)
+		return ok
+	})
+	assert.Len(t, tb.discord.Reactions(msg.ID), 1)
+}
+
+func TestFailureReason(t *testing.T) {
+	assert.Equal(t, "Unknown Channel", failureReason(&discordgo.RESTError{
+		Message: &discordgo.APIErrorMessage{Code: discordgo.ErrCodeUnknownChannel, Message: "Unknown Channel"},
+	}))
+	assert.Equal(t, "the bridge is missing a Discord permission", failureReason(withCategory(assert.AnError, errPermission)))
+	assert.Equal(t, "Discord is having problems, try again later", failureReason(assert.AnError))
+}
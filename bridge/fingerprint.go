@@ -0,0 +1,89 @@
+package bridge
+
+import (
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	ircf "github.com/qaisjp/go-discord-irc/irc/format"
+)
+
+// fingerprintMinLength is the shortest (normalised) message that is deduplicated,
+// so that two people saying "lol" at the same time are both relayed.
+var fingerprintMinLength = 8
+
+// fingerprints remembers what was recently relayed in one direction, so that
+// bots echoing it back (e.g. log bots) aren't relayed in the other direction.
+//
+// It is safe for concurrent use.
+type fingerprints struct {
+	window time.Duration
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func newFingerprints(window time.Duration) *fingerprints {
+	return &fingerprints{
+		window: window,
+		seen:   make(map[string]time.Time),
+	}
+}
+
+// fingerprint normalises content so that formatting and punctuation don't matter.
+func fingerprint(content string) string {
+	content = ircf.StripCodes(content)
+
+	var b strings.Builder
+	for _, r := range content {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(unicode.ToLower(r))
+		}
+	}
+	return b.String()
+}
+
+// Add records content relayed from an author.
+//
+// Echoes can include the author, like "<alice> hello" or "alice: hello", so both are remembered.
+func (f *fingerprints) Add(author, content string) {
+	if f.window <= 0 {
+		return
+	}
+
+	now := time.Now()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for print, at := range f.seen {
+		if now.Sub(at) > f.window {
+			delete(f.seen, print)
+		}
+	}
+
+	for _, print := range []string{fingerprint(content), fingerprint(author + content)} {
+		if len(print) >= fingerprintMinLength {
+			f.seen[print] = now
+		}
+	}
+}
+
+// Seen returns true if the content was recently relayed.
+func (f *fingerprints) Seen(content string) bool {
+	if f.window <= 0 {
+		return false
+	}
+
+	print := fingerprint(content)
+	if len(print) < fingerprintMinLength {
+		return false
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	at, ok := f.seen[print]
+	return ok && time.Since(at) <= f.window
+}
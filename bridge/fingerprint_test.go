@@ -0,0 +1,30 @@
+package bridge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFingerprints(t *testing.T) {
+	f := newFingerprints(time.Minute)
+	f.Add("alice", "Hello, **world**!")
+
+	assert.True(t, f.Seen("hello world"))
+	assert.True(t, f.Seen("<alice> Hello, world!"))
+	assert.True(t, f.Seen("\x02alice\x02: hello world"))
+	assert.False(t, f.Seen("<bob> hello world"))
+	assert.False(t, f.Seen("goodbye world"))
+
+	// Short messages are never deduplicated
+	f.Add("alice", "lol")
+	assert.False(t, f.Seen("lol"))
+
+	f.seen[fingerprint("hello world")] = time.Now().Add(-time.Hour)
+	assert.False(t, f.Seen("hello world"))
+
+	disabled := newFingerprints(0)
+	disabled.Add("alice", "Hello, world!")
+	assert.False(t, disabled.Seen("Hello, world!"))
+}
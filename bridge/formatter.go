@@ -0,0 +1,169 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	ircf "github.com/qaisjp/go-discord-irc/irc/format"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultFormatter is used if Config.Formatter isn't set
+const defaultFormatter = "markdown"
+
+// canaryDiffContext is how much text is logged around where canary output differs
+const canaryDiffContext = 20
+
+// A Formatter converts the text of relayed messages between IRC formatting and Discord markdown.
+// It is called for every relayed message, and concurrently, so it should be quick and safe
+// for concurrent use. The formatting benchmarks in benchmark_test.go measure it.
+type Formatter interface {
+	// ToDiscord converts a message from IRC, with its formatting codes, to Discord markdown
+	ToDiscord(text string) string
+
+	// ToIRC converts a message from Discord, in markdown, to IRC
+	ToIRC(text string) string
+}
+
+// markdownEscaper escapes the characters Discord treats as markdown.
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"*", `\*`,
+	"_", `\_`,
+	"~", `\~`,
+	"|", `\|`,
+	"`", "\\`",
+)
+
+// escapeMarkdown makes text that isn't markdown, like a nick, show up on Discord as typed.
+func escapeMarkdown(text string) string {
+	return markdownEscaper.Replace(text)
+}
+
+// formatters are the formatters that can be picked in the config, keyed by name.
+var formatters = map[string]Formatter{
+	"markdown": markdownFormatter{},
+	"plain":    plainFormatter{},
+}
+
+// RegisterFormatter adds a formatter that can be used as Config.Formatter or Config.CanaryFormatter.
+// It must be called before New.
+func RegisterFormatter(name string, f Formatter) {
+	if _, ok := formatters[name]; ok {
+		panic("formatter registered twice: " + name)
+	}
+	formatters[name] = f
+}
+
+func validateFormatters(opts *Config) error {
+	for _, name := range []string{opts.Formatter, opts.CanaryFormatter} {
+		if _, ok := formatters[name]; name != "" && !ok {
+			return errors.Errorf("unknown formatter %q", name)
+		}
+	}
+	return nil
+}
+
+// markdownFormatter turns IRC formatting into the markdown that looks the same, and Discord
+// markdown into the IRC formatting that looks the same.
+type markdownFormatter struct{}
+
+func (markdownFormatter) ToDiscord(text string) string {
+	// Most messages have no formatting at all
+	if !ircf.HasCodes(text) {
+		return text
+	}
+	return ircf.BlocksToMarkdown(ircf.Parse(ircf.StripColor(text)))
+}
+
+func (markdownFormatter) ToIRC(text string) string {
+	return ircf.MarkdownToIRC(text)
+}
+
+// plainFormatter drops IRC formatting, and relays Discord markdown to IRC as it was typed.
+type plainFormatter struct{}
+
+func (plainFormatter) ToDiscord(text string) string {
+	return ircf.StripCodes(text)
+}
+
+func (plainFormatter) ToIRC(text string) string {
+	return text
+}
+
+func (b *Bridge) formatter() Formatter {
+	if f, ok := formatters[b.Config.Formatter]; ok {
+		return f
+	}
+	return formatters[defaultFormatter]
+}
+
+// formatToDiscord formats a message relayed from an IRC channel.
+func (b *Bridge) formatToDiscord(channel, text string) string {
+	formatted := b.formatter().ToDiscord(text)
+	if canary, ok := formatters[b.Config.CanaryFormatter]; ok {
+		b.compareCanary("discord", channel, formatted, canary.ToDiscord(text))
+	}
+	return formatted
+}
+
+// formatToIRC formats a message relayed from a Discord channel.
+func (b *Bridge) formatToIRC(channel, text string) string {
+	formatted := b.formatter().ToIRC(text)
+	if canary, ok := formatters[b.Config.CanaryFormatter]; ok {
+		b.compareCanary("irc", channel, formatted, canary.ToIRC(text))
+	}
+
+	// Some networks don't allow formatting, so the codes are dropped after the markdown is converted
+	if b.Config.StripIRCFormatting {
+		formatted = ircf.StripCodes(formatted)
+	}
+	return formatted
+}
+
+// compareCanary logs where the canary formatter's output differs from what was relayed.
+func (b *Bridge) compareCanary(direction, channel, relayed, canary string) {
+	if relayed == canary {
+		return
+	}
+	log.WithFields(log.Fields{
+		"to":      direction,
+		"channel": channel,
+		"relayed": relayed,
+		"canary":  canary,
+		"diff":    canaryDiff(relayed, canary),
+	}).Infoln("Canary formatter output differs.")
+}
+
+// canaryDiff shows where two strings differ, like `hello [**world**→*world*]`,
+// with canaryDiffContext characters around the difference.
+func canaryDiff(a, b string) string {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	// Don't split characters in half
+	for prefix > 0 && prefix < len(a) && !utf8.RuneStart(a[prefix]) {
+		prefix--
+	}
+	for suffix > 0 && !utf8.RuneStart(a[len(a)-suffix]) {
+		suffix--
+	}
+
+	before := []rune(a[:prefix])
+	if len(before) > canaryDiffContext {
+		before = append([]rune("…"), before[len(before)-canaryDiffContext:]...)
+	}
+	after := []rune(a[len(a)-suffix:])
+	if len(after) > canaryDiffContext {
+		after = append(after[:canaryDiffContext], '…')
+	}
+	return fmt.Sprintf("%s[%s→%s]%s", string(before), a[prefix:len(a)-suffix], b[prefix:len(b)-suffix], string(after))
+}
@@ -0,0 +1,82 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanaryDiff(t *testing.T) {
+	assert.Equal(t, "[**bold**→bold] text", canaryDiff("**bold** text", "bold text"))
+	assert.Equal(t, "a [*→_]b", canaryDiff("a *b", "a _b"))
+	assert.Equal(t, "caf[é→e]", canaryDiff("café", "cafe"))
+	assert.Equal(t, "…"+strings.Repeat("x", 20)+"[a→b]"+strings.Repeat("y", 20)+"…",
+		canaryDiff(strings.Repeat("x", 30)+"a"+strings.Repeat("y", 30), strings.Repeat("x", 30)+"b"+strings.Repeat("y", 30)))
+
+	assert.NoError(t, validateFormatters(&Config{Formatter: "plain", CanaryFormatter: "markdown"}))
+	assert.Error(t, validateFormatters(&Config{CanaryFormatter: "fancy"}))
+}
+
+func TestCanaryFormatter(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.CanaryFormatter = "plain"
+	})
+	defer tb.Close()
+
+	hook := logtest.NewGlobal()
+	log.SetLevel(log.InfoLevel)
+	defer log.SetLevel(log.WarnLevel)
+
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :\x02bold\x02 text")
+	waitFor(t, "formatted message on discord", func() bool {
+		_, ok := tb.discord.Find("**bold** text" + relayMarker)
+		return ok
+	})
+
+	var diffs []string
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Canary formatter output differs." {
+			diffs = append(diffs, entry.Data["diff"].(string))
+		}
+	}
+	assert.Equal(t, []string{"[**bold**→bold] text"}, diffs)
+}
+
+func TestMarkdownToIRC(t *testing.T) {
+	tb := newTestBridge(t, nil)
+	defer tb.Close()
+
+	bob := tb.discordMember("100", "bob", "")
+	tb.discordSay(bob, "**bold** and ~~struck~~")
+	waitFor(t, "formatted message on irc", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<b\u200Bob#0001> \x02bold\x02 and \x1estruck\x1e")
+	})
+
+	// Actions aren't italicised
+	tb.discordSay(bob, "_waves_")
+	waitFor(t, "action on irc", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<b\u200Bob#0001> waves")
+	})
+}
+
+func TestStripIRCFormatting(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.StripIRCFormatting = true
+	})
+	defer tb.Close()
+
+	bob := tb.discordMember("100", "bob", "")
+	tb.discordSay(bob, "**bold** and __underlined__")
+	waitFor(t, "plain message on irc", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<b\u200Bob#0001> bold and underlined")
+	})
+
+	// Escaped markdown is relayed as the literal characters
+	tb.discordSay(bob, `\*literal\*`)
+	waitFor(t, "literal asterisks on irc", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<b\u200Bob#0001> *literal*")
+	})
+}
@@ -0,0 +1,128 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	ircnick "github.com/qaisjp/go-discord-irc/irc/nick"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// newFuzzDiscord returns a discordBot with a guild in its state, but no connections.
+func newFuzzDiscord(t testing.TB) *discordBot {
+	log.SetLevel(log.WarnLevel)
+
+	b := &Bridge{Config: &Config{GuildID: testGuildID, Suffix: "_d", Separator: "_"}, activity: newActivity()}
+	b.ircListener = &ircListener{users: newIRCUserTracker()}
+	b.ircManager = newIRCManager(b)
+
+	session, err := discordgo.New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = session.State.GuildAdd(&discordgo.Guild{
+		ID:       testGuildID,
+		Channels: []*discordgo.Channel{{ID: testChannelID, GuildID: testGuildID, Name: "general"}},
+		Roles:    []*discordgo.Role{{ID: "4000", Name: "staff", Mentionable: true}},
+		Members: []*discordgo.Member{
+			{GuildID: testGuildID, User: &discordgo.User{ID: "100", Username: "bob", Discriminator: "0001"}},
+			{GuildID: testGuildID, User: &discordgo.User{ID: "200", Username: "carol", Discriminator: "0002"}, Nick: "Caz 🔴"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.discord = &discordBot{Session: session, bridge: b, guildID: testGuildID}
+	return b.discord
+}
+
+func FuzzParseText(f *testing.F) {
+	seeds := []string{
+		"",
+		"hello world",
+		"hey <@100> and <@!200>",
+		"<@&4000> meeting now",
+		"see <#2000> or <#999>",
+		"<@&999> <#> <@> <@!>",
+		"nice <:pog:123456789> <a:spin:987654321>",
+		"line one\r\nline two\rthree",
+		"_waves at <@200>_",
+		"<#<#2000>>",
+	}
+	for _, seed := range seeds {
+		f.Add(seed, "200")
+	}
+
+	d := newFuzzDiscord(f)
+
+	f.Fuzz(func(t *testing.T, content string, mention string) {
+		m := &discordgo.Message{
+			Content:      content,
+			GuildID:      testGuildID,
+			Mentions:     []*discordgo.User{{ID: mention, Username: "carol"}, {ID: "100", Username: "bob"}},
+			MentionRoles: []string{"4000", mention},
+		}
+
+		parsed := d.ParseText(m)
+		if strings.Contains(parsed, "\r") {
+			t.Errorf("ParseText(%q) = %q contains a carriage return", content, parsed)
+		}
+
+		parseAction(parsed)
+	})
+}
+
+func TestParseAction(t *testing.T) {
+	content, ok := parseAction("_waves at Caz_d_")
+	assert.True(t, ok)
+	assert.Equal(t, "waves at Caz_d", content)
+
+	// Mentions can make the parsed content longer than the original message
+	d := newFuzzDiscord(t)
+	content, ok = parseAction(d.ParseText(&discordgo.Message{
+		Content:  "_<@200>_",
+		Mentions: []*discordgo.User{{ID: "200", Username: "carol"}},
+	}))
+	assert.True(t, ok)
+	assert.NotContains(t, content, "_d_")
+
+	_, ok = parseAction("__")
+	assert.False(t, ok)
+}
+
+func FuzzPmTargetFromContent(f *testing.F) {
+	seeds := []string{
+		"qais, come on, i need this!",
+		"qais,no space",
+		"no comma here",
+		",empty nick",
+		"bad nick!, hello",
+		"",
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, content string) {
+		nick, newContent := pmTargetFromContent(content)
+		if nick == "" {
+			return
+		}
+
+		if !strings.HasPrefix(content, nick+",") {
+			t.Errorf("pmTargetFromContent(%q) returned nick %q which does not start the content", content, nick)
+		}
+		if !strings.HasSuffix(content, newContent) {
+			t.Errorf("pmTargetFromContent(%q) returned content %q which does not end the content", content, newContent)
+		}
+		for _, c := range []byte(nick) {
+			if !ircnick.IsNickChar(c) {
+				t.Errorf("pmTargetFromContent(%q) returned invalid nick %q", content, nick)
+			}
+		}
+	})
+}
@@ -0,0 +1,734 @@
+package bridge
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/qaisjp/go-discord-irc/transmitter"
+	log "github.com/sirupsen/logrus"
+)
+
+// This file contains a test harness for the relay pipeline.
+//
+// fakeDiscord answers the REST requests made by discordgo in memory,
+// and fakeIRCd is a minimal IRC server. Gateway events are simulated
+// by calling the Discord handlers directly.
+
+const (
+	testGuildID   = "1000"
+	testChannelID = "2000"
+	testBotID     = "3000"
+	testChannel   = "#test"
+)
+
+// waitFor polls cond until it returns true, failing the test after a few seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second * 5)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+// fakeDiscord is an http.RoundTripper implementing the parts of the Discord REST API used by the bridge.
+type fakeDiscord struct {
+	mu       sync.Mutex
+	nextID   int
+	webhooks map[string]*discordgo.Webhook
+	messages map[string]*discordgo.Message
+	pins     map[string]bool
+	threads  []*discordgo.Channel
+
+	// raw are messages fetched as given, for fields discordgo doesn't decode
+	raw map[string]json.RawMessage
+
+	// reactions are the emoji the bot has reacted with, keyed by message ID
+	reactions map[string][]string
+
+	// refuseStatus makes webhook messages fail with this status and error, if it is set
+	refuseStatus   int
+	refuseWebhooks discordgo.APIErrorMessage
+
+	// responses are the interaction responses sent by the bot, in order
+	responses []discordgo.InteractionResponse
+
+	// sent are the messages created by the bot and its webhooks, in order
+	sent []*discordgo.Message
+}
+
+func newFakeDiscord() *fakeDiscord {
+	return &fakeDiscord{
+		nextID:   5000,
+		webhooks: make(map[string]*discordgo.Webhook),
+		messages: make(map[string]*discordgo.Message),
+		pins:     make(map[string]bool),
+		raw:      make(map[string]json.RawMessage),
+
+		reactions: make(map[string][]string),
+	}
+}
+
+func (f *fakeDiscord) id() string {
+	f.nextID++
+	return fmt.Sprint(f.nextID)
+}
+
+// Sent returns a copy of the messages created so far.
+func (f *fakeDiscord) Sent() []discordgo.Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	sent := make([]discordgo.Message, len(f.sent))
+	for i, m := range f.sent {
+		sent[i] = *m
+	}
+	return sent
+}
+
+// Responses returns a copy of the interaction responses sent so far.
+func (f *fakeDiscord) Responses() []discordgo.InteractionResponse {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]discordgo.InteractionResponse{}, f.responses...)
+}
+
+// Reactions returns the emoji the bot has reacted to a message with.
+func (f *fakeDiscord) Reactions(messageID string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.reactions[messageID]...)
+}
+
+// SetRawMessage makes fetching the message with the given ID return the JSON as given.
+func (f *fakeDiscord) SetRawMessage(id, data string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.raw[id] = json.RawMessage(data)
+}
+
+// RefuseWebhooks makes webhook messages fail with the given status and message.
+// A status of 0 lets them succeed again.
+func (f *fakeDiscord) RefuseWebhooks(status int, message string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.refuseStatus = status
+	f.refuseWebhooks = discordgo.APIErrorMessage{Message: message}
+	if status == http.StatusForbidden {
+		f.refuseWebhooks.Code = discordgo.ErrCodeMissingPermissions
+	}
+}
+
+// Find returns the last message created with the given content, if any.
+func (f *fakeDiscord) Find(content string) (discordgo.Message, bool) {
+	sent := f.Sent()
+	for i := len(sent) - 1; i >= 0; i-- {
+		if sent[i].Content == content {
+			return sent[i], true
+		}
+	}
+	return discordgo.Message{}, false
+}
+
+func (f *fakeDiscord) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		body, _ = ioutil.ReadAll(req.Body)
+	}
+
+	f.mu.Lock()
+	status, resp := f.handle(req.Method, req.URL.Path, body)
+	f.mu.Unlock()
+
+	data := []byte{}
+	if resp != nil {
+		data, _ = json.Marshal(resp)
+	}
+
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(bytes.NewReader(data)),
+		Request:    req,
+	}, nil
+}
+
+func (f *fakeDiscord) handle(method, path string, body []byte) (int, interface{}) {
+	path = strings.TrimPrefix(path, "/api/v"+discordgo.APIVersion+"/")
+	parts := strings.Split(path, "/")
+	route := method + " " + parts[0]
+	if len(parts) > 2 {
+		route += " " + parts[2]
+	}
+	if len(parts) > 4 {
+		route += " " + parts[4]
+	}
+
+	switch {
+	case route == "GET guilds webhooks":
+		return http.StatusOK, []*discordgo.Webhook{}
+
+	case route == "POST channels webhooks":
+		var params discordgo.Webhook
+		json.Unmarshal(body, &params)
+		wh := &discordgo.Webhook{ID: f.id(), Token: "token", ChannelID: parts[1], GuildID: testGuildID, Name: params.Name}
+		f.webhooks[wh.ID] = wh
+		return http.StatusOK, wh
+
+	case method == "PATCH" && parts[0] == "webhooks" && len(parts) == 2:
+		wh, ok := f.webhooks[parts[1]]
+		if !ok {
+			return http.StatusNotFound, discordgo.APIErrorMessage{Message: "Unknown Webhook"}
+		}
+		var params discordgo.Webhook
+		json.Unmarshal(body, &params)
+		if params.ChannelID != "" {
+			wh.ChannelID = params.ChannelID
+		}
+		return http.StatusOK, wh
+
+	case method == "DELETE" && parts[0] == "webhooks" && len(parts) == 2:
+		delete(f.webhooks, parts[1])
+		return http.StatusNoContent, nil
+
+	case method == "POST" && parts[0] == "webhooks" && len(parts) == 3:
+		wh, ok := f.webhooks[parts[1]]
+		if !ok {
+			return http.StatusNotFound, discordgo.APIErrorMessage{Message: "Unknown Webhook"}
+		}
+		if f.refuseStatus != 0 {
+			return f.refuseStatus, f.refuseWebhooks
+		}
+		var params discordgo.WebhookParams
+		json.Unmarshal(body, &params)
+		msg := &discordgo.Message{
+			ID:        f.id(),
+			ChannelID: wh.ChannelID,
+			GuildID:   testGuildID,
+			Content:   params.Content,
+			Embeds:    params.Embeds,
+			WebhookID: wh.ID,
+			Author:    &discordgo.User{ID: wh.ID, Username: params.Username, Avatar: params.AvatarURL, Bot: true},
+		}
+		f.messages[msg.ID] = msg
+		f.sent = append(f.sent, msg)
+		return http.StatusOK, msg
+
+	case route == "PATCH webhooks messages":
+		msg, ok := f.messages[parts[4]]
+		if !ok {
+			return http.StatusNotFound, discordgo.APIErrorMessage{Message: "Unknown Message"}
+		}
+		var params discordgo.WebhookEdit
+		json.Unmarshal(body, &params)
+		if params.Content != nil {
+			msg.Content = *params.Content
+		}
+		return http.StatusOK, msg
+
+	case route == "POST channels messages":
+		var params discordgo.MessageSend
+		json.Unmarshal(body, &params)
+		msg := &discordgo.Message{
+			ID:        f.id(),
+			ChannelID: parts[1],
+			Content:   params.Content,
+			Author:    &discordgo.User{ID: testBotID, Username: "bridge", Bot: true},
+		}
+		f.messages[msg.ID] = msg
+		f.sent = append(f.sent, msg)
+		return http.StatusOK, msg
+
+	case method == "GET" && route == "GET channels messages" && len(parts) == 4:
+		if raw, ok := f.raw[parts[3]]; ok {
+			return http.StatusOK, raw
+		}
+		msg, ok := f.messages[parts[3]]
+		if !ok {
+			return http.StatusNotFound, discordgo.APIErrorMessage{Message: "Unknown Message"}
+		}
+		return http.StatusOK, msg
+
+	case method == "DELETE" && route == "DELETE channels messages" && len(parts) == 4:
+		delete(f.messages, parts[3])
+		return http.StatusNoContent, nil
+
+	case method == "POST" && parts[0] == "interactions" && parts[len(parts)-1] == "callback":
+		var resp discordgo.InteractionResponse
+		json.Unmarshal(body, &resp)
+		f.responses = append(f.responses, resp)
+		return http.StatusNoContent, nil
+
+	case route == "POST channels messages threads":
+		var params discordgo.ThreadStart
+		json.Unmarshal(body, &params)
+		thread := &discordgo.Channel{ID: f.id(), GuildID: testGuildID, ParentID: parts[1], Name: params.Name, Type: discordgo.ChannelTypeGuildPublicThread}
+		f.threads = append(f.threads, thread)
+		return http.StatusOK, thread
+
+	case route == "GET channels pins" && len(parts) == 3:
+		pinned := []*discordgo.Message{}
+		for id := range f.pins {
+			if msg, ok := f.messages[id]; ok && msg.ChannelID == parts[1] {
+				pinned = append(pinned, msg)
+			}
+		}
+		// Most recent first
+		sort.Slice(pinned, func(i, j int) bool { return pinned[i].ID > pinned[j].ID })
+		return http.StatusOK, pinned
+
+	case route == "PUT channels messages reactions" && len(parts) == 7:
+		f.reactions[parts[3]] = append(f.reactions[parts[3]], parts[5])
+		return http.StatusNoContent, nil
+
+	case route == "PUT channels pins":
+		f.pins[parts[3]] = true
+		return http.StatusNoContent, nil
+	}
+
+	return http.StatusNotFound, discordgo.APIErrorMessage{Message: "fakeDiscord: no route for " + method + " " + path}
+}
+
+// fakeIRCClient is a connection to the fakeIRCd
+type fakeIRCClient struct {
+	conn net.Conn
+	mu   sync.Mutex
+	nick string
+	user string
+}
+
+func (c *fakeIRCClient) source() string {
+	return fmt.Sprintf("%s!%s@fake.host", c.nick, c.user)
+}
+
+func (c *fakeIRCClient) send(format string, args ...interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(c.conn, format+"\r\n", args...)
+}
+
+// fakeIRCd is a minimal IRC server, just enough for the listener and puppets.
+//
+// Virtual users that only exist on the server can talk in channels using Inject.
+type fakeIRCd struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	clients  map[string]*fakeIRCClient  // keyed by lowercase nick
+	channels map[string]map[string]bool // lowercase channel to nicks, including virtual users
+	muted    map[string]bool            // lowercase "channel nick" that can't speak in the channel
+
+	// received are the lines sent by clients, as "nick: line"
+	received []string
+}
+
+func newFakeIRCd(t *testing.T) *fakeIRCd {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fakeIRCd{
+		ln:       ln,
+		clients:  make(map[string]*fakeIRCClient),
+		channels: make(map[string]map[string]bool),
+		muted:    make(map[string]bool),
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(&fakeIRCClient{conn: conn})
+		}
+	}()
+
+	return s
+}
+
+func (s *fakeIRCd) Addr() string {
+	return s.ln.Addr().String()
+}
+
+func (s *fakeIRCd) Close() {
+	s.ln.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.clients {
+		c.conn.Close()
+	}
+}
+
+// Received returns the lines received from the given nick, without the trailing CRLF.
+func (s *fakeIRCd) Received(nick string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lines := []string{}
+	for _, line := range s.received {
+		if strings.HasPrefix(line, strings.ToLower(nick)+": ") {
+			lines = append(lines, strings.TrimPrefix(line, strings.ToLower(nick)+": "))
+		}
+	}
+	return lines
+}
+
+// HasReceived returns true if any client has sent the line.
+func (s *fakeIRCd) HasReceived(nick, line string) bool {
+	for _, l := range s.Received(nick) {
+		if l == line {
+			return true
+		}
+	}
+	return false
+}
+
+// InChannel returns true if the nick has joined the channel.
+func (s *fakeIRCd) InChannel(channel, nick string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.channels[strings.ToLower(channel)][strings.ToLower(nick)]
+}
+
+// SendTo sends a raw line to a client.
+func (s *fakeIRCd) SendTo(nick, format string, args ...interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.clients[strings.ToLower(nick)]; ok {
+		c.send(format, args...)
+	}
+}
+
+// Mute stops a nick from speaking in a channel, as if it were moderated.
+func (s *fakeIRCd) Mute(channel, nick string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.muted[strings.ToLower(channel+" "+nick)] = true
+}
+
+// Voice lets a muted nick speak in a channel again, and tells the channel.
+func (s *fakeIRCd) Voice(channel, nick string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.muted, strings.ToLower(channel+" "+nick))
+	s.broadcast(channel, "", ":ChanServ!ChanServ@services. MODE %s +v %s", channel, nick)
+}
+
+// Inject makes a virtual user send a message to a channel, joining it first if needed.
+func (s *fakeIRCd) Inject(source, channel, line string) {
+	nick, _, _ := parseHostmask(source)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	members := s.members(channel)
+	if !members[strings.ToLower(nick)] {
+		members[strings.ToLower(nick)] = true
+		s.broadcast(channel, "", ":%s JOIN %s", source, channel)
+	}
+
+	s.broadcast(channel, "", ":%s %s", source, line)
+}
+
+// members returns the members of a channel. s.mu must be held.
+func (s *fakeIRCd) members(channel string) map[string]bool {
+	key := strings.ToLower(channel)
+	if s.channels[key] == nil {
+		s.channels[key] = make(map[string]bool)
+	}
+	return s.channels[key]
+}
+
+// broadcast sends a line to all clients in a channel except one. s.mu must be held.
+func (s *fakeIRCd) broadcast(channel, except string, format string, args ...interface{}) {
+	for nick := range s.members(channel) {
+		if c, ok := s.clients[nick]; ok && nick != strings.ToLower(except) {
+			c.send(format, args...)
+		}
+	}
+}
+
+func (s *fakeIRCd) serve(c *fakeIRCClient) {
+	defer c.conn.Close()
+
+	scanner := bufio.NewScanner(c.conn)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		// Drop message tags and prefixes sent by the client
+		if strings.HasPrefix(line, "@") || strings.HasPrefix(line, ":") {
+			if i := strings.IndexByte(line, ' '); i != -1 {
+				line = line[i+1:]
+			}
+		}
+
+		fields := strings.SplitN(line, " ", 2)
+		command := strings.ToUpper(fields[0])
+		args := []string{}
+		if len(fields) > 1 {
+			params := fields[1]
+			trailing := ""
+			hasTrailing := false
+			if i := strings.Index(params, " :"); i != -1 {
+				trailing = params[i+2:]
+				params = params[:i]
+				hasTrailing = true
+			} else if strings.HasPrefix(params, ":") {
+				trailing = params[1:]
+				params = ""
+				hasTrailing = true
+			}
+			args = strings.Fields(params)
+			if hasTrailing {
+				args = append(args, trailing)
+			}
+		}
+
+		s.mu.Lock()
+		if c.nick != "" {
+			s.received = append(s.received, strings.ToLower(c.nick)+": "+line)
+		}
+		s.handle(c, command, args)
+		s.mu.Unlock()
+
+		if command == "QUIT" {
+			return
+		}
+	}
+
+	s.mu.Lock()
+	s.part(c, "")
+	s.mu.Unlock()
+}
+
+// part removes a client from the server. s.mu must be held.
+func (s *fakeIRCd) part(c *fakeIRCClient, message string) {
+	if c.nick == "" {
+		return
+	}
+	key := strings.ToLower(c.nick)
+	if s.clients[key] != c {
+		return
+	}
+	for channel, members := range s.channels {
+		if members[key] {
+			s.broadcast(channel, c.nick, ":%s QUIT :%s", c.source(), message)
+			delete(members, key)
+		}
+	}
+	delete(s.clients, key)
+}
+
+// handle handles a command from a client. s.mu must be held.
+func (s *fakeIRCd) handle(c *fakeIRCClient, command string, args []string) {
+	arg := func(i int) string {
+		if i < len(args) {
+			return args[i]
+		}
+		return ""
+	}
+
+	switch command {
+	case "NICK":
+		if c.nick != "" {
+			delete(s.clients, strings.ToLower(c.nick))
+		}
+		c.nick = arg(0)
+		s.clients[strings.ToLower(c.nick)] = c
+		if c.user != "" {
+			c.send(":fake.ircd 001 %s :Welcome", c.nick)
+		}
+	case "USER":
+		c.user = arg(0)
+		if c.nick != "" {
+			c.send(":fake.ircd 001 %s :Welcome", c.nick)
+		}
+	case "PING":
+		c.send(":fake.ircd PONG fake.ircd :%s", arg(0))
+	case "JOIN":
+		for _, channel := range strings.Split(arg(0), ",") {
+			members := s.members(channel)
+			members[strings.ToLower(c.nick)] = true
+			s.broadcast(channel, "", ":%s JOIN %s", c.source(), channel)
+
+			names := []string{}
+			for nick := range members {
+				names = append(names, nick)
+			}
+			c.send(":fake.ircd 353 %s = %s :%s", c.nick, channel, strings.Join(names, " "))
+			c.send(":fake.ircd 366 %s %s :End of /NAMES list.", c.nick, channel)
+		}
+	case "PART":
+		for _, channel := range strings.Split(arg(0), ",") {
+			s.broadcast(channel, "", ":%s PART %s", c.source(), channel)
+			delete(s.members(channel), strings.ToLower(c.nick))
+		}
+	case "MODE":
+		if strings.HasPrefix(arg(0), "#") && len(args) == 1 {
+			c.send(":fake.ircd 324 %s %s +nt", c.nick, arg(0))
+		}
+	case "WHO":
+		c.send(":fake.ircd 315 %s %s :End of /WHO list.", c.nick, arg(0))
+	case "PRIVMSG", "NOTICE":
+		target := arg(0)
+		if strings.HasPrefix(target, "#") && s.muted[strings.ToLower(target+" "+c.nick)] {
+			c.send(":fake.ircd 404 %s %s :Cannot send to channel", c.nick, target)
+		} else if strings.HasPrefix(target, "#") {
+			s.broadcast(target, c.nick, ":%s %s %s :%s", c.source(), command, target, arg(1))
+		} else if other, ok := s.clients[strings.ToLower(target)]; ok {
+			other.send(":%s %s %s :%s", c.source(), command, target, arg(1))
+		}
+	case "QUIT":
+		s.part(c, arg(0))
+	case "PONG", "AWAY", "CAP":
+		// CAP is answered with 421 below, so clients fall back to no capabilities
+		if command == "CAP" {
+			c.send(":fake.ircd 421 %s CAP :Unknown command", c.nick)
+		}
+	default:
+		c.send(":fake.ircd 421 %s %s :Unknown command", c.nick, command)
+	}
+}
+
+// testBridge is a bridge connected to a fakeDiscord and fakeIRCd.
+type testBridge struct {
+	*Bridge
+	discord *fakeDiscord
+	ircd    *fakeIRCd
+}
+
+// newTestBridge starts a bridge mapping testChannel to testChannelID.
+func newTestBridge(t *testing.T, configure func(*Config)) *testBridge {
+	t.Helper()
+	log.SetLevel(log.WarnLevel)
+
+	ircd := newFakeIRCd(t)
+	fake := newFakeDiscord()
+
+	conf := &Config{
+		DiscordBotToken: "token",
+		GuildID:         testGuildID,
+		ChannelMappings: map[string]string{testChannel: testChannelID},
+		IRCServer:       ircd.Addr(),
+		IRCListenerName: "listener",
+		NoTLS:           true,
+		WebhookPrefix:   "test",
+		WebhookLimit:    1,
+		Suffix:          "_d",
+		Separator:       "_",
+		CommandPrefix:   "!",
+	}
+	if configure != nil {
+		configure(conf)
+	}
+
+	b, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Instead of opening a gateway connection, set up the state the bridge expects
+	session := b.discord.Session
+	session.Client = &http.Client{Transport: fake}
+	session.State.User = &discordgo.User{ID: testBotID, Username: "bridge", Bot: true}
+	if err := session.State.GuildAdd(&discordgo.Guild{
+		ID:       testGuildID,
+		Channels: []*discordgo.Channel{{ID: testChannelID, GuildID: testGuildID, Name: "test"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	b.discord.transmitter, err = transmitter.New(session, testGuildID, conf.WebhookPrefix, conf.WebhookLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.ircListener.Connect(conf.IRCServer); err != nil {
+		t.Fatal(err)
+	}
+	go b.ircListener.Loop()
+
+	tb := &testBridge{Bridge: b, discord: fake, ircd: ircd}
+	waitFor(t, "listener to join", func() bool {
+		return ircd.InChannel(testChannel, "listener")
+	})
+
+	return tb
+}
+
+func (tb *testBridge) Close() {
+	tb.Bridge.Close()
+	tb.ircd.Close()
+}
+
+// discordMember adds a member to the Discord state.
+func (tb *testBridge) discordMember(id, username, nick string) *discordgo.User {
+	user := &discordgo.User{ID: id, Username: username, Discriminator: "0001"}
+	tb.Bridge.discord.State.MemberAdd(&discordgo.Member{GuildID: testGuildID, User: user, Nick: nick})
+	return user
+}
+
+// discordSay simulates a message being created on Discord.
+func (tb *testBridge) discordSay(author *discordgo.User, content string, mentions ...*discordgo.User) *discordgo.Message {
+	return tb.discordSayAt(time.Now(), author, content, mentions...)
+}
+
+// discordSayAt simulates a message created on Discord at the given time arriving now.
+// The message is handed to the bridge, so it must not be changed afterwards.
+func (tb *testBridge) discordSayAt(sent time.Time, author *discordgo.User, content string, mentions ...*discordgo.User) *discordgo.Message {
+	msg := &discordgo.Message{
+		ID:        tb.discord.id(),
+		ChannelID: testChannelID,
+		GuildID:   testGuildID,
+		Author:    author,
+		Content:   content,
+		Mentions:  mentions,
+		Type:      discordgo.MessageTypeDefault,
+		Timestamp: sent,
+	}
+	tb.Bridge.discord.publishMessage(tb.Bridge.discord.Session, msg, false)
+	return msg
+}
+
+// discordEdit simulates a message being edited on Discord.
+func (tb *testBridge) discordEdit(msg *discordgo.Message, content string) {
+	now := time.Now()
+	edited := *msg
+	edited.Content = content
+	edited.EditedTimestamp = &now
+	tb.Bridge.discord.publishMessage(tb.Bridge.discord.Session, &edited, true)
+}
+
+// puppet creates an IRC puppet for a Discord user, and waits for it to join.
+func (tb *testBridge) puppet(t *testing.T, user *discordgo.User, nick string) string {
+	tb.Bridge.updateUserChan <- DiscordUser{
+		ID:            user.ID,
+		Username:      user.Username,
+		Discriminator: user.Discriminator,
+		Nick:          nick,
+		Online:        true,
+	}
+
+	ircNick := nick + tb.Config.Suffix
+	waitFor(t, "puppet to join", func() bool {
+		return tb.ircd.InChannel(testChannel, ircNick)
+	})
+	return ircNick
+}
@@ -0,0 +1,89 @@
+package bridge
+
+import (
+	"expvar"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// healthMetrics is 1 for each mapping that passed its last health check and 0 for each
+// that failed, keyed by IRC channel
+var healthMetrics = expvar.NewMap("mapping_health")
+
+// mappingHealth remembers which mappings failed their last health check, so that only
+// changes are logged, and when the listener was last refused sending to each IRC channel.
+type mappingHealth struct {
+	mu      sync.Mutex
+	broken  map[string]bool
+	refused map[string]refusal
+}
+
+type refusal struct {
+	reason string
+	at     time.Time
+}
+
+// refusedToSend records that the IRC server refused a message from the listener.
+func (h *mappingHealth) refusedToSend(channel, reason string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.refused == nil {
+		h.refused = make(map[string]refusal)
+	}
+	h.refused[strings.ToLower(channel)] = refusal{reason: reason, at: time.Now()}
+}
+
+// checkHealth checks that every mapping would relay in both directions, alerting in the
+// status channel when one breaks and when it works again. A mapping is broken if the
+// bot is missing permissions in the Discord channel, the listener isn't in the IRC
+// channel, or the IRC server has refused to let it speak there since the last check.
+func (b *Bridge) checkHealth() {
+	h := &b.health
+	since := time.Now().Add(-b.Config.HealthCheckInterval)
+
+	for _, mapping := range b.channelMappings() {
+		ircChannel := strings.Split(mapping.IRCChannel, " ")[0]
+		key := strings.ToLower(ircChannel)
+
+		problems := b.discord.diagnoseChannel(mapping.DiscordChannel)
+		if problem := b.ircListener.diagnoseChannel(ircChannel); problem != "" {
+			problems = append(problems, "the IRC listener "+problem)
+		}
+		h.mu.Lock()
+		if r, ok := h.refused[key]; ok && r.at.After(since) {
+			problems = append(problems, "the IRC server refused a message: "+r.reason)
+		}
+		if h.broken == nil {
+			h.broken = make(map[string]bool)
+		}
+		wasBroken := h.broken[key]
+		h.broken[key] = len(problems) > 0
+		h.mu.Unlock()
+
+		healthy := new(expvar.Int)
+		if len(problems) == 0 {
+			healthy.Set(1)
+		}
+		healthMetrics.Set(key, healthy)
+
+		if len(problems) == 0 {
+			if wasBroken {
+				log.WithField("channel", ircChannel).Infoln("Mapping passed its health check again.")
+			}
+			b.statusResolved("health:"+key, fmt.Sprintf("Relaying between %s and Discord is working again.", ircChannel))
+			continue
+		}
+
+		if !wasBroken {
+			log.WithFields(log.Fields{
+				"channel":  ircChannel,
+				"problems": strings.Join(problems, "; "),
+			}).Warnln("Mapping failed its health check.")
+		}
+		b.statusProblem("health:"+key, fmt.Sprintf("Relaying between %s and Discord is broken: %s.", ircChannel, strings.Join(problems, "; ")))
+	}
+}
@@ -0,0 +1,49 @@
+package bridge
+
+import (
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthCheck(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.StatusIRCChannel = "#ops"
+		conf.HealthCheckInterval = time.Hour
+	})
+	defer tb.Close()
+	waitFor(t, "listener to be connected", tb.ircListener.Healthy)
+
+	d := tb.Bridge.discord
+	guild, _ := d.State.Guild(testGuildID)
+	guild.Roles = append(guild.Roles, &discordgo.Role{ID: "4001", Permissions: discordgo.PermissionAdministrator})
+	assert.NoError(t, d.State.MemberAdd(&discordgo.Member{GuildID: testGuildID, User: d.State.User, Roles: []string{"4001"}}))
+
+	tb.Bridge.checkHealth()
+	assert.Equal(t, "1", healthMetrics.Get(testChannel).(*expvar.Int).String())
+
+	tb.ircd.SendTo("listener", ":fake.ircd 404 listener "+testChannel+" :Cannot send to channel (+b)")
+	waitFor(t, "refusal recorded", func() bool {
+		tb.Bridge.health.mu.Lock()
+		defer tb.Bridge.health.mu.Unlock()
+		_, ok := tb.Bridge.health.refused[testChannel]
+		return ok
+	})
+	tb.Bridge.checkHealth()
+	waitFor(t, "broken mapping announced", func() bool {
+		return tb.ircd.HasReceived("listener", "NOTICE #ops :[bridge] Relaying between "+testChannel+" and Discord is broken: the IRC server refused a message: Cannot send to channel (+b).")
+	})
+	assert.Equal(t, "0", healthMetrics.Get(testChannel).(*expvar.Int).String())
+
+	// Refusals before the last check are forgotten
+	tb.Bridge.health.mu.Lock()
+	tb.Bridge.health.refused[testChannel] = refusal{reason: "Cannot send to channel (+b)", at: time.Now().Add(-2 * time.Hour)}
+	tb.Bridge.health.mu.Unlock()
+	tb.Bridge.checkHealth()
+	waitFor(t, "recovery announced", func() bool {
+		return tb.ircd.HasReceived("listener", "NOTICE #ops :[bridge] Relaying between "+testChannel+" and Discord is working again.")
+	})
+}
@@ -0,0 +1,98 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	irc "github.com/qaisjp/go-ircevent"
+)
+
+// identityDiscord describes how the bridge shows a Discord user on IRC, for them to read.
+func (b *Bridge) identityDiscord(user *discordgo.User) []string {
+	var lines []string
+	optedOut := b.optedOut(karmaKeyDiscord(user.ID))
+
+	if con, ok := b.ircManager.connection(user.ID); ok {
+		lines = append(lines, fmt.Sprintf("On IRC you are %s.", con.Nick()))
+	} else if !optedOut {
+		lines = append(lines, fmt.Sprintf("You don't have an IRC puppet, so your messages are relayed by %s.", b.ircListener.GetNick()))
+	}
+
+	if link := b.linkByDiscord(user.ID); link != nil {
+		who := link.IRCNick
+		if link.IRCAccount != "" {
+			who += " (account " + link.IRCAccount + ")"
+		}
+		lines = append(lines, fmt.Sprintf("You are linked to IRC user %s, whose messages on Discord use your avatar.", who))
+	} else {
+		lines = append(lines, "You are not linked to an IRC user.")
+	}
+
+	if optedOut {
+		lines = append(lines, "You have opted out, so your messages and presence aren't relayed to IRC. Use /bridge optin to undo this.")
+	} else {
+		lines = append(lines, "Your messages and presence are relayed to IRC.")
+	}
+
+	if b.isRelayBotDiscord(user.ID) {
+		lines = append(lines, "The bridge ignores your messages, because it treats you as another relay bot.")
+	}
+	return lines
+}
+
+// identityIRC describes how the bridge shows an IRC user on Discord, for them to read.
+func (b *Bridge) identityIRC(nick, account, hostmask string) []string {
+	var lines []string
+
+	avatar := "the bridge's own avatar"
+	if b.fallbackAvatar(nick) != "" {
+		avatar = "an avatar made from your nick"
+	}
+	if b.discord.GetAvatar(b.Config.GuildID, nick) != "" {
+		avatar = "the avatar of the Discord user with the same name"
+	}
+
+	link := b.linkByIRC(nick, account)
+	if link != nil {
+		if b.discord.GetAvatarByID(link.DiscordID) != "" {
+			avatar = "the avatar of your linked Discord user"
+		}
+		lines = append(lines, fmt.Sprintf("You are linked to Discord user %s.", b.discord.memberName(link.DiscordID)))
+	} else {
+		lines = append(lines, "You are not linked to a Discord user.")
+	}
+	lines = append(lines, fmt.Sprintf("On Discord you are %s, with %s.", nick, avatar))
+
+	if account != "" {
+		lines = append(lines, fmt.Sprintf("You are identified to services as %s.", account))
+	}
+
+	if b.optedOut(b.karmaKeyIRC(nick, account)) {
+		lines = append(lines, fmt.Sprintf("You have opted out, so your messages aren't relayed to Discord. Use %soptin to undo this.", b.Config.CommandPrefix))
+	} else {
+		lines = append(lines, "Your messages are relayed to Discord.")
+	}
+
+	if b.isIgnored(hostmask) {
+		lines = append(lines, "A Discord moderator has stopped messages from your host being relayed.")
+	}
+	if b.isRelayBotIRC(nick) {
+		lines = append(lines, "The bridge ignores your messages, because it treats you as another relay bot.")
+	}
+	return lines
+}
+
+func init() {
+	registerChatCommand(&chatCommand{
+		Name: "identify",
+		IRC: func(i *ircListener, e *irc.Event, args []string) {
+			for _, line := range i.bridge.identityIRC(e.Nick, i.account(e), e.Source) {
+				i.Notice(e.Nick, line)
+			}
+		},
+		Discord: func(d *discordBot, m *discordgo.Message, args []string) {
+			d.reply(m, strings.Join(d.bridge.identityDiscord(m.Author), "\n"))
+		},
+	})
+}
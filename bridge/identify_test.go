@@ -0,0 +1,49 @@
+package bridge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdentifyDiscord(t *testing.T) {
+	tb := newTestBridge(t, nil)
+	defer tb.Close()
+
+	bob := tb.discordMember("100", "bob", "")
+	nick := tb.puppet(t, bob, "bob")
+	assert.NoError(t, tb.Bridge.saveLink(&identityLink{DiscordID: "100", IRCNick: "bobby", IRCAccount: "bob", Linked: time.Now()}))
+
+	assert.Equal(t, "On IRC you are "+nick+".\n"+
+		"You are linked to IRC user bobby (account bob), whose messages on Discord use your avatar.\n"+
+		"Your messages and presence are relayed to IRC.",
+		tb.bridgeInteraction(&discordgo.Member{User: bob}, "identity"))
+
+	carol := tb.discordMember("200", "carol", "")
+	assert.NoError(t, tb.Bridge.setOptOut(karmaKeyDiscord("200"), "carol", true))
+	assert.Equal(t, []string{
+		"You are not linked to an IRC user.",
+		"You have opted out, so your messages and presence aren't relayed to IRC. Use /bridge optin to undo this.",
+	}, tb.Bridge.identityDiscord(carol))
+}
+
+func TestIdentifyIRC(t *testing.T) {
+	tb := newTestBridge(t, nil)
+	defer tb.Close()
+
+	assert.NoError(t, tb.Bridge.store.Put(ignoredBucket, "spam.example.com", &ignoredUser{Host: "spam.example.com", Time: time.Now()}))
+	assert.Equal(t, []string{
+		"You are not linked to a Discord user.",
+		"On Discord you are alice, with the bridge's own avatar.",
+		"Your messages are relayed to Discord.",
+		"A Discord moderator has stopped messages from your host being relayed.",
+	}, tb.Bridge.identityIRC("alice", "", "alice!al@spam.example.com"))
+
+	// Ignored people can't use commands in channels, but can ask the listener
+	tb.ircd.Inject("alice!al@spam.example.com", testChannel, "PRIVMSG listener :identify")
+	waitFor(t, "identity reply", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG alice :A Discord moderator has stopped messages from your host being relayed.")
+	})
+}
@@ -0,0 +1,227 @@
+package bridge
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// linksBucket is the store bucket containing identity links, keyed by Discord user ID
+const linksBucket = "links"
+
+// linkCodeExpiry is how long a link code can be used for
+var linkCodeExpiry = time.Minute * 10
+
+// An identityLink associates a Discord user with an IRC user.
+type identityLink struct {
+	DiscordID  string
+	IRCNick    string
+	IRCAccount string // services account, empty if unknown
+	Linked     time.Time
+}
+
+// pendingLink is a link that has been requested on Discord, but not yet confirmed on IRC
+type pendingLink struct {
+	DiscordID string
+	Expires   time.Time
+}
+
+// linkCodes holds link codes that are waiting to be confirmed on IRC
+type linkCodes struct {
+	mu    sync.Mutex
+	codes map[string]pendingLink
+}
+
+// linkIndex finds the identity links for IRC users without reading every link.
+//
+// It is loaded from the store when first used, and updated by saveLink. Entries are checked
+// against the link they point to when used, so ones left behind by changed or purged links are harmless.
+type linkIndex struct {
+	mu        sync.Mutex
+	loaded    bool
+	byAccount map[string]string // lowercase services account to Discord ID
+	byNick    map[string]string // lowercase nick to Discord ID, for links without an account
+}
+
+func (x *linkIndex) add(link *identityLink) {
+	if link.IRCAccount != "" {
+		x.byAccount[strings.ToLower(link.IRCAccount)] = link.DiscordID
+	} else {
+		x.byNick[strings.ToLower(link.IRCNick)] = link.DiscordID
+	}
+}
+
+// startLink creates a code that the IRC user needs to send to the listener to confirm a link.
+func (b *Bridge) startLink(discordID string) (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+
+	b.linkCodes.mu.Lock()
+	defer b.linkCodes.mu.Unlock()
+
+	// Expired codes are cleaned up whenever a new code is made
+	now := time.Now()
+	for c, p := range b.linkCodes.codes {
+		if now.After(p.Expires) || p.DiscordID == discordID {
+			delete(b.linkCodes.codes, c)
+		}
+	}
+
+	b.linkCodes.codes[code] = pendingLink{
+		DiscordID: discordID,
+		Expires:   now.Add(linkCodeExpiry),
+	}
+
+	return code, nil
+}
+
+// completeLink links an IRC user to the Discord user that requested the code.
+//
+// Returns nil if the code is invalid or has expired.
+func (b *Bridge) completeLink(code, nick, account string) (*identityLink, error) {
+	b.linkCodes.mu.Lock()
+	pending, ok := b.linkCodes.codes[code]
+	delete(b.linkCodes.codes, code)
+	b.linkCodes.mu.Unlock()
+
+	if !ok || time.Now().After(pending.Expires) {
+		return nil, nil
+	}
+
+	link := &identityLink{
+		DiscordID:  pending.DiscordID,
+		IRCNick:    nick,
+		IRCAccount: account,
+		Linked:     time.Now(),
+	}
+
+	if err := b.saveLink(link); err != nil {
+		return nil, err
+	}
+
+	return link, nil
+}
+
+// saveLink stores an identity link, and indexes it so linkByIRC can find it.
+func (b *Bridge) saveLink(link *identityLink) error {
+	if err := b.store.Put(linksBucket, link.DiscordID, link); err != nil {
+		return err
+	}
+
+	b.links.mu.Lock()
+	defer b.links.mu.Unlock()
+	if b.links.loaded {
+		b.links.add(link)
+	}
+	return nil
+}
+
+// indexedLinks returns the Discord IDs of the links indexed under the account and nick,
+// loading the index from the store first if needed. Either can be empty.
+func (b *Bridge) indexedLinks(nick, account string) (byAccount, byNick string) {
+	b.links.mu.Lock()
+	defer b.links.mu.Unlock()
+
+	if !b.links.loaded {
+		b.links.byAccount = make(map[string]string)
+		b.links.byNick = make(map[string]string)
+		for _, id := range b.store.Keys(linksBucket) {
+			if link := b.linkByDiscord(id); link != nil {
+				b.links.add(link)
+			}
+		}
+		b.links.loaded = true
+	}
+
+	if account != "" {
+		byAccount = b.links.byAccount[strings.ToLower(account)]
+	}
+	return byAccount, b.links.byNick[strings.ToLower(nick)]
+}
+
+// linkByDiscord returns the identity link for a Discord user, or nil if they are not linked.
+func (b *Bridge) linkByDiscord(discordID string) *identityLink {
+	link := &identityLink{}
+	ok, err := b.store.Get(linksBucket, discordID, link)
+	if err != nil {
+		log.WithField("error", err).Errorln("could not read identity link")
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+	return link
+}
+
+// linkByIRC returns the identity link for an IRC user, or nil if they are not linked.
+//
+// Links are matched on services account, so that they survive nick changes. Links without
+// an account are matched on nick, as anyone can take the nick of a link with one.
+func (b *Bridge) linkByIRC(nick, account string) *identityLink {
+	byAccount, byNick := b.indexedLinks(nick, account)
+
+	if byAccount != "" {
+		if link := b.linkByDiscord(byAccount); link != nil && strings.EqualFold(link.IRCAccount, account) {
+			return link
+		}
+	}
+
+	if byNick != "" {
+		if link := b.linkByDiscord(byNick); link != nil && link.IRCAccount == "" && strings.EqualFold(link.IRCNick, nick) {
+			return link
+		}
+	}
+
+	return nil
+}
+
+// observeNick is called when the listener sees an IRC user change nick.
+//
+// Links without a services account follow the user to their new nick,
+// links with an account are updated by observeAccount instead.
+func (b *Bridge) observeNick(oldNick, newNick string) {
+	link := b.linkByIRC(oldNick, "")
+	if link == nil {
+		return
+	}
+
+	link.IRCNick = newNick
+	if err := b.saveLink(link); err != nil {
+		log.WithField("error", err).Errorln("could not update identity link")
+	}
+}
+
+// observeAccount is called when the listener learns the services account of an IRC user.
+//
+// Links with that account follow the user to the nick they are using. A link is only
+// given an account when it is made, so someone taking the nick of a link without one
+// can't claim it by logging in.
+func (b *Bridge) observeAccount(nick, account string) {
+	if account == "" {
+		return
+	}
+
+	link := b.linkByIRC(nick, account)
+	if link == nil || !strings.EqualFold(link.IRCAccount, account) || link.IRCNick == nick {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"discord-id":  link.DiscordID,
+		"irc-nick":    nick,
+		"irc-account": account,
+	}).Infoln("Updating identity link nick from services account")
+
+	link.IRCNick = nick
+	if err := b.saveLink(link); err != nil {
+		log.WithField("error", err).Errorln("could not update identity link")
+	}
+}
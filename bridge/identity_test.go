@@ -0,0 +1,65 @@
+package bridge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLinkByIRC(t *testing.T) {
+	tb := newTestBridge(t, nil)
+	defer tb.Close()
+	b := tb.Bridge
+
+	assert.NoError(t, b.saveLink(&identityLink{DiscordID: "100", IRCNick: "bob", IRCAccount: "bob", Linked: time.Now()}))
+	assert.NoError(t, b.saveLink(&identityLink{DiscordID: "200", IRCNick: "carol", Linked: time.Now()}))
+
+	// Links with an account are only found by it, not by whoever has the nick
+	if link := b.linkByIRC("someone", "bob"); assert.NotNil(t, link) {
+		assert.Equal(t, "100", link.DiscordID)
+	}
+	assert.Nil(t, b.linkByIRC("bob", ""))
+	assert.Nil(t, b.linkByIRC("bob", "mallory"))
+
+	if link := b.linkByIRC("Carol", ""); assert.NotNil(t, link) {
+		assert.Equal(t, "200", link.DiscordID)
+	}
+
+	// and only links without one follow nick changes
+	b.observeNick("bob", "bobby")
+	b.observeNick("carol", "caz")
+	assert.Equal(t, "bob", b.linkByDiscord("100").IRCNick)
+	assert.Equal(t, "caz", b.linkByDiscord("200").IRCNick)
+	assert.Nil(t, b.linkByIRC("carol", ""))
+	assert.NotNil(t, b.linkByIRC("caz", ""))
+
+	// Links with an account follow the account's nick
+	b.observeAccount("bobby", "bob")
+	assert.Equal(t, "bobby", b.linkByDiscord("100").IRCNick)
+
+	// Logging in with the nick of a link without an account doesn't claim it
+	b.observeAccount("caz", "mallory")
+	assert.Equal(t, "", b.linkByDiscord("200").IRCAccount)
+	assert.Nil(t, b.linkByIRC("someone", "mallory"))
+	if link := b.linkByIRC("caz", ""); assert.NotNil(t, link) {
+		assert.Equal(t, "200", link.DiscordID)
+	}
+}
+
+func TestLinkIndexLoaded(t *testing.T) {
+	tb := newTestBridge(t, nil)
+	defer tb.Close()
+	b := tb.Bridge
+
+	// Links saved by an earlier run are found
+	assert.NoError(t, b.store.Put(linksBucket, "100", &identityLink{DiscordID: "100", IRCNick: "bob", IRCAccount: "bob"}))
+	b.links = linkIndex{}
+	if link := b.linkByIRC("whoever", "Bob"); assert.NotNil(t, link) {
+		assert.Equal(t, "100", link.DiscordID)
+	}
+
+	// and purged ones aren't
+	assert.NoError(t, b.store.Delete(linksBucket, "100"))
+	assert.Nil(t, b.linkByIRC("whoever", "bob"))
+}
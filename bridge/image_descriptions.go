@@ -0,0 +1,115 @@
+package bridge
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// imageDescriptionLength is how much of an image's description is relayed to IRC
+var imageDescriptionLength = 200
+
+// captionClient asks the captioning service to describe images.
+// The timeout keeps a slow service from holding up relaying.
+var captionClient = &http.Client{Timeout: 10 * time.Second}
+
+// describeImages returns descriptions of the images attached to a Discord message, keyed by attachment ID:
+// their alt text, or what the captioning service says they show.
+func (d *discordBot) describeImages(m *discordgo.Message) map[string]string {
+	b := d.bridge
+	if !b.Config.ImageDescriptions && b.Config.CaptionURL == "" {
+		return nil
+	}
+
+	var images []*discordgo.MessageAttachment
+	for _, attachment := range m.Attachments {
+		if strings.HasPrefix(attachment.ContentType, "image/") {
+			images = append(images, attachment)
+		}
+	}
+	if len(images) == 0 {
+		return nil
+	}
+
+	descriptions := make(map[string]string)
+	if b.Config.ImageDescriptions {
+		alt, err := d.altText(m)
+		if err != nil {
+			d.bridge.handleError(err, log.Fields{"message": m.ID}, "could not get alt text of images")
+		}
+		for id, text := range alt {
+			descriptions[id] = text
+		}
+	}
+
+	for _, image := range images {
+		if descriptions[image.ID] != "" || b.Config.CaptionURL == "" {
+			continue
+		}
+		caption, err := b.caption(image.URL)
+		if err != nil {
+			log.WithFields(log.Fields{"error": err, "image": image.URL}).Warnln("could not caption image")
+			continue
+		}
+		descriptions[image.ID] = caption
+	}
+
+	for id, text := range descriptions {
+		descriptions[id] = TruncateString(imageDescriptionLength, strings.Join(strings.Fields(text), " "))
+	}
+	return descriptions
+}
+
+// altText returns the alt text of a message's attachments, keyed by attachment ID.
+// The Discord library doesn't decode alt text, so the message is fetched again.
+func (d *discordBot) altText(m *discordgo.Message) (map[string]string, error) {
+	data, err := d.Request("GET", discordgo.EndpointChannelMessage(m.ChannelID, m.ID), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get message")
+	}
+
+	var msg struct {
+		Attachments []struct {
+			ID          string `json:"id"`
+			Description string `json:"description"`
+		} `json:"attachments"`
+	}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, errors.Wrap(err, "could not decode message")
+	}
+
+	alt := make(map[string]string)
+	for _, attachment := range msg.Attachments {
+		if attachment.Description != "" {
+			alt[attachment.ID] = attachment.Description
+		}
+	}
+	return alt, nil
+}
+
+// caption asks the captioning service what the image at the URL shows.
+//
+// The service must accept the image URL as the body of a POST request, and respond with the description.
+func (b *Bridge) caption(imageURL string) (string, error) {
+	resp, err := captionClient.Post(b.Config.CaptionURL, "text/plain; charset=utf-8", strings.NewReader(imageURL))
+	if err != nil {
+		return "", errors.Wrap(err, "could not reach captioning service")
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return "", errors.Wrap(err, "could not read captioning service response")
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", errors.Errorf("captioning service responded with %s", resp.Status)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
@@ -0,0 +1,53 @@
+package bridge
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestImageDescriptions(t *testing.T) {
+	captions := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		url, _ := ioutil.ReadAll(r.Body)
+		fmt.Fprintf(w, "a cat\nsitting on %s\n", url)
+	}))
+	defer captions.Close()
+
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.ImageDescriptions = true
+		conf.CaptionURL = captions.URL
+	})
+	defer tb.Close()
+
+	bob := tb.discordMember("100", "bob", "")
+	msg := &discordgo.Message{
+		ID:        tb.discord.id(),
+		ChannelID: testChannelID,
+		GuildID:   testGuildID,
+		Author:    bob,
+		Type:      discordgo.MessageTypeDefault,
+		Attachments: []*discordgo.MessageAttachment{
+			{ID: "1", URL: "https://cdn.example.com/trace.png", ContentType: "image/png"},
+			{ID: "2", URL: "https://cdn.example.com/cat.jpg", ContentType: "image/jpeg"},
+			{ID: "3", URL: "https://cdn.example.com/notes.txt", ContentType: "text/plain"},
+		},
+	}
+	tb.discord.SetRawMessage(msg.ID, `{"attachments": [{"id": "1", "description": "screenshot of a stack trace"}, {"id": "2"}]}`)
+
+	d := tb.Bridge.discord
+	d.publishMessage(d.Session, msg, false)
+	for _, line := range []string{
+		"[image: screenshot of a stack trace] https://cdn.example.com/trace.png",
+		"[image: a cat sitting on https://cdn.example.com/cat.jpg] https://cdn.example.com/cat.jpg",
+		"https://cdn.example.com/notes.txt",
+	} {
+		line := line
+		waitFor(t, line, func() bool {
+			return tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<b\u200Bob#0001> "+line)
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package bridge
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	irc "github.com/qaisjp/go-ircevent"
+	log "github.com/sirupsen/logrus"
+)
+
+// wantedCaps are the IRCv3 capabilities requested by the listener, if the server supports them.
+var wantedCaps = []string{
+	"account-notify",
+	"account-tag",
+	"away-notify",
+	"chghost",
+	"draft/message-redaction",
+	"extended-join",
+	"message-tags",
+	"multi-prefix",
+	"server-time",
+	"userhost-in-names",
+}
+
+// capTimeout is how long to wait for the server to finish capability negotiation
+var capTimeout = time.Second * 5
+
+// capNegotiator negotiates IRCv3 capabilities after registration.
+//
+// go-ircevent can only negotiate capabilities before registration, and it waits
+// forever for servers that don't support CAP (or send a multiline CAP LS).
+// Requesting capabilities after registration is allowed by the IRCv3 spec,
+// and servers without CAP support just reply with ERR_UNKNOWNCOMMAND.
+//
+// It is safe for concurrent use.
+type capNegotiator struct {
+	mu sync.Mutex
+
+	con    *irc.Connection
+	onDone func()
+
+	available []string        // caps advertised so far by a (multiline) CAP LS
+	pending   map[string]bool // caps requested but not yet ACKed or NAKed
+	enabled   map[string]bool // caps that have been ACKed
+	done      bool
+	timeout   *time.Timer // finishes this negotiation if the server doesn't
+}
+
+func newCapNegotiator(con *irc.Connection) *capNegotiator {
+	n := &capNegotiator{
+		con:     con,
+		pending: make(map[string]bool),
+		enabled: make(map[string]bool),
+	}
+
+	con.AddCallback("CAP", n.onCap)
+
+	// ERR_UNKNOWNCOMMAND: "<me> <command> :Unknown command"
+	con.AddCallback("421", func(e *irc.Event) {
+		if len(e.Arguments) > 1 && strings.EqualFold(e.Arguments[1], "CAP") {
+			log.Infoln("IRC server does not support capability negotiation.")
+			n.finish()
+		}
+	})
+
+	return n
+}
+
+// Start begins negotiation. onDone is called once all the capabilities
+// have been negotiated, or if negotiation failed or timed out.
+func (n *capNegotiator) Start(onDone func()) {
+	n.mu.Lock()
+	if n.timeout != nil {
+		n.timeout.Stop()
+	}
+	n.onDone = onDone
+	n.available = nil
+	n.pending = make(map[string]bool)
+	n.enabled = make(map[string]bool)
+	n.done = false
+
+	var timeout *time.Timer
+	timeout = time.AfterFunc(capTimeout, func() {
+		n.mu.Lock()
+		// A timer from an earlier negotiation can fire after Stop, so check it's ours
+		current := n.timeout == timeout && !n.done
+		n.mu.Unlock()
+
+		if current {
+			log.Warnln("IRC capability negotiation timed out.")
+			n.finish()
+		}
+	})
+	n.timeout = timeout
+	n.mu.Unlock()
+
+	n.con.SendRaw("CAP LS 302")
+}
+
+// Enabled returns true if the given capability was acknowledged by the server.
+func (n *capNegotiator) Enabled(name string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.enabled[name]
+}
+
+// finish marks negotiation as complete and calls onDone exactly once.
+func (n *capNegotiator) finish() {
+	n.mu.Lock()
+	if n.done {
+		n.mu.Unlock()
+		return
+	}
+	n.done = true
+	onDone := n.onDone
+	if n.timeout != nil {
+		n.timeout.Stop()
+	}
+
+	enabled := []string{}
+	for name := range n.enabled {
+		enabled = append(enabled, name)
+	}
+	n.mu.Unlock()
+
+	log.WithField("caps", enabled).Infoln("IRC capability negotiation finished.")
+
+	if onDone != nil {
+		onDone()
+	}
+}
+
+// onCap handles "CAP <target> <subcommand> [*] :<caps>"
+func (n *capNegotiator) onCap(e *irc.Event) {
+	if len(e.Arguments) < 3 {
+		return
+	}
+
+	subcommand := strings.ToUpper(e.Arguments[1])
+	caps := strings.Fields(e.Arguments[len(e.Arguments)-1])
+
+	// A "*" before the caps means there are more lines to come
+	more := len(e.Arguments) > 3 && e.Arguments[2] == "*"
+
+	switch subcommand {
+	case "LS":
+		n.onLS(caps, more)
+	case "ACK":
+		n.onReply(caps, true)
+	case "NAK":
+		n.onReply(caps, false)
+	case "NEW":
+		n.onNew(caps)
+	case "DEL":
+		n.mu.Lock()
+		for _, c := range caps {
+			delete(n.enabled, c)
+		}
+		n.mu.Unlock()
+	}
+}
+
+// capName strips the value from a CAP LS 302 capability, e.g. "sasl=PLAIN" becomes "sasl"
+func capName(c string) string {
+	return strings.SplitN(c, "=", 2)[0]
+}
+
+func (n *capNegotiator) onLS(caps []string, more bool) {
+	n.mu.Lock()
+	for _, c := range caps {
+		n.available = append(n.available, capName(c))
+	}
+	if more {
+		n.mu.Unlock()
+		return
+	}
+	available := n.available
+	n.mu.Unlock()
+
+	n.request(available)
+}
+
+func (n *capNegotiator) onNew(caps []string) {
+	names := []string{}
+	for _, c := range caps {
+		names = append(names, capName(c))
+	}
+	n.request(names)
+}
+
+// request sends a CAP REQ for each wanted capability in the given list.
+//
+// Capabilities are requested one at a time, so that one being
+// rejected does not prevent the others from being enabled.
+func (n *capNegotiator) request(available []string) {
+	toRequest := []string{}
+
+	n.mu.Lock()
+	for _, want := range wantedCaps {
+		if n.enabled[want] || n.pending[want] {
+			continue
+		}
+		for _, c := range available {
+			if c == want {
+				toRequest = append(toRequest, want)
+				n.pending[want] = true
+				break
+			}
+		}
+	}
+	n.mu.Unlock()
+
+	if len(toRequest) == 0 {
+		n.finish()
+		return
+	}
+
+	for _, c := range toRequest {
+		n.con.SendRawf("CAP REQ :%s", c)
+	}
+}
+
+func (n *capNegotiator) onReply(caps []string, ack bool) {
+	n.mu.Lock()
+	for _, c := range caps {
+		c = strings.TrimPrefix(c, "-")
+		delete(n.pending, c)
+		if ack {
+			n.enabled[c] = true
+		}
+	}
+	remaining := len(n.pending)
+	n.mu.Unlock()
+
+	if remaining == 0 {
+		n.finish()
+	}
+}
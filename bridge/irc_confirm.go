@@ -0,0 +1,111 @@
+package bridge
+
+import (
+	"strings"
+	"sync"
+)
+
+// confirmPrefix marks the PINGs that puppets send after each burst of channel messages.
+const confirmPrefix = "relayed-"
+
+// maxUnconfirmedBursts is how many bursts are remembered while waiting for the server's PONGs.
+const maxUnconfirmedBursts = 50
+
+// sendBursts tracks the channel messages a puppet sends, in bursts, until the PONG to the
+// PING sent after each burst shows which of them the server accepted.
+//
+// The server answers in order, so ERR_CANNOTSENDTOCHAN for a burst arrives before its PONG.
+// Rejections are counted per channel, and because a channel that stops taking messages
+// rejects the rest of the burst too, the last messages sent there are the rejected ones.
+//
+// It is safe for concurrent use.
+type sendBursts struct {
+	mu      sync.Mutex
+	seq     uint64
+	open    *sendBurst   // the burst being sent, if any
+	waiting []*sendBurst // bursts waiting for their PONG, oldest first
+}
+
+type sendBurst struct {
+	seq      uint64
+	messages []IRCMessage
+	rejected map[string]int // lowercase channel to how many messages it rejected
+}
+
+// sent adds a channel message to the burst being sent.
+func (s *sendBursts) sent(m IRCMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.open == nil {
+		s.seq++
+		s.open = &sendBurst{seq: s.seq, rejected: make(map[string]int)}
+	}
+	s.open.messages = append(s.open.messages, m)
+}
+
+// end finishes the burst being sent, returning the sequence number to PING with.
+// It returns false if no channel messages were sent.
+func (s *sendBursts) end() (uint64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.open == nil {
+		return 0, false
+	}
+	burst := s.open
+	s.open = nil
+
+	s.waiting = append(s.waiting, burst)
+	if len(s.waiting) > maxUnconfirmedBursts {
+		s.waiting = s.waiting[len(s.waiting)-maxUnconfirmedBursts:]
+	}
+	return burst.seq, true
+}
+
+// rejected counts a message the channel rejected. Earlier bursts have been answered by
+// the time it arrives, so it belongs to the oldest burst still waiting.
+func (s *sendBursts) rejected(channel string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	burst := s.open
+	if len(s.waiting) > 0 {
+		burst = s.waiting[0]
+	}
+	if burst != nil {
+		burst.rejected[strings.ToLower(channel)]++
+	}
+}
+
+// confirmed forgets the bursts up to seq, now that the server has answered them,
+// and returns the messages that were rejected.
+func (s *sendBursts) confirmed(seq uint64) []IRCMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var rejected []IRCMessage
+	for len(s.waiting) > 0 && s.waiting[0].seq <= seq {
+		burst := s.waiting[0]
+		s.waiting = s.waiting[1:]
+
+		// Walk back from the end of the burst, to find the last messages to each channel
+		var last []IRCMessage
+		remaining := make(map[string]int, len(burst.rejected))
+		for channel, n := range burst.rejected {
+			remaining[channel] = n
+		}
+		for n := len(burst.messages) - 1; n >= 0; n-- {
+			m := burst.messages[n]
+			key := strings.ToLower(m.IRCChannel)
+			if remaining[key] > 0 {
+				remaining[key]--
+				last = append(last, m)
+			}
+		}
+		for n := len(last) - 1; n >= 0; n-- {
+			rejected = append(rejected, last[n])
+		}
+	}
+	return rejected
+}
@@ -0,0 +1,39 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendBursts(t *testing.T) {
+	var s sendBursts
+
+	// Bursts without channel messages aren't confirmed
+	_, ok := s.end()
+	assert.False(t, ok)
+
+	s.sent(IRCMessage{IRCChannel: "#a", Message: "one"})
+	s.sent(IRCMessage{IRCChannel: "#b", Message: "two"})
+	s.sent(IRCMessage{IRCChannel: "#a", Message: "three"})
+	s.sent(IRCMessage{IRCChannel: "#a", Message: "four"})
+	first, ok := s.end()
+	assert.True(t, ok)
+
+	s.sent(IRCMessage{IRCChannel: "#a", Message: "five"})
+	second, ok := s.end()
+	assert.True(t, ok)
+
+	// #a stopped taking messages partway through the first burst
+	s.rejected("#A")
+	s.rejected("#a")
+	assert.Equal(t, []IRCMessage{
+		{IRCChannel: "#a", Message: "three"},
+		{IRCChannel: "#a", Message: "four"},
+	}, s.confirmed(first))
+
+	// and rejected the second as well
+	s.rejected("#a")
+	assert.Equal(t, []IRCMessage{{IRCChannel: "#a", Message: "five"}}, s.confirmed(second))
+	assert.Empty(t, s.confirmed(second))
+}
@@ -2,7 +2,9 @@ package bridge
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	irc "github.com/qaisjp/go-ircevent"
@@ -14,10 +16,14 @@ import (
 type ircConnection struct {
 	innerCon *irc.Connection
 
-	discord DiscordUser
-	nick    string
+	// detailsMu guards discord, nick and serverNick, which change while callbacks read them
+	detailsMu  sync.Mutex
+	discord    DiscordUser
+	nick       string
+	serverNick string // the nick the server last gave the puppet
 
 	messages      chan IRCMessage
+	overflow      *overflowQueue // messages waiting for the IRC server to take them
 	cooldownTimer *time.Timer
 
 	manager *IRCManager
@@ -28,63 +34,270 @@ type ircConnection struct {
 	// Tell users this feature is in beta
 	pmNoticed        bool
 	pmNoticedSenders map[string]struct{}
+
+	// degraded maps lowercase channels this puppet can't speak in to the reason why.
+	// Messages for these channels are relayed by the listener instead.
+	degradedMu sync.Mutex
+	degraded   map[string]string
+
+	// bursts are the channel messages the server hasn't answered yet
+	bursts sendBursts
+
+	// nickTaken is set when services say the preferred nick belongs to someone else
+	servicesMu sync.Mutex
+	nickTaken  bool
+
+	// partedOffline is set when the puppet has left the channels without smart presence,
+	// because its Discord user has been offline for the cooldown
+	presenceMu    sync.Mutex
+	partedOffline bool
+}
+
+// Discord returns a copy of the Discord user this puppet is for.
+func (i *ircConnection) Discord() DiscordUser {
+	i.detailsMu.Lock()
+	defer i.detailsMu.Unlock()
+	return i.discord
+}
+
+// Nick returns the nick this puppet wants, which may not be the one it has yet.
+func (i *ircConnection) Nick() string {
+	i.detailsMu.Lock()
+	defer i.detailsMu.Unlock()
+	return i.nick
+}
+
+// ServerNick returns the nick the puppet has on the server. Callbacks use this
+// instead of GetNick, which races the library's own callbacks.
+func (i *ircConnection) ServerNick() string {
+	i.detailsMu.Lock()
+	defer i.detailsMu.Unlock()
+	return i.serverNick
 }
 
 func (i *ircConnection) OnWelcome(e *irc.Event) {
+	// The welcome is addressed to the nick we got
+	nick := e.Arguments[0]
+	i.detailsMu.Lock()
+	i.serverNick = nick
+	discord := i.discord
+	i.detailsMu.Unlock()
+
 	i.JoinChannels()
-	i.innerCon.SendRawf("MODE %s +D", i.innerCon.GetNick())
+	i.innerCon.SendRawf("MODE %s +D", nick)
+	if mode := i.manager.bridge.ircListener.BotMode(); mode != "" && discord.Bot {
+		i.innerCon.SendRawf("MODE %s +%s", nick, mode)
+	}
+	i.registerWithServices()
+	i.manager.bridge.ircListener.cloakPuppet(nick, discord)
+	i.publishMetadata(nick)
 
 	go func(i *ircConnection) {
 		for m := range i.messages {
-			if m.IsAction {
-				i.innerCon.Action(m.IRCChannel, m.Message)
-			} else {
-				if !strings.HasPrefix(m.IRCChannel, "#") {
-					i.experimentalNotice(m.IRCChannel)
+			i.send(m)
+
+			// Send the rest of the burst, then confirm it with one PING
+			for more := true; more; {
+				select {
+				case m, ok := <-i.messages:
+					if ok {
+						i.send(m)
+					}
+					more = ok
+				default:
+					more = false
 				}
-				i.innerCon.Privmsg(m.IRCChannel, m.Message)
+			}
+			if seq, ok := i.bursts.end(); ok {
+				i.innerCon.SendRawf("PING :%s%d", confirmPrefix, seq)
 			}
 		}
 	}(i)
 }
 
+// OnNick follows the puppet's own nick changes.
+func (i *ircConnection) OnNick(e *irc.Event) {
+	i.detailsMu.Lock()
+	defer i.detailsMu.Unlock()
+	if strings.EqualFold(e.Nick, i.serverNick) {
+		i.serverNick = e.Message()
+	}
+}
+
+// OnCannotJoin handles the errors for when the puppet can't join a channel: it is full (+l),
+// invite only (+i), the puppet is banned (+b), the key is wrong (+k), or only registered
+// nicks are allowed (+R/+r).
+func (i *ircConnection) OnCannotJoin(e *irc.Event) {
+	if len(e.Arguments) < 2 {
+		return
+	}
+	i.setDegraded(e.Arguments[1], e.Message())
+}
+
+// send sends a message, remembering it until the server answers if it is for a channel.
+func (i *ircConnection) send(m IRCMessage) {
+	if m.IsAction {
+		i.innerCon.Action(m.IRCChannel, m.Message)
+	} else {
+		if !strings.HasPrefix(m.IRCChannel, "#") {
+			i.experimentalNotice(m.IRCChannel)
+		}
+		i.innerCon.Privmsg(m.IRCChannel, m.Message)
+	}
+
+	if strings.HasPrefix(m.IRCChannel, "#") {
+		i.bursts.sent(m)
+	}
+}
+
+// OnPong relays the messages the server rejected, once the PING after their burst is answered.
+func (i *ircConnection) OnPong(e *irc.Event) {
+	token := e.Message()
+	if !strings.HasPrefix(token, confirmPrefix) {
+		return
+	}
+	seq, err := strconv.ParseUint(strings.TrimPrefix(token, confirmPrefix), 10, 64)
+	if err != nil {
+		return
+	}
+
+	for _, m := range i.bursts.confirmed(seq) {
+		i.manager.sendViaListener(m.IRCChannel, i.Discord(), m.Message)
+	}
+}
+
+// OnCannotSend handles ERR_CANNOTSENDTOCHAN. The rejected message is relayed
+// by the listener once the server has answered the rest of its burst.
+func (i *ircConnection) OnCannotSend(e *irc.Event) {
+	if len(e.Arguments) < 2 {
+		return
+	}
+	channel := e.Arguments[1]
+	i.setDegraded(channel, e.Message())
+	i.bursts.rejected(channel)
+}
+
+// OnMode clears a channel's degraded state when the puppet is voiced or opped there,
+// because it can speak again.
+func (i *ircConnection) OnMode(e *irc.Event) {
+	if len(e.Arguments) < 2 || !strings.HasPrefix(e.Arguments[0], "#") {
+		return
+	}
+	channel := e.Arguments[0]
+
+	modes := i.manager.bridge.ircListener.users.ServerModes()
+	modes.walk(e.Arguments[1], e.Arguments[2:], func(adding bool, mode byte, arg string) {
+		if _, ok := modes.prefix(mode); ok && adding && strings.EqualFold(arg, i.ServerNick()) {
+			i.clearDegraded(channel)
+		}
+	})
+}
+
+// OnJoined handles RPL_ENDOFNAMES, which means the puppet has successfully joined a channel.
+func (i *ircConnection) OnJoined(e *irc.Event) {
+	if len(e.Arguments) < 2 {
+		return
+	}
+
+	i.clearDegraded(e.Arguments[1])
+}
+
+func (i *ircConnection) clearDegraded(channel string) {
+	i.degradedMu.Lock()
+	defer i.degradedMu.Unlock()
+	delete(i.degraded, strings.ToLower(channel))
+}
+
+func (i *ircConnection) setDegraded(channel, reason string) {
+	log.WithFields(log.Fields{
+		"nick":    i.Nick(),
+		"channel": channel,
+		"reason":  reason,
+	}).Warnln("Puppet can't speak in channel, relaying its messages through the listener.")
+
+	i.degradedMu.Lock()
+	defer i.degradedMu.Unlock()
+	i.degraded[strings.ToLower(channel)] = reason
+}
+
+// IsDegraded returns true if this puppet can't send messages to the given channel.
+func (i *ircConnection) IsDegraded(channel string) bool {
+	i.degradedMu.Lock()
+	defer i.degradedMu.Unlock()
+	_, ok := i.degraded[strings.ToLower(channel)]
+	return ok
+}
+
+// Degraded returns a copy of the channels this puppet can't speak in, and why.
+func (i *ircConnection) Degraded() map[string]string {
+	i.degradedMu.Lock()
+	defer i.degradedMu.Unlock()
+
+	degraded := make(map[string]string, len(i.degraded))
+	for c, reason := range i.degraded {
+		degraded[c] = reason
+	}
+	return degraded
+}
+
 func (i *ircConnection) JoinChannels() {
 	i.innerCon.SendRaw(i.manager.bridge.GetJoinCommand())
 }
 
+// rejoinOnline joins the channels the puppet left while its Discord user was offline.
+func (i *ircConnection) rejoinOnline() {
+	i.presenceMu.Lock()
+	parted := i.partedOffline
+	i.partedOffline = false
+	i.presenceMu.Unlock()
+
+	if parted {
+		i.JoinChannels()
+	}
+}
+
+// UpdateDetails changes the puppet's nick when its Discord user changes their name.
+//
+// Puppets belong to a Discord ID, so someone changing their username keeps the same connection
+// and only changes nick. The real name can't be changed while connected, so it is updated
+// the next time the puppet connects.
 func (i *ircConnection) UpdateDetails(discord DiscordUser) {
-	if i.discord.Username != discord.Username {
-		i.innerCon.QuitMessage = fmt.Sprintf("Changing real name from %s to %s", i.discord.Username, discord.Username)
-		i.manager.CloseConnection(i)
-
-		// After one second make the user reconnect.
-		// This should be enough time for the nick tracker to update.
-		time.AfterFunc(time.Second, func() {
-			i.manager.HandleUser(discord)
-		})
-		return
+	var nick string
+	if i.NickTaken() {
+		nick = i.manager.fallbackNickname(discord)
+	} else {
+		nick = i.manager.generateNickname(discord)
 	}
 
+	i.detailsMu.Lock()
+	old := i.discord
 	// if their details haven't changed, don't do anything
-	if (i.discord.Nick == discord.Nick) && (i.discord.Discriminator == discord.Discriminator) {
+	if (old.Username == discord.Username) && (old.Nick == discord.Nick) && (old.Discriminator == discord.Discriminator) {
+		i.detailsMu.Unlock()
 		return
 	}
-
 	i.discord = discord
-	i.nick = i.manager.generateNickname(i.discord)
+	i.nick = nick
+	i.detailsMu.Unlock()
+
+	i.manager.bridge.mentions.Invalidate(discord.ID)
 	i.innerCon.RealName = discord.Username
 
-	go i.innerCon.Nick(i.nick)
+	go func() {
+		// SWHOIS follows the puppet through the rename, so set it on the nick it has now
+		i.publishMetadata(i.ServerNick())
+		i.innerCon.Nick(nick)
+	}()
 }
 
 func (i *ircConnection) experimentalNotice(nick string) {
 	d := i.manager.bridge.discord
 
 	if i.pmDiscordChannel == "" {
-		c, err := d.UserChannelCreate(i.discord.ID)
+		c, err := d.UserChannelCreate(i.Discord().ID)
 		if err != nil {
 			// todo: sentry
-			log.Warnln("Could not create private message room", i.discord, err)
+			i.manager.bridge.handleError(err, log.Fields{"discord": i.Discord()}, "could not create private message room")
 			return
 		}
 		i.pmDiscordChannel = c.ID
@@ -94,7 +307,7 @@ func (i *ircConnection) experimentalNotice(nick string) {
 		i.pmNoticed = true
 		_, err := d.ChannelMessageSend(i.pmDiscordChannel, "**Private messaging is still in dev. Proceed with caution.**")
 		if err != nil {
-			log.Warnln("Could not send pmNotice", i.discord, err)
+			i.manager.bridge.handleError(err, log.Fields{"discord": i.Discord()}, "could not send pmNotice")
 			return
 		}
 	}
@@ -112,7 +325,8 @@ func (i *ircConnection) OnPrivateMessage(e *irc.Event) {
 		if e.Message() == "help" {
 			i.innerCon.Privmsg(e.Nick, "Commands: help, who")
 		} else if e.Message() == "who" {
-			i.innerCon.Privmsgf(e.Nick, "I am: %s#%s with ID %s", i.discord.Nick, i.discord.Discriminator, i.discord.ID)
+			discord := i.Discord()
+			i.innerCon.Privmsgf(e.Nick, "I am: %s#%s with ID %s", discord.Nick, discord.Discriminator, discord.ID)
 		} else {
 			// i.innerCon.Privmsg(e.Nick, "Private messaging Discord users is not supported, but I support commands! Type 'help'.")
 		}
@@ -124,7 +338,7 @@ func (i *ircConnection) OnPrivateMessage(e *irc.Event) {
 		msg := fmt.Sprintf("%s,%s: %s", e.Connection.Server, e.Source, e.Message())
 		_, err := d.ChannelMessageSend(i.pmDiscordChannel, msg)
 		if err != nil {
-			log.Warnln("Could not send PM", i.discord, err)
+			i.manager.bridge.handleError(err, log.Fields{"discord": i.Discord()}, "could not send PM")
 			return
 		}
 		return
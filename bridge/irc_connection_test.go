@@ -0,0 +1,53 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDegradedPuppet(t *testing.T) {
+	tb := newTestBridge(t, nil)
+	defer tb.Close()
+
+	bob := tb.discordMember("100", "bob", "")
+	nick := tb.puppet(t, bob, "bob")
+	con := tb.ircManager.ircConnections["100"]
+
+	tb.discordSay(bob, "accepted")
+	waitFor(t, "puppet message", func() bool {
+		return tb.ircd.HasReceived(nick, "PRIVMSG "+testChannel+" :accepted")
+	})
+
+	// Messages the server rejects are relayed by the listener, each one exactly once
+	tb.ircd.Mute(testChannel, nick)
+	tb.discordSay(bob, "first")
+	tb.discordSay(bob, "second")
+
+	relayed := func(content string) int {
+		count := 0
+		for _, line := range tb.ircd.Received("listener") {
+			if line == "PRIVMSG "+testChannel+" :<b\u200Bob#0001> "+content {
+				count++
+			}
+		}
+		return count
+	}
+	waitFor(t, "listener to relay the rejected messages", func() bool {
+		return relayed("first") == 1 && relayed("second") == 1
+	})
+	assert.True(t, con.IsDegraded(testChannel))
+	assert.Equal(t, 0, relayed("accepted"))
+
+	// Being voiced lets the puppet speak for itself again
+	tb.ircd.Voice(testChannel, nick)
+	waitFor(t, "puppet to recover", func() bool {
+		return !con.IsDegraded(testChannel)
+	})
+
+	tb.discordSay(bob, "third")
+	waitFor(t, "puppet message after voice", func() bool {
+		return tb.ircd.HasReceived(nick, "PRIVMSG "+testChannel+" :third")
+	})
+	assert.Equal(t, 0, relayed("third"))
+}
@@ -0,0 +1,92 @@
+package bridge
+
+import (
+	"strings"
+
+	irc "github.com/qaisjp/go-ircevent"
+	log "github.com/sirupsen/logrus"
+)
+
+// channelKey returns the key to join an IRC channel with: the one in its mapping,
+// like "#channel key", or else the key channel option.
+func (b *Bridge) channelKey(channel string) string {
+	if mapping := b.GetMappingByIRC(strings.Split(channel, " ")[0]); mapping != nil {
+		if pair := strings.SplitN(mapping.IRCChannel, " ", 2); len(pair) > 1 {
+			return pair[1]
+		}
+	}
+	return b.channelOptions(channel).Key
+}
+
+// trustedInviter returns true if invites from the nick should be accepted.
+func (b *Bridge) trustedInviter(nick string) bool {
+	if strings.EqualFold(nick, b.ircListener.GetNick()) {
+		return true
+	}
+	for _, trusted := range b.Config.TrustedInviters {
+		if strings.EqualFold(nick, trusted) {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptInvite joins a bridged channel that the connection was invited to by someone trusted.
+func (b *Bridge) acceptInvite(con *irc.Connection, e *irc.Event) {
+	if len(e.Arguments) < 2 {
+		return
+	}
+	channel := e.Arguments[1]
+
+	if b.GetMappingByIRC(channel) == nil || !b.trustedInviter(e.Nick) {
+		log.WithFields(log.Fields{
+			"channel": channel,
+			"inviter": e.Nick,
+		}).Debugln("Ignoring an invite to an IRC channel.")
+		return
+	}
+
+	if key := b.channelKey(channel); key != "" {
+		con.Join(channel + " " + key)
+	} else {
+		con.Join(channel)
+	}
+}
+
+// OnInvite accepts invites to bridged channels, e.g. after the listener was kicked from an invite only channel.
+func (i *ircListener) OnInvite(e *irc.Event) {
+	i.bridge.acceptInvite(i.Connection, e)
+}
+
+// OnInvite accepts invites to bridged channels.
+func (i *ircConnection) OnInvite(e *irc.Event) {
+	i.manager.bridge.acceptInvite(i.innerCon, e)
+}
+
+// OnInviteOnly handles ERR_INVITEONLYCHAN for a puppet. The listener invites it if it is
+// a channel operator, and otherwise the puppet knocks, if the server supports KNOCK.
+// Until it gets in, its messages are relayed by the listener.
+func (i *ircConnection) OnInviteOnly(e *irc.Event) {
+	i.OnCannotJoin(e)
+	if len(e.Arguments) < 2 {
+		return
+	}
+	channel := e.Arguments[1]
+
+	listener := i.manager.bridge.ircListener
+	if prefixes, ok := listener.users.Prefixes(channel, listener.GetNick()); ok && listener.users.HasPrefixAtLeast(prefixes, "@") {
+		listener.SendRawf("INVITE %s %s", i.ServerNick(), channel)
+		return
+	}
+
+	if listener.SupportsKnock() {
+		i.innerCon.SendRawf("KNOCK %s :%s is on Discord, and relayed by the bridge", channel, i.Discord().Username)
+	}
+}
+
+// SupportsKnock returns true if the server supports KNOCK, going by ISUPPORT.
+func (i *ircListener) SupportsKnock() bool {
+	i.topicMu.Lock()
+	defer i.topicMu.Unlock()
+	return i.knock
+}
@@ -0,0 +1,42 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannelKeyAndInvites(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.ChannelOptions = map[string]ChannelOptions{testChannel: {Key: "hunter2"}}
+		conf.TrustedInviters = []string{"ChanServ"}
+	})
+	defer tb.Close()
+
+	joins := func() int {
+		n := 0
+		for _, line := range tb.ircd.Received("listener") {
+			if line == "JOIN "+testChannel+" hunter2" {
+				n++
+			}
+		}
+		return n
+	}
+	assert.Equal(t, 1, joins())
+
+	// Invites from untrusted nicks, or to unbridged channels, are ignored.
+	// The listener has handled them once it answers the PING.
+	tb.ircd.SendTo("listener", ":mallory!m@host INVITE listener :%s", testChannel)
+	tb.ircd.SendTo("listener", ":ChanServ!cs@services INVITE listener :#elsewhere")
+	tb.ircd.SendTo("listener", "PING :invites")
+	waitFor(t, "PONG", func() bool {
+		return tb.ircd.HasReceived("listener", "PONG :invites")
+	})
+	assert.Equal(t, 1, joins())
+	assert.False(t, tb.ircd.HasReceived("listener", "JOIN #elsewhere"))
+
+	tb.ircd.SendTo("listener", ":ChanServ!cs@services INVITE listener :%s", testChannel)
+	waitFor(t, "listener to accept the invite", func() bool {
+		return joins() == 2
+	})
+}
@@ -2,6 +2,8 @@ package bridge
 
 import (
 	"strings"
+	"sync"
+	"time"
 
 	ircf "github.com/qaisjp/go-discord-irc/irc/format"
 	irc "github.com/qaisjp/go-ircevent"
@@ -11,25 +13,105 @@ import (
 type ircListener struct {
 	*irc.Connection
 	bridge *Bridge
+
+	users *ircUserTracker
+	caps  *capNegotiator
+
+	// joinErrors maps lowercase channels to why they could not be joined
+	joinErrorsMu sync.Mutex
+	joinErrors   map[string]string
+
+	// topics maps lowercase channels to the topic last set by the listener.
+	// The ISUPPORT tokens the bridge uses are kept with them.
+	topicMu     sync.Mutex
+	topics      map[string]string
+	topicLength int
+	knock       bool
+	botMode     string
+
+	// opered is whether the listener is an IRC operator,
+	// and challenge is a CHALLENGE being received
+	operMu    sync.Mutex
+	opered    bool
+	challenge strings.Builder
+
+	// pings are the replies awaited by measureLag, keyed by PING token
+	pingMu sync.Mutex
+	pings  map[string]chan time.Duration
+
+	// health is whether the listener is connected, for the bot's status
+	health ircHealth
+
+	// whois is the WHOIS replies the listener has received, for /bridge profile
+	whois whoisCache
+
+	// registered is the channels the listener has registered with ChanServ
+	registered registeredChannels
 }
 
 func newIRCListener(dib *Bridge, webIRCPass string) *ircListener {
 	irccon := irc.IRC(dib.Config.IRCListenerName, "discord")
-	listener := &ircListener{irccon, dib}
+	listener := &ircListener{
+		Connection: irccon,
+		bridge:     dib,
+
+		users: newIRCUserTracker(),
+		caps:  newCapNegotiator(irccon),
+
+		joinErrors: make(map[string]string),
+
+		topics:      make(map[string]string),
+		topicLength: defaultTopicLength,
+
+		pings: make(map[string]chan time.Duration),
+	}
 
 	dib.SetupIRCConnection(irccon, "discord.", "fd75:f5f5:226f::")
 	listener.SetDebugMode(dib.Config.Debug)
 
 	// Nick tracker for nick tracking
-	irccon.SetupNickTrack()
+	listener.users.Setup(irccon)
+	listener.users.onAccount = dib.observeAccount
+	listener.users.onModes = dib.updateRelayPolicy
+	listener.users.onNick = listener.OnNickChange
 
 	// Welcome event
 	irccon.AddCallback("001", listener.OnWelcome)
+	listener.setupHealth()
+	listener.setupWhois()
+
+	if dib.recorder != nil {
+		for _, code := range recordedIRCEvents {
+			irccon.AddCallback(code, listener.recordIRC)
+		}
+	}
 
 	// Called when received channel names... essentially OnJoinChannel
 	irccon.AddCallback("366", listener.OnJoinChannel)
 	irccon.AddCallback("PRIVMSG", listener.OnPrivateMessage)
 	irccon.AddCallback("CTCP_ACTION", listener.OnPrivateMessage)
+	irccon.AddCallback("NOTICE", listener.OnChannelNotice)
+	irccon.AddCallback("REDACT", listener.OnRedact)
+	irccon.AddCallback("PONG", listener.OnPong)
+	irccon.AddCallback("404", listener.OnCannotSend)
+	irccon.AddCallback("INVITE", listener.OnInvite)
+
+	// Reasons the listener could not join a channel, for diagnostics
+	for _, code := range []string{"403", "405", "471", "473", "474", "475", "477"} {
+		irccon.AddCallback(code, listener.OnJoinError)
+	}
+
+	// Discord pins can be mirrored into topics
+	irccon.AddCallback("005", listener.OnISupport)
+	irccon.AddCallback("482", listener.OnNotChannelOp)
+
+	// Oper up, and relay server notices
+	irccon.AddCallback("381", listener.OnOper)
+	irccon.AddCallback("464", listener.OnOperFailed)
+	irccon.AddCallback("491", listener.OnOperFailed)
+	irccon.AddCallback("740", listener.OnChallenge)
+	irccon.AddCallback("741", listener.OnChallengeEnd)
+	irccon.AddCallback("NOTICE", listener.OnServerNotice)
 
 	irccon.AddCallback("900", func(e *irc.Event) {
 		// Try to rejoni channels after authenticated with NickServ
@@ -40,14 +122,7 @@ func newIRCListener(dib *Bridge, webIRCPass string) *ircListener {
 }
 
 func (i *ircListener) DoesUserExist(user string) bool {
-	for _, channel := range i.Channels {
-		_, ok := channel.Users[user]
-		if ok {
-			return true
-		}
-	}
-
-	return false
+	return i.users.Exists(user)
 }
 
 func (i *ircListener) SetDebugMode(debug bool) {
@@ -62,27 +137,85 @@ func (i *ircListener) OnWelcome(e *irc.Event) {
 		i.Privmsgf("nickserv", "identify %s", identify)
 	}
 
-	// Join all channels
-	i.JoinChannels()
+	i.oper()
+
+	// Join all channels once we know what the server supports
+	i.caps.Start(i.JoinChannels)
+
+	if !i.bridge.Config.Shadow {
+		go i.bridge.connectIdentities()
+	}
 }
 
 func (i *ircListener) JoinChannels() {
 	i.SendRaw(i.bridge.GetJoinCommand())
+
+	// The listener also posts to some unmapped channels, which puppets don't join
+	for _, channel := range []string{i.bridge.Config.AuditIRCChannel, i.bridge.Config.DigestIRCChannel, i.bridge.Config.JoinAnnounceChannel, i.bridge.Config.StatusIRCChannel} {
+		if channel != "" && i.bridge.GetMappingByIRC(channel) == nil {
+			i.Join(channel)
+		}
+	}
 }
 
 func (i *ircListener) OnJoinChannel(e *irc.Event) {
 	log.Infof("Listener has joined IRC channel %s.", e.Arguments[1])
+
+	i.joinErrorsMu.Lock()
+	delete(i.joinErrors, strings.ToLower(e.Arguments[1]))
+	i.joinErrorsMu.Unlock()
+
+	// Find out if the channel is moderated or invite only
+	i.SendRawf("MODE %s", e.Arguments[1])
+
+	// NAMES does not tell us who is away, but WHO does
+	if i.caps.Enabled("away-notify") {
+		i.SendRawf("WHO %s", e.Arguments[1])
+	}
+
+	i.registerIfNew(e.Arguments[1])
+}
+
+// OnChannelNotice relays NOTICEs sent to bridged channels, if that is enabled.
+func (i *ircListener) OnChannelNotice(e *irc.Event) {
+	if !i.bridge.Config.RelayIRCNotices || e.Nick == "" || len(e.Arguments) == 0 || !strings.HasPrefix(e.Arguments[0], "#") {
+		return
+	}
+	i.OnPrivateMessage(e)
 }
 
 func (i *ircListener) OnPrivateMessage(e *irc.Event) {
 	// Ignore private messages
 	if string(e.Arguments[0][0]) != "#" {
+		if i.bridge.Config.Shadow {
+			shadowed(log.Fields{"nick": e.Nick, "message": e.Message()}, "answered a private message")
+			return
+		}
+
+		if i.runOperator(e) {
+			return
+		}
+
 		if e.Message() == "help" {
-			i.Privmsg(e.Nick, "Commands: help, who")
+			i.Privmsg(e.Nick, "Commands: help, who, link, status, notify, optout, optin, identify")
 		} else if e.Message() == "who" {
 			i.Privmsg(e.Nick, "I am the bot listener.")
+		} else if e.Message() == "status" {
+			for _, line := range i.bridge.statusLines() {
+				i.Privmsg(e.Nick, line)
+			}
+		} else if strings.HasPrefix(e.Message(), "link") {
+			i.handleLink(e)
+		} else if fields := strings.Fields(strings.TrimPrefix(e.Message(), "!")); len(fields) > 0 && fields[0] == "notify" {
+			i.handleNotify(e, fields[1:])
+		} else if len(fields) > 0 && (fields[0] == "optout" || fields[0] == "optin") {
+			i.handleOptOut(e, fields[0] == "optout")
+		} else if len(fields) > 0 && fields[0] == "identify" {
+			for _, line := range i.bridge.identityIRC(e.Nick, i.account(e), e.Source) {
+				i.Privmsg(e.Nick, line)
+			}
 		} else {
-			i.Privmsg(e.Nick, "Private messaging Discord users is not supported, but I support commands! Type 'help'.")
+			i.autoRespondIRC(e)
 		}
 		return
 	}
@@ -92,31 +225,302 @@ func (i *ircListener) OnPrivateMessage(e *irc.Event) {
 		return
 	}
 
+	// The listener is also in channels that aren't bridged, like the audit channel
+	if i.bridge.GetMappingByIRC(e.Arguments[0]) == nil {
+		i.bridge.unmappedMessage("irc", e.Arguments[0])
+		return
+	}
+
 	// Ignore messages from Discord bots
 	if strings.HasSuffix(strings.TrimRight(e.Nick, "_"), i.bridge.Config.Suffix) {
 		return
 	}
 
-	replacements := []string{}
-	for _, con := range i.bridge.ircManager.ircConnections {
-		replacements = append(replacements, con.nick, "<@!"+con.discord.ID+">")
+	// Ignore what the listener identities relayed from Discord
+	if i.bridge.isListenerIdentity(e.Nick) {
+		return
+	}
+
+	trace := i.bridge.traces.Start("irc", i.bridge.karmaKeyIRC(e.Nick, i.account(e)), e.Nick+" in "+e.Arguments[0], e.Tags["msgid"])
+
+	// Ignore messages from other relay bots
+	if i.bridge.isRelayBotIRC(e.Nick) {
+		i.bridge.traces.Step(trace, traceFilter, "dropped: sent by a relay bot")
+		return
 	}
 
-	msg := strings.NewReplacer(
-		replacements...,
-	).Replace(e.Message())
+	// Discord moderators can stop IRC users being relayed
+	if i.bridge.isIgnored(e.Source) {
+		i.bridge.traces.Step(trace, traceFilter, "dropped: %s is ignored", e.Source)
+		return
+	}
 
-	if e.Code == "CTCP_ACTION" {
-		msg = "_" + msg + "_"
+	// Bots echoing what we relayed from Discord would cause duplicates on Discord
+	if i.bridge.relayedToIRC.Seen(e.Message()) {
+		i.bridge.traces.Step(trace, traceFilter, "dropped: echo of a message relayed from Discord")
+		return
 	}
 
-	msg = ircf.BlocksToMarkdown(ircf.Parse(ircf.StripColor(msg)))
+	// Some mappings only relay messages from voiced users or ops
+	if minPrefix := i.bridge.channelOptions(e.Arguments[0]).IRCMinPrefix; minPrefix != "" {
+		prefixes, _ := i.users.Prefixes(e.Arguments[0], e.Nick)
+		if !i.users.HasPrefixAtLeast(prefixes, minPrefix) {
+			i.bridge.traces.Step(trace, traceFilter, "dropped: %s doesn't have %s", e.Nick, minPrefix)
+			return
+		}
+	}
 
+	// Bridge commands aren't relayed
+	if i.runCommand(e) {
+		i.bridge.traces.Step(trace, traceFilter, "ran as a bridge command")
+		return
+	}
+
+	// People can opt out of being relayed
+	if i.bridge.optedOut(i.bridge.karmaKeyIRC(e.Nick, i.account(e))) {
+		if _, edit := e.Tags["+draft/edit"]; !edit {
+			i.bridge.withheld(i.bridge.GetMappingByIRC(e.Arguments[0]), false)
+		}
+		i.bridge.traces.Step(trace, traceFilter, "dropped: %s opted out", e.Nick)
+		return
+	}
+
+	if i.bridge.Config.Karma && e.Code == "PRIVMSG" {
+		i.countKarma(e)
+	}
+
+	msg := i.formatMessage(e.Arguments[0], e.Code, e.Message())
+	i.bridge.traces.Step(trace, traceFormat, "formatted: %q", msg)
+
+	// Edits refer to the msgid of the original message
+	if target, ok := e.Tags["+draft/edit"]; ok && i.editRelayed(e, target, msg) {
+		i.bridge.traces.Step(trace, traceSend, "relayed as an edit of %s", target)
+		return
+	}
+
+	away := false
+	account := i.account(e)
+	if user, ok := i.users.Get(e.Nick); ok {
+		away = user.Away
+	}
+
+	place := i.bridge.relayOrder.next(e.Arguments[0])
 	go func(e *irc.Event) {
+		defer place.done()
+
+		channel, msg := i.applyLanguagePolicy(e, e.Arguments[0], msg)
+		if i.bridge.Config.LinkTitlesToDiscord {
+			msg = i.bridge.appendLinkTitles(msg, ircf.StripCodes(e.Message()))
+		}
+
+		place.wait()
 		i.bridge.discordMessagesChan <- IRCMessage{
-			IRCChannel: e.Arguments[0],
+			IRCChannel: channel,
 			Username:   e.Nick,
+			Hostmask:   e.Source,
 			Message:    msg,
+			Away:       away,
+			Account:    account,
+			MsgID:      e.Tags["msgid"],
+			Time:       serverTime(e),
+			TraceID:    trace,
 		}
 	}(e)
 }
+
+// formatMessage turns the text of a PRIVMSG, CTCP_ACTION or NOTICE in an IRC channel
+// into what is relayed to Discord.
+func (i *ircListener) formatMessage(channel, code, text string) string {
+	msg := i.bridge.mentions.Puppets(i.bridge.ircManager.mentionReplacer).Replace(text)
+
+	switch code {
+	case "CTCP_ACTION":
+		// Actions are italic, with a leading "*" like on IRC
+		msg = "_\\* " + msg + "_"
+	case "NOTICE":
+		msg = "> " + msg
+	}
+
+	return i.bridge.discord.expandEmoji(i.bridge.formatToDiscord(channel, msg))
+}
+
+// OnNickChange relays nick changes of IRC users to the Discord channels they are in.
+func (i *ircListener) OnNickChange(oldNick, newNick string, channels []string) {
+	i.bridge.observeNick(oldNick, newNick)
+
+	// Puppets change nick when the Discord user changes nick, which Discord users can already see.
+	// The server may have added underscores to a nick that was taken.
+	if i.bridge.ircManager.connectionByNick(newNick) != nil || i.bridge.ircManager.connectionByNick(strings.TrimRight(newNick, "_")) != nil {
+		return
+	}
+
+	if newNick == i.GetNick() || i.bridge.isRelayBotIRC(newNick) {
+		return
+	}
+
+	for _, mapping := range i.bridge.channelMappings() {
+		ircChannel := strings.Split(mapping.IRCChannel, " ")[0]
+		if !containsFold(channels, ircChannel) || i.bridge.channelOptions(ircChannel).HideNickChanges {
+			continue
+		}
+
+		i.bridge.discordMessagesChan <- IRCMessage{
+			IRCChannel: ircChannel,
+			Username:   oldNick,
+			Message:    "_is now known as " + escapeMarkdown(newNick) + "_",
+		}
+	}
+}
+
+// containsFold returns true if the list contains s (case insensitive).
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// isChannelOp returns true if the nick is a channel operator (or half-op) in the given channel.
+func (i *ircListener) isChannelOp(channel, nick string) bool {
+	prefixes, ok := i.users.Prefixes(channel, nick)
+	return ok && strings.ContainsAny(prefixes, "~&@%")
+}
+
+// handlePin pins the Discord counterpart of a recently relayed IRC message.
+//
+// The command takes the form "!pin [fragment]". If a fragment is given,
+// the most recent message containing the fragment is pinned, otherwise
+// the most recent message in the channel is pinned.
+func (i *ircListener) handlePin(e *irc.Event, args []string) {
+	channel := e.Arguments[0]
+	fragment := strings.Join(args, " ")
+
+	msg := i.bridge.messages.LatestFromIRC(channel, fragment)
+	if msg == nil {
+		i.Notice(e.Nick, "Could not find a recently relayed message to pin.")
+		return
+	}
+
+	err := i.bridge.discord.ChannelMessagePin(msg.DiscordChannel, msg.DiscordID)
+	if err != nil {
+		i.bridge.handleError(err, nil, "could not pin message on discord")
+		i.Notice(e.Nick, "Could not pin that message on Discord.")
+		return
+	}
+
+	i.Noticef(channel, "%s pinned a message from %s on Discord.", e.Nick, msg.IRCNick)
+}
+
+func init() {
+	registerChatCommand(&chatCommand{
+		Name:       "pin",
+		Permission: permissionModerator,
+		Enabled:    func(b *Bridge) bool { return b.Config.AllowIRCPins },
+		IRC:        (*ircListener).handlePin,
+	})
+}
+
+// account returns the services account of the sender of an event, if known.
+// The account-tag is preferred, as it is sent with every message.
+func (i *ircListener) account(e *irc.Event) string {
+	if account, ok := e.Tags["account"]; ok {
+		i.bridge.observeAccount(e.Nick, account)
+		return account
+	}
+
+	if user, ok := i.users.Get(e.Nick); ok {
+		return user.Account
+	}
+
+	return ""
+}
+
+// handleLink confirms an identity link using the code given to the user on Discord.
+func (i *ircListener) handleLink(e *irc.Event) {
+	fields := strings.Fields(e.Message())
+	if len(fields) != 2 {
+		i.Privmsg(e.Nick, "To link your IRC and Discord identities, send \"!link\" to the bridge bot on Discord.")
+		return
+	}
+
+	link, err := i.bridge.completeLink(fields[1], e.Nick, i.account(e))
+	if err != nil {
+		log.WithField("error", err).Errorln("could not save identity link")
+		i.Privmsg(e.Nick, "Something went wrong linking your identities, sorry.")
+		return
+	}
+
+	if link == nil {
+		i.Privmsg(e.Nick, "That link code is invalid or has expired.")
+		return
+	}
+
+	if link.IRCAccount == "" {
+		i.Privmsg(e.Nick, "Your IRC nick has been linked to your Discord account.")
+	} else {
+		i.Privmsgf(e.Nick, "Your services account %s has been linked to your Discord account.", link.IRCAccount)
+	}
+}
+
+// channelRelayPolicy works out how messages should be relayed to a channel from its modes.
+func (i *ircListener) channelRelayPolicy(channel string) relayPolicy {
+	prefixes, _ := i.users.Prefixes(channel, i.GetNick())
+	voiced := prefixes != "" // any prefix can speak in a moderated channel
+
+	if i.users.HasMode(channel, 'm') {
+		// Unvoiced puppets can't speak, and neither can we
+		if !voiced {
+			return relayPaused
+		}
+		return relayListener
+	}
+
+	// New puppets can't join
+	if i.users.HasMode(channel, 'i') {
+		return relayListener
+	}
+
+	return relayPuppets
+}
+
+// editRelayed edits the Discord counterpart of an edited IRC message.
+//
+// Returns false if the original message is unknown, in which case
+// the edit should be relayed as a new message.
+func (i *ircListener) editRelayed(e *irc.Event, msgID string, content string) bool {
+	relayed := i.bridge.messages.ByIRCMsgID(msgID)
+	if relayed == nil || relayed.IRCNick != e.Nick {
+		return false
+	}
+
+	err := i.bridge.discord.getTransmitter().Edit(relayed.DiscordWebhookID, relayed.DiscordID, sanitiseDiscordContent(content))
+	if err != nil {
+		i.bridge.handleError(err, nil, "could not relay IRC edit to discord")
+		return false
+	}
+
+	i.bridge.messages.SetContent(relayed, content)
+	return true
+}
+
+// OnRedact deletes the Discord counterpart of a message redacted on IRC.
+//
+// See https://ircv3.net/specs/extensions/message-redaction
+// "REDACT <target> <msgid> [:reason]"
+func (i *ircListener) OnRedact(e *irc.Event) {
+	if len(e.Arguments) < 2 {
+		return
+	}
+
+	relayed := i.bridge.messages.ByIRCMsgID(e.Arguments[1])
+	if relayed == nil {
+		return
+	}
+
+	err := i.bridge.discord.ChannelMessageDelete(relayed.DiscordChannel, relayed.DiscordID)
+	if err != nil {
+		i.bridge.handleError(err, nil, "could not relay IRC redaction to discord")
+	}
+}
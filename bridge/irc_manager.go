@@ -3,8 +3,11 @@ package bridge
 import (
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/mozillazg/go-unidecode"
 	"github.com/pkg/errors"
@@ -16,8 +19,10 @@ import (
 
 var cooldownDuration = time.Hour * 24
 
-// IRCManager should only be used from one thread.
+// IRCManager should only be used from one thread. Other goroutines look up
+// puppets with connections, connection and connectionByNick.
 type IRCManager struct {
+	connectionsMu  sync.RWMutex
 	ircConnections map[string]*ircConnection
 
 	bridge *Bridge
@@ -31,16 +36,59 @@ func newIRCManager(bridge *Bridge) *IRCManager {
 	}
 }
 
+// connections returns a snapshot of the puppets.
+func (m *IRCManager) connections() []*ircConnection {
+	m.connectionsMu.RLock()
+	defer m.connectionsMu.RUnlock()
+
+	cons := make([]*ircConnection, 0, len(m.ircConnections))
+	for _, con := range m.ircConnections {
+		cons = append(cons, con)
+	}
+	return cons
+}
+
+// connection returns the puppet of a Discord user.
+func (m *IRCManager) connection(userID string) (*ircConnection, bool) {
+	m.connectionsMu.RLock()
+	defer m.connectionsMu.RUnlock()
+	con, ok := m.ircConnections[userID]
+	return con, ok
+}
+
+// connectionByNick returns the puppet using an IRC nick, or nil if there isn't one.
+func (m *IRCManager) connectionByNick(nick string) *ircConnection {
+	for _, con := range m.connections() {
+		if strings.EqualFold(con.Nick(), nick) {
+			return con
+		}
+	}
+	return nil
+}
+
+// mentionReplacer returns a replacer that turns the nick of each puppet into a mention of its Discord user.
+func (m *IRCManager) mentionReplacer() *strings.Replacer {
+	replacements := []string{}
+	for _, con := range m.connections() {
+		replacements = append(replacements, con.Nick(), "<@!"+con.Discord().ID+">")
+	}
+	return strings.NewReplacer(replacements...)
+}
+
 // CloseConnection shuts down a particular connection and its channels.
 func (m *IRCManager) CloseConnection(i *ircConnection) {
-	log.WithField("nick", i.nick).Println("Closing connection.")
+	log.WithField("nick", i.Nick()).Println("Closing connection.")
 	// Destroy the cooldown timer
 	if i.cooldownTimer != nil {
 		i.cooldownTimer.Stop()
 		i.cooldownTimer = nil
 	}
 
-	delete(m.ircConnections, i.discord.ID)
+	m.connectionsMu.Lock()
+	delete(m.ircConnections, i.Discord().ID)
+	m.connectionsMu.Unlock()
+	m.bridge.mentions.Invalidate(i.Discord().ID)
+	i.overflow.Close()
 	close(i.messages)
 
 	if i.innerCon.Connected() {
@@ -50,34 +98,62 @@ func (m *IRCManager) CloseConnection(i *ircConnection) {
 
 // Close closes all of an IRCManager's connections.
 func (m *IRCManager) Close() {
-	i := 0
-	for _, con := range m.ircConnections {
+	for _, con := range m.connections() {
 		m.CloseConnection(con)
-		i++
 	}
 }
 
 // SetConnectionCooldown renews/starts a timer for expiring a connection.
 func (m *IRCManager) SetConnectionCooldown(con *ircConnection) {
 	if con.cooldownTimer != nil {
-		log.WithField("nick", con.nick).Println("IRC connection cooldownTimer stopped!")
+		log.WithField("nick", con.Nick()).Println("IRC connection cooldownTimer stopped!")
 		con.cooldownTimer.Stop()
 	}
 
 	con.cooldownTimer = time.AfterFunc(
 		cooldownDuration,
 		func() {
-			log.WithField("nick", con.nick).Println("IRC connection expired by cooldownTimer...")
-			m.CloseConnection(con)
+			log.WithField("nick", con.Nick()).Println("IRC connection expired by cooldownTimer...")
+			m.expireConnection(con)
 		},
 	)
 
-	log.WithField("nick", con.nick).Println("IRC connection cooldownTimer created...")
+	log.WithField("nick", con.Nick()).Println("IRC connection cooldownTimer created...")
+}
+
+// expireConnection disconnects a puppet whose Discord user has been offline for the cooldown.
+//
+// Puppets stay in channels with smart presence, and only leave the others,
+// so that people going offline on Discord don't make them quit and rejoin.
+func (m *IRCManager) expireConnection(con *ircConnection) {
+	var parting []string
+	stay := false
+	for channel := range m.bridge.GetIRCChannels() {
+		if m.bridge.channelOptions(channel).SmartPresence {
+			stay = true
+		} else {
+			parting = append(parting, channel)
+		}
+	}
+
+	if !stay {
+		m.CloseConnection(con)
+		return
+	}
+	if len(parting) == 0 {
+		return
+	}
+
+	sort.Strings(parting)
+	con.innerCon.SendRawf("PART %s :%s", strings.Join(parting, ","), con.innerCon.QuitMessage)
+	con.presenceMu.Lock()
+	con.partedOffline = true
+	con.presenceMu.Unlock()
 }
 
 // DisconnectUser immediately disconnects a Discord user if it exists
 func (m *IRCManager) DisconnectUser(userID string) {
-	con, ok := m.ircConnections[userID]
+	con, ok := m.connection(userID)
 	if !ok {
 		return
 	}
@@ -86,18 +162,8 @@ func (m *IRCManager) DisconnectUser(userID string) {
 
 // HandleUser deals with messages sent from a DiscordUser
 func (m *IRCManager) HandleUser(user DiscordUser) {
-	if user.Username == "" || user.Discriminator == "" {
-		log.WithFields(log.Fields{
-			"err":                errors.WithStack(errors.New("Username or Discriminator is empty")).Error(),
-			"user.Username":      user.Username,
-			"user.Discriminator": user.Discriminator,
-			"user.ID":            user.ID,
-		}).Println("ignoring a HandleUser")
-		return
-	}
-
 	// Does the user exist on the IRC side?
-	if con, ok := m.ircConnections[user.ID]; ok {
+	if con, ok := m.connection(user.ID); ok {
 		// Close the connection if they are not
 		// online on Discord anymore (after cooldown)
 		if !user.Online {
@@ -111,6 +177,7 @@ func (m *IRCManager) HandleUser(user DiscordUser) {
 				con.cooldownTimer = nil
 
 				con.SetAway("")
+				con.rejoinOnline()
 			}
 		}
 
@@ -120,7 +187,6 @@ func (m *IRCManager) HandleUser(user DiscordUser) {
 		}
 
 		// Update their nickname / username
-		// TODO: Support username changes
 		// Note: this event is still called when their status is changed
 		//       from `online` to `dnd` (online related states)
 		//       In UpdateDetails we handle nickname changes so it is
@@ -129,6 +195,18 @@ func (m *IRCManager) HandleUser(user DiscordUser) {
 		return
 	}
 
+	// Presence updates for people going offline only have their ID, which is
+	// enough to update a puppet but not to create one
+	if user.Username == "" || user.Discriminator == "" {
+		log.WithFields(log.Fields{
+			"err":                errors.WithStack(errors.New("Username or Discriminator is empty")).Error(),
+			"user.Username":      user.Username,
+			"user.Discriminator": user.Discriminator,
+			"user.ID":            user.ID,
+		}).Println("ignoring a HandleUser")
+		return
+	}
+
 	// If they are not online, do not create a connection.
 	// if !user.Online {
 	// 	return
@@ -168,17 +246,37 @@ func (m *IRCManager) HandleUser(user DiscordUser) {
 		nick:    nick,
 
 		messages:      make(chan IRCMessage),
+		overflow:      newOverflowQueue(m.bridge.Config, user.ID),
 		cooldownTimer: nil,
 
 		manager: m,
 
 		pmNoticedSenders: make(map[string]struct{}),
+
+		degraded: make(map[string]string),
 	}
 
 	con.innerCon.AddCallback("001", con.OnWelcome)
+	con.innerCon.AddCallback("NICK", con.OnNick)
 	con.innerCon.AddCallback("PRIVMSG", con.OnPrivateMessage)
-
+	con.innerCon.AddCallback("366", con.OnJoined)
+	con.innerCon.AddCallback("401", con.OnNoSuchNick)
+	con.innerCon.AddCallback("404", con.OnCannotSend)
+	con.innerCon.AddCallback("PONG", con.OnPong)
+	con.innerCon.AddCallback("MODE", con.OnMode)
+	con.innerCon.AddCallback("INVITE", con.OnInvite)
+	con.innerCon.AddCallback("471", con.OnCannotJoin)
+	con.innerCon.AddCallback("473", con.OnInviteOnly)
+	con.innerCon.AddCallback("474", con.OnCannotJoin)
+	con.innerCon.AddCallback("475", con.OnCannotJoin)
+	con.innerCon.AddCallback("477", con.OnCannotJoin)
+	con.innerCon.AddCallback("480", con.OnJoinThrottled)
+	con.innerCon.AddCallback("NOTICE", con.OnServicesNotice)
+
+	m.connectionsMu.Lock()
 	m.ircConnections[user.ID] = con
+	m.connectionsMu.Unlock()
+	m.bridge.mentions.Invalidate(user.ID)
 
 	err := con.innerCon.Connect(m.bridge.Config.IRCServer)
 	if err != nil {
@@ -198,10 +296,7 @@ func sanitiseNickname(nick string) string {
 		return "_"
 	}
 
-	// Unidecode the nickname — we make sure it's not empty to prevent "🔴🔴" becoming ""
-	if newnick := unidecode.Unidecode(nick); newnick != "" {
-		nick = newnick
-	}
+	nick = transliterate(nick)
 
 	// https://github.com/lp0/charybdis/blob/9ced2a7932dddd069636fe6fe8e9faa6db904703/ircd/client.c#L854-L884
 	if nick[0] == '-' {
@@ -227,14 +322,35 @@ func sanitiseNickname(nick string) string {
 	return string(newNick)
 }
 
+// transliterate converts non-ASCII characters to their closest ASCII equivalents, so "Łukasz" becomes "Lukasz"
+// and "東京" becomes "Dong Jing". Text that can't be transliterated, like "🔴🔴", is returned unchanged rather than becoming "".
+func transliterate(text string) string {
+	// Unidecode adds a space after each CJK character
+	if ascii := strings.TrimSpace(unidecode.Unidecode(text)); ascii != "" {
+		return ascii
+	}
+	return text
+}
+
+// isReadableNick returns true if a sanitised nick has any letters or digits, unlike "_".
+func isReadableNick(nick string) bool {
+	for _, c := range []byte(nick) {
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ircnick.IsDigit(c) {
+			return true
+		}
+	}
+	return false
+}
+
 func (m *IRCManager) generateNickname(discord DiscordUser) string {
-	nick := sanitiseNickname(discord.Nick)
-	suffix := m.bridge.Config.Suffix
+	nick := sanitiseNickname(m.templateNick(discord))
+	suffix := m.botMarker(discord) + m.bridge.Config.Suffix
 	newNick := nick + suffix
 
-	useFallback := len(newNick) > ircnick.MAXLENGTH || m.bridge.ircListener.DoesUserExist(newNick)
+	// Names that couldn't be transliterated would all become "_", so they use the fallback too
+	useFallback := !isReadableNick(nick) || len(newNick) > m.nickMaxLength() || m.bridge.ircListener.DoesUserExist(newNick)
 	// log.WithFields(log.Fields{
-	// 	"length":      len(newNick) > ircnick.MAXLENGTH,
+	// 	"length":      len(newNick) > m.nickMaxLength(),
 	// 	"useFallback": useFallback,
 	// }).Infoln("nickgen: fallback?")
 
@@ -260,7 +376,13 @@ func (m *IRCManager) generateNickname(discord DiscordUser) string {
 				continue
 			}
 
-			if sanitiseNickname(name) == nick {
+			other := DiscordUser{
+				ID:            member.User.ID,
+				Nick:          name,
+				Username:      member.User.Username,
+				Discriminator: member.User.Discriminator,
+			}
+			if sanitiseNickname(m.templateNick(other)) == nick {
 				// log.WithField("member", member).Infoln("nickgen: using fallback because of discord")
 				useFallback = true
 				break
@@ -269,24 +391,7 @@ func (m *IRCManager) generateNickname(discord DiscordUser) string {
 	}
 
 	if useFallback {
-		discriminator := discord.Discriminator
-		username := sanitiseNickname(discord.Username)
-		suffix = m.bridge.Config.Separator + discriminator + suffix
-
-		// Maximum length of a username but without the suffix
-		length := ircnick.MAXLENGTH - len(suffix)
-		if length >= len(username) {
-			length = len(username)
-			// log.Infoln("nickgen: maximum length limit not reached")
-		}
-
-		newNick = username[:length] + suffix
-		// log.WithFields(log.Fields{
-		// 	"nick":     discord.Nick,
-		// 	"username": discord.Username,
-		// 	"newNick":  newNick,
-		// }).Infoln("nickgen: resultant nick after falling back")
-		return newNick
+		return m.fallbackNickname(discord)
 	}
 
 	// log.WithFields(log.Fields{
@@ -298,25 +403,65 @@ func (m *IRCManager) generateNickname(discord DiscordUser) string {
 	return newNick
 }
 
+// fallbackNickname generates a nickname from the username and discriminator,
+// for when the preferred nickname is too long or already in use.
+func (m *IRCManager) fallbackNickname(discord DiscordUser) string {
+	discriminator := discord.Discriminator
+	username := sanitiseNickname(discord.Username)
+	suffix := m.bridge.Config.Separator + discriminator + m.botMarker(discord) + m.bridge.Config.Suffix
+
+	// Maximum length of a username but without the suffix
+	length := m.nickMaxLength() - len(suffix)
+	if length >= len(username) {
+		length = len(username)
+		// log.Infoln("nickgen: maximum length limit not reached")
+	}
+
+	newNick := username[:length] + suffix
+	// log.WithFields(log.Fields{
+	// 	"nick":     discord.Nick,
+	// 	"username": discord.Username,
+	// 	"newNick":  newNick,
+	// }).Infoln("nickgen: resultant nick after falling back")
+	return newNick
+}
+
 // SendMessage sends a broken down Discord Message to a particular IRC channel.
 func (m *IRCManager) SendMessage(channel string, msg *DiscordMessage) {
-	con, ok := m.ircConnections[msg.Author.ID]
+	con, ok := m.connection(msg.Author.ID)
 
 	content := msg.Content
 
 	channel = strings.Split(channel, " ")[0]
 
+	switch m.bridge.relayPolicy(channel) {
+	case relayPaused:
+		log.WithField("channel", channel).Debugln("Dropping message, relaying to this channel is paused.")
+		return
+	case relayListener:
+		m.sendViaListener(channel, DiscordUser{
+			ID:            msg.Author.ID,
+			Username:      msg.Author.Username,
+			Discriminator: msg.Author.Discriminator,
+			Bot:           isBotMessage(msg.Message),
+		}, content)
+		return
+	}
+
 	// Person is appearing offline (or the bridge is running in Simple Mode)
 	if !ok {
-		length := len(msg.Author.Username)
-		for _, line := range strings.Split(content, "\n") {
-			m.bridge.ircListener.Privmsg(channel, fmt.Sprintf(
-				"<%s#%s> %s",
-				msg.Author.Username[:1]+"\u200B"+msg.Author.Username[1:length],
-				msg.Author.Discriminator,
-				line,
-			))
-		}
+		m.sendViaListener(channel, DiscordUser{
+			ID:            msg.Author.ID,
+			Username:      msg.Author.Username,
+			Discriminator: msg.Author.Discriminator,
+			Bot:           isBotMessage(msg.Message),
+		}, content)
+		return
+	}
+
+	// The puppet can't speak in this channel
+	if con.IsDegraded(channel) {
+		m.sendViaListener(channel, con.Discord(), content)
 		return
 	}
 
@@ -332,15 +477,49 @@ func (m *IRCManager) SendMessage(channel string, msg *DiscordMessage) {
 			IsAction:   msg.IsAction,
 		}
 
+		// Stay behind messages that are already queued
+		if con.overflow.Draining() {
+			con.queue(ircMessage)
+			continue
+		}
+
 		select {
 		// Try to send the message immediately
 		case con.messages <- ircMessage:
-		// If it can't after 5ms, do it in a separate goroutine
+		// If it can't after 5ms, queue it
 		case <-time.After(time.Millisecond * 5):
-			go func() {
-				con.messages <- ircMessage
-			}()
+			con.queue(ircMessage)
+		}
+	}
+}
+
+// sendViaListener relays a message from a Discord user through the listener,
+// prefixed with their Discord username.
+func (m *IRCManager) sendViaListener(channel string, user DiscordUser, content string) {
+	name := user.Username
+	if name == "" {
+		name = "unknown"
+	}
+
+	// Insert a zero width space so that IRC users with the same nick aren't highlighted
+	_, size := utf8.DecodeRuneInString(name)
+	name = name[:size] + "\u200B" + name[size:]
+
+	// Webhooks and users with the new usernames don't have a discriminator
+	if user.Discriminator != "" && user.Discriminator != "0" && user.Discriminator != "0000" {
+		name += "#" + user.Discriminator
+	}
+
+	name = colorNick(name, m.bridge.nickColor(channel, user))
+	if user.Bot && m.bridge.Config.BotMarker != "" {
+		name += " " + m.bridge.Config.BotMarker
+	}
+
+	for _, line := range strings.Split(content, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
 		}
+		m.bridge.listenerFor(channel).Privmsg(channel, fmt.Sprintf("<%s> %s", name, line))
 	}
 }
 
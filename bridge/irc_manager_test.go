@@ -0,0 +1,62 @@
+package bridge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSmartPresence(t *testing.T) {
+	defer func(d time.Duration) { cooldownDuration = d }(cooldownDuration)
+	cooldownDuration = 50 * time.Millisecond
+
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.ChannelMappings["#smart"] = "2001"
+		conf.ChannelOptions = map[string]ChannelOptions{"#smart": {SmartPresence: true}}
+	})
+	defer tb.Close()
+
+	bob := tb.discordMember("100", "bob", "")
+	nick := tb.puppet(t, bob, "bob")
+	waitFor(t, "puppet to join the smart presence channel", func() bool {
+		return tb.ircd.InChannel("#smart", nick)
+	})
+
+	tb.Bridge.updateUserChan <- DiscordUser{ID: "100"}
+	waitFor(t, "puppet to leave after the cooldown", func() bool {
+		return !tb.ircd.InChannel(testChannel, nick)
+	})
+	assert.True(t, tb.ircd.InChannel("#smart", nick))
+	assert.True(t, tb.ircd.HasReceived(nick, "AWAY :offline on discord"))
+
+	tb.puppet(t, bob, "bob")
+	assert.True(t, tb.ircd.InChannel("#smart", nick))
+}
+
+func TestDiscordRename(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.RenameNotices = true
+	})
+	defer tb.Close()
+
+	bob := tb.discordMember("100", "bob", "")
+	nick := tb.puppet(t, bob, "bob")
+
+	// A new username keeps the same puppet, which only changes nick
+	tb.Bridge.updateUserChan <- DiscordUser{ID: "100", Username: "bobby", Discriminator: bob.Discriminator, Nick: "bobby", Online: true}
+	waitFor(t, "puppet to change nick", func() bool {
+		return tb.ircd.HasReceived(nick, "NICK bobby_d")
+	})
+	for _, line := range tb.ircd.Received(nick) {
+		assert.NotContains(t, line, "QUIT")
+	}
+
+	d := tb.Bridge.discord
+	renamed := &discordgo.Member{User: &discordgo.User{ID: "100", Username: "bobby"}}
+	d.announceRename(&discordgo.Member{User: bob}, renamed)
+	waitFor(t, "rename notice", func() bool {
+		return tb.ircd.HasReceived("listener", "NOTICE "+testChannel+" :* bob is now known as bobby")
+	})
+}
@@ -0,0 +1,50 @@
+package bridge
+
+import (
+	"fmt"
+)
+
+// Ways of publishing puppet metadata, for Config.PuppetMetadata
+const (
+	metadataNone   = ""
+	metadataIRCv3  = "metadata" // IRCv3 METADATA, set by the puppet itself (e.g. Ergo)
+	metadataSWHOIS = "swhois"   // SWHOIS, set by the listener, which must be an oper (e.g. InspIRCd)
+)
+
+// publishMetadata tells the IRC server who the Discord user behind this puppet is,
+// so that it shows up in WHOIS. nick is the puppet's nick on the server right now.
+func (i *ircConnection) publishMetadata(nick string) {
+	bridge := i.manager.bridge
+	discord := i.Discord()
+
+	switch bridge.Config.PuppetMetadata {
+	case metadataIRCv3:
+		i.innerCon.SendRawf("METADATA * SET discord.id :%s", discord.ID)
+		i.innerCon.SendRawf("METADATA * SET discord.username :%s", discord.Username)
+		i.innerCon.SendRawf("METADATA * SET display-name :%s", discord.Nick)
+		if avatar := bridge.discord.GetAvatarByID(discord.ID); avatar != "" {
+			i.innerCon.SendRawf("METADATA * SET avatar :%s", avatar)
+		}
+		if discord.Bot {
+			i.innerCon.SendRaw("METADATA * SET bot :1")
+		}
+	case metadataSWHOIS:
+		bridge.ircListener.SendRawf("SWHOIS %s :%s", nick, i.whoisLine())
+	}
+}
+
+// whoisLine describes the Discord user behind this puppet.
+func (i *ircConnection) whoisLine() string {
+	discord := i.Discord()
+	kind := "user"
+	if discord.Bot {
+		kind = "bot"
+	}
+
+	name := discord.Username
+	if discord.Discriminator != "" && discord.Discriminator != "0" && discord.Discriminator != "0000" {
+		name += "#" + discord.Discriminator
+	}
+
+	return fmt.Sprintf("is Discord %s %s (ID %s)", kind, name, discord.ID)
+}
@@ -0,0 +1,51 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPuppetMetadata(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.PuppetMetadata = metadataIRCv3
+	})
+	defer tb.Close()
+
+	bob := tb.discordMember("100", "bob", "Bobby")
+	nick := tb.puppet(t, bob, "Bobby")
+
+	waitFor(t, "metadata", func() bool {
+		return tb.ircd.HasReceived(nick, "METADATA * SET discord.id :100") &&
+			tb.ircd.HasReceived(nick, "METADATA * SET discord.username :bob") &&
+			tb.ircd.HasReceived(nick, "METADATA * SET display-name :Bobby")
+	})
+}
+
+func TestPuppetSWHOIS(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.PuppetMetadata = metadataSWHOIS
+	})
+	defer tb.Close()
+
+	bob := tb.discordMember("100", "bob", "")
+	nick := tb.puppet(t, bob, "bob")
+
+	waitFor(t, "swhois", func() bool {
+		return tb.ircd.HasReceived("listener", "SWHOIS "+nick+" :is Discord user bob#0001 (ID 100)")
+	})
+	for _, line := range tb.ircd.Received(nick) {
+		assert.NotContains(t, line, "METADATA")
+	}
+}
+
+func TestPuppetMetadataConfig(t *testing.T) {
+	_, err := New(&Config{
+		IRCServer:      "irc.example.com",
+		WebhookPrefix:  "test",
+		PuppetMetadata: "whois",
+	})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unknown puppet metadata method")
+	}
+}
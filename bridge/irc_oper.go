@@ -0,0 +1,179 @@
+package bridge
+
+import (
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+	ircf "github.com/qaisjp/go-discord-irc/irc/format"
+	irc "github.com/qaisjp/go-ircevent"
+	log "github.com/sirupsen/logrus"
+)
+
+// oper makes the listener an IRC operator, using CHALLENGE if a key file is configured.
+func (i *ircListener) oper() {
+	conf := i.bridge.Config
+	if conf.OperName == "" {
+		return
+	}
+
+	if conf.OperKeyFile != "" {
+		i.operMu.Lock()
+		i.challenge.Reset()
+		i.operMu.Unlock()
+
+		i.SendRawf("CHALLENGE %s", conf.OperName)
+		return
+	}
+
+	i.SendRawf("OPER %s %s", conf.OperName, conf.OperPassword)
+}
+
+// OnChallenge handles RPL_RSACHALLENGE2, which is one part of the challenge.
+func (i *ircListener) OnChallenge(e *irc.Event) {
+	i.operMu.Lock()
+	defer i.operMu.Unlock()
+	i.challenge.WriteString(e.Message())
+}
+
+// OnChallengeEnd handles RPL_ENDOFRSACHALLENGE2, by responding to the challenge.
+func (i *ircListener) OnChallengeEnd(e *irc.Event) {
+	i.operMu.Lock()
+	challenge := i.challenge.String()
+	i.challenge.Reset()
+	i.operMu.Unlock()
+
+	key, err := loadOperKey(i.bridge.Config.OperKeyFile)
+	if err != nil {
+		i.bridge.handleError(withCategory(err, errConfig), nil, "could not load oper key")
+		return
+	}
+
+	response, err := challengeResponse(key, challenge)
+	if err != nil {
+		i.bridge.handleError(withCategory(err, errConfig), nil, "could not respond to oper challenge")
+		return
+	}
+
+	i.SendRawf("CHALLENGE +%s", response)
+}
+
+// loadOperKey reads a PEM encoded RSA private key.
+func loadOperKey(path string) (*rsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read oper key file")
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("oper key file is not PEM encoded")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse oper key")
+	}
+
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("oper key is not an RSA key")
+	}
+	return key, nil
+}
+
+// challengeResponse answers a ratbox/charybdis CHALLENGE, which is random bytes
+// encrypted with our public key. The response is the SHA-1 of those bytes.
+func challengeResponse(key *rsa.PrivateKey, challenge string) (string, error) {
+	ciphertext, err := base64.StdEncoding.DecodeString(challenge)
+	if err != nil {
+		return "", errors.Wrap(err, "challenge is not base64")
+	}
+
+	plaintext, err := rsa.DecryptOAEP(sha1.New(), nil, key, ciphertext, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "could not decrypt challenge")
+	}
+
+	sum := sha1.Sum(plaintext)
+	return base64.StdEncoding.EncodeToString(sum[:]), nil
+}
+
+// OnOper handles RPL_YOUREOPER.
+func (i *ircListener) OnOper(e *irc.Event) {
+	i.operMu.Lock()
+	i.opered = true
+	i.operMu.Unlock()
+
+	log.Infoln("Listener is now an IRC operator.")
+
+	if snomask := i.bridge.Config.OperSnomask; snomask != "" {
+		i.SendRawf("MODE %s +s %s", i.GetNick(), snomask)
+	}
+}
+
+// OnOperFailed handles ERR_PASSWDMISMATCH and ERR_NOOPERHOST.
+func (i *ircListener) OnOperFailed(e *irc.Event) {
+	i.bridge.handleError(withCategory(errors.New(e.Message()), errConfig), nil, "listener could not become an IRC operator")
+}
+
+// Opered returns true if the listener is an IRC operator.
+func (i *ircListener) Opered() bool {
+	i.operMu.Lock()
+	defer i.operMu.Unlock()
+	return i.opered
+}
+
+// OnServerNotice relays server notices to the Discord ops channel.
+func (i *ircListener) OnServerNotice(e *irc.Event) {
+	channel := i.bridge.Config.OperDiscordChannel
+	if channel == "" || e.Nick != "" || e.Source == "" || !i.Opered() {
+		return
+	}
+
+	_, err := i.bridge.discord.ChannelMessageSend(channel, e.Source+": "+ircf.StripCodes(e.Message()))
+	if err != nil {
+		i.bridge.handleError(err, nil, "could not relay server notice to discord")
+	}
+}
+
+// cloakPuppet gives a puppet the configured host.
+func (i *ircListener) cloakPuppet(nick string, user DiscordUser) {
+	host := i.bridge.Config.OperPuppetHost
+	if host == "" || !i.Opered() {
+		return
+	}
+
+	host = strings.NewReplacer(
+		"{id}", user.ID,
+		"{username}", strings.ToLower(sanitiseNickname(user.Username)),
+	).Replace(host)
+
+	i.SendRawf("CHGHOST %s %s", nick, host)
+}
+
+// OnJoinThrottled handles ERR_THROTTLE, when a puppet joins channels too quickly.
+// If the listener is an oper it forces the puppet in, otherwise the listener
+// relays for the puppet.
+func (i *ircConnection) OnJoinThrottled(e *irc.Event) {
+	if len(e.Arguments) < 2 {
+		return
+	}
+
+	listener := i.manager.bridge.ircListener
+	if listener.Opered() {
+		listener.SendRawf("SAJOIN %s %s", i.ServerNick(), e.Arguments[1])
+	}
+
+	// Until the join succeeds, the listener relays for the puppet
+	i.setDegraded(e.Arguments[1], e.Message())
+}
@@ -0,0 +1,71 @@
+package bridge
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChallengeResponse(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := []byte("a random challenge")
+	ciphertext, err := rsa.EncryptOAEP(sha1.New(), rand.Reader, &key.PublicKey, secret, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha1.Sum(secret)
+	response, err := challengeResponse(key, base64.StdEncoding.EncodeToString(ciphertext))
+	assert.NoError(t, err)
+	assert.Equal(t, base64.StdEncoding.EncodeToString(sum[:]), response)
+
+	_, err = challengeResponse(key, "not base64!")
+	assert.Error(t, err)
+}
+
+func TestOperMode(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.OperName = "bridge"
+		conf.OperPassword = "hunter2"
+		conf.OperSnomask = "+cF"
+		conf.OperDiscordChannel = "2100"
+		conf.OperPuppetHost = "{id}.discord.example.com"
+	})
+	defer tb.Close()
+
+	assert.True(t, tb.ircd.HasReceived("listener", "OPER bridge hunter2"))
+
+	// Server notices are ignored until the listener is an oper
+	tb.ircd.SendTo("listener", ":fake.ircd NOTICE listener :*** Notice -- too early")
+	tb.ircd.SendTo("listener", ":fake.ircd 381 listener :You are now an IRC operator")
+	waitFor(t, "snomask", func() bool {
+		return tb.ircd.HasReceived("listener", "MODE listener +s +cF")
+	})
+
+	tb.ircd.SendTo("listener", ":fake.ircd NOTICE listener :*** Notice -- Client connecting: alice")
+	waitFor(t, "server notice on discord", func() bool {
+		_, ok := tb.discord.Find("fake.ircd: *** Notice -- Client connecting: alice")
+		return ok
+	})
+	_, ok := tb.discord.Find("fake.ircd: *** Notice -- too early")
+	assert.False(t, ok)
+
+	bob := tb.discordMember("100", "bob", "")
+	nick := tb.puppet(t, bob, "bob")
+	waitFor(t, "puppet cloak", func() bool {
+		return tb.ircd.HasReceived("listener", "CHGHOST "+nick+" 100.discord.example.com")
+	})
+
+	tb.ircd.SendTo(nick, ":fake.ircd 480 %s #throttled :Cannot join channel, throttled", nick)
+	waitFor(t, "sajoin", func() bool {
+		return tb.ircd.HasReceived("listener", "SAJOIN "+nick+" #throttled")
+	})
+}
@@ -0,0 +1,71 @@
+package bridge
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+func validateChannelRegistration(opts *Config) error {
+	if !opts.RegisterIRCChannels {
+		return nil
+	}
+	// ChanServ registers channels to the account the listener is identified to
+	if opts.NickServIdentify == "" {
+		return errors.New("register_irc_channels needs the listener to identify with nickserv_identify")
+	}
+	if opts.RegisterIRCModes != "" && !strings.HasPrefix(opts.RegisterIRCModes, "+") && !strings.HasPrefix(opts.RegisterIRCModes, "-") {
+		return errors.Errorf("register_irc_modes %q must start with + or -, like +nt", opts.RegisterIRCModes)
+	}
+	return nil
+}
+
+// registeredChannels are the channels the listener has registered since it started,
+// so rejoining them doesn't register them again.
+type registeredChannels struct {
+	mu       sync.Mutex
+	channels map[string]bool
+}
+
+// registerIfNew registers a bridged IRC channel with ChanServ if the listener has just created it,
+// by being the first to join, and sets it up: its modes from RegisterIRCModes, and its topic
+// from the Discord channel's.
+func (i *ircListener) registerIfNew(channel string) {
+	if !i.bridge.Config.RegisterIRCChannels || i.bridge.Config.Shadow {
+		return
+	}
+	mapping := i.bridge.GetMappingByIRC(channel)
+	if mapping == nil {
+		return
+	}
+
+	// Whoever creates a channel is its only member, and an operator
+	prefixes, _ := i.users.Prefixes(channel, i.GetNick())
+	if i.users.Count(channel) != 1 || !i.users.HasPrefixAtLeast(prefixes, "@") {
+		return
+	}
+
+	i.registered.mu.Lock()
+	if i.registered.channels == nil {
+		i.registered.channels = make(map[string]bool)
+	}
+	already := i.registered.channels[strings.ToLower(channel)]
+	i.registered.channels[strings.ToLower(channel)] = true
+	i.registered.mu.Unlock()
+	if already {
+		return
+	}
+
+	log.WithField("channel", channel).Infoln("Registering new IRC channel with ChanServ.")
+	i.Privmsgf("ChanServ", "REGISTER %s", channel)
+
+	if modes := i.bridge.Config.RegisterIRCModes; modes != "" {
+		i.SendRawf("MODE %s %s", channel, modes)
+	}
+
+	if discordChannel, err := i.bridge.discord.State.Channel(mapping.DiscordChannel); err == nil && discordChannel.Topic != "" {
+		i.SetTopic(channel, discordChannel.Topic)
+	}
+}
@@ -0,0 +1,60 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateChannelRegistration(t *testing.T) {
+	assert.NoError(t, validateChannelRegistration(&Config{}))
+	assert.Error(t, validateChannelRegistration(&Config{RegisterIRCChannels: true}))
+	assert.NoError(t, validateChannelRegistration(&Config{RegisterIRCChannels: true, NickServIdentify: "secret", RegisterIRCModes: "+nt"}))
+	assert.Error(t, validateChannelRegistration(&Config{RegisterIRCChannels: true, NickServIdentify: "secret", RegisterIRCModes: "nt"}))
+}
+
+func TestRegisterNewChannel(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.ChannelMappings["#new"] = "2002"
+		conf.RegisterIRCChannels = true
+		conf.RegisterIRCModes = "+nt"
+		conf.NickServIdentify = "secret"
+	})
+	defer tb.Close()
+	tb.Bridge.discord.State.ChannelAdd(&discordgo.Channel{ID: "2002", GuildID: testGuildID, Name: "new", Topic: "Brand new\nchannel"})
+
+	// Channels that already have people in them aren't new
+	tb.ircd.SendTo("listener", ":fake.ircd 353 listener = "+testChannel+" :@listener alice")
+	tb.ircd.SendTo("listener", ":fake.ircd 366 listener "+testChannel+" :End of /NAMES list.")
+
+	// The listener created #new
+	tb.ircd.SendTo("listener", ":fake.ircd 353 listener = #new :@listener")
+	tb.ircd.SendTo("listener", ":fake.ircd 366 listener #new :End of /NAMES list.")
+	waitFor(t, "channel set up", func() bool {
+		return tb.ircd.HasReceived("listener", "TOPIC #new :Brand new channel")
+	})
+	assert.True(t, tb.ircd.HasReceived("listener", "PRIVMSG ChanServ :REGISTER #new"))
+	assert.True(t, tb.ircd.HasReceived("listener", "MODE #new +nt"))
+	assert.False(t, tb.ircd.HasReceived("listener", "PRIVMSG ChanServ :REGISTER "+testChannel))
+
+	// Rejoining doesn't register it again. Each join asks for the channel's modes,
+	// so once the listener has asked for #test's, it has handled #new's too.
+	count := func(want string) int {
+		n := 0
+		for _, line := range tb.ircd.Received("listener") {
+			if line == want {
+				n++
+			}
+		}
+		return n
+	}
+	modes := count("MODE " + testChannel)
+	tb.ircd.SendTo("listener", ":fake.ircd 353 listener = #new :@listener")
+	tb.ircd.SendTo("listener", ":fake.ircd 366 listener #new :End of /NAMES list.")
+	tb.ircd.SendTo("listener", ":fake.ircd 366 listener "+testChannel+" :End of /NAMES list.")
+	waitFor(t, "names handled", func() bool {
+		return count("MODE "+testChannel) > modes
+	})
+	assert.Equal(t, 1, count("PRIVMSG ChanServ :REGISTER #new"))
+}
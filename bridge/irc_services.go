@@ -0,0 +1,86 @@
+package bridge
+
+import (
+	"regexp"
+	"strings"
+
+	ircf "github.com/qaisjp/go-discord-irc/irc/format"
+	irc "github.com/qaisjp/go-ircevent"
+	log "github.com/sirupsen/logrus"
+)
+
+// Services packages supported by Config.Services
+const (
+	servicesNone   = ""
+	servicesAtheme = "atheme"
+	servicesAnope  = "anope"
+)
+
+// servicesNickTaken matches NickServ notices meaning a puppet's nick
+// is registered to someone else, and can't be grouped under the bridge account.
+var servicesNickTaken = regexp.MustCompile(`(?i)(is already registered|is registered to|belongs to another|failed to identify|being changed to|you must first drop)`)
+
+// registerWithServices groups the puppet's nick under the bridge's services account,
+// and requests a vhost for it.
+func (i *ircConnection) registerWithServices() {
+	conf := i.manager.bridge.Config
+
+	switch conf.Services {
+	case servicesAtheme:
+		i.innerCon.Privmsgf("NickServ", "IDENTIFY %s %s", conf.ServicesAccount, conf.ServicesPassword)
+		i.innerCon.Privmsg("NickServ", "GROUP")
+	case servicesAnope:
+		// Grouping also identifies us to the account
+		i.innerCon.Privmsgf("NickServ", "GROUP %s %s", conf.ServicesAccount, conf.ServicesPassword)
+	default:
+		return
+	}
+
+	if conf.ServicesVhost != "" {
+		vhost := strings.NewReplacer(
+			"{id}", i.Discord().ID,
+			"{username}", sanitiseNickname(i.Discord().Username),
+		).Replace(conf.ServicesVhost)
+
+		i.innerCon.Privmsg("HostServ", "REQUEST "+vhost)
+		i.innerCon.Privmsg("HostServ", "ON")
+	}
+}
+
+// OnServicesNotice handles NickServ enforcing a nick that belongs to someone else,
+// by switching the puppet to its fallback nick before services rename it.
+func (i *ircConnection) OnServicesNotice(e *irc.Event) {
+	if i.manager.bridge.Config.Services == servicesNone || !strings.EqualFold(e.Nick, "NickServ") {
+		return
+	}
+
+	if !servicesNickTaken.MatchString(ircf.StripCodes(e.Message())) {
+		return
+	}
+
+	i.servicesMu.Lock()
+	alreadyTaken := i.nickTaken
+	i.nickTaken = true
+	i.servicesMu.Unlock()
+
+	if alreadyTaken {
+		return
+	}
+
+	fallback := i.manager.fallbackNickname(i.Discord())
+	log.WithFields(log.Fields{
+		"nick":     i.ServerNick(),
+		"fallback": fallback,
+		"notice":   e.Message(),
+	}).Warnln("Puppet's nick is registered to someone else, using the fallback nick.")
+
+	i.innerCon.Nick(fallback)
+	i.registerWithServices()
+}
+
+// NickTaken returns true if services said the puppet's preferred nick belongs to someone else.
+func (i *ircConnection) NickTaken() bool {
+	i.servicesMu.Lock()
+	defer i.servicesMu.Unlock()
+	return i.nickTaken
+}
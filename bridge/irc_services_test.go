@@ -0,0 +1,67 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServicesAtheme(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.Services = servicesAtheme
+		conf.ServicesAccount = "bridge"
+		conf.ServicesPassword = "hunter2"
+		conf.ServicesVhost = "discord/{id}"
+	})
+	defer tb.Close()
+
+	bob := tb.discordMember("100", "bob", "")
+	nick := tb.puppet(t, bob, "bob")
+
+	waitFor(t, "services registration", func() bool {
+		return tb.ircd.HasReceived(nick, "PRIVMSG NickServ :IDENTIFY bridge hunter2") &&
+			tb.ircd.HasReceived(nick, "PRIVMSG NickServ :GROUP") &&
+			tb.ircd.HasReceived(nick, "PRIVMSG HostServ :REQUEST discord/100")
+	})
+
+	// Unrelated notices are ignored
+	tb.ircd.SendTo(nick, ":NickServ!services@services NOTICE %s :You are now identified for \x02bridge\x02.", nick)
+
+	tb.ircd.SendTo(nick, ":NickServ!services@services NOTICE %s :Nick \x02%s\x02 is already registered to \x02bobby\x02.", nick, nick)
+	waitFor(t, "fallback nick", func() bool {
+		return tb.ircd.HasReceived(nick, "NICK bob_0001_d")
+	})
+
+	con := tb.ircManager.ircConnections["100"]
+	assert.True(t, con.NickTaken())
+}
+
+func TestServicesAnope(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.Services = servicesAnope
+		conf.ServicesAccount = "bridge"
+		conf.ServicesPassword = "hunter2"
+	})
+	defer tb.Close()
+
+	bob := tb.discordMember("100", "bob", "")
+	nick := tb.puppet(t, bob, "bob")
+
+	waitFor(t, "services registration", func() bool {
+		return tb.ircd.HasReceived(nick, "PRIVMSG NickServ :GROUP bridge hunter2")
+	})
+	for _, line := range tb.ircd.Received(nick) {
+		assert.NotContains(t, line, "HostServ")
+	}
+}
+
+func TestServicesConfig(t *testing.T) {
+	_, err := New(&Config{
+		IRCServer:     "irc.example.com",
+		WebhookPrefix: "test",
+		Services:      servicesAtheme,
+	})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "services account and password are required")
+	}
+}
@@ -0,0 +1,606 @@
+package bridge
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	irc "github.com/qaisjp/go-ircevent"
+	log "github.com/sirupsen/logrus"
+)
+
+// ircUser is what the listener knows about a user on IRC
+type ircUser struct {
+	Nick string
+	User string
+	Host string
+
+	// Account is the services account the user is logged in to.
+	// This is only known if the server supports extended-join or account-notify.
+	Account string
+
+	// Away is only known if the server supports away-notify
+	Away bool
+}
+
+// ircUserTracker keeps track of the users in the channels joined by a connection.
+//
+// It replaces the go-ircevent nick tracker, which does not understand the
+// multi-prefix, userhost-in-names, extended-join, account-notify, away-notify
+// and chghost capabilities.
+//
+// It is safe for concurrent use.
+type ircUserTracker struct {
+	mu sync.RWMutex
+
+	// users is keyed by the lowercase nick
+	users map[string]*ircUser
+
+	// channels maps a lowercase channel name to the lowercase nicks in that channel,
+	// and their channel prefixes (e.g. "@+")
+	channels map[string]map[string]string
+
+	// modes maps a lowercase channel name to the channel's flags (e.g. "mnt")
+	modes map[string]string
+
+	// serverModes is how channel modes work on the server
+	serverModes ircModes
+
+	// onModes, if set, is called after the flags of a channel may have changed
+	onModes func(channel string)
+
+	// onAccount, if set, is called whenever the services account of a user becomes known
+	onAccount func(nick, account string)
+
+	// onNick, if set, is called after a user changes nick, with the channels they are in
+	onNick func(oldNick, newNick string, channels []string)
+}
+
+func newIRCUserTracker() *ircUserTracker {
+	return &ircUserTracker{
+		users:    make(map[string]*ircUser),
+		channels: make(map[string]map[string]string),
+		modes:    make(map[string]string),
+
+		serverModes: defaultModes,
+	}
+}
+
+// SetPrefix sets the channel prefixes from ISUPPORT PREFIX, e.g. "(ov)@+".
+func (t *ircUserTracker) SetPrefix(value string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.serverModes.parsePrefix(value) {
+		log.WithField("prefix", value).Warnln("Could not understand the IRC server's channel prefixes.")
+	}
+}
+
+// SetChanModes sets which channel modes take arguments from ISUPPORT CHANMODES, e.g. "beI,k,l,imnpst".
+func (t *ircUserTracker) SetChanModes(value string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.serverModes.parseChanModes(value) {
+		log.WithField("chanmodes", value).Warnln("Could not understand the IRC server's channel modes.")
+	}
+}
+
+// ServerModes returns how channel modes work on the server.
+func (t *ircUserTracker) ServerModes() ircModes {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.serverModes
+}
+
+// HasPrefixAtLeast returns true if any of the prefixes is at least as high as min, e.g. "@".
+func (t *ircUserTracker) HasPrefixAtLeast(prefixes string, min string) bool {
+	return t.ServerModes().hasPrefixAtLeast(prefixes, min)
+}
+
+// ircModes is how a server's channel modes work, going by ISUPPORT PREFIX and CHANMODES.
+type ircModes struct {
+	// prefixModes give privileges in a channel, shown by the prefix at the same index.
+	// Both are ordered from highest to lowest rank.
+	prefixModes string
+	prefixes    string
+
+	// listModes (e.g. bans) and argModes (e.g. the key) always take an argument,
+	// and setArgModes (e.g. the limit) only take one when set
+	listModes   string
+	argModes    string
+	setArgModes string
+}
+
+// defaultModes are used until the server says otherwise
+var defaultModes = ircModes{
+	prefixModes: "qaohv",
+	prefixes:    "~&@%+",
+	listModes:   "beI",
+	argModes:    "k",
+	setArgModes: "lfjL",
+}
+
+// parsePrefix reads an ISUPPORT PREFIX value, e.g. "(ov)@+", into m.
+func (m *ircModes) parsePrefix(value string) bool {
+	if value == "" {
+		m.prefixModes, m.prefixes = "", ""
+		return true
+	}
+
+	end := strings.IndexByte(value, ')')
+	if !strings.HasPrefix(value, "(") || end == -1 || end-1 != len(value)-end-1 {
+		return false
+	}
+	m.prefixModes, m.prefixes = value[1:end], value[end+1:]
+	return true
+}
+
+// parseChanModes reads an ISUPPORT CHANMODES value, e.g. "beI,k,l,imnpst", into m.
+func (m *ircModes) parseChanModes(value string) bool {
+	kinds := strings.Split(value, ",")
+	if len(kinds) < 4 {
+		return false
+	}
+	m.listModes, m.argModes, m.setArgModes = kinds[0], kinds[1], kinds[2]
+	return true
+}
+
+// prefix returns the prefix given by a channel mode, if it gives one.
+func (m ircModes) prefix(mode byte) (byte, bool) {
+	i := strings.IndexByte(m.prefixModes, mode)
+	if i == -1 {
+		return 0, false
+	}
+	return m.prefixes[i], true
+}
+
+// hasPrefixAtLeast returns true if any of the prefixes is at least as high as min.
+// If the server doesn't have min (e.g. no halfops), the next rank up it does have is needed.
+func (m ircModes) hasPrefixAtLeast(prefixes string, min string) bool {
+	rank := strings.Index(m.prefixes, min)
+	for known := strings.Index(defaultModes.prefixes, min) - 1; rank == -1 && known >= 0; known-- {
+		rank = strings.IndexByte(m.prefixes, defaultModes.prefixes[known])
+	}
+	if rank == -1 {
+		return false
+	}
+	return strings.ContainsAny(prefixes, m.prefixes[:rank+1])
+}
+
+// walk calls fn for each change in a mode string like "+ov-l alice bob",
+// with the argument the change takes, if any.
+func (m ircModes) walk(modes string, args []string, fn func(adding bool, mode byte, arg string)) {
+	// nextArg consumes the next mode argument
+	nextArg := func() string {
+		if len(args) == 0 {
+			return ""
+		}
+		arg := args[0]
+		args = args[1:]
+		return arg
+	}
+
+	adding := true
+	for i := 0; i < len(modes); i++ {
+		c := modes[i]
+		switch {
+		case c == '+':
+			adding = true
+			continue
+		case c == '-':
+			adding = false
+			continue
+		}
+
+		arg := ""
+		if strings.IndexByte(m.prefixModes+m.listModes+m.argModes, c) != -1 || (adding && strings.IndexByte(m.setArgModes, c) != -1) {
+			arg = nextArg()
+		}
+		fn(adding, c, arg)
+	}
+}
+
+// parseHostmask splits "nick!user@host" into its parts.
+// The user and host are empty if the mask only contains a nick.
+func parseHostmask(mask string) (nick, user, host string) {
+	nick = mask
+	if i := strings.IndexByte(nick, '@'); i != -1 {
+		host = nick[i+1:]
+		nick = nick[:i]
+	}
+	if i := strings.IndexByte(nick, '!'); i != -1 {
+		user = nick[i+1:]
+		nick = nick[:i]
+	}
+	return
+}
+
+// Setup adds the callbacks required for tracking users to a connection.
+func (t *ircUserTracker) Setup(con *irc.Connection) {
+	// RPL_NAMREPLY: "<me> <symbol> <channel> :[prefixes]<nick>[!user@host] ..."
+	con.AddCallback("353", func(e *irc.Event) {
+		if len(e.Arguments) < 4 {
+			return
+		}
+		t.handleNames(e.Arguments[2], strings.Fields(e.Message()))
+	})
+
+	con.AddCallback("JOIN", func(e *irc.Event) {
+		account := ""
+		// extended-join: "JOIN <channel> <account> :<realname>"
+		if len(e.Arguments) >= 3 && e.Arguments[1] != "*" {
+			account = e.Arguments[1]
+		}
+		t.handleJoin(e.Arguments[0], e.Nick, e.User, e.Host, account, len(e.Arguments) >= 3)
+		t.accountSeen(e.Nick, account)
+	})
+
+	con.AddCallback("PART", func(e *irc.Event) {
+		if e.Nick == con.GetNick() {
+			t.removeChannel(e.Arguments[0])
+			return
+		}
+		t.handlePart(e.Arguments[0], e.Nick)
+	})
+
+	con.AddCallback("KICK", func(e *irc.Event) {
+		if len(e.Arguments) < 2 {
+			return
+		}
+		if e.Arguments[1] == con.GetNick() {
+			t.removeChannel(e.Arguments[0])
+			return
+		}
+		t.handlePart(e.Arguments[0], e.Arguments[1])
+	})
+
+	con.AddCallback("QUIT", func(e *irc.Event) {
+		t.handleQuit(e.Nick)
+	})
+
+	con.AddCallback("NICK", func(e *irc.Event) {
+		if len(e.Arguments) < 1 {
+			return
+		}
+		channels := t.handleNick(e.Nick, e.Arguments[0])
+		if t.onNick != nil && len(channels) > 0 {
+			t.onNick(e.Nick, e.Arguments[0], channels)
+		}
+	})
+
+	con.AddCallback("MODE", func(e *irc.Event) {
+		if len(e.Arguments) < 2 {
+			return
+		}
+		t.handleMode(e.Arguments[0], e.Arguments[1], e.Arguments[2:])
+		t.modesChanged(e.Arguments[0])
+	})
+
+	// RPL_CHANNELMODEIS: "<me> <channel> <modes> [args...]"
+	con.AddCallback("324", func(e *irc.Event) {
+		if len(e.Arguments) < 3 {
+			return
+		}
+		t.handleChannelModes(e.Arguments[1], e.Arguments[2], e.Arguments[3:])
+		t.modesChanged(e.Arguments[1])
+	})
+
+	// account-notify: "ACCOUNT <account>", where "*" means logged out
+	con.AddCallback("ACCOUNT", func(e *irc.Event) {
+		if len(e.Arguments) < 1 {
+			return
+		}
+		account := e.Arguments[0]
+		if account == "*" {
+			account = ""
+		}
+		t.update(e.Nick, func(u *ircUser) {
+			u.Account = account
+		})
+		t.accountSeen(e.Nick, account)
+	})
+
+	// away-notify: "AWAY [:message]", where no message means they are back
+	con.AddCallback("AWAY", func(e *irc.Event) {
+		away := len(e.Arguments) > 0 && e.Arguments[0] != ""
+		t.update(e.Nick, func(u *ircUser) {
+			u.Away = away
+		})
+	})
+
+	// chghost: "CHGHOST <user> <host>"
+	con.AddCallback("CHGHOST", func(e *irc.Event) {
+		if len(e.Arguments) < 2 {
+			return
+		}
+		t.update(e.Nick, func(u *ircUser) {
+			u.User = e.Arguments[0]
+			u.Host = e.Arguments[1]
+		})
+	})
+
+	// RPL_WHOREPLY: "<me> <channel> <user> <host> <server> <nick> <flags> :<hopcount> <realname>"
+	con.AddCallback("352", func(e *irc.Event) {
+		if len(e.Arguments) < 7 {
+			return
+		}
+		t.update(e.Arguments[5], func(u *ircUser) {
+			u.User = e.Arguments[2]
+			u.Host = e.Arguments[3]
+			u.Away = strings.HasPrefix(e.Arguments[6], "G")
+		})
+	})
+}
+
+// accountSeen calls the onAccount hook if the account is known.
+func (t *ircUserTracker) accountSeen(nick, account string) {
+	if account != "" && t.onAccount != nil {
+		t.onAccount(nick, account)
+	}
+}
+
+// modesChanged calls the onModes hook.
+func (t *ircUserTracker) modesChanged(channel string) {
+	if t.onModes != nil {
+		t.onModes(channel)
+	}
+}
+
+// getOrCreate returns the user with the given nick, creating it if it doesn't exist.
+// The caller must hold the write lock.
+func (t *ircUserTracker) getOrCreate(nick string) *ircUser {
+	key := strings.ToLower(nick)
+	u, ok := t.users[key]
+	if !ok {
+		u = &ircUser{Nick: nick}
+		t.users[key] = u
+	}
+	return u
+}
+
+// update calls fn with a known user. Unknown users are ignored.
+func (t *ircUserTracker) update(nick string, fn func(u *ircUser)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if u, ok := t.users[strings.ToLower(nick)]; ok {
+		fn(u)
+	}
+}
+
+func (t *ircUserTracker) handleNames(channel string, entries []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	members := t.channelMembers(channel)
+	for _, entry := range entries {
+		// multi-prefix means there may be more than one prefix
+		prefixes := ""
+		for len(entry) > 0 && strings.IndexByte(t.serverModes.prefixes, entry[0]) != -1 {
+			prefixes += entry[:1]
+			entry = entry[1:]
+		}
+
+		nick, user, host := parseHostmask(entry)
+		if nick == "" {
+			continue
+		}
+
+		u := t.getOrCreate(nick)
+		if user != "" {
+			u.User = user
+			u.Host = host
+		}
+		members[strings.ToLower(nick)] = prefixes
+	}
+}
+
+// channelMembers returns the members of a channel, creating the channel if necessary.
+// The caller must hold the write lock.
+func (t *ircUserTracker) channelMembers(channel string) map[string]string {
+	key := strings.ToLower(channel)
+	members, ok := t.channels[key]
+	if !ok {
+		members = make(map[string]string)
+		t.channels[key] = members
+	}
+	return members
+}
+
+func (t *ircUserTracker) handleJoin(channel, nick, user, host, account string, extended bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	u := t.getOrCreate(nick)
+	u.User = user
+	u.Host = host
+	if extended {
+		u.Account = account
+	}
+
+	t.channelMembers(channel)[strings.ToLower(nick)] = ""
+}
+
+func (t *ircUserTracker) handlePart(channel, nick string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := strings.ToLower(nick)
+	delete(t.channels[strings.ToLower(channel)], key)
+	t.forgetIfAlone(key)
+}
+
+// forgetIfAlone removes a user if they are not in any tracked channel.
+// The caller must hold the write lock.
+func (t *ircUserTracker) forgetIfAlone(key string) {
+	for _, members := range t.channels {
+		if _, ok := members[key]; ok {
+			return
+		}
+	}
+	delete(t.users, key)
+}
+
+func (t *ircUserTracker) removeChannel(channel string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	members := t.channels[strings.ToLower(channel)]
+	delete(t.channels, strings.ToLower(channel))
+	delete(t.modes, strings.ToLower(channel))
+	for key := range members {
+		t.forgetIfAlone(key)
+	}
+}
+
+func (t *ircUserTracker) handleQuit(nick string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := strings.ToLower(nick)
+	for _, members := range t.channels {
+		delete(members, key)
+	}
+	delete(t.users, key)
+}
+
+func (t *ircUserTracker) handleNick(oldNick, newNick string) (channels []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	oldKey := strings.ToLower(oldNick)
+	newKey := strings.ToLower(newNick)
+
+	u, ok := t.users[oldKey]
+	if !ok {
+		return nil
+	}
+	u.Nick = newNick
+	delete(t.users, oldKey)
+	t.users[newKey] = u
+
+	for channel, members := range t.channels {
+		if prefixes, ok := members[oldKey]; ok {
+			delete(members, oldKey)
+			members[newKey] = prefixes
+			channels = append(channels, channel)
+		}
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+// handleMode applies mode changes like "+ov-l alice bob" to a channel and its members.
+//
+// Prefix modes (+o, +v) update the channel members, and
+// other modes are recorded as channel flags (e.g. +m, +i).
+func (t *ircUserTracker) handleMode(channel, modes string, args []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := strings.ToLower(channel)
+	members, ok := t.channels[key]
+	if !ok {
+		return
+	}
+	flags := t.modes[key]
+
+	t.serverModes.walk(modes, args, func(adding bool, c byte, arg string) {
+		if prefix, ok := t.serverModes.prefix(c); ok {
+			nick := strings.ToLower(arg)
+			prefixes, ok := members[nick]
+			if !ok {
+				return
+			}
+
+			prefixes = strings.Replace(prefixes, string(prefix), "", -1)
+			if adding {
+				prefixes += string(prefix)
+			}
+			members[nick] = prefixes
+			return
+		}
+
+		// List modes aren't flags
+		if strings.IndexByte(t.serverModes.listModes, c) != -1 {
+			return
+		}
+
+		flags = strings.Replace(flags, string(c), "", -1)
+		if adding {
+			flags += string(c)
+		}
+	})
+
+	t.modes[key] = flags
+}
+
+// handleChannelModes replaces the flags of a channel, as given by RPL_CHANNELMODEIS.
+func (t *ircUserTracker) handleChannelModes(channel, modes string, args []string) {
+	t.mu.Lock()
+	if _, ok := t.channels[strings.ToLower(channel)]; !ok {
+		t.mu.Unlock()
+		return
+	}
+	t.modes[strings.ToLower(channel)] = ""
+	t.mu.Unlock()
+
+	t.handleMode(channel, modes, args)
+}
+
+// HasMode returns true if the channel has the given flag set (e.g. 'm' for moderated).
+func (t *ircUserTracker) HasMode(channel string, mode byte) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return strings.IndexByte(t.modes[strings.ToLower(channel)], mode) != -1
+}
+
+// Exists returns true if the nick is in any of the tracked channels.
+func (t *ircUserTracker) Exists(nick string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	_, ok := t.users[strings.ToLower(nick)]
+	return ok
+}
+
+// Get returns a copy of what is known about a user.
+func (t *ircUserTracker) Get(nick string) (ircUser, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	u, ok := t.users[strings.ToLower(nick)]
+	if !ok {
+		return ircUser{}, false
+	}
+	return *u, true
+}
+
+// ByAccount returns a copy of a user logged in to the given services account.
+func (t *ircUserTracker) ByAccount(account string) (ircUser, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, u := range t.users {
+		if u.Account != "" && strings.EqualFold(u.Account, account) {
+			return *u, true
+		}
+	}
+	return ircUser{}, false
+}
+
+// Count returns how many people are known to be in a channel.
+func (t *ircUserTracker) Count(channel string) int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.channels[strings.ToLower(channel)])
+}
+
+// Prefixes returns the channel prefixes (e.g. "@+") the nick has in the given channel.
+func (t *ircUserTracker) Prefixes(channel, nick string) (string, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	prefixes, ok := t.channels[strings.ToLower(channel)][strings.ToLower(nick)]
+	return prefixes, ok
+}
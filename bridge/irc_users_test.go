@@ -0,0 +1,121 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHostmask(t *testing.T) {
+	nick, user, host := parseHostmask("alice!~al@example.com")
+	assert.Equal(t, []string{"alice", "~al", "example.com"}, []string{nick, user, host})
+
+	nick, user, host = parseHostmask("bob")
+	assert.Equal(t, []string{"bob", "", ""}, []string{nick, user, host})
+}
+
+func TestUserTrackerNames(t *testing.T) {
+	tr := newIRCUserTracker()
+	tr.handleNames("#Chan", []string{"@+alice!a@host.a", "bob", "%carol!c@host.c"})
+
+	assert.True(t, tr.Exists("ALICE"))
+	assert.True(t, tr.Exists("bob"))
+	assert.False(t, tr.Exists("alice!a@host.a"))
+
+	prefixes, ok := tr.Prefixes("#chan", "alice")
+	assert.True(t, ok)
+	assert.Equal(t, "@+", prefixes)
+
+	u, _ := tr.Get("carol")
+	assert.Equal(t, "host.c", u.Host)
+}
+
+func TestUserTrackerMode(t *testing.T) {
+	tr := newIRCUserTracker()
+	tr.handleNames("#chan", []string{"alice", "@bob"})
+
+	tr.handleMode("#chan", "+o-o", []string{"alice", "bob"})
+	prefixes, _ := tr.Prefixes("#chan", "alice")
+	assert.Equal(t, "@", prefixes)
+	prefixes, _ = tr.Prefixes("#chan", "bob")
+	assert.Equal(t, "", prefixes)
+
+	tr.handleMode("#chan", "+nv", []string{"bob"})
+	prefixes, _ = tr.Prefixes("#chan", "bob")
+	assert.Equal(t, "+", prefixes)
+}
+
+func TestUserTrackerNickAndQuit(t *testing.T) {
+	tr := newIRCUserTracker()
+	tr.handleJoin("#chan", "alice", "a", "host.a", "alice_acct", true)
+	tr.handleJoin("#other", "alice", "a", "host.a", "alice_acct", true)
+
+	assert.Equal(t, []string{"#chan", "#other"}, tr.handleNick("alice", "alice2"))
+	assert.Nil(t, tr.handleNick("nobody", "somebody"))
+	assert.False(t, tr.Exists("alice"))
+	u, ok := tr.Get("alice2")
+	assert.True(t, ok)
+	assert.Equal(t, "alice_acct", u.Account)
+
+	tr.handlePart("#chan", "alice2")
+	assert.True(t, tr.Exists("alice2"))
+
+	tr.handleQuit("alice2")
+	assert.False(t, tr.Exists("alice2"))
+}
+
+func TestUserTrackerChannelModes(t *testing.T) {
+	tr := newIRCUserTracker()
+	tr.handleNames("#chan", []string{"alice"})
+
+	tr.handleChannelModes("#chan", "+ntk", []string{"secret"})
+	assert.True(t, tr.HasMode("#chan", 'k'))
+	assert.False(t, tr.HasMode("#chan", 'm'))
+
+	tr.handleMode("#chan", "+bmv-k", []string{"*!*@spam", "alice", "secret"})
+	assert.True(t, tr.HasMode("#CHAN", 'm'))
+	assert.False(t, tr.HasMode("#chan", 'k'))
+	prefixes, _ := tr.Prefixes("#chan", "alice")
+	assert.Equal(t, "+", prefixes)
+
+	tr.handleMode("#chan", "+l-m", []string{"10"})
+	assert.True(t, tr.HasMode("#chan", 'l'))
+	assert.False(t, tr.HasMode("#chan", 'm'))
+}
+
+func TestHasPrefixAtLeast(t *testing.T) {
+	m := defaultModes
+	assert.True(t, m.hasPrefixAtLeast("@", "+"))
+	assert.True(t, m.hasPrefixAtLeast("+", "+"))
+	assert.True(t, m.hasPrefixAtLeast("%+", "%"))
+	assert.False(t, m.hasPrefixAtLeast("+", "@"))
+	assert.False(t, m.hasPrefixAtLeast("", "+"))
+	assert.False(t, m.hasPrefixAtLeast("@", "x"))
+
+	// Without halfops, moderators need to be ops
+	assert.True(t, m.parsePrefix("(ov)@+"))
+	assert.True(t, m.hasPrefixAtLeast("@", "%"))
+	assert.False(t, m.hasPrefixAtLeast("+", "%"))
+}
+
+func TestServerModes(t *testing.T) {
+	tr := newIRCUserTracker()
+	tr.SetPrefix("(Yov)!@+")
+	tr.SetChanModes("beIq,k,l,imnpst")
+	tr.handleNames("#chan", []string{"!alice", "@bob", "carol"})
+
+	// +q is a quiet here, which takes a mask, not a prefix
+	tr.handleMode("#chan", "+qYm", []string{"*!*@spam", "carol"})
+	prefixes, _ := tr.Prefixes("#chan", "carol")
+	assert.Equal(t, "!", prefixes)
+	assert.True(t, tr.HasMode("#chan", 'm'))
+	assert.False(t, tr.HasMode("#chan", 'q'))
+	assert.True(t, tr.HasPrefixAtLeast(prefixes, "@"))
+
+	prefixes, _ = tr.Prefixes("#chan", "alice")
+	assert.Equal(t, "!", prefixes)
+
+	// Bad values are ignored
+	tr.SetPrefix("(ov)@")
+	assert.Equal(t, "Yov", tr.ServerModes().prefixModes)
+}
@@ -0,0 +1,236 @@
+package bridge
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	irc "github.com/qaisjp/go-ircevent"
+	log "github.com/sirupsen/logrus"
+)
+
+// karmaBucket is the store bucket containing karma, keyed by karma key
+const karmaBucket = "karma"
+
+// ircKarma matches "nick++" on IRC
+var ircKarma = regexp.MustCompile(`(?:^|\s)([^\s+:,]+)[:,]?\+\+(?:\s|$)`)
+
+// discordKarma matches "@user++" on Discord
+var discordKarma = regexp.MustCompile(`<@!?(\d+)>\s*\+\+`)
+
+// karmaScore is the karma of one person, counted on both sides of the bridge.
+type karmaScore struct {
+	Name   string // last name they were given karma as
+	Points int
+}
+
+// karmaCommand is the slash command used to look up karma on Discord.
+var karmaCommand = &discordgo.ApplicationCommand{
+	Name:        "karma",
+	Description: "Look up someone's karma, counted on Discord and IRC",
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionUser,
+			Name:        "user",
+			Description: "A Discord user",
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "nick",
+			Description: "An IRC nick",
+		},
+	},
+}
+
+// karmaKeyDiscord returns the karma key of a Discord user.
+func karmaKeyDiscord(discordID string) string {
+	return "discord:" + discordID
+}
+
+// karmaKeyIRC returns the karma key of an IRC user. Users linked to Discord, and puppets,
+// share karma with their Discord user.
+func (b *Bridge) karmaKeyIRC(nick, account string) string {
+	if link := b.linkByIRC(nick, account); link != nil {
+		return karmaKeyDiscord(link.DiscordID)
+	}
+
+	if con := b.ircManager.connectionByNick(nick); con != nil {
+		return karmaKeyDiscord(con.Discord().ID)
+	}
+
+	return notifyKey(nick, account)
+}
+
+// karmaKeyNick returns the karma key of someone on IRC, by their nick alone.
+func (b *Bridge) karmaKeyNick(nick string) string {
+	account := ""
+	if user, ok := b.ircListener.users.Get(nick); ok {
+		account = user.Account
+	}
+	return b.karmaKeyIRC(nick, account)
+}
+
+// addKarma changes the karma of someone, returning their new total.
+func (b *Bridge) addKarma(key, name string, points int) int {
+	b.karmaMu.Lock()
+	defer b.karmaMu.Unlock()
+
+	score := &karmaScore{}
+	if _, err := b.store.Get(karmaBucket, key, score); err != nil {
+		log.WithField("error", err).Errorln("could not read karma")
+		return 0
+	}
+
+	score.Name = name
+	score.Points += points
+	if err := b.store.Put(karmaBucket, key, score); err != nil {
+		log.WithField("error", err).Errorln("could not save karma")
+	}
+	return score.Points
+}
+
+// karma returns the karma of someone.
+func (b *Bridge) karma(key string) int {
+	b.karmaMu.Lock()
+	defer b.karmaMu.Unlock()
+
+	score := &karmaScore{}
+	if _, err := b.store.Get(karmaBucket, key, score); err != nil {
+		log.WithField("error", err).Errorln("could not read karma")
+	}
+	return score.Points
+}
+
+// countKarma gives karma for each "nick++" in a message from IRC.
+func (i *ircListener) countKarma(e *irc.Event) {
+	giver := i.bridge.karmaKeyIRC(e.Nick, i.account(e))
+
+	for _, match := range ircKarma.FindAllStringSubmatch(e.Message(), -1) {
+		nick := match[1]
+		user, ok := i.users.Get(nick)
+		if !ok {
+			continue
+		}
+
+		key := i.bridge.karmaKeyIRC(nick, user.Account)
+		if key == giver {
+			continue
+		}
+		i.bridge.addKarma(key, nick, 1)
+	}
+}
+
+// handleKarma replies to "!karma [nick]" with the karma of the nick, or the sender.
+func (i *ircListener) handleKarma(e *irc.Event, args []string) {
+	nick := e.Nick
+	if len(args) > 0 {
+		nick = strings.TrimRight(args[0], ":,")
+	}
+
+	i.Noticef(e.Arguments[0], "%s has %d karma.", nick, i.bridge.karma(i.bridge.karmaKeyNick(nick)))
+}
+
+// handleKarmaMessage replies to "!karma [@user or nick]" on Discord.
+func (d *discordBot) handleKarmaMessage(m *discordgo.Message, args []string) {
+	key, name := karmaKeyDiscord(m.Author.ID), d.memberName(m.Author.ID)
+	if len(args) > 0 {
+		if match := discordMention.FindStringSubmatch(args[0]); match != nil {
+			key, name = karmaKeyDiscord(match[1]), d.memberName(match[1])
+		} else {
+			key, name = d.bridge.karmaKeyNick(args[0]), args[0]
+		}
+	}
+
+	d.reply(m, fmt.Sprintf("%s has %d karma.", name, d.bridge.karma(key)))
+}
+
+// countKarma gives karma for each "@user++" in a message from Discord.
+func (d *discordBot) countKarma(m *discordgo.Message) {
+	for _, match := range discordKarma.FindAllStringSubmatch(m.Content, -1) {
+		if match[1] == m.Author.ID {
+			continue
+		}
+		d.bridge.addKarma(karmaKeyDiscord(match[1]), d.memberName(match[1]), 1)
+	}
+}
+
+// onKarmaReactionAdd and onKarmaReactionRemove count karma reactions to the author of a message.
+func (d *discordBot) onKarmaReactionAdd(s *discordgo.Session, r *discordgo.MessageReactionAdd) {
+	d.karmaReaction(r.MessageReaction, 1)
+}
+
+func (d *discordBot) onKarmaReactionRemove(s *discordgo.Session, r *discordgo.MessageReactionRemove) {
+	d.karmaReaction(r.MessageReaction, -1)
+}
+
+func (d *discordBot) karmaReaction(r *discordgo.MessageReaction, points int) {
+	if !d.bridge.Config.Karma || r.GuildID != d.guildID || r.Emoji.Name != d.bridge.Config.KarmaEmoji {
+		return
+	}
+
+	key, name := "", ""
+	if msg := d.bridge.messages.ByDiscordID(r.MessageID); msg != nil && msg.IRCNick != "" {
+		// Karma for a message relayed from IRC goes to the IRC user
+		key, name = d.bridge.karmaKeyIRC(msg.IRCNick, ""), msg.IRCNick
+	} else if msg != nil && msg.DiscordAuthorID != "" {
+		key, name = karmaKeyDiscord(msg.DiscordAuthorID), d.memberName(msg.DiscordAuthorID)
+	} else {
+		m, err := d.ChannelMessage(r.ChannelID, r.MessageID)
+		if err != nil {
+			d.bridge.handleError(err, nil, "could not get message for karma")
+			return
+		}
+		if m.WebhookID != "" {
+			return
+		}
+		key, name = karmaKeyDiscord(m.Author.ID), d.memberName(m.Author.ID)
+	}
+
+	if key == karmaKeyDiscord(r.UserID) {
+		return
+	}
+	d.bridge.addKarma(key, name, points)
+}
+
+// handleKarmaCommand replies to /karma with the karma of a Discord user or IRC nick,
+// or the user asking.
+func (d *discordBot) handleKarmaCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	user := interactionUser(i)
+	if user == nil {
+		return
+	}
+	key, name := karmaKeyDiscord(user.ID), d.memberName(user.ID)
+
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case "user":
+			if u := option.UserValue(nil); u != nil {
+				key, name = karmaKeyDiscord(u.ID), d.memberName(u.ID)
+			}
+		case "nick":
+			name = option.StringValue()
+			key = d.bridge.karmaKeyNick(name)
+		}
+	}
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content:         fmt.Sprintf("%s has %d karma.", name, d.bridge.karma(key)),
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		},
+	})
+	if err != nil {
+		d.bridge.handleError(err, nil, "could not respond to /karma")
+	}
+}
+
+func init() {
+	registerChatCommand(&chatCommand{
+		Name:    "karma",
+		Enabled: func(b *Bridge) bool { return b.Config.Karma },
+		IRC:     (*ircListener).handleKarma,
+		Discord: (*discordBot).handleKarmaMessage,
+	})
+}
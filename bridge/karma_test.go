@@ -0,0 +1,80 @@
+package bridge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKarma(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.Karma = true
+		conf.KarmaEmoji = "👍"
+	})
+	defer tb.Close()
+
+	d := tb.Bridge.discord
+	bob := tb.discordMember("100", "bob", "")
+	carol := tb.discordMember("200", "carol", "")
+	nick := tb.puppet(t, bob, "bob")
+
+	// Karma given to a puppet on IRC counts for its Discord user
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :"+nick+"++ thanks")
+	waitFor(t, "karma from irc", func() bool {
+		return tb.Bridge.karma(karmaKeyDiscord("100")) == 1
+	})
+
+	tb.discordSay(carol, "<@100>++", bob)
+	waitFor(t, "karma from discord", func() bool {
+		return tb.Bridge.karma(karmaKeyDiscord("100")) == 2
+	})
+
+	// Nobody can give themselves karma
+	tb.discordSay(bob, "<@100>++", bob)
+
+	msg := &discordgo.Message{
+		ID:        tb.discord.id(),
+		ChannelID: testChannelID,
+		GuildID:   testGuildID,
+		Author:    bob,
+		Content:   "a helpful answer",
+		Timestamp: time.Now(),
+	}
+	d.onMessageCreate(d.Session, &discordgo.MessageCreate{Message: msg})
+	reaction := &discordgo.MessageReaction{
+		UserID:    "200",
+		MessageID: msg.ID,
+		ChannelID: testChannelID,
+		GuildID:   testGuildID,
+		Emoji:     discordgo.Emoji{Name: "👍"},
+	}
+	d.onKarmaReactionAdd(d.Session, &discordgo.MessageReactionAdd{MessageReaction: reaction})
+	assert.Equal(t, 3, tb.Bridge.karma(karmaKeyDiscord("100")))
+	d.onKarmaReactionRemove(d.Session, &discordgo.MessageReactionRemove{MessageReaction: reaction})
+	d.onKarmaReactionAdd(d.Session, &discordgo.MessageReactionAdd{MessageReaction: reaction})
+
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :!karma "+nick)
+	waitFor(t, "!karma reply", func() bool {
+		return tb.ircd.HasReceived("listener", "NOTICE "+testChannel+" :"+nick+" has 3 karma.")
+	})
+
+	d.onInteractionCreate(d.Session, &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		ID:     "8000",
+		Token:  "token",
+		Type:   discordgo.InteractionApplicationCommand,
+		Member: &discordgo.Member{User: carol},
+		Data: discordgo.ApplicationCommandInteractionData{
+			Name: "karma",
+			Options: []*discordgo.ApplicationCommandInteractionDataOption{
+				{Name: "user", Type: discordgo.ApplicationCommandOptionUser, Value: "100"},
+			},
+		},
+	}})
+
+	responses := tb.discord.Responses()
+	if assert.Len(t, responses, 1) {
+		assert.Equal(t, "bob has 3 karma.", responses[0].Data.Content)
+	}
+}
@@ -0,0 +1,216 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	ircf "github.com/qaisjp/go-discord-irc/irc/format"
+	irc "github.com/qaisjp/go-ircevent"
+)
+
+// Language policies, for ChannelOptions.LanguagePolicy
+const (
+	languageWarn     = "warn"     // relay it, and tell the sender which language the channel is for
+	languageTag      = "tag"      // relay it, tagged with its language, like "[es]"
+	languageRedirect = "redirect" // relay it to the mapping for its language instead
+)
+
+// languageMinWords is how many words a message needs before its language is guessed,
+// since short messages ("ok", "lol") are often the same in every language
+var languageMinWords = 4
+
+// A LanguageDetector guesses the language of a message, returning its ISO 639-1 code (like "es"),
+// or "" if it can't tell.
+type LanguageDetector interface {
+	Detect(text string) string
+}
+
+// stopwordDetector is the LanguageDetector used by default. It counts common words of each language,
+// which is enough to tell apart most messages in the languages it knows.
+type stopwordDetector struct{}
+
+var stopwords = map[string][]string{
+	"de": {"und", "ich", "nicht", "ist", "das", "die", "der", "ein", "eine", "mit", "auch", "auf", "wir", "sie", "aber", "wie", "noch", "nur"},
+	"en": {"the", "and", "is", "you", "that", "it", "of", "to", "in", "this", "what", "have", "with", "for", "are", "was", "but", "not"},
+	"es": {"el", "la", "que", "de", "y", "es", "en", "los", "las", "por", "un", "una", "pero", "con", "para", "como", "muy", "está"},
+	"fr": {"le", "la", "les", "et", "est", "je", "que", "pas", "un", "une", "des", "pour", "dans", "avec", "mais", "vous", "nous", "c'est"},
+	"it": {"il", "che", "di", "e", "non", "sono", "per", "una", "gli", "con", "ma", "come", "anche", "questo", "della", "perché", "ho", "sei"},
+	"nl": {"de", "het", "een", "en", "van", "ik", "niet", "dat", "is", "je", "op", "maar", "met", "voor", "ook", "wat", "zijn", "nog"},
+	"pt": {"o", "que", "de", "e", "não", "um", "uma", "com", "para", "os", "as", "mas", "você", "está", "muito", "isso", "por", "como"},
+}
+
+// stopwordIndex maps each stopword to the languages it is common in
+var stopwordIndex = func() map[string][]string {
+	index := make(map[string][]string)
+	for lang, words := range stopwords {
+		for _, word := range words {
+			index[word] = append(index[word], lang)
+		}
+	}
+	return index
+}()
+
+// Detect implements LanguageDetector.
+func (stopwordDetector) Detect(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+	if len(words) < languageMinWords {
+		return ""
+	}
+
+	counts := make(map[string]int)
+	for _, word := range words {
+		for _, lang := range stopwordIndex[word] {
+			counts[lang]++
+		}
+	}
+
+	best, bestCount, tied := "", 0, false
+	for lang, count := range counts {
+		switch {
+		case count > bestCount:
+			best, bestCount, tied = lang, count, false
+		case count == bestCount:
+			tied = true
+		}
+	}
+
+	// One common word isn't enough to go on
+	if bestCount < 2 || tied {
+		return ""
+	}
+	return best
+}
+
+func validateLanguagePolicy(opts ChannelOptions) error {
+	switch opts.LanguagePolicy {
+	case "":
+		return nil
+	case languageWarn, languageTag, languageRedirect:
+	default:
+		return errors.Errorf("unknown language_policy %q, should be %q, %q or %q", opts.LanguagePolicy, languageWarn, languageTag, languageRedirect)
+	}
+	if opts.Language == "" {
+		return errors.New("language_policy needs the channel's language")
+	}
+	return nil
+}
+
+// languageCheck is what to do with a message that isn't in its channel's language.
+type languageCheck struct {
+	Detected string // the language of the message
+	Policy   string // the channel's language policy
+	Language string // the language of the channel
+
+	// Redirect is the mapping for the detected language, if there is one
+	Redirect *Mapping
+}
+
+// checkLanguage returns what to do with a message relayed in the mapping,
+// or nil if it is in the mapping's language, or the mapping doesn't have a language policy.
+func (b *Bridge) checkLanguage(mapping *Mapping, text string) *languageCheck {
+	opts := b.channelOptions(mapping.IRCChannel)
+	if opts.LanguagePolicy == "" {
+		return nil
+	}
+
+	detector := b.Config.LanguageDetector
+	if detector == nil {
+		detector = stopwordDetector{}
+	}
+
+	detected := detector.Detect(text)
+	if detected == "" || strings.EqualFold(detected, opts.Language) {
+		return nil
+	}
+
+	check := &languageCheck{Detected: detected, Policy: opts.LanguagePolicy, Language: opts.Language}
+	for _, other := range b.channelMappings() {
+		if other != mapping && strings.EqualFold(b.channelOptions(other.IRCChannel).Language, detected) {
+			check.Redirect = other
+			break
+		}
+	}
+	return check
+}
+
+// tag prefixes a message with its language.
+func (c *languageCheck) tag(text string) string {
+	return fmt.Sprintf("[%s] %s", c.Detected, text)
+}
+
+// warning is what the sender of the message is told, given the name of the channel
+// for their language on their side of the bridge.
+func (c *languageCheck) warning(redirectName string) string {
+	warning := fmt.Sprintf("This channel is for messages in %s.", c.Language)
+	if c.Redirect != nil {
+		warning += fmt.Sprintf(" Try %s for %s.", redirectName, c.Detected)
+	}
+	return warning
+}
+
+// redirected prefixes a message relayed to the mapping for its language with where it was sent.
+func (c *languageCheck) redirected(from, text string) string {
+	return fmt.Sprintf("[redirected from %s] %s", from, text)
+}
+
+// applyLanguagePolicy applies the language policy of a Discord message's channel, returning the message
+// to relay, which is a copy in the redirect channel if it was redirected, and the content to relay.
+func (d *discordBot) applyLanguagePolicy(m *discordgo.Message, content string) (*discordgo.Message, string) {
+	mapping := d.bridge.GetMappingByDiscord(m.ChannelID)
+	if mapping == nil {
+		return m, content
+	}
+	check := d.bridge.checkLanguage(mapping, m.Content)
+	if check == nil {
+		return m, content
+	}
+
+	switch {
+	case check.Policy == languageWarn:
+		redirectName := ""
+		if check.Redirect != nil {
+			redirectName = "<#" + check.Redirect.DiscordChannel + ">"
+		}
+		d.reply(m, check.warning(redirectName))
+		return m, content
+	case check.Policy == languageRedirect && check.Redirect != nil:
+		redirected := *m
+		redirected.ChannelID = check.Redirect.DiscordChannel
+		return &redirected, check.redirected(mapping.IRCName(), content)
+	default:
+		// Messages without a channel for their language are tagged instead of being redirected
+		return m, check.tag(content)
+	}
+}
+
+// applyLanguagePolicy applies the language policy of an IRC message's channel, returning the IRC channel
+// to relay it from, which is the redirect channel if it was redirected, and the message to relay.
+func (i *ircListener) applyLanguagePolicy(e *irc.Event, channel, msg string) (string, string) {
+	mapping := i.bridge.GetMappingByIRC(channel)
+	if mapping == nil {
+		return channel, msg
+	}
+	check := i.bridge.checkLanguage(mapping, ircf.StripCodes(e.Message()))
+	if check == nil {
+		return channel, msg
+	}
+
+	switch {
+	case check.Policy == languageWarn:
+		redirectName := ""
+		if check.Redirect != nil {
+			redirectName = check.Redirect.IRCName()
+		}
+		i.Notice(e.Nick, check.warning(redirectName))
+		return channel, msg
+	case check.Policy == languageRedirect && check.Redirect != nil:
+		return check.Redirect.IRCName(), check.redirected(mapping.IRCName(), msg)
+	default:
+		return channel, check.tag(msg)
+	}
+}
@@ -0,0 +1,102 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStopwordDetector(t *testing.T) {
+	detector := stopwordDetector{}
+	assert.Equal(t, "en", detector.Detect("what is the plan for this weekend?"))
+	assert.Equal(t, "es", detector.Detect("hola, ¿alguien sabe que pasa con el servidor?"))
+	assert.Equal(t, "fr", detector.Detect("je ne sais pas pourquoi c'est cassé"))
+	assert.Equal(t, "de", detector.Detect("ich weiß nicht, das ist auch komisch"))
+
+	// Too short, or not enough to go on
+	assert.Equal(t, "", detector.Detect("ok lol"))
+	assert.Equal(t, "", detector.Detect("deploying build 4821 now"))
+
+	assert.Error(t, validateLanguagePolicy(ChannelOptions{LanguagePolicy: "translate", Language: "en"}))
+	assert.Error(t, validateLanguagePolicy(ChannelOptions{LanguagePolicy: languageTag}))
+	assert.NoError(t, validateLanguagePolicy(ChannelOptions{LanguagePolicy: languageTag, Language: "en"}))
+}
+
+func TestLanguageTag(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.ChannelOptions = map[string]ChannelOptions{testChannel: {Language: "en", LanguagePolicy: languageTag}}
+	})
+	defer tb.Close()
+
+	bob := tb.discordMember("100", "bob", "")
+	tb.discordSay(bob, "hola, ¿alguien sabe que pasa con el servidor?")
+	tb.discordSay(bob, "what is the plan for this weekend?")
+	waitFor(t, "tagged message on irc", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<b\u200Bob#0001> [es] hola, ¿alguien sabe que pasa con el servidor?")
+	})
+	waitFor(t, "untagged message on irc", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<b\u200Bob#0001> what is the plan for this weekend?")
+	})
+
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :je ne sais pas pourquoi c'est cassé")
+	waitFor(t, "tagged message on discord", func() bool {
+		_, ok := tb.discord.Find("[fr] je ne sais pas pourquoi c'est cassé" + relayMarker)
+		return ok
+	})
+}
+
+func TestLanguageWarn(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.ChannelMappings["#es"] = "2001"
+		conf.ChannelOptions = map[string]ChannelOptions{
+			testChannel: {Language: "en", LanguagePolicy: languageWarn},
+			"#es":       {Language: "es"},
+		}
+	})
+	defer tb.Close()
+
+	bob := tb.discordMember("100", "bob", "")
+	tb.discordSay(bob, "hola, ¿alguien sabe que pasa con el servidor?")
+	waitFor(t, "message on irc", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<b\u200Bob#0001> hola, ¿alguien sabe que pasa con el servidor?")
+	})
+	_, ok := tb.discord.Find("This channel is for messages in en. Try <#2001> for es.")
+	assert.True(t, ok)
+
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :hola, ¿alguien sabe que pasa con el servidor?")
+	waitFor(t, "warning on irc", func() bool {
+		return tb.ircd.HasReceived("listener", "NOTICE alice :This channel is for messages in en. Try #es for es.")
+	})
+}
+
+func TestLanguageRedirect(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.ChannelMappings["#es"] = "2001"
+		conf.ChannelOptions = map[string]ChannelOptions{
+			testChannel: {Language: "en", LanguagePolicy: languageRedirect},
+			"#es":       {Language: "es"},
+		}
+	})
+	defer tb.Close()
+	waitFor(t, "listener to join #es", func() bool {
+		return tb.ircd.InChannel("#es", "listener")
+	})
+
+	bob := tb.discordMember("100", "bob", "")
+	tb.discordSay(bob, "hola, ¿alguien sabe que pasa con el servidor?")
+	waitFor(t, "redirected message on irc", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG #es :<b\u200Bob#0001> [redirected from "+testChannel+"] hola, ¿alguien sabe que pasa con el servidor?")
+	})
+
+	// There is no channel for French, so it is tagged instead
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :je ne sais pas pourquoi c'est cassé")
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :hola, ¿alguien sabe que pasa con el servidor?")
+	waitFor(t, "tagged message on discord", func() bool {
+		_, ok := tb.discord.Find("[fr] je ne sais pas pourquoi c'est cassé" + relayMarker)
+		return ok
+	})
+	waitFor(t, "redirected message on discord", func() bool {
+		msg, ok := tb.discord.Find("[redirected from " + testChannel + "] hola, ¿alguien sabe que pasa con el servidor?" + relayMarker)
+		return ok && msg.ChannelID == "2001"
+	})
+}
@@ -0,0 +1,289 @@
+package bridge
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+// latencyMetrics is published with expvar, at /debug/vars if http_addr is set
+var latencyMetrics = expvar.NewMap("relay_latency")
+
+// latencyBuckets are the upper bounds of the latency histogram buckets
+var latencyBuckets = []time.Duration{
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// latencyRecent is how many of the most recent samples percentiles are calculated from
+var latencyRecent = 500
+
+// probeTimeout is how long a latency probe can take before it is considered lost
+var probeTimeout = time.Minute
+
+// probeMarker matches the content of a latency probe
+var probeMarker = regexp.MustCompile(`\[latency probe ([0-9a-z]+)\]`)
+
+// latencyHistogram counts relay latencies. It is an expvar.Var, and is safe for concurrent use.
+type latencyHistogram struct {
+	mu     sync.Mutex
+	counts []int64 // one per bucket, then one for anything slower
+	sum    time.Duration
+	recent []time.Duration
+	next   int
+}
+
+func newLatencyHistogram() *latencyHistogram {
+	return &latencyHistogram{counts: make([]int64, len(latencyBuckets)+1)}
+}
+
+// Observe records a latency.
+func (h *latencyHistogram) Observe(latency time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	bucket := sort.Search(len(latencyBuckets), func(i int) bool { return latency <= latencyBuckets[i] })
+	h.counts[bucket]++
+	h.sum += latency
+
+	if len(h.recent) < latencyRecent {
+		h.recent = append(h.recent, latency)
+	} else {
+		h.recent[h.next] = latency
+		h.next = (h.next + 1) % latencyRecent
+	}
+}
+
+// Percentile returns the p-th percentile (0-100) of the recent latencies, or false if there are none.
+func (h *latencyHistogram) Percentile(p float64) (time.Duration, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.percentile(p)
+}
+
+func (h *latencyHistogram) percentile(p float64) (time.Duration, bool) {
+	if len(h.recent) == 0 {
+		return 0, false
+	}
+
+	sorted := append([]time.Duration(nil), h.recent...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	i := int(p/100*float64(len(sorted))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i], true
+}
+
+// String implements expvar.Var
+func (h *latencyHistogram) String() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var count int64
+	buckets := make(map[string]int64, len(h.counts))
+	for i, n := range h.counts {
+		count += n
+		le := "+Inf"
+		if i < len(latencyBuckets) {
+			le = strconv.FormatInt(int64(latencyBuckets[i]/time.Millisecond), 10)
+		}
+		// Buckets are cumulative, like Prometheus
+		buckets[le] = count
+	}
+
+	out := map[string]interface{}{
+		"buckets_ms": buckets,
+		"count":      count,
+		"sum_ms":     int64(h.sum / time.Millisecond),
+	}
+	if p50, ok := h.percentile(50); ok {
+		out["p50_ms"] = int64(p50 / time.Millisecond)
+	}
+	if p95, ok := h.percentile(95); ok {
+		out["p95_ms"] = int64(p95 / time.Millisecond)
+	}
+
+	data, _ := json.Marshal(out)
+	return string(data)
+}
+
+// latencyProbe is a marker sent through the bridge to time it.
+type latencyProbe struct {
+	toIRC bool
+	sent  time.Time
+}
+
+// latencyProbes tracks the probes that haven't arrived yet, and times the ones that have.
+type latencyProbes struct {
+	mu      sync.Mutex
+	pending map[string]latencyProbe
+
+	toIRC     *latencyHistogram
+	toDiscord *latencyHistogram
+}
+
+func newLatencyProbes() *latencyProbes {
+	p := &latencyProbes{
+		pending:   make(map[string]latencyProbe),
+		toIRC:     newLatencyHistogram(),
+		toDiscord: newLatencyHistogram(),
+	}
+	latencyMetrics.Set("to_irc", p.toIRC)
+	latencyMetrics.Set("to_discord", p.toDiscord)
+	return p
+}
+
+// Start returns the content of a new probe.
+func (p *latencyProbes) Start(toIRC bool) (token, content string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for token, probe := range p.pending {
+		if now.Sub(probe.sent) > probeTimeout {
+			log.WithFields(log.Fields{"token": token, "to_irc": probe.toIRC}).Warnln("latency probe was lost")
+			delete(p.pending, token)
+		}
+	}
+
+	token = strconv.FormatInt(now.UnixNano(), 36)
+	p.pending[token] = latencyProbe{toIRC: toIRC, sent: now}
+	return token, "[latency probe " + token + "]"
+}
+
+// Token returns the token of a probe being relayed in the given direction, if the content is one.
+func (p *latencyProbes) Token(content string, toIRC bool) string {
+	match := probeMarker.FindStringSubmatch(content)
+	if match == nil {
+		return ""
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if probe, ok := p.pending[match[1]]; ok && probe.toIRC == toIRC {
+		return match[1]
+	}
+	return ""
+}
+
+// Arrived records how long a probe took.
+func (p *latencyProbes) Arrived(token string) {
+	p.mu.Lock()
+	probe, ok := p.pending[token]
+	delete(p.pending, token)
+	p.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	latency := time.Since(probe.sent)
+	if probe.toIRC {
+		p.toIRC.Observe(latency)
+	} else {
+		p.toDiscord.Observe(latency)
+	}
+}
+
+// Summary describes the recent relay latencies, or returns "" if there haven't been any probes.
+func (p *latencyProbes) Summary() string {
+	describe := func(h *latencyHistogram) string {
+		p50, ok := h.Percentile(50)
+		if !ok {
+			return "unknown"
+		}
+		p95, _ := h.Percentile(95)
+		return fmt.Sprintf("p50 %s, p95 %s", formatLag(p50), formatLag(p95))
+	}
+
+	toIRC, toDiscord := describe(p.toIRC), describe(p.toDiscord)
+	if toIRC == "unknown" && toDiscord == "unknown" {
+		return ""
+	}
+	return fmt.Sprintf("Relay latency to IRC: %s; to Discord: %s", toIRC, toDiscord)
+}
+
+// sendProbes sends a latency probe through the bridge in each direction.
+//
+// The probe to Discord is sent to the IRC channel by the listener, then relayed,
+// and arrives when Discord sends the relayed message back to us.
+// The probe to IRC is sent to the Discord channel by the bot, then relayed when
+// Discord sends it back to us, and arrives when the IRC server has received it.
+func (b *Bridge) sendProbes() {
+	mapping := b.GetMappingByIRC(b.Config.LatencyProbeChannel)
+	if mapping == nil {
+		log.WithField("channel", b.Config.LatencyProbeChannel).Warnln("latency probe channel is not bridged")
+		return
+	}
+
+	_, content := b.probes.Start(true)
+	if _, err := b.discord.ChannelMessageSend(mapping.DiscordChannel, content); err != nil {
+		b.handleError(err, nil, "could not send latency probe to discord")
+	}
+
+	token, content := b.probes.Start(false)
+	b.ircListener.Privmsg(mapping.IRCChannel, content)
+	if _, err := b.ircListener.measureLag(); err != nil {
+		log.WithField("error", err).Warnln("latency probe was not received by the IRC server")
+		return
+	}
+	b.discordMessagesChan <- IRCMessage{
+		IRCChannel: mapping.IRCChannel,
+		Username:   b.ircListener.GetNick(),
+		Message:    content,
+		Probe:      token,
+	}
+}
+
+// probeArrived handles a probe sent back to us by Discord, returning false if the message isn't a probe.
+func (d *discordBot) probeArrived(m *discordgo.Message) bool {
+	if m.GuildID != d.guildID || d.bridge.GetMappingByDiscord(m.ChannelID) == nil {
+		return false
+	}
+
+	if token := d.bridge.probes.Token(m.Content, false); token != "" {
+		d.bridge.probes.Arrived(token)
+		return true
+	}
+
+	if token := d.bridge.probes.Token(m.Content, true); token != "" && m.Author.ID == d.State.User.ID {
+		go func() {
+			d.bridge.discordMessageEventsChan <- &DiscordMessage{
+				Message: m,
+				Content: m.Content,
+				Probe:   token,
+			}
+		}()
+		return true
+	}
+
+	return false
+}
+
+// probeRelayed times a probe once the IRC server has received it.
+func (b *Bridge) probeRelayed(token string) {
+	if _, err := b.ircListener.measureLag(); err != nil {
+		log.WithField("error", err).Warnln("latency probe was not received by the IRC server")
+		return
+	}
+	b.probes.Arrived(token)
+}
@@ -0,0 +1,75 @@
+package bridge
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatencyHistogram(t *testing.T) {
+	h := newLatencyHistogram()
+	_, ok := h.Percentile(50)
+	assert.False(t, ok)
+
+	for i := 1; i <= 100; i++ {
+		h.Observe(time.Duration(i) * 10 * time.Millisecond)
+	}
+
+	p50, _ := h.Percentile(50)
+	p95, _ := h.Percentile(95)
+	assert.Equal(t, 500*time.Millisecond, p50)
+	assert.Equal(t, 950*time.Millisecond, p95)
+
+	var out struct {
+		Buckets map[string]int64 `json:"buckets_ms"`
+		Count   int64
+	}
+	assert.NoError(t, json.Unmarshal([]byte(h.String()), &out))
+	assert.EqualValues(t, 100, out.Count)
+	assert.EqualValues(t, 5, out.Buckets["50"])
+	assert.EqualValues(t, 100, out.Buckets["1000"])
+	assert.EqualValues(t, 100, out.Buckets["+Inf"])
+}
+
+func TestLatencyProbes(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.LatencyProbeChannel = testChannel
+	})
+	defer tb.Close()
+
+	d := tb.Bridge.discord
+	tb.Bridge.sendProbes()
+
+	// Discord sends both probes back to us
+	var toIRC, toDiscord discordgo.Message
+	waitFor(t, "probes on discord", func() bool {
+		for _, msg := range tb.discord.Sent() {
+			if probeMarker.MatchString(msg.Content) {
+				if msg.WebhookID == "" {
+					toIRC = msg
+				} else {
+					toDiscord = msg
+				}
+			}
+		}
+		return toIRC.ID != "" && toDiscord.ID != ""
+	})
+	toIRC.GuildID, toDiscord.GuildID = testGuildID, testGuildID
+	d.onMessageCreate(d.Session, &discordgo.MessageCreate{Message: &toDiscord})
+	d.onMessageCreate(d.Session, &discordgo.MessageCreate{Message: &toIRC})
+
+	waitFor(t, "probe on irc", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<b\u200Bridge> "+toIRC.Content)
+	})
+	waitFor(t, "probes timed", func() bool {
+		return tb.Bridge.probes.Summary() != ""
+	})
+	assert.Regexp(t, `^Relay latency to IRC: p50 \d+ms, p95 \d+ms; to Discord: p50 \d+ms, p95 \d+ms$`, tb.Bridge.probes.Summary())
+
+	// Probes aren't counted as activity
+	lines := tb.Bridge.activity.Digest()
+	assert.Contains(t, lines, "No messages were relayed.")
+}
@@ -0,0 +1,533 @@
+package bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// High availability modes, for Config.HAMode
+const (
+	haModeNone = ""
+	haModeFile = "file" // the lease is a file on shared storage
+)
+
+// Standby modes, for Config.HAStandby
+const (
+	standbyWarm = "warm" // connected to Discord, but not IRC, while waiting to lead
+	standbyCold = "cold" // not connected at all while waiting to lead
+)
+
+// leaseRetry is how often a standby checks whether the lease has lapsed,
+// as a fraction of the lease TTL
+const leaseRetry = 3
+
+// A lease is who is relaying, and until when.
+//
+// Generation goes up each time the lease changes hands, so each leader has its own.
+type lease struct {
+	Holder     string
+	Expires    time.Time
+	Generation int64
+}
+
+// leaseFile is a lease on leadership kept in a file on storage shared by every instance.
+//
+// Instances take turns to change the lease by creating a lock file next to it, which only
+// one can do at a time. The lease is written to a temporary file and renamed into place,
+// so it is never seen half written.
+type leaseFile struct {
+	path   string
+	holder string
+	ttl    time.Duration
+}
+
+func (l *leaseFile) read() (*lease, error) {
+	data, err := ioutil.ReadFile(l.path)
+	if os.IsNotExist(err) {
+		return &lease{}, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "could not read lease")
+	}
+
+	current := &lease{}
+	if err := json.Unmarshal(data, current); err != nil {
+		// A half written lease is treated as lapsed
+		return &lease{}, nil
+	}
+	return current, nil
+}
+
+func (l *leaseFile) write(current *lease) error {
+	data, err := json.Marshal(current)
+	if err != nil {
+		return errors.Wrap(err, "could not encode lease")
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(l.path), filepath.Base(l.path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "could not create temporary lease file")
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "could not write lease")
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "could not write lease")
+	}
+	return errors.Wrap(os.Rename(tmp.Name(), l.path), "could not replace lease")
+}
+
+// lock takes the lock on changing the lease, returning false if another instance has it.
+// A lock older than the lease TTL was left by an instance that died holding it, and is broken.
+func (l *leaseFile) lock() (bool, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(l.path+".lock", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			return true, errors.Wrap(f.Close(), "could not create lease lock")
+		}
+		if !os.IsExist(err) {
+			return false, errors.Wrap(err, "could not create lease lock")
+		}
+
+		info, err := os.Stat(l.path + ".lock")
+		if err != nil || time.Since(info.ModTime()) < l.ttl {
+			return false, nil
+		}
+		if !l.breakLock(info) {
+			return false, nil
+		}
+	}
+	return false, nil
+}
+
+// breakLock removes the stale lock described by stale, returning false if someone else got to it first.
+//
+// The lock is renamed to a name of our own before it is removed. Only one instance's rename
+// can take a lock away, and if the lock we took isn't the stale one, another instance broke it
+// and made a new one since, so it is put back.
+func (l *leaseFile) breakLock(stale os.FileInfo) bool {
+	broken := fmt.Sprintf("%s.lock.%s.%d", l.path, l.holder, time.Now().UnixNano())
+	if err := os.Rename(l.path+".lock", broken); err != nil {
+		return false
+	}
+	defer os.Remove(broken)
+
+	info, err := os.Stat(broken)
+	if err != nil {
+		return false
+	}
+	// Inodes are reused, so the new lock could be the same file as far as SameFile knows
+	if !os.SameFile(stale, info) || !info.ModTime().Equal(stale.ModTime()) {
+		// os.Link won't replace a lock made while this one was away
+		os.Link(broken, l.path+".lock")
+		return false
+	}
+	return true
+}
+
+func (l *leaseFile) unlock() {
+	os.Remove(l.path + ".lock")
+}
+
+// Acquire takes the lease if it has lapsed, or renews it if we already hold it.
+// It returns when the lease now expires, or the zero time if someone else holds it,
+// or is changing it at the same time.
+func (l *leaseFile) Acquire() (time.Time, error) {
+	expires, _, err := l.acquire()
+	return expires, err
+}
+
+// acquire is Acquire, also returning the generation of the lease.
+func (l *leaseFile) acquire() (time.Time, int64, error) {
+	locked, err := l.lock()
+	if err != nil || !locked {
+		return time.Time{}, 0, err
+	}
+	defer l.unlock()
+
+	current, err := l.read()
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+
+	now := time.Now()
+	if current.Holder != l.holder && now.Before(current.Expires) {
+		return time.Time{}, 0, nil
+	}
+
+	next := &lease{Holder: l.holder, Expires: now.Add(l.ttl), Generation: current.Generation}
+	if current.Holder != l.holder || now.After(current.Expires) {
+		next.Generation++
+	}
+	if err := l.write(next); err != nil {
+		return time.Time{}, 0, err
+	}
+	return next.Expires, next.Generation, nil
+}
+
+// Release gives up the lease, if we hold it, so that a standby can take over straight away.
+// The lease is kept with its generation, but expired.
+func (l *leaseFile) Release() error {
+	locked, err := l.lock()
+	if err != nil || !locked {
+		return err
+	}
+	defer l.unlock()
+
+	current, err := l.read()
+	if err != nil || current.Holder != l.holder {
+		return err
+	}
+	current.Expires = time.Time{}
+	return l.write(current)
+}
+
+func validateHA(opts *Config) error {
+	switch opts.HAMode {
+	case haModeNone:
+		return nil
+	case haModeFile:
+	default:
+		return errors.Errorf("unknown high availability mode %q", opts.HAMode)
+	}
+
+	switch opts.HAStandby {
+	case "", standbyWarm, standbyCold:
+	default:
+		return errors.Errorf("unknown standby mode %q", opts.HAStandby)
+	}
+
+	if opts.HALeaseFile == "" {
+		return errors.New("a lease file is required for high availability")
+	}
+	if opts.HALeaseTTL <= 0 {
+		return errors.New("the high availability lease must last longer than zero")
+	}
+	if opts.HAInstance == "" {
+		return errors.New("an instance name is required for high availability")
+	}
+	return nil
+}
+
+// leadership is whether this instance is the one relaying messages.
+//
+// Until is when our lease runs out. The leader stops relaying then, even if it
+// couldn't find out whether it still holds the lease, and no other instance can take
+// the lease before then, so two instances only relay at once if their clocks disagree.
+// The leader saves what it relayed in the store as it renews the lease, and an instance
+// taking over doesn't relay those messages again.
+type leadership struct {
+	mu         sync.Mutex
+	until      time.Time
+	generation int64 // of the lease we hold
+
+	// relaying is whether this instance has taken over the webhooks and connected to IRC
+	relaying bool
+
+	// lost is closed if the leader loses the lease
+	lost     chan struct{}
+	lostOnce sync.Once
+}
+
+// isLeader returns true if this instance should relay messages.
+func (b *Bridge) isLeader() bool {
+	if b.lease == nil {
+		return true
+	}
+
+	b.leadership.mu.Lock()
+	defer b.leadership.mu.Unlock()
+	return time.Now().Before(b.leadership.until)
+}
+
+// standingBy returns true if this instance hasn't taken over relaying yet,
+// so isn't connected to IRC.
+func (b *Bridge) standingBy() bool {
+	if b.lease == nil {
+		return false
+	}
+
+	b.leadership.mu.Lock()
+	defer b.leadership.mu.Unlock()
+	return !b.leadership.relaying
+}
+
+// leaderOnly wraps a discordgo event handler so that it is only called if this instance
+// is the leader. The returned handler has the same type as the original.
+func (d *discordBot) leaderOnly(handler interface{}) interface{} {
+	fn := reflect.ValueOf(handler)
+	wrapped := reflect.MakeFunc(fn.Type(), func(args []reflect.Value) []reflect.Value {
+		if !d.bridge.isLeader() {
+			return nil
+		}
+		return fn.Call(args)
+	})
+
+	return wrapped.Interface()
+}
+
+// LeadershipLost is closed if this instance was relaying, but another instance has taken over.
+// The bridge stops relaying, and should be restarted so it can stand by.
+//
+// It is never closed if high availability is not set up.
+func (b *Bridge) LeadershipLost() <-chan struct{} {
+	return b.leadership.lost
+}
+
+// loseLeadership stops relaying, and tells whoever is running the bridge.
+func (b *Bridge) loseLeadership() {
+	b.leadership.mu.Lock()
+	b.leadership.until = time.Time{}
+	b.leadership.mu.Unlock()
+
+	b.leadership.lostOnce.Do(func() { close(b.leadership.lost) })
+}
+
+// openStandby connects to Discord, unless this is a cold standby,
+// and takes over relaying in the background once this instance holds the lease.
+func (b *Bridge) openStandby() error {
+	if b.Config.HAStandby != standbyCold {
+		if err := b.discord.openSession(); err != nil {
+			return errors.Wrap(err, "can't open discord")
+		}
+	}
+
+	go b.takeOver()
+	return nil
+}
+
+// takeOver waits for the lease and then starts relaying.
+//
+// Only messages received after taking over are relayed, so that nothing
+// the previous leader relayed is relayed again.
+func (b *Bridge) takeOver() {
+	log.WithField("instance", b.Config.HAInstance).Infoln("Standing by until the high availability lease is free.")
+	if !b.awaitLeadership() {
+		return
+	}
+	b.leadership.mu.Lock()
+	generation := b.leadership.generation
+	b.leadership.mu.Unlock()
+	log.WithFields(log.Fields{
+		"instance":   b.Config.HAInstance,
+		"generation": generation,
+	}).Infoln("Took the high availability lease, relaying now.")
+
+	// The previous leader may have stored links, karma and so on since we started,
+	// and what it relayed
+	if err := b.store.Reload(); err != nil {
+		log.WithField("error", err).Warnln("could not reload the store")
+	}
+	b.loadRelayed()
+
+	err := b.openLeader()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"alert": true,
+		}).Errorln("Could not take over relaying.")
+		if err := b.lease.Release(); err != nil {
+			log.WithField("error", err).Warnln("could not release the high availability lease")
+		}
+		b.loseLeadership()
+		return
+	}
+
+	b.leadership.mu.Lock()
+	b.leadership.relaying = true
+	b.leadership.mu.Unlock()
+
+	go b.renewLeadership()
+}
+
+// openLeader opens everything a standby didn't.
+func (b *Bridge) openLeader() error {
+	if b.Config.HAStandby == standbyCold {
+		if err := b.discord.Open(); err != nil {
+			return errors.Wrap(err, "can't open discord")
+		}
+	} else {
+		if err := b.discord.openTransmitter(); err != nil {
+			return errors.Wrap(err, "can't open discord")
+		}
+
+		// Members were ignored while standing by, so puppets are made for them now
+		if !b.Config.SimpleMode {
+			if err := b.discord.RequestGuildMembers(b.Config.GuildID, "", 0, "", true); err != nil {
+				return errors.Wrap(err, "could not request guild members")
+			}
+		}
+	}
+
+	return b.openIRC()
+}
+
+// awaitLeadership blocks until this instance holds the lease,
+// returning false if the bridge is closed first.
+func (b *Bridge) awaitLeadership() bool {
+	for {
+		expires, generation, err := b.lease.acquire()
+		if err != nil {
+			log.WithField("error", err).Warnln("could not check the high availability lease")
+		} else if !expires.IsZero() {
+			b.leadership.mu.Lock()
+			b.leadership.until = expires
+			b.leadership.generation = generation
+			b.leadership.mu.Unlock()
+			return true
+		}
+
+		select {
+		case <-time.After(b.lease.ttl / leaseRetry):
+		case <-b.stopWatchdog:
+			return false
+		}
+	}
+}
+
+// renewLeadership keeps renewing the lease until it is lost, or the bridge is closed.
+func (b *Bridge) renewLeadership() {
+	for {
+		select {
+		case <-time.After(b.lease.ttl / leaseRetry):
+		case <-b.stopWatchdog:
+			b.saveRelayed()
+			if err := b.lease.Release(); err != nil {
+				log.WithField("error", err).Warnln("could not release the high availability lease")
+			}
+			return
+		}
+
+		b.saveRelayed()
+		expires, err := b.lease.Acquire()
+		if err != nil {
+			// We keep relaying until the lease we have runs out
+			log.WithField("error", err).Warnln("could not renew the high availability lease")
+			if b.isLeader() {
+				continue
+			}
+		} else if !expires.IsZero() {
+			b.leadership.mu.Lock()
+			b.leadership.until = expires
+			b.leadership.mu.Unlock()
+			continue
+		}
+
+		log.WithField("alert", true).Errorln("Lost the high availability lease, another instance is relaying now.")
+		b.loseLeadership()
+		return
+	}
+}
+
+// haRelayedBucket is the store bucket holding the messages each instance relayed recently,
+// keyed by instance, so that an instance taking over doesn't relay them again
+const haRelayedBucket = "relayed"
+
+// haRelayedWindow is how long relayed messages are remembered, in lease TTLs
+const haRelayedWindow = 3
+
+// relayedIDs are the keys of messages relayed recently, and when they were relayed.
+type relayedIDs struct {
+	mu  sync.Mutex
+	ids map[string]time.Time
+}
+
+// discordRelayKey identifies a message from Discord. A message with attachments is relayed
+// once for each, and edits are relayed again, so the content is part of the key.
+func discordRelayKey(msg *DiscordMessage) string {
+	h := fnv.New64a()
+	h.Write([]byte(msg.Content))
+	return fmt.Sprintf("discord:%s:%x", msg.ID, h.Sum64())
+}
+
+// ircRelayKey identifies a message from IRC, if the server gives messages IDs.
+func ircRelayKey(msg IRCMessage) string {
+	if msg.MsgID == "" {
+		return ""
+	}
+	return "irc:" + msg.MsgID
+}
+
+// relayOnce returns false if a message was already relayed, by this instance or the one
+// before it, and otherwise remembers it is being relayed. Without high availability,
+// or without a key, it always returns true.
+func (b *Bridge) relayOnce(key string) bool {
+	if b.lease == nil || key == "" {
+		return true
+	}
+
+	r := &b.relayedIDs
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.ids[key]; ok {
+		return false
+	}
+	if r.ids == nil {
+		r.ids = make(map[string]time.Time)
+	}
+	r.ids[key] = time.Now()
+	return true
+}
+
+// forgetRelayed forgets a message that couldn't be relayed after all.
+func (b *Bridge) forgetRelayed(key string) {
+	b.relayedIDs.mu.Lock()
+	defer b.relayedIDs.mu.Unlock()
+	delete(b.relayedIDs.ids, key)
+}
+
+// saveRelayed saves the messages relayed recently in the store, forgetting older ones.
+func (b *Bridge) saveRelayed() {
+	r := &b.relayedIDs
+	r.mu.Lock()
+	since := time.Now().Add(-haRelayedWindow * b.lease.ttl)
+	for key, at := range r.ids {
+		if at.Before(since) {
+			delete(r.ids, key)
+		}
+	}
+	ids := make(map[string]time.Time, len(r.ids))
+	for key, at := range r.ids {
+		ids[key] = at
+	}
+	r.mu.Unlock()
+
+	if err := b.store.Put(haRelayedBucket, b.Config.HAInstance, ids); err != nil {
+		log.WithField("error", err).Warnln("could not save relayed messages for high availability")
+	}
+}
+
+// loadRelayed remembers the messages other instances saved as relayed.
+func (b *Bridge) loadRelayed() {
+	since := time.Now().Add(-haRelayedWindow * b.lease.ttl)
+
+	r := &b.relayedIDs
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.ids == nil {
+		r.ids = make(map[string]time.Time)
+	}
+	for _, instance := range b.store.Keys(haRelayedBucket) {
+		var ids map[string]time.Time
+		if ok, err := b.store.Get(haRelayedBucket, instance, &ids); !ok || err != nil {
+			continue
+		}
+		for key, at := range ids {
+			if at.After(since) {
+				r.ids[key] = at
+			}
+		}
+	}
+}
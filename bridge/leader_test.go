@@ -0,0 +1,236 @@
+package bridge
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/qaisjp/go-discord-irc/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaseFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lease")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "lease.json")
+	a := &leaseFile{path: path, holder: "a", ttl: time.Minute}
+	b := &leaseFile{path: path, holder: "b", ttl: time.Minute}
+
+	expires, err := a.Acquire()
+	assert.NoError(t, err)
+	assert.False(t, expires.IsZero())
+
+	// Someone else can't take a lease that hasn't lapsed, but the holder can renew it
+	expires, err = b.Acquire()
+	assert.NoError(t, err)
+	assert.True(t, expires.IsZero())
+
+	renewed, err := a.Acquire()
+	assert.NoError(t, err)
+	assert.False(t, renewed.IsZero())
+
+	// Releasing it lets someone else take over straight away
+	assert.NoError(t, b.Release())
+	assert.NoError(t, a.Release())
+	expires, err = b.Acquire()
+	assert.NoError(t, err)
+	assert.False(t, expires.IsZero())
+
+	// Until it lapses
+	assert.NoError(t, b.write(&lease{Holder: "b", Expires: time.Now().Add(-time.Second)}))
+	expires, err = a.Acquire()
+	assert.NoError(t, err)
+	assert.False(t, expires.IsZero())
+}
+
+func TestLeaseFileExclusive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lease")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "lease.json")
+
+	// Instances racing for a lapsed lease can't both get it
+	var wg sync.WaitGroup
+	winners := make(chan string, 10)
+	for n := 0; n < 10; n++ {
+		l := &leaseFile{path: path, holder: fmt.Sprint(n), ttl: time.Minute}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if expires, err := l.Acquire(); err == nil && !expires.IsZero() {
+				winners <- l.holder
+			}
+		}()
+	}
+	wg.Wait()
+	close(winners)
+	assert.Len(t, winners, 1)
+
+	// A lock left behind by an instance that died is broken once it's older than the TTL
+	a := &leaseFile{path: filepath.Join(dir, "other.json"), holder: "a", ttl: time.Minute}
+	assert.NoError(t, ioutil.WriteFile(a.path+".lock", nil, 0600))
+	expires, err := a.Acquire()
+	assert.NoError(t, err)
+	assert.True(t, expires.IsZero())
+	old := time.Now().Add(-2 * time.Minute)
+	assert.NoError(t, os.Chtimes(a.path+".lock", old, old))
+	expires, err = a.Acquire()
+	assert.NoError(t, err)
+	assert.False(t, expires.IsZero())
+}
+
+func TestLeaseFileBreakStaleLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lease")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "lease.json")
+	a := &leaseFile{path: path, holder: "a", ttl: time.Minute}
+	b := &leaseFile{path: path, holder: "b", ttl: time.Minute}
+
+	// Two instances breaking the same stale lock at once can't both end up holding it
+	old := time.Now().Add(-2 * time.Minute)
+	for round := 0; round < 200; round++ {
+		assert.NoError(t, ioutil.WriteFile(path+".lock", nil, 0600))
+		assert.NoError(t, os.Chtimes(path+".lock", old, old))
+
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+		locked := make(chan string, 2)
+		for _, l := range []*leaseFile{a, b} {
+			wg.Add(1)
+			go func(l *leaseFile) {
+				defer wg.Done()
+				<-start
+				if ok, err := l.lock(); err == nil && ok {
+					locked <- l.holder
+				}
+			}(l)
+		}
+		close(start)
+		wg.Wait()
+		close(locked)
+		if !assert.Len(t, locked, 1, "round %d", round) {
+			return
+		}
+		a.unlock()
+	}
+
+	// Even when one of them breaks it and takes a new lock between the other seeing it and breaking it
+	assert.NoError(t, ioutil.WriteFile(path+".lock", nil, 0600))
+	assert.NoError(t, os.Chtimes(path+".lock", old, old))
+	stale, err := os.Stat(path + ".lock")
+	assert.NoError(t, err)
+	locked, err := a.lock()
+	assert.NoError(t, err)
+	assert.True(t, locked)
+	assert.False(t, b.breakLock(stale))
+	locked, err = b.lock()
+	assert.NoError(t, err)
+	assert.False(t, locked)
+	a.unlock()
+
+	// Nothing is left behind by breaking locks
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestLeaseGeneration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lease")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "lease.json")
+	a := &leaseFile{path: path, holder: "a", ttl: time.Minute}
+	b := &leaseFile{path: path, holder: "b", ttl: time.Minute}
+
+	_, first, _ := a.acquire()
+	_, renewed, _ := a.acquire()
+	assert.Equal(t, first, renewed)
+
+	assert.NoError(t, a.Release())
+	_, next, _ := b.acquire()
+	assert.Equal(t, first+1, next)
+}
+
+func TestRelayOnceAcrossInstances(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lease")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	st, err := store.Open(filepath.Join(dir, "store.json"))
+	assert.NoError(t, err)
+	lease := &leaseFile{ttl: time.Minute}
+	a := &Bridge{Config: &Config{HAInstance: "a"}, lease: lease, store: st}
+	b := &Bridge{Config: &Config{HAInstance: "b"}, lease: lease, store: st}
+
+	msg := &DiscordMessage{Message: &discordgo.Message{ID: "5"}, Content: "hello"}
+	assert.True(t, a.relayOnce(discordRelayKey(msg)))
+	assert.False(t, a.relayOnce(discordRelayKey(msg)))
+	assert.True(t, a.relayOnce(ircRelayKey(IRCMessage{MsgID: "abc"})))
+
+	// Messages that failed to send can be retried
+	assert.True(t, a.relayOnce(ircRelayKey(IRCMessage{MsgID: "failed"})))
+	a.forgetRelayed(ircRelayKey(IRCMessage{MsgID: "failed"}))
+	assert.True(t, a.relayOnce(ircRelayKey(IRCMessage{MsgID: "failed"})))
+	a.saveRelayed()
+
+	// The instance taking over doesn't relay them again, but does relay edits
+	b.loadRelayed()
+	assert.False(t, b.relayOnce(discordRelayKey(msg)))
+	assert.False(t, b.relayOnce(ircRelayKey(IRCMessage{MsgID: "abc"})))
+	assert.True(t, b.relayOnce(discordRelayKey(&DiscordMessage{Message: msg.Message, Content: "[edit]: hello"})))
+
+	// Without message IDs from IRC, there is nothing to go on
+	assert.True(t, b.relayOnce(ircRelayKey(IRCMessage{})))
+	assert.True(t, b.relayOnce(ircRelayKey(IRCMessage{})))
+}
+
+func TestStandbyDoesNotRelay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lease")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.HAMode = haModeFile
+		conf.HALeaseFile = filepath.Join(dir, "lease.json")
+		conf.HALeaseTTL = time.Minute
+		conf.HAInstance = "a"
+	})
+	defer tb.Close()
+	b := tb.Bridge
+
+	// The harness connects everything, as if we had taken over, but the lease has run out
+	b.leadership.relaying = true
+	assert.False(t, b.isLeader())
+
+	bob := tb.discordMember("100", "bob", "")
+	tb.discordSay(bob, "while standing by")
+	// The loop is done with that message once it takes this one
+	tb.discordSay(bob, "still standing by")
+
+	b.awaitLeadership()
+	assert.True(t, b.isLeader())
+
+	tb.discordSay(bob, "after taking over")
+	waitFor(t, "message on irc", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<b\u200Bob#0001> after taking over")
+	})
+	for _, line := range tb.ircd.Received("listener") {
+		assert.NotContains(t, line, "while standing by")
+	}
+}
+
+func TestValidateHA(t *testing.T) {
+	assert.NoError(t, validateHA(&Config{}))
+	assert.NoError(t, validateHA(&Config{HAMode: haModeFile, HALeaseFile: "lease", HALeaseTTL: time.Second, HAInstance: "a"}))
+	assert.Error(t, validateHA(&Config{HAMode: "redis"}))
+	assert.Error(t, validateHA(&Config{HAMode: haModeFile, HALeaseTTL: time.Second, HAInstance: "a"}))
+	assert.Error(t, validateHA(&Config{HAMode: haModeFile, HALeaseFile: "lease", HALeaseTTL: time.Second, HAInstance: "a", HAStandby: "lukewarm"}))
+}
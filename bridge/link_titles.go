@@ -0,0 +1,240 @@
+package bridge
+
+import (
+	"context"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// linkTitleSize is how much of a page is read looking for its title
+var linkTitleSize int64 = 64 * 1024
+
+// linkTitleLength is how much of a page title is relayed
+var linkTitleLength = 150
+
+// linkTitleCacheTTL is how long titles are remembered, including for pages without one.
+// Pages that couldn't be fetched aren't remembered.
+var linkTitleCacheTTL = time.Hour
+
+// linkTitleLinks is the most links in a message that titles are fetched for
+const linkTitleLinks = 3
+
+// linkTitleRate is the most pages fetched each minute, so that busy channels
+// don't make the bridge hammer a site
+var linkTitleRate = 20
+
+// linkTitleTimeout is how long a message waits for the titles of all its links,
+// so that slow sites don't hold up relaying
+var linkTitleTimeout = 5 * time.Second
+
+// linkTitleRedirects is the most redirects followed to fetch a page
+const linkTitleRedirects = 3
+
+var linkPattern = regexp.MustCompile(`https?://[^\s<>]+`)
+var titlePattern = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+// linkTitles remembers the titles of pages that have been fetched.
+type linkTitles struct {
+	mu      sync.Mutex
+	cache   map[string]linkTitle // keyed by URL
+	fetched []time.Time          // when pages were fetched in the last minute
+}
+
+type linkTitle struct {
+	title   string
+	expires time.Time
+}
+
+func (t *linkTitles) get(link string) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cached, ok := t.cache[link]
+	if !ok || time.Now().After(cached.expires) {
+		return "", false
+	}
+	return cached.title, true
+}
+
+func (t *linkTitles) put(link, title string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if t.cache == nil {
+		t.cache = make(map[string]linkTitle)
+	}
+	for cachedLink, cached := range t.cache {
+		if now.After(cached.expires) {
+			delete(t.cache, cachedLink)
+		}
+	}
+	t.cache[link] = linkTitle{title: title, expires: now.Add(linkTitleCacheTTL)}
+}
+
+// allowFetch returns true if another page can be fetched without going over linkTitleRate.
+func (t *linkTitles) allowFetch() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	recent := t.fetched[:0]
+	for _, fetched := range t.fetched {
+		if now.Sub(fetched) < time.Minute {
+			recent = append(recent, fetched)
+		}
+	}
+	t.fetched = recent
+
+	if len(t.fetched) >= linkTitleRate {
+		return false
+	}
+	t.fetched = append(t.fetched, now)
+	return true
+}
+
+// titleDomainAllowed returns true if titles are fetched for links to the host:
+// one of LinkTitleDomains, or a subdomain of one.
+func (b *Bridge) titleDomainAllowed(host string) bool {
+	for _, domain := range b.Config.LinkTitleDomains {
+		if strings.EqualFold(host, domain) || strings.HasSuffix(strings.ToLower(host), "."+strings.ToLower(domain)) {
+			return true
+		}
+	}
+	return false
+}
+
+// linkTitlesIn returns the titles of the pages linked to in the text, for links to allowed domains.
+// Pages without a title, or that couldn't be fetched within linkTitleTimeout, are left out.
+func (b *Bridge) linkTitlesIn(text string) []string {
+	var links []string
+	seen := make(map[string]bool)
+	for _, link := range linkPattern.FindAllString(text, -1) {
+		link = strings.TrimRight(link, ".,:;!?)]'\"")
+		if seen[link] || len(seen) == linkTitleLinks {
+			continue
+		}
+
+		u, err := url.Parse(link)
+		if err != nil || !b.titleDomainAllowed(u.Hostname()) {
+			continue
+		}
+		seen[link] = true
+		links = append(links, link)
+	}
+
+	// The pages are fetched at the same time, so they share the one deadline
+	ctx, cancel := context.WithTimeout(context.Background(), linkTitleTimeout)
+	defer cancel()
+
+	found := make([]string, len(links))
+	var wg sync.WaitGroup
+	for n, link := range links {
+		if title, ok := b.titles.get(link); ok {
+			found[n] = title
+			continue
+		}
+		if !b.titles.allowFetch() {
+			continue
+		}
+
+		wg.Add(1)
+		go func(n int, link string) {
+			defer wg.Done()
+			title, err := b.fetchTitle(ctx, link)
+			if err != nil {
+				// Not cached, so the page is tried again next time
+				log.WithFields(log.Fields{"error": err, "link": link}).Debugln("could not fetch page title")
+				return
+			}
+			b.titles.put(link, title)
+			found[n] = title
+		}(n, link)
+	}
+	wg.Wait()
+
+	var titles []string
+	for _, title := range found {
+		if title != "" {
+			titles = append(titles, title)
+		}
+	}
+	return titles
+}
+
+// appendLinkTitles adds the titles of pages linked to in text to message, like "(Title: …)".
+func (b *Bridge) appendLinkTitles(message, text string) string {
+	for _, title := range b.linkTitlesIn(text) {
+		message += " (Title: " + title + ")"
+	}
+	return message
+}
+
+// appendLinkTitles adds the titles of pages linked to in a Discord message to the content relayed to IRC.
+// Titles Discord has already shown in embeds are used instead of fetching the page again.
+func (d *discordBot) appendLinkTitles(m *discordgo.Message, content string) string {
+	for _, embed := range m.Embeds {
+		if embed.URL != "" && embed.Title != "" && embed.Type != discordgo.EmbedTypeRich {
+			d.bridge.titles.put(embed.URL, TruncateString(linkTitleLength, strings.Join(strings.Fields(embed.Title), " ")))
+		}
+	}
+	return d.bridge.appendLinkTitles(content, m.Content)
+}
+
+// fetchTitle returns the title of an HTML page, or "" if it doesn't have one.
+// Redirects are only followed to domains titles are fetched for.
+func (b *Bridge) fetchTitle(ctx context.Context, link string) (string, error) {
+	req, err := http.NewRequest("GET", link, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid link")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "text/html")
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > linkTitleRedirects {
+				return errors.New("too many redirects")
+			}
+			if !b.titleDomainAllowed(req.URL.Hostname()) {
+				return errors.Errorf("redirected to %s, which titles aren't fetched for", req.URL.Hostname())
+			}
+			return nil
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "could not fetch page")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("page responded with %s", resp.Status)
+	}
+	if !strings.Contains(resp.Header.Get("Content-Type"), "html") {
+		return "", nil
+	}
+
+	page, err := ioutil.ReadAll(io.LimitReader(resp.Body, linkTitleSize))
+	if err != nil {
+		return "", errors.Wrap(err, "could not read page")
+	}
+
+	match := titlePattern.FindSubmatch(page)
+	if match == nil {
+		return "", nil
+	}
+	title := strings.Join(strings.Fields(html.UnescapeString(string(match[1]))), " ")
+	return TruncateString(linkTitleLength, title), nil
+}
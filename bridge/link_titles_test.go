@@ -0,0 +1,171 @@
+package bridge
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLinkTitlesToDiscord(t *testing.T) {
+	var fetches int32
+	pages := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, "<html><head><title>\n  Fish &amp; chips\n</title></head></html>")
+	}))
+	defer pages.Close()
+
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.LinkTitlesToDiscord = true
+		conf.LinkTitleDomains = []string{"127.0.0.1"}
+	})
+	defer tb.Close()
+
+	link := pages.URL + "/menu"
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :have you seen "+link+"?")
+	waitFor(t, "message on discord", func() bool {
+		_, ok := tb.discord.Find("have you seen " + link + "? (Title: Fish & chips)" + relayMarker)
+		return ok
+	})
+
+	// Titles are cached, and only fetched for allowed domains
+	assert.Equal(t, []string{"Fish & chips"}, tb.Bridge.linkTitlesIn(link+" and "+link))
+	assert.Empty(t, tb.Bridge.linkTitlesIn("http://localhost:1/menu"))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+}
+
+func TestTitleDomainAllowed(t *testing.T) {
+	b := &Bridge{Config: &Config{LinkTitleDomains: []string{"example.com"}}}
+	assert.True(t, b.titleDomainAllowed("example.com"))
+	assert.True(t, b.titleDomainAllowed("www.EXAMPLE.com"))
+	assert.False(t, b.titleDomainAllowed("badexample.com"))
+	assert.False(t, b.titleDomainAllowed("example.com.evil"))
+}
+
+func TestLinkTitlesToIRC(t *testing.T) {
+	pages := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<title>Fetched</title>")
+	}))
+	defer pages.Close()
+
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.LinkTitlesToIRC = true
+		conf.LinkTitleDomains = []string{"127.0.0.1", "example.com"}
+	})
+	defer tb.Close()
+
+	bob := tb.discordMember("100", "bob", "")
+	tb.discordSay(bob, "look at "+pages.URL+"/a")
+	waitFor(t, "message on irc", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<b\u200Bob#0001> look at "+pages.URL+"/a (Title: Fetched)")
+	})
+
+	// Embed titles are used without fetching the page
+	d := tb.Bridge.discord
+	assert.Equal(t, "see https://example.com/x (Title: From the embed)", d.appendLinkTitles(&discordgo.Message{
+		Content: "see https://example.com/x",
+		Embeds:  []*discordgo.MessageEmbed{{Type: discordgo.EmbedTypeArticle, URL: "https://example.com/x", Title: "From the embed"}},
+	}, "see https://example.com/x"))
+}
+
+func TestLinkTitleRate(t *testing.T) {
+	defer func(rate int) { linkTitleRate = rate }(linkTitleRate)
+	linkTitleRate = 2
+
+	titles := &linkTitles{}
+	assert.True(t, titles.allowFetch())
+	assert.True(t, titles.allowFetch())
+	assert.False(t, titles.allowFetch())
+}
+
+func TestLinkTitleRedirects(t *testing.T) {
+	var pages *httptest.Server
+	pages = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/elsewhere":
+			// localhost isn't allowed, even though it's the same server
+			http.Redirect(w, r, strings.Replace(pages.URL, "127.0.0.1", "localhost", 1)+"/hop/0", http.StatusFound)
+		case strings.HasPrefix(r.URL.Path, "/hop/"):
+			n, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/hop/"))
+			if n > 0 {
+				http.Redirect(w, r, fmt.Sprintf("/hop/%d", n-1), http.StatusFound)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, "<title>Landed</title>")
+		}
+	}))
+	defer pages.Close()
+
+	b := &Bridge{Config: &Config{LinkTitleDomains: []string{"127.0.0.1"}}}
+	assert.Equal(t, []string{"Landed"}, b.linkTitlesIn(fmt.Sprintf("%s/hop/%d", pages.URL, linkTitleRedirects)))
+	assert.Empty(t, b.linkTitlesIn(fmt.Sprintf("%s/hop/%d", pages.URL, linkTitleRedirects+1)))
+	assert.Empty(t, b.linkTitlesIn(pages.URL+"/elsewhere"))
+}
+
+func TestLinkTitleTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { linkTitleTimeout = timeout }(linkTitleTimeout)
+	linkTitleTimeout = 100 * time.Millisecond
+
+	pages := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer pages.Close()
+
+	// Slow pages share the one deadline
+	b := &Bridge{Config: &Config{LinkTitleDomains: []string{"127.0.0.1"}}}
+	start := time.Now()
+	assert.Empty(t, b.linkTitlesIn(pages.URL+"/a "+pages.URL+"/b "+pages.URL+"/c"))
+	assert.True(t, time.Since(start) < 2*linkTitleTimeout, "took %s", time.Since(start))
+
+	// Pages that timed out are tried again next time
+	_, ok := b.titles.get(pages.URL + "/a")
+	assert.False(t, ok)
+}
+
+func TestLinkTitlesKeepOrder(t *testing.T) {
+	release := make(chan struct{})
+	pages := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<title>Slow</title>")
+	}))
+	defer pages.Close()
+	var once sync.Once
+	unblock := func() { once.Do(func() { close(release) }) }
+	defer unblock()
+
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.LinkTitlesToDiscord = true
+		conf.LinkTitleDomains = []string{"127.0.0.1"}
+	})
+	defer tb.Close()
+
+	// The message after a link waits for its title, rather than overtaking it
+	link := pages.URL + "/slow"
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :"+link)
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :after the link")
+	time.Sleep(100 * time.Millisecond)
+	_, ok := tb.discord.Find("after the link" + relayMarker)
+	assert.False(t, ok)
+
+	unblock()
+	waitFor(t, "messages on discord", func() bool {
+		_, first := tb.discord.Find(link + " (Title: Slow)" + relayMarker)
+		_, second := tb.discord.Find("after the link" + relayMarker)
+		return first && second
+	})
+}
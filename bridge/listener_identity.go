@@ -0,0 +1,195 @@
+package bridge
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	irc "github.com/qaisjp/go-ircevent"
+	log "github.com/sirupsen/logrus"
+)
+
+func validateListenerIdentities(opts *Config) error {
+	for channel, channelOpts := range opts.ChannelOptions {
+		nick, ident := channelOpts.ListenerNick, channelOpts.ListenerIdent
+		if ident != "" && nick == "" {
+			return errors.Errorf("channel options for %s: listener_ident needs a listener_nick", channel)
+		}
+		if strings.ContainsAny(nick+ident, " ,!@:\r\n") || strings.HasPrefix(nick, "#") {
+			return errors.Errorf("channel options for %s: listener_nick %q or listener_ident %q isn't valid on IRC", channel, nick, ident)
+		}
+		if nick != "" && strings.EqualFold(nick, opts.IRCListenerName) {
+			return errors.Errorf("channel options for %s: listener_nick is already the listener's nick", channel)
+		}
+	}
+	return nil
+}
+
+// listenerIdentities are the IRC connections for mappings with their own ListenerNick,
+// keyed by lowercase nick. They only send what the listener would have, in their own
+// channels. The listener is still what relays those channels to Discord.
+type listenerIdentities struct {
+	mu         sync.Mutex
+	identities map[string]*listenerIdentity
+	connecting map[string]bool
+	closed     bool
+}
+
+type listenerIdentity struct {
+	*irc.Connection
+
+	mu     sync.Mutex
+	joined map[string]bool // lowercase channels
+}
+
+// Joined returns true if the identity is connected and in the channel.
+func (l *listenerIdentity) Joined(channel string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.Connected() && l.joined[strings.ToLower(channel)]
+}
+
+// identityChannels returns the IRC channels and their keys for each ListenerNick,
+// keyed by lowercase nick, along with the ident to use for each.
+func (b *Bridge) identityChannels() (channels map[string]map[string]string, idents map[string]string) {
+	channels = make(map[string]map[string]string)
+	idents = make(map[string]string)
+	for channel, key := range b.GetIRCChannels() {
+		opts := b.channelOptions(channel)
+		if opts.ListenerNick == "" {
+			continue
+		}
+		nick := strings.ToLower(opts.ListenerNick)
+		if channels[nick] == nil {
+			channels[nick] = make(map[string]string)
+			idents[nick] = "discord"
+		}
+		channels[nick][channel] = key
+		if opts.ListenerIdent != "" {
+			idents[nick] = opts.ListenerIdent
+		}
+	}
+	return channels, idents
+}
+
+// connectIdentities connects each ListenerNick that isn't connected yet. It is called
+// when the listener connects, so new nicks are picked up when the listener reconnects.
+func (b *Bridge) connectIdentities() {
+	channels, idents := b.identityChannels()
+
+	// Connecting blocks, so it is done without holding the lock
+	var nicks []string
+	b.identities.mu.Lock()
+	if b.identities.identities == nil {
+		b.identities.identities = make(map[string]*listenerIdentity)
+	}
+	if b.identities.connecting == nil {
+		b.identities.connecting = make(map[string]bool)
+	}
+	for nick := range channels {
+		if _, ok := b.identities.identities[nick]; ok || b.identities.connecting[nick] || b.identities.closed {
+			continue
+		}
+		b.identities.connecting[nick] = true
+		nicks = append(nicks, nick)
+	}
+	b.identities.mu.Unlock()
+
+	for _, nick := range nicks {
+		identity := b.connectIdentity(nick, idents[nick], channels[nick])
+
+		b.identities.mu.Lock()
+		delete(b.identities.connecting, nick)
+		closed := b.identities.closed
+		if identity != nil && !closed {
+			b.identities.identities[nick] = identity
+		}
+		b.identities.mu.Unlock()
+
+		// The bridge closed while it was connecting
+		if identity != nil && closed {
+			identity.Quit()
+		}
+	}
+}
+
+// connectIdentity connects the identity for a ListenerNick, returning nil if it couldn't.
+func (b *Bridge) connectIdentity(nick, ident string, channels map[string]string) *listenerIdentity {
+	var name string
+	for channel := range channels {
+		name = b.channelOptions(channel).ListenerNick
+	}
+	identity := &listenerIdentity{
+		Connection: irc.IRC(name, ident),
+		joined:     make(map[string]bool),
+	}
+	b.SetupIRCConnection(identity.Connection, "discord.", "fd75:f5f5:226f::")
+	identity.Debug = b.Config.Debug
+	identity.AddCallback("001", func(e *irc.Event) {
+		channels, _ := b.identityChannels()
+		for channel, key := range channels[nick] {
+			identity.Join(strings.TrimSpace(channel + " " + key))
+		}
+	})
+	identity.AddCallback("366", func(e *irc.Event) {
+		identity.mu.Lock()
+		identity.joined[strings.ToLower(e.Arguments[1])] = true
+		identity.mu.Unlock()
+	})
+	identity.AddCallback("KICK", func(e *irc.Event) {
+		if e.Arguments[1] == identity.GetNick() {
+			identity.mu.Lock()
+			delete(identity.joined, strings.ToLower(e.Arguments[0]))
+			identity.mu.Unlock()
+		}
+	})
+
+	if err := identity.Connect(b.Config.IRCServer); err != nil {
+		log.WithError(err).WithField("nick", name).Warnln("Could not connect listener identity, relaying as the listener instead.")
+		return nil
+	}
+	go identity.Loop()
+	return identity
+}
+
+// listenerFor returns what relays to an IRC channel in place of a puppet: the identity for
+// the mapping's ListenerNick, if it is in the channel, or else the listener.
+func (b *Bridge) listenerFor(channel string) interface{ Privmsg(target, message string) } {
+	nick := strings.ToLower(b.channelOptions(channel).ListenerNick)
+	if nick == "" {
+		return b.ircListener
+	}
+
+	b.identities.mu.Lock()
+	identity, ok := b.identities.identities[nick]
+	b.identities.mu.Unlock()
+	if ok && identity.Joined(channel) {
+		return identity
+	}
+	return b.ircListener
+}
+
+// isListenerIdentity returns true if the nick is one of the bridge's listener identities.
+func (b *Bridge) isListenerIdentity(nick string) bool {
+	b.identities.mu.Lock()
+	defer b.identities.mu.Unlock()
+	for _, identity := range b.identities.identities {
+		if strings.EqualFold(identity.GetNick(), nick) {
+			return true
+		}
+	}
+	return false
+}
+
+// closeIdentities disconnects the listener identities.
+func (b *Bridge) closeIdentities() {
+	b.identities.mu.Lock()
+	defer b.identities.mu.Unlock()
+	b.identities.closed = true
+	for nick, identity := range b.identities.identities {
+		if identity.Connected() {
+			identity.Quit()
+		}
+		delete(b.identities.identities, nick)
+	}
+}
@@ -0,0 +1,52 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListenerIdentity(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.ChannelOptions = map[string]ChannelOptions{testChannel: {ListenerNick: "ocf-d2i", ListenerIdent: "ocf"}}
+	})
+	defer tb.Close()
+
+	waitFor(t, "identity to join", func() bool {
+		return tb.Bridge.listenerFor(testChannel) != tb.Bridge.ircListener
+	})
+	assert.True(t, tb.ircd.HasReceived("ocf-d2i", "USER ocf 0.0.0.0 0.0.0.0 :ocf"))
+
+	bob := tb.discordMember("100", "bob", "")
+	tb.discordSay(bob, "hi there")
+	waitFor(t, "message from the identity", func() bool {
+		return tb.ircd.HasReceived("ocf-d2i", "PRIVMSG "+testChannel+" :<b\u200Bob#0001> hi there")
+	})
+	assert.False(t, tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<b\u200Bob#0001> hi there"))
+
+	// What the identity relays isn't relayed back to Discord
+	for _, sent := range tb.discord.Sent() {
+		assert.NotContains(t, sent.Content, "hi there")
+	}
+
+	// Connected identities aren't connected again, and none are once the bridge closes
+	identity := tb.Bridge.listenerFor(testChannel)
+	tb.Bridge.connectIdentities()
+	assert.True(t, tb.Bridge.listenerFor(testChannel) == identity)
+	tb.Bridge.closeIdentities()
+	tb.Bridge.connectIdentities()
+	assert.True(t, tb.Bridge.listenerFor(testChannel) == tb.Bridge.ircListener)
+}
+
+func TestValidateListenerIdentities(t *testing.T) {
+	valid := &Config{IRCListenerName: "listener", ChannelOptions: map[string]ChannelOptions{"#a": {ListenerNick: "ocf-d2i"}}}
+	assert.NoError(t, validateListenerIdentities(valid))
+
+	for _, opts := range []ChannelOptions{
+		{ListenerIdent: "ocf"},
+		{ListenerNick: "two words"},
+		{ListenerNick: "Listener"},
+	} {
+		assert.Error(t, validateListenerIdentities(&Config{IRCListenerName: "listener", ChannelOptions: map[string]ChannelOptions{"#a": opts}}))
+	}
+}
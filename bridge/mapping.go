@@ -0,0 +1,96 @@
+package bridge
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Mapping is a mapping between a Discord channel and an IRC channel (essentially a tuple).
+//
+// IRCChannel can be followed by a space and the channel key, like "#secret hunter2".
+type Mapping struct {
+	DiscordChannel string
+	IRCChannel     string
+}
+
+// IRCName is the IRC channel, without its key.
+func (m *Mapping) IRCName() string {
+	return strings.Split(m.IRCChannel, " ")[0]
+}
+
+// mappingList is all the bridged channels.
+type mappingList []*Mapping
+
+// CheckChannelMappings returns an error if the mappings can't be bridged,
+// like SetChannelMappings would.
+func CheckChannelMappings(in map[string]string) error {
+	_, err := parseMappings(in)
+	return err
+}
+
+// parseMappings makes mappings from IRC channels, with their keys, to Discord channel IDs.
+// Each channel can only be bridged once.
+func parseMappings(in map[string]string) (mappingList, error) {
+	mappings := mappingList{}
+	for irc, discord := range in {
+		mappings = append(mappings, &Mapping{
+			DiscordChannel: discord,
+			IRCChannel:     irc,
+		})
+	}
+
+	// Check for duplicate channels
+	for i, mapping := range mappings {
+		for j, check := range mappings {
+			if (mapping.DiscordChannel == check.DiscordChannel) || (mapping.IRCChannel == check.IRCChannel) {
+				if i != j {
+					return nil, errors.New("channel_mappings contains duplicate entries")
+				}
+			}
+		}
+	}
+
+	return mappings, nil
+}
+
+// byIRC returns the mapping for an IRC channel, or nil.
+func (ms mappingList) byIRC(channel string) *Mapping {
+	for _, mapping := range ms {
+		if mapping.IRCName() == channel {
+			return mapping
+		}
+	}
+	return nil
+}
+
+// byDiscord returns the mapping for a Discord channel ID, or nil.
+func (ms mappingList) byDiscord(channel string) *Mapping {
+	for _, mapping := range ms {
+		if mapping.DiscordChannel == channel {
+			return mapping
+		}
+	}
+	return nil
+}
+
+// parted returns the IRC channels, with their keys, that were mapped in old but aren't
+// in ms, and so should be left. Channels mapped to a different Discord channel are kept,
+// so that swapping mappings doesn't make the bots leave and rejoin.
+func (ms mappingList) parted(old mappingList) []string {
+	parted := []string{}
+	for _, mapping := range old {
+		found := false
+		for _, curr := range ms {
+			if curr.IRCChannel == mapping.IRCChannel {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			parted = append(parted, mapping.IRCChannel)
+		}
+	}
+	return parted
+}
@@ -0,0 +1,25 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMappings(t *testing.T) {
+	mappings, err := parseMappings(map[string]string{"#a": "1", "#b key": "2"})
+	assert.NoError(t, err)
+	assert.Equal(t, "1", mappings.byIRC("#a").DiscordChannel)
+	assert.Equal(t, "#b key", mappings.byIRC("#b").IRCChannel)
+	assert.Equal(t, "#b", mappings.byDiscord("2").IRCName())
+	assert.Nil(t, mappings.byIRC("#c"))
+
+	_, err = parseMappings(map[string]string{"#a": "1", "#b": "1"})
+	assert.Error(t, err)
+	assert.Error(t, CheckChannelMappings(map[string]string{"#a": "1", "#b": "1"}))
+
+	// Swapped channels are kept, and removed ones are parted
+	updated, err := parseMappings(map[string]string{"#a": "2", "#c": "3"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"#b key"}, updated.parted(mappings))
+}
@@ -0,0 +1,79 @@
+package bridge
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// mentionCacheTTL is how long a resolved mention is cached. Entries are also dropped when the
+// Discord member or their puppet changes, but nicks generated for users without a puppet
+// depend on who is on IRC, so they can't be kept forever.
+var mentionCacheTTL = 10 * time.Minute
+
+// mentionCache caches the IRC nicks Discord users are mentioned as, keyed by Discord ID.
+//
+// It is safe for concurrent use.
+type mentionCache struct {
+	mu    sync.Mutex
+	nicks map[string]cachedMention
+
+	// puppets turns puppet nicks in messages from IRC into mentions of their Discord users
+	puppets *strings.Replacer
+}
+
+type cachedMention struct {
+	nick    string
+	expires time.Time
+}
+
+// Get returns the cached nick of a Discord user, if there is one.
+func (c *mentionCache) Get(discordID string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.nicks[discordID]
+	if !ok || time.Now().After(cached.expires) {
+		return "", false
+	}
+	return cached.nick, true
+}
+
+// Put caches the nick of a Discord user.
+func (c *mentionCache) Put(discordID, nick string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.nicks == nil {
+		c.nicks = make(map[string]cachedMention)
+	}
+
+	// Drop expired entries, so that everyone ever mentioned isn't kept
+	now := time.Now()
+	for id, cached := range c.nicks {
+		if now.After(cached.expires) {
+			delete(c.nicks, id)
+		}
+	}
+
+	c.nicks[discordID] = cachedMention{nick: nick, expires: now.Add(mentionCacheTTL)}
+}
+
+// Invalidate forgets the nick of a Discord user, when their member or puppet changes.
+func (c *mentionCache) Invalidate(discordID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.nicks, discordID)
+	c.puppets = nil
+}
+
+// Puppets returns the replacer for mentions of puppets, building it if a puppet has changed
+// since it was last built.
+func (c *mentionCache) Puppets(build func() *strings.Replacer) *strings.Replacer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.puppets == nil {
+		c.puppets = build()
+	}
+	return c.puppets
+}
@@ -0,0 +1,69 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMentionCache(t *testing.T) {
+	tb := newTestBridge(t, nil)
+	defer tb.Close()
+	d := tb.Bridge.discord
+
+	carol := tb.discordMember("200", "carol", "Caz")
+	nick := d.ircNick(carol)
+	assert.Contains(t, nick, "Caz")
+
+	// Resolutions are cached until the member changes
+	member := &discordgo.Member{GuildID: testGuildID, User: carol, Nick: "Carrie"}
+	d.State.MemberAdd(member)
+	assert.Equal(t, nick, d.ircNick(carol))
+
+	d.handleMemberUpdate(member, false)
+	assert.Contains(t, d.ircNick(carol), "Carrie")
+
+	// and until their puppet changes
+	puppet := tb.puppet(t, carol, "Carrie")
+	assert.Equal(t, puppet, d.ircNick(carol))
+}
+
+func TestRenderMentions(t *testing.T) {
+	tb := newTestBridge(t, nil)
+	defer tb.Close()
+	d := tb.Bridge.discord
+
+	// Users without a puppet are rendered as the nick they would get
+	carol := tb.discordMember("200", "carol", "Caz")
+	text := d.ParseText(&discordgo.Message{
+		Content:  "hey <@200> and <@!200>",
+		Mentions: []*discordgo.User{carol},
+	})
+	nick := d.ircNick(carol)
+	assert.Contains(t, nick, "Caz")
+	assert.Equal(t, "hey "+nick+" and "+nick, text)
+}
+
+func TestRenderInteractions(t *testing.T) {
+	tb := newTestBridge(t, nil)
+	defer tb.Close()
+	d := tb.Bridge.discord
+
+	bob := tb.discordMember("100", "bob", "")
+	nick := d.ircNick(bob)
+
+	assert.Equal(t, nick+" used /roll", d.interactionPrefix(&discordgo.Message{
+		Type:        discordgo.MessageTypeChatInputCommand,
+		Interaction: &discordgo.MessageInteraction{Name: "roll", User: bob},
+	}))
+	assert.Equal(t, nick+" used Translate", d.interactionPrefix(&discordgo.Message{
+		Type:        discordgo.MessageTypeContextMenuCommand,
+		Interaction: &discordgo.MessageInteraction{Name: "Translate", User: bob},
+	}))
+	assert.Equal(t, nick+" used a command", d.interactionPrefix(&discordgo.Message{
+		Type:                discordgo.MessageTypeChatInputCommand,
+		InteractionMetadata: &discordgo.MessageInteractionMetadata{User: bob},
+	}))
+	assert.Equal(t, "", d.interactionPrefix(&discordgo.Message{Type: discordgo.MessageTypeDefault}))
+}
@@ -0,0 +1,165 @@
+package bridge
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// messageMapLimit is the number of relayed messages remembered by a messageMap
+var messageMapLimit = 500
+
+// relayedMessage is a message that has been relayed across the bridge.
+type relayedMessage struct {
+	DiscordChannel string
+	DiscordID      string // ID of the message on Discord (our webhook message for IRC messages)
+
+	DiscordWebhookID string // ID of the webhook that sent the message, for messages from IRC
+
+	DiscordAuthorID string // ID of the Discord sender, empty if the message came from IRC
+	DiscordAuthor   string // username of the Discord sender
+
+	IRCChannel string
+	IRCNick    string // nick of the IRC sender, empty if the message came from Discord
+	IRCAccount string // services account of the IRC sender, if known
+	IRCMsgID   string // IRCv3 msgid of the message, if the server supports message-tags
+
+	IRCHostmask string // nick!user@host of the IRC sender
+
+	Content string
+	Time    time.Time
+}
+
+// messageMap remembers recently relayed messages so that Discord
+// and IRC counterparts can be looked up later on.
+//
+// It is safe for concurrent use.
+type messageMap struct {
+	mu       sync.Mutex
+	messages []*relayedMessage
+}
+
+func newMessageMap() *messageMap {
+	return &messageMap{}
+}
+
+// Add records a relayed message, forgetting the oldest one if the map is full.
+func (m *messageMap) Add(msg *relayedMessage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, msg)
+	if len(m.messages) > messageMapLimit {
+		m.messages = m.messages[len(m.messages)-messageMapLimit:]
+	}
+}
+
+// LatestFromIRC returns the most recent message relayed from the given IRC channel
+// containing the fragment (case insensitive). An empty fragment matches everything.
+//
+// Returns nil if no message could be found.
+func (m *messageMap) LatestFromIRC(ircChannel string, fragment string) *relayedMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fragment = strings.ToLower(fragment)
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		msg := m.messages[i]
+		if msg.IRCNick == "" || !strings.EqualFold(msg.IRCChannel, ircChannel) {
+			continue
+		}
+
+		if strings.Contains(strings.ToLower(msg.Content), fragment) {
+			return msg
+		}
+	}
+
+	return nil
+}
+
+// LatestFromDiscord returns the most recent message relayed from the given Discord channel,
+// sent by the Discord user with the given ID or username. An empty author matches everyone.
+//
+// Returns nil if no message could be found.
+func (m *messageMap) LatestFromDiscord(discordChannel string, author string) *relayedMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		msg := m.messages[i]
+		if msg.IRCNick != "" || msg.DiscordChannel != discordChannel {
+			continue
+		}
+
+		if author == "" || msg.DiscordAuthorID == author || strings.EqualFold(msg.DiscordAuthor, author) {
+			return msg
+		}
+	}
+
+	return nil
+}
+
+// ByIRCMsgID returns the message with the given IRCv3 msgid, or nil if it could not be found.
+func (m *messageMap) ByIRCMsgID(msgID string) *relayedMessage {
+	if msgID == "" {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].IRCMsgID == msgID {
+			return m.messages[i]
+		}
+	}
+	return nil
+}
+
+// ByDiscordID returns the message with the given Discord ID, or nil if it could not be found.
+func (m *messageMap) ByDiscordID(discordID string) *relayedMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].DiscordID == discordID {
+			return m.messages[i]
+		}
+	}
+	return nil
+}
+
+// Len returns the number of messages remembered.
+func (m *messageMap) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.messages)
+}
+
+// Forget removes the messages that match, returning how many there were.
+func (m *messageMap) Forget(match func(*relayedMessage) bool) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := m.messages[:0]
+	for _, msg := range m.messages {
+		if !match(msg) {
+			kept = append(kept, msg)
+		}
+	}
+	forgotten := len(m.messages) - len(kept)
+
+	// Don't leave forgotten messages behind in the backing array
+	for i := len(kept); i < len(m.messages); i++ {
+		m.messages[i] = nil
+	}
+	m.messages = kept
+	return forgotten
+}
+
+// SetContent updates the content recorded for a message, after it has been edited.
+func (m *messageMap) SetContent(msg *relayedMessage, content string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	msg.Content = content
+}
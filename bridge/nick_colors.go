@@ -0,0 +1,122 @@
+package bridge
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	nickColorsRole = "role" // the colour of the member's top role, or nickColorsHash if it has none
+	nickColorsHash = "hash" // a colour picked from a hash of the member's ID
+)
+
+// mircColors are the mIRC colours nicks can be given, with their usual RGB values.
+// White, black and greys are left out, so nicks are readable on light and dark themes.
+var mircColors = []struct {
+	code int
+	rgb  int
+}{
+	{2, 0x00007F}, {3, 0x009300}, {4, 0xFF0000}, {5, 0x7F0000},
+	{6, 0x9C009C}, {7, 0xFC7F00}, {8, 0xFFFF00}, {9, 0x00FC00},
+	{10, 0x009393}, {11, 0x00FFFF}, {12, 0x0000FC}, {13, 0xFF00FF},
+}
+
+func validateNickColors(setting string) error {
+	switch setting {
+	case "", nickColorsRole, nickColorsHash:
+		return nil
+	}
+	return errors.Errorf("unknown nick_colors %q, should be %q or %q", setting, nickColorsRole, nickColorsHash)
+}
+
+// nearestMIRCColor returns the mIRC colour closest to an RGB colour. Colours are compared by hue first,
+// as that is what people notice, so Discord's red (#E74C3C) is red rather than orange.
+// It returns -1 for greys, which have no mIRC colour here.
+func nearestMIRCColor(rgb int) int {
+	hue, saturation, value := hsv(rgb)
+	if saturation < 0.15 {
+		return -1
+	}
+
+	best, bestDistance := mircColors[0].code, -1.0
+	for _, c := range mircColors {
+		h, _, v := hsv(c.rgb)
+		dh := math.Abs(hue - h)
+		if dh > 180 {
+			dh = 360 - dh
+		}
+		dv := (value - v) * 180
+		if distance := dh*dh + dv*dv; bestDistance < 0 || distance < bestDistance {
+			best, bestDistance = c.code, distance
+		}
+	}
+	return best
+}
+
+// hsv returns the hue (0-360), saturation and value (0-1) of an RGB colour.
+func hsv(rgb int) (hue, saturation, value float64) {
+	r := float64((rgb>>16)&0xFF) / 255
+	g := float64((rgb>>8)&0xFF) / 255
+	b := float64(rgb&0xFF) / 255
+
+	max := math.Max(r, math.Max(g, b))
+	min := math.Min(r, math.Min(g, b))
+	if max == min {
+		return 0, 0, max
+	}
+
+	switch max {
+	case r:
+		hue = 60 * math.Mod((g-b)/(max-min), 6)
+	case g:
+		hue = 60 * ((b-r)/(max-min) + 2)
+	default:
+		hue = 60 * ((r-g)/(max-min) + 4)
+	}
+	if hue < 0 {
+		hue += 360
+	}
+	return hue, (max - min) / max, max
+}
+
+// hashMIRCColor returns an mIRC colour that is always the same for the same ID.
+func hashMIRCColor(id string) int {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return mircColors[h.Sum32()%uint32(len(mircColors))].code
+}
+
+// nickColor returns the mIRC colour to give a Discord user's name in an IRC channel, or -1 for none.
+func (b *Bridge) nickColor(ircChannel string, user DiscordUser) int {
+	setting := b.channelOptions(ircChannel).NickColors
+	if setting == "" || user.ID == "" {
+		return -1
+	}
+
+	// Colours would stop the message being sent at all
+	if b.ircListener.users.HasMode(ircChannel, 'c') {
+		return -1
+	}
+
+	if setting == nickColorsRole {
+		if mapping := b.GetMappingByIRC(ircChannel); mapping != nil {
+			if color := nearestMIRCColor(b.discord.State.UserColor(user.ID, mapping.DiscordChannel)); color >= 0 {
+				return color
+			}
+		}
+	}
+
+	return hashMIRCColor(user.ID)
+}
+
+// colorNick surrounds a name with an mIRC colour code, unless the colour is -1.
+func colorNick(name string, color int) string {
+	if color < 0 {
+		return name
+	}
+	// Always use two digits, in case the name starts with one
+	return fmt.Sprintf("\x03%02d%s\x03", color, name)
+}
@@ -0,0 +1,44 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNearestMIRCColor(t *testing.T) {
+	assert.Equal(t, 4, nearestMIRCColor(0xE74C3C))
+	assert.Equal(t, 12, nearestMIRCColor(0x0000FF))
+	assert.Equal(t, 5, nearestMIRCColor(0x992D22))
+	assert.Equal(t, 3, nearestMIRCColor(0x1F8B4C))
+	assert.Equal(t, -1, nearestMIRCColor(0x95A5A6))
+	assert.Equal(t, -1, nearestMIRCColor(0))
+	assert.Equal(t, hashMIRCColor("100"), hashMIRCColor("100"))
+}
+
+func TestNickColors(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.ChannelOptions = map[string]ChannelOptions{testChannel: {NickColors: nickColorsRole}}
+	})
+	defer tb.Close()
+
+	guild, _ := tb.Bridge.discord.State.Guild(testGuildID)
+	assert.NoError(t, tb.Bridge.discord.State.RoleAdd(testGuildID, &discordgo.Role{ID: "500", Name: "red", Color: 0xE74C3C, Position: 1}))
+	bob := tb.discordMember("100", "bob", "")
+	member, _ := tb.Bridge.discord.State.Member(guild.ID, bob.ID)
+	member.Roles = []string{"500"}
+	carol := tb.discordMember("200", "carol", "")
+
+	tb.discordSay(bob, "hello")
+	waitFor(t, "role coloured nick", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<\x0304b\u200Bob#0001\x03> hello")
+	})
+
+	tb.discordSay(carol, "hi")
+	waitFor(t, "hash coloured nick", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<"+colorNick("c\u200Barol#0001", hashMIRCColor("200"))+"> hi")
+	})
+
+	assert.Error(t, validateNickColors("rainbow"))
+}
@@ -0,0 +1,56 @@
+package bridge
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	ircnick "github.com/qaisjp/go-discord-irc/irc/nick"
+)
+
+// nickPlaceholder matches a placeholder in the puppet nick template
+var nickPlaceholder = regexp.MustCompile(`\{[a-z]+\}`)
+
+// validateNickTemplate checks that a puppet nick template only uses known placeholders,
+// and includes something that identifies the Discord user.
+func validateNickTemplate(template string) error {
+	if template == "" {
+		return nil
+	}
+
+	for _, placeholder := range nickPlaceholder.FindAllString(template, -1) {
+		switch placeholder {
+		case "{nick}", "{username}", "{discriminator}", "{id}":
+		default:
+			return errors.Errorf("unknown placeholder %s in puppet nick template", placeholder)
+		}
+	}
+
+	if !strings.Contains(template, "{nick}") && !strings.Contains(template, "{username}") && !strings.Contains(template, "{id}") {
+		return errors.New("puppet nick template must contain {nick}, {username} or {id}")
+	}
+	return nil
+}
+
+// templateNick fills in the puppet nick template for a Discord user. The result still needs sanitising.
+func (m *IRCManager) templateNick(discord DiscordUser) string {
+	template := m.bridge.Config.PuppetNickTemplate
+	if template == "" {
+		return discord.Nick
+	}
+
+	return strings.NewReplacer(
+		"{nick}", discord.Nick,
+		"{username}", discord.Username,
+		"{discriminator}", discord.Discriminator,
+		"{id}", discord.ID,
+	).Replace(template)
+}
+
+// nickMaxLength is the longest nick a puppet can have, including the suffix.
+func (m *IRCManager) nickMaxLength() int {
+	if length := m.bridge.Config.PuppetNickMaxLength; length > 0 {
+		return length
+	}
+	return ircnick.MAXLENGTH
+}
@@ -0,0 +1,49 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransliterate(t *testing.T) {
+	assert.Equal(t, "Lukasz", transliterate("Łukasz"))
+	assert.Equal(t, "Dong Jing", transliterate("東京"))
+	assert.Equal(t, "🔴🔴", transliterate("🔴🔴"))
+}
+
+func TestValidateNickTemplate(t *testing.T) {
+	assert.NoError(t, validateNickTemplate(""))
+	assert.NoError(t, validateNickTemplate("{nick}[d]"))
+	assert.Error(t, validateNickTemplate("{name}"))
+	assert.Error(t, validateNickTemplate("{discriminator}"))
+}
+
+func TestNickTemplate(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.PuppetNickTemplate = "{nick}[d]"
+		conf.PuppetNickMaxLength = 12
+	})
+	defer tb.Close()
+
+	m := tb.Bridge.ircManager
+	assert.Equal(t, "Lukasz[d]_d", m.generateNickname(DiscordUser{ID: "100", Nick: "Łukasz", Username: "lukasz", Discriminator: "0001"}))
+
+	// Nicks that are too long fall back to the username, shortened to fit
+	assert.Equal(t, "barba_0002_d", m.generateNickname(DiscordUser{ID: "200", Nick: "Barbara Liskov", Username: "barbara", Discriminator: "0002"}))
+}
+
+func TestNickTransliteration(t *testing.T) {
+	tb := newTestBridge(t, nil)
+	defer tb.Close()
+
+	m := tb.Bridge.ircManager
+	assert.Equal(t, "Dong_Jing_d", m.generateNickname(DiscordUser{ID: "100", Nick: "東京", Username: "tokyo", Discriminator: "0"}))
+
+	// Names that can't be transliterated use the fallback, so they don't all become "__d"
+	assert.Equal(t, "red_0001_d", m.generateNickname(DiscordUser{ID: "200", Nick: "🔴🔴", Username: "red", Discriminator: "0001"}))
+
+	// Discord members whose names transliterate to the same nick both use the fallback
+	tb.discordMember("300", "Lukasz", "")
+	assert.Equal(t, "lukasz_0004_d", m.generateNickname(DiscordUser{ID: "400", Nick: "Łukasz", Username: "lukasz", Discriminator: "0004"}))
+}
@@ -0,0 +1,25 @@
+package bridge
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/mozillazg/go-unidecode"
+)
+
+// normalizeContent returns the text of a message as content filters see it: without invisible
+// characters like zero-width spaces and combining marks, with lookalike characters (Cyrillic,
+// fullwidth and mathematical letters) folded to ASCII, and in lowercase. "ｆrее\u200Bcoins"
+// becomes "freecoins", so that filters can't be got around by disguising words.
+// Filters decide on the normalized text, but what is relayed is unchanged.
+func normalizeContent(text string) string {
+	visible := strings.Map(func(r rune) rune {
+		// Zero-width characters are formatting characters, and marks stack on letters
+		if unicode.Is(unicode.Cf, r) || unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Me, r) {
+			return -1
+		}
+		return r
+	}, text)
+
+	return strings.ToLower(unidecode.Unidecode(visible))
+}
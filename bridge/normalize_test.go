@@ -0,0 +1,21 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeContent(t *testing.T) {
+	for in, want := range map[string]string{
+		"free coins":                                     "free coins",
+		"\uff46\uff52\uff45\uff45 coins":                 "free coins", // fullwidth
+		"fr\u0435\u0435 coins":                           "free coins", // Cyrillic
+		"\U0001d41f\U0001d42b\U0001d41e\U0001d41e coins": "free coins", // mathematical bold
+		"f\u200Br\u200Dee coins":                         "free coins", // zero-width
+		"fre\u0301e\u0336 coins":                         "free coins", // combining marks
+		"FREE\u00ADCOINS":                                "freecoins",  // soft hyphen
+	} {
+		assert.Equal(t, want, normalizeContent(in), in)
+	}
+}
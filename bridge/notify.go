@@ -0,0 +1,198 @@
+package bridge
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	irc "github.com/qaisjp/go-ircevent"
+	log "github.com/sirupsen/logrus"
+)
+
+// notifyBucket is the store bucket containing keyword subscriptions, keyed by notifyKey
+const notifyBucket = "notify"
+
+// notifyKeywordLimit is the number of keywords each IRC user can subscribe to
+var notifyKeywordLimit = 20
+
+// notifySubscription is the list of keywords an IRC user wants to be notified about.
+type notifySubscription struct {
+	Nick     string
+	Account  string // services account, empty if unknown
+	Keywords []string
+}
+
+// notifyKey returns the store key for an IRC user's subscription.
+//
+// Subscriptions belong to the services account if there is one, so they survive nick changes.
+func notifyKey(nick, account string) string {
+	if account != "" {
+		return "account:" + strings.ToLower(account)
+	}
+	return "nick:" + strings.ToLower(nick)
+}
+
+// keywordPatterns are the compiled patterns for subscribed keywords, so each is compiled
+// once rather than for every relayed message.
+type keywordPatterns struct {
+	mu       sync.Mutex
+	patterns map[string]*regexp.Regexp
+}
+
+// Get returns the pattern matching the keyword as a whole word (case insensitive),
+// compiling it the first time it is asked for.
+func (k *keywordPatterns) Get(keyword string) *regexp.Regexp {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if re, ok := k.patterns[keyword]; ok {
+		return re
+	}
+	if k.patterns == nil {
+		k.patterns = make(map[string]*regexp.Regexp)
+	}
+	re := regexp.MustCompile(`(?i)(^|\W)` + regexp.QuoteMeta(keyword) + `($|\W)`)
+	k.patterns[keyword] = re
+	return re
+}
+
+// handleNotify handles "!notify add|remove|list [keyword]" from an IRC user.
+func (i *ircListener) handleNotify(e *irc.Event, args []string) {
+	account := i.account(e)
+	key := notifyKey(e.Nick, account)
+
+	sub := &notifySubscription{}
+	if _, err := i.bridge.store.Get(notifyBucket, key, sub); err != nil {
+		log.WithField("error", err).Errorln("could not read keyword subscriptions")
+		i.Notice(e.Nick, "Something went wrong reading your keywords, sorry.")
+		return
+	}
+	sub.Nick = e.Nick
+	sub.Account = account
+
+	if len(args) == 0 {
+		i.Notice(e.Nick, "Usage: !notify add <keyword>, !notify remove <keyword>, !notify list")
+		return
+	}
+
+	keyword := strings.ToLower(strings.Join(args[1:], " "))
+
+	switch args[0] {
+	case "list":
+		if len(sub.Keywords) == 0 {
+			i.Notice(e.Nick, "You are not subscribed to any keywords.")
+		} else {
+			i.Noticef(e.Nick, "Your keywords: %s", strings.Join(sub.Keywords, ", "))
+		}
+		return
+	case "add":
+		if keyword == "" {
+			i.Notice(e.Nick, "Usage: !notify add <keyword>")
+			return
+		}
+		// Otherwise notifications would go to whoever has the nick next
+		if account == "" {
+			i.Notice(e.Nick, "You need to be logged in to services to subscribe to keywords.")
+			return
+		}
+		for _, kw := range sub.Keywords {
+			if kw == keyword {
+				i.Noticef(e.Nick, "You are already subscribed to %q.", keyword)
+				return
+			}
+		}
+		if len(sub.Keywords) >= notifyKeywordLimit {
+			i.Noticef(e.Nick, "You can only subscribe to %d keywords.", notifyKeywordLimit)
+			return
+		}
+		sub.Keywords = append(sub.Keywords, keyword)
+		i.bridge.notifyPatterns.Get(keyword)
+	case "remove":
+		kept := sub.Keywords[:0]
+		for _, kw := range sub.Keywords {
+			if kw != keyword {
+				kept = append(kept, kw)
+			}
+		}
+		if len(kept) == len(sub.Keywords) {
+			i.Noticef(e.Nick, "You are not subscribed to %q.", keyword)
+			return
+		}
+		sub.Keywords = kept
+	default:
+		i.Notice(e.Nick, "Usage: !notify add <keyword>, !notify remove <keyword>, !notify list")
+		return
+	}
+
+	var err error
+	if len(sub.Keywords) == 0 {
+		err = i.bridge.store.Delete(notifyBucket, key)
+	} else {
+		err = i.bridge.store.Put(notifyBucket, key, sub)
+	}
+	if err != nil {
+		log.WithField("error", err).Errorln("could not save keyword subscriptions")
+		i.Notice(e.Nick, "Something went wrong saving your keywords, sorry.")
+		return
+	}
+
+	if args[0] == "add" {
+		i.Noticef(e.Nick, "You will be notified when %q is mentioned on Discord.", keyword)
+	} else {
+		i.Noticef(e.Nick, "You will no longer be notified about %q.", keyword)
+	}
+}
+
+// notifySubscribers sends a NOTICE to IRC users subscribed to keywords in a Discord message.
+func (b *Bridge) notifySubscribers(ircChannel string, msg *DiscordMessage) {
+	keys := b.store.Keys(notifyBucket)
+	if len(keys) == 0 || msg.Author == nil {
+		return
+	}
+
+	author := b.linkByDiscord(msg.Author.ID)
+	ircChannel = strings.Split(ircChannel, " ")[0]
+	excerpt := fmt.Sprintf("[%s] <%s> %s", ircChannel, msg.Author.Username, TruncateString(200, msg.Content))
+
+	for _, key := range keys {
+		sub := &notifySubscription{}
+		if ok, err := b.store.Get(notifyBucket, key, sub); !ok || err != nil {
+			continue
+		}
+
+		// Find the nick the subscriber is currently using. Subscriptions from before an
+		// account was needed could go to anyone with the nick, so they are skipped.
+		if sub.Account == "" {
+			continue
+		}
+		u, ok := b.ircListener.users.ByAccount(sub.Account)
+		if !ok {
+			continue
+		}
+		nick := u.Nick
+
+		// Only people in the channel can see what is said in it
+		if _, ok := b.ircListener.users.Prefixes(ircChannel, nick); !ok {
+			continue
+		}
+
+		// Don't notify people about their own messages
+		if author != nil && (strings.EqualFold(author.IRCNick, nick) || (author.IRCAccount != "" && strings.EqualFold(author.IRCAccount, sub.Account))) {
+			continue
+		}
+
+		for _, kw := range sub.Keywords {
+			if b.notifyPatterns.Get(kw).MatchString(msg.Content) {
+				b.ircListener.Notice(nick, excerpt)
+				break
+			}
+		}
+	}
+}
+
+func init() {
+	registerChatCommand(&chatCommand{
+		Name: "notify",
+		IRC:  (*ircListener).handleNotify,
+	})
+}
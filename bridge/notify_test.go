@@ -0,0 +1,49 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeywordPatterns(t *testing.T) {
+	var k keywordPatterns
+	assert.True(t, k.Get("kubernetes").MatchString("We should use Kubernetes here"))
+	assert.True(t, k.Get("kubernetes").MatchString("kubernetes!"))
+	assert.True(t, k.Get("c++").MatchString("what about c++?"))
+	assert.False(t, k.Get("kubernetes").MatchString("kubernetesish"))
+	assert.False(t, k.Get("kubernetes").MatchString("nothing to see"))
+	assert.True(t, k.Get("kubernetes") == k.Get("kubernetes"))
+}
+
+func TestNotifyOnlyChannelMembers(t *testing.T) {
+	tb := newTestBridge(t, nil)
+	defer tb.Close()
+
+	for _, nick := range []string{"alice", "mallory", "bob"} {
+		assert.NoError(t, tb.Bridge.store.Put(notifyBucket, notifyKey(nick, nick), &notifySubscription{Nick: nick, Account: nick, Keywords: []string{"deploy"}}))
+	}
+	assert.NoError(t, tb.Bridge.store.Put(notifyBucket, notifyKey("carol", ""), &notifySubscription{Nick: "carol", Keywords: []string{"deploy"}}))
+	users := tb.Bridge.ircListener.users
+	users.handleJoin(testChannel, "alice", "a", "host.a", "alice", true)
+	users.handleJoin(testChannel, "carol", "c", "host.c", "", true)
+	users.handleJoin("#secret", "mallory", "m", "host.m", "mallory", true)
+
+	author := tb.discordMember("100", "dave", "")
+	tb.Bridge.notifySubscribers(testChannel, &DiscordMessage{Message: &discordgo.Message{Author: author}, Content: "time to deploy"})
+
+	notice := "[" + testChannel + "] <dave> time to deploy"
+	waitFor(t, "notice to alice", func() bool {
+		return tb.ircd.HasReceived("listener", "NOTICE alice :"+notice)
+	})
+	// Not in the channel, not online, or with no account
+	assert.False(t, tb.ircd.HasReceived("listener", "NOTICE mallory :"+notice))
+	assert.False(t, tb.ircd.HasReceived("listener", "NOTICE bob :"+notice))
+	assert.False(t, tb.ircd.HasReceived("listener", "NOTICE carol :"+notice))
+}
+
+func TestNotifyKey(t *testing.T) {
+	assert.Equal(t, "account:alice", notifyKey("Alice_", "Alice"))
+	assert.Equal(t, "nick:alice_", notifyKey("Alice_", ""))
+}
@@ -0,0 +1,94 @@
+package bridge
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	irc "github.com/qaisjp/go-ircevent"
+	log "github.com/sirupsen/logrus"
+)
+
+// onlineListLimit is the most names listed for each status by !online
+var onlineListLimit = 25
+
+// onlineStatuses are the statuses listed by !online, in order
+var onlineStatuses = []struct {
+	status discordgo.Status
+	name   string
+}{
+	{discordgo.StatusOnline, "Online"},
+	{discordgo.StatusIdle, "Idle"},
+	{discordgo.StatusDoNotDisturb, "Do not disturb"},
+}
+
+// onlineSummary lists the Discord members who can see a channel and aren't offline, grouped by status.
+func (d *discordBot) onlineSummary(channelID string) []string {
+	guild, err := d.State.Guild(d.guildID)
+	if err != nil {
+		log.WithField("error", err).Warnln("could not get guild to list online members")
+		return nil
+	}
+
+	names := map[discordgo.Status][]string{}
+	for _, presence := range guild.Presences {
+		if presence.User == nil {
+			continue
+		}
+
+		member, err := d.State.Member(d.guildID, presence.User.ID)
+		if err != nil || member.User.Bot {
+			continue
+		}
+
+		perms, err := d.State.UserChannelPermissions(member.User.ID, channelID)
+		if err != nil || perms&discordgo.PermissionViewChannel == 0 {
+			continue
+		}
+
+		names[presence.Status] = append(names[presence.Status], GetMemberNick(member))
+	}
+
+	lines := []string{}
+	for _, s := range onlineStatuses {
+		list := names[s.status]
+		if len(list) == 0 {
+			continue
+		}
+
+		sort.Strings(list)
+		line := fmt.Sprintf("%s (%d): ", s.name, len(list))
+		if len(list) > onlineListLimit {
+			line += strings.Join(list[:onlineListLimit], ", ") + fmt.Sprintf(" and %d more", len(list)-onlineListLimit)
+		} else {
+			line += strings.Join(list, ", ")
+		}
+		lines = append(lines, line)
+	}
+
+	if len(lines) == 0 {
+		lines = append(lines, "Nobody is online on Discord.")
+	}
+	return lines
+}
+
+// handleOnline replies to "!online" with who is online in the mapped Discord channel.
+func (i *ircListener) handleOnline(e *irc.Event, args []string) {
+	channel := e.Arguments[0]
+	mapping := i.bridge.GetMappingByIRC(channel)
+	if mapping == nil {
+		return
+	}
+
+	for _, line := range i.bridge.discord.onlineSummary(mapping.DiscordChannel) {
+		i.Notice(channel, line)
+	}
+}
+
+func init() {
+	registerChatCommand(&chatCommand{
+		Name: "online",
+		IRC:  (*ircListener).handleOnline,
+	})
+}
@@ -0,0 +1,37 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOnlineSummary(t *testing.T) {
+	d := newFuzzDiscord(t)
+
+	guild, _ := d.State.Guild(testGuildID)
+	guild.Roles = append(guild.Roles, &discordgo.Role{ID: testGuildID, Permissions: discordgo.PermissionViewChannel})
+
+	assert.Equal(t, []string{"Nobody is online on Discord."}, d.onlineSummary(testChannelID))
+
+	add := func(id, name string, status discordgo.Status, bot bool) {
+		user := &discordgo.User{ID: id, Username: name, Bot: bot}
+		assert.NoError(t, d.State.MemberAdd(&discordgo.Member{GuildID: testGuildID, User: user}))
+		assert.NoError(t, d.State.PresenceAdd(testGuildID, &discordgo.Presence{User: user, Status: status}))
+	}
+	add("101", "carol", discordgo.StatusOnline, false)
+	add("102", "bob", discordgo.StatusOnline, false)
+	add("103", "dave", discordgo.StatusIdle, false)
+	add("104", "erin", discordgo.StatusOffline, false)
+	add("105", "robot", discordgo.StatusOnline, true)
+
+	assert.Equal(t, []string{
+		"Online (2): bob, carol",
+		"Idle (1): dave",
+	}, d.onlineSummary(testChannelID))
+
+	onlineListLimit = 1
+	defer func() { onlineListLimit = 25 }()
+	assert.Equal(t, "Online (2): bob and 1 more", d.onlineSummary(testChannelID)[0])
+}
@@ -0,0 +1,132 @@
+package bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	irc "github.com/qaisjp/go-ircevent"
+	log "github.com/sirupsen/logrus"
+)
+
+// operatorReplyLength is how much of a Discord API response is sent back to the operator
+const operatorReplyLength = 400
+
+func validateOperators(opts *Config) error {
+	if opts.OperatorPrefix == "" {
+		return nil
+	}
+	if len(opts.OperatorDiscordIDs) == 0 && len(opts.OperatorIRCAccounts) == 0 {
+		return errors.New("operator_prefix needs operator_discord_ids or operator_irc_accounts")
+	}
+	if opts.OperatorPrefix == opts.CommandPrefix {
+		return errors.New("operator_prefix must be different from command_prefix")
+	}
+	return nil
+}
+
+// operatorCommand returns the operator command in a private message, like "raw PRIVMSG #help :hi",
+// or "" if it doesn't start with the operator prefix.
+func (b *Bridge) operatorCommand(message string) string {
+	prefix := b.Config.OperatorPrefix
+	if prefix == "" || !strings.HasPrefix(message, prefix) {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(message, prefix))
+}
+
+// operatorAudit logs an operator command, and tells moderators in the audit and report channels.
+func (b *Bridge) operatorAudit(who, command string) {
+	log.WithFields(log.Fields{
+		"operator": who,
+		"command":  command,
+	}).Warnln("Running an operator command.")
+
+	message := fmt.Sprintf("Operator %s ran: %s", who, command)
+	if channel := b.Config.AuditIRCChannel; channel != "" {
+		b.ircListener.Notice(channel, "[bridge] "+message)
+	}
+	if channel := b.Config.ReportDiscordChannel; channel != "" {
+		if _, err := b.discord.ChannelMessageSendComplex(channel, &discordgo.MessageSend{
+			Content:         "**[bridge]** " + sanitiseDiscordContent(message),
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		}); err != nil {
+			b.handleError(err, nil, "could not post operator audit to discord")
+		}
+	}
+}
+
+// runOperator runs an operator command: "raw <line>" sends a line to the IRC server as the listener,
+// and "discord <method> <path> [json]" calls the Discord API as the bot. It returns the reply.
+func (b *Bridge) runOperator(who, command string) string {
+	b.operatorAudit(who, command)
+
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return "Operator commands: raw <line>, discord <method> <path> [json]"
+	}
+
+	switch strings.ToLower(fields[0]) {
+	case "raw":
+		line := strings.TrimSpace(command[len(fields[0]):])
+		if line == "" || strings.ContainsAny(line, "\r\n") {
+			return "Usage: raw <line>"
+		}
+		b.ircListener.SendRaw(line)
+		return "Sent to IRC."
+
+	case "discord":
+		if len(fields) < 3 {
+			return "Usage: discord <method> <path> [json]"
+		}
+		method := strings.ToUpper(fields[1])
+		path := "/" + strings.TrimPrefix(fields[2], "/")
+
+		var body interface{}
+		if rest := strings.TrimSpace(command[strings.Index(command, fields[2])+len(fields[2]):]); rest != "" {
+			if !json.Valid([]byte(rest)) {
+				return "The body must be JSON."
+			}
+			body = json.RawMessage(rest)
+		}
+
+		response, err := b.discord.RequestWithBucketID(method, strings.TrimSuffix(discordgo.EndpointAPI, "/")+path, body, path)
+		if err != nil {
+			b.handleError(err, log.Fields{"method": method, "path": path}, "operator discord request failed")
+			return "Discord said: " + err.Error()
+		}
+		return "Discord said: " + TruncateString(operatorReplyLength, strings.TrimSpace(string(response)))
+	}
+
+	return "Unknown operator command " + fields[0] + ". Operator commands: raw <line>, discord <method> <path> [json]"
+}
+
+// runOperator runs an operator command sent to the bot in a DM, returning false if the message isn't one.
+func (d *discordBot) runOperator(m *discordgo.Message) bool {
+	command := d.bridge.operatorCommand(m.Content)
+	if command == "" || m.GuildID != "" || m.WebhookID != "" || !containsFold(d.bridge.Config.OperatorDiscordIDs, m.Author.ID) {
+		return false
+	}
+
+	if d.bridge.Config.Shadow {
+		shadowed(log.Fields{"author": m.Author.ID, "command": command}, "run an operator command")
+		return true
+	}
+	d.reply(m, d.bridge.runOperator("discord:"+m.Author.ID, command))
+	return true
+}
+
+// runOperator runs an operator command sent to the listener in a private message,
+// returning false if the message isn't one. Operators are known by their services account.
+func (i *ircListener) runOperator(e *irc.Event) bool {
+	command := i.bridge.operatorCommand(e.Message())
+	account := i.account(e)
+	if command == "" || account == "" || !containsFold(i.bridge.Config.OperatorIRCAccounts, account) {
+		return false
+	}
+
+	i.Notice(e.Nick, strings.Replace(i.bridge.runOperator("irc:"+account, command), "\n", " ", -1))
+	return true
+}
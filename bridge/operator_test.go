@@ -0,0 +1,56 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOperatorCommands(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.OperatorPrefix = "!!"
+		conf.OperatorDiscordIDs = []string{"100"}
+		conf.OperatorIRCAccounts = []string{"alice"}
+	})
+	defer tb.Close()
+
+	// Operators are known by their account on IRC
+	tb.ircd.SendTo("listener", "@account=mallory :mallory!m@host PRIVMSG listener :!!raw KICK %s alice", testChannel)
+	tb.ircd.SendTo("listener", "@account=alice :alice!a@host PRIVMSG listener :!!raw MODE %s +m", testChannel)
+	waitFor(t, "raw line", func() bool {
+		return tb.ircd.HasReceived("listener", "MODE "+testChannel+" +m")
+	})
+	waitFor(t, "reply", func() bool {
+		return tb.ircd.HasReceived("listener", "NOTICE alice :Sent to IRC.")
+	})
+	assert.False(t, tb.ircd.HasReceived("listener", "KICK "+testChannel+" alice"))
+
+	// and by their ID on Discord, in a DM
+	bob := tb.discordMember("100", "bob", "")
+	tb.Bridge.discord.publishMessage(tb.Bridge.discord.Session, &discordgo.Message{
+		ID:        tb.discord.id(),
+		ChannelID: "3000",
+		Author:    bob,
+		Content:   `!!discord POST /channels/` + testChannelID + `/messages {"content": "fixed"}`,
+		Type:      discordgo.MessageTypeDefault,
+		Timestamp: time.Now(),
+	}, false)
+	_, ok := tb.discord.Find("fixed")
+	assert.True(t, ok)
+
+	found := false
+	for _, msg := range tb.discord.Sent() {
+		found = found || (msg.ChannelID == "3000" && strings.HasPrefix(msg.Content, "Discord said: "))
+	}
+	assert.True(t, found)
+}
+
+func TestValidateOperators(t *testing.T) {
+	assert.NoError(t, validateOperators(&Config{}))
+	assert.NoError(t, validateOperators(&Config{OperatorPrefix: "!!", OperatorDiscordIDs: []string{"100"}, CommandPrefix: "!"}))
+	assert.Error(t, validateOperators(&Config{OperatorPrefix: "!!"}))
+	assert.Error(t, validateOperators(&Config{OperatorPrefix: "!", OperatorIRCAccounts: []string{"alice"}, CommandPrefix: "!"}))
+}
@@ -0,0 +1,117 @@
+package bridge
+
+import (
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	irc "github.com/qaisjp/go-ircevent"
+	log "github.com/sirupsen/logrus"
+)
+
+// optOutBucket is the store bucket of people who don't want to be relayed,
+// keyed like karma so that linked identities opt out together
+const optOutBucket = "optout"
+
+// withheldMarker is relayed in place of messages from people who have opted out
+const withheldMarker = "[message withheld]"
+
+// optOut is someone who has asked not to be relayed.
+type optOut struct {
+	Name  string
+	Since time.Time
+}
+
+// optedOut returns true if the person with the given identity key has opted out of relaying.
+func (b *Bridge) optedOut(key string) bool {
+	ok, err := b.store.Get(optOutBucket, key, &optOut{})
+	if err != nil {
+		log.WithField("error", err).Errorln("could not read relay opt-outs")
+	}
+	return ok
+}
+
+// setOptOut opts someone out of relaying, or back in.
+func (b *Bridge) setOptOut(key, name string, out bool) error {
+	if !out {
+		return b.store.Delete(optOutBucket, key)
+	}
+	return b.store.Put(optOutBucket, key, &optOut{Name: name, Since: time.Now()})
+}
+
+// withheld relays the withheld marker in place of a message from someone who has opted out,
+// if the marker is turned on. It doesn't say who the message was from.
+func (b *Bridge) withheld(mapping *Mapping, toIRC bool) {
+	if !b.Config.OptOutMarker {
+		return
+	}
+
+	if toIRC {
+		b.listenerFor(mapping.IRCChannel).Privmsg(mapping.IRCChannel, withheldMarker)
+		return
+	}
+	if _, err := b.discord.ChannelMessageSend(mapping.DiscordChannel, withheldMarker); err != nil {
+		b.handleError(err, nil, "could not send withheld marker to discord")
+	}
+}
+
+// optOutDiscord opts a Discord user out of relaying, or back in, and returns the reply for them.
+//
+// Puppets of people who opt out are disconnected, and reconnect when they opt back in.
+func (d *discordBot) optOutDiscord(user *discordgo.User, out bool) string {
+	if err := d.bridge.setOptOut(karmaKeyDiscord(user.ID), user.Username, out); err != nil {
+		log.WithField("error", err).Errorln("could not save relay opt-out")
+		return "Something went wrong, sorry. Please try again later."
+	}
+
+	if out {
+		if !d.bridge.Config.SimpleMode {
+			d.bridge.removeUserChan <- user.ID
+		}
+		return "Your messages and presence are no longer relayed to IRC. Use /bridge optin to undo this."
+	}
+
+	if d.bridge.Config.PresenceFallback > 0 && !d.bridge.Config.SimpleMode {
+		if d.isActive(user.ID) {
+			d.handlePresenceUpdate(user.ID, discordgo.StatusOnline, true)
+		}
+	} else if presence, err := d.State.Presence(d.guildID, user.ID); err == nil && !d.bridge.Config.SimpleMode {
+		d.handlePresenceUpdate(user.ID, presence.Status, false)
+	}
+	return "Your messages and presence are relayed to IRC again."
+}
+
+// handleOptOut opts an IRC user out of relaying, or back in.
+func (i *ircListener) handleOptOut(e *irc.Event, out bool) {
+	if err := i.bridge.setOptOut(i.bridge.karmaKeyIRC(e.Nick, i.account(e)), e.Nick, out); err != nil {
+		log.WithField("error", err).Errorln("could not save relay opt-out")
+		i.Notice(e.Nick, "Something went wrong, sorry. Please try again later.")
+		return
+	}
+
+	if out {
+		i.Noticef(e.Nick, "Your messages are no longer relayed to Discord. Use %soptin to undo this.", i.bridge.Config.CommandPrefix)
+	} else {
+		i.Notice(e.Nick, "Your messages are relayed to Discord again.")
+	}
+}
+
+func init() {
+	registerChatCommand(&chatCommand{
+		Name: "optout",
+		IRC: func(i *ircListener, e *irc.Event, args []string) {
+			i.handleOptOut(e, true)
+		},
+		Discord: func(d *discordBot, m *discordgo.Message, args []string) {
+			d.reply(m, d.optOutDiscord(m.Author, true))
+		},
+	})
+	registerChatCommand(&chatCommand{
+		Name: "optin",
+		IRC: func(i *ircListener, e *irc.Event, args []string) {
+			i.handleOptOut(e, false)
+		},
+		Discord: func(d *discordBot, m *discordgo.Message, args []string) {
+			d.reply(m, d.optOutDiscord(m.Author, false))
+		},
+	})
+}
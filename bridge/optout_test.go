@@ -0,0 +1,95 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+// bridgeInteraction runs a /bridge subcommand as the given member.
+func (tb *testBridge) bridgeInteraction(member *discordgo.Member, sub string) string {
+	d := tb.Bridge.discord
+	before := len(tb.discord.Responses())
+	d.onInteractionCreate(d.Session, &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		ID:     tb.discord.id(),
+		Token:  "token",
+		Type:   discordgo.InteractionApplicationCommand,
+		Member: member,
+		Data: discordgo.ApplicationCommandInteractionData{
+			Name:    bridgeCommand.Name,
+			Options: []*discordgo.ApplicationCommandInteractionDataOption{{Name: sub, Type: discordgo.ApplicationCommandOptionSubCommand}},
+		},
+	}})
+
+	responses := tb.discord.Responses()
+	if len(responses) == before {
+		return ""
+	}
+	return responses[len(responses)-1].Data.Content
+}
+
+func TestOptOutDiscord(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.OptOutMarker = true
+	})
+	defer tb.Close()
+
+	bob := tb.discordMember("100", "bob", "")
+	assert.Equal(t, "Your messages and presence are no longer relayed to IRC. Use /bridge optin to undo this.", tb.bridgeInteraction(&discordgo.Member{User: bob}, "optout"))
+
+	tb.discordSay(bob, "this is private")
+	waitFor(t, "withheld marker on irc", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :"+withheldMarker)
+	})
+
+	tb.discordSay(bob, "!optin")
+	waitFor(t, "reply to !optin", func() bool {
+		_, ok := tb.discord.Find("Your messages and presence are relayed to IRC again.")
+		return ok
+	})
+
+	tb.discordSay(bob, "this is public")
+	waitFor(t, "message on irc", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<b\u200Bob#0001> this is public")
+	})
+	for _, line := range tb.ircd.Received("listener") {
+		assert.NotContains(t, line, "private")
+	}
+}
+
+func TestOptOutIRC(t *testing.T) {
+	tb := newTestBridge(t, nil)
+	defer tb.Close()
+
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :!optout")
+	waitFor(t, "opt out notice", func() bool {
+		return tb.ircd.HasReceived("listener", "NOTICE alice :Your messages are no longer relayed to Discord. Use !optin to undo this.")
+	})
+	assert.True(t, tb.Bridge.optedOut(notifyKey("alice", "")))
+
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :this is private")
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG listener :optin")
+	waitFor(t, "opt in notice", func() bool {
+		return tb.ircd.HasReceived("listener", "NOTICE alice :Your messages are relayed to Discord again.")
+	})
+
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :this is public")
+	waitFor(t, "message on discord", func() bool {
+		_, ok := tb.discord.Find("this is public" + relayMarker)
+		return ok
+	})
+	for _, msg := range tb.discord.Sent() {
+		assert.NotContains(t, msg.Content, "private")
+	}
+}
+
+func TestBridgeCommandPermissions(t *testing.T) {
+	tb := newTestBridge(t, nil)
+	defer tb.Close()
+
+	bob := tb.discordMember("100", "bob", "")
+	assert.Equal(t, "You need the Manage Server permission to use /bridge broadcast.", tb.bridgeInteraction(&discordgo.Member{User: bob}, "broadcast"))
+	assert.NotEqual(t, "You need the Manage Server permission to use /bridge diagnose.",
+		tb.bridgeInteraction(&discordgo.Member{User: bob, Permissions: discordgo.PermissionManageServer}, "diagnose"))
+}
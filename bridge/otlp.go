@@ -0,0 +1,285 @@
+package bridge
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"expvar"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// otlpInterval is how often spans and metrics are exported
+const otlpInterval = 15 * time.Second
+
+// otlpServiceName is the service.name spans and metrics are exported with
+const otlpServiceName = "go-discord-irc"
+
+// otlpExpvars are the expvar maps exported as OTLP metrics, named bridge.<map>
+var otlpExpvars = []string{"relay_latency", "failed_sends", "store", "unmapped_messages", "mapping_health", "overflow", "watchdog"}
+
+// otlpClient sends spans and metrics to the collector.
+var otlpClient = &http.Client{Timeout: 10 * time.Second}
+
+func validateOTLP(opts *Config) error {
+	if opts.OTLPEndpoint == "" {
+		return nil
+	}
+	endpoint, err := url.Parse(opts.OTLPEndpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return errors.Errorf("otlp_endpoint %s must be an http or https URL, like http://localhost:4318", opts.OTLPEndpoint)
+	}
+	return nil
+}
+
+// The types below are the OTLP/HTTP JSON encoding of the OpenTelemetry protocol.
+// Times and 64-bit integers are strings, and IDs are hex.
+
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+func otlpString(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{StringValue: &value}}
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"` // 2 is an error
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID      string          `json:"traceId"`
+	SpanID       string          `json:"spanId"`
+	ParentSpanID string          `json:"parentSpanId,omitempty"`
+	Name         string          `json:"name"`
+	Kind         int             `json:"kind"` // 1 is internal
+	Start        string          `json:"startTimeUnixNano"`
+	End          string          `json:"endTimeUnixNano"`
+	Attributes   []otlpAttribute `json:"attributes,omitempty"`
+	Status       *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpDataPoint struct {
+	Attributes []otlpAttribute `json:"attributes"`
+	Time       string          `json:"timeUnixNano"`
+	AsInt      string          `json:"asInt,omitempty"`
+	AsDouble   *float64        `json:"asDouble,omitempty"`
+}
+
+type otlpMetric struct {
+	Name  string `json:"name"`
+	Gauge struct {
+		DataPoints []otlpDataPoint `json:"dataPoints"`
+	} `json:"gauge"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpMetrics struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+func otlpTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// otlpID returns a random trace or span ID of n bytes.
+func otlpID(n int) string {
+	id := make([]byte, n)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// otlpSpans turns the trace of a message into spans: one for the whole relay,
+// with one for each step, from the step before it.
+// Message content isn't exported, but why messages were dropped or queued is.
+func otlpSpans(trace messageTrace) []otlpSpan {
+	traceID := otlpID(16)
+	root := otlpSpan{
+		TraceID: traceID,
+		SpanID:  otlpID(8),
+		Name:    "relay from " + trace.From,
+		Kind:    1,
+		Start:   otlpTime(trace.Steps[0].At),
+		End:     otlpTime(trace.Steps[len(trace.Steps)-1].At),
+		Attributes: []otlpAttribute{
+			otlpString("bridge.trace_id", trace.ID),
+			otlpString("bridge.from", trace.From),
+		},
+	}
+	spans := []otlpSpan{root}
+
+	for n := 1; n < len(trace.Steps); n++ {
+		step := trace.Steps[n]
+		span := otlpSpan{
+			TraceID:      traceID,
+			SpanID:       otlpID(8),
+			ParentSpanID: root.SpanID,
+			Name:         string(step.Stage),
+			Kind:         1,
+			Start:        otlpTime(trace.Steps[n-1].At),
+			End:          otlpTime(step.At),
+		}
+		if step.Stage == traceFilter || step.Stage == traceQueue {
+			span.Attributes = []otlpAttribute{otlpString("bridge.detail", step.Detail)}
+		}
+		if step.Stage == traceSend && strings.HasPrefix(step.Detail, "failed") {
+			span.Status = &otlpStatus{Code: 2, Message: step.Detail}
+			spans[0].Status = span.Status
+		}
+		spans = append(spans, span)
+	}
+	return spans
+}
+
+// otlpGauges reads the exported expvar maps. Each number in a map is a data point with the
+// map key as its "key" attribute. Numbers inside JSON values, like latency percentiles, are
+// keyed like "to_irc.p95_ms".
+func otlpGauges(now time.Time) []otlpMetric {
+	metrics := []otlpMetric{}
+	for _, name := range otlpExpvars {
+		vars, ok := expvar.Get(name).(*expvar.Map)
+		if !ok {
+			continue
+		}
+
+		metric := otlpMetric{Name: "bridge." + name}
+		point := func(key string) otlpDataPoint {
+			return otlpDataPoint{Attributes: []otlpAttribute{otlpString("key", key)}, Time: otlpTime(now)}
+		}
+		vars.Do(func(kv expvar.KeyValue) {
+			switch v := kv.Value.(type) {
+			case *expvar.Int:
+				p := point(kv.Key)
+				p.AsInt = strconv.FormatInt(v.Value(), 10)
+				metric.Gauge.DataPoints = append(metric.Gauge.DataPoints, p)
+			case *expvar.Float:
+				p := point(kv.Key)
+				f := v.Value()
+				p.AsDouble = &f
+				metric.Gauge.DataPoints = append(metric.Gauge.DataPoints, p)
+			default:
+				var fields map[string]interface{}
+				if json.Unmarshal([]byte(kv.Value.String()), &fields) != nil {
+					return
+				}
+				for field, value := range fields {
+					if f, ok := value.(float64); ok {
+						p := point(kv.Key + "." + field)
+						p.AsDouble = &f
+						metric.Gauge.DataPoints = append(metric.Gauge.DataPoints, p)
+					}
+				}
+			}
+		})
+		if len(metric.Gauge.DataPoints) > 0 {
+			metrics = append(metrics, metric)
+		}
+	}
+	return metrics
+}
+
+// exportOTLP sends the traces of the messages relayed since the last export, and the metrics,
+// to the OTLP collector at OTLPEndpoint.
+func (b *Bridge) exportOTLP() {
+	resource := otlpResource{Attributes: []otlpAttribute{otlpString("service.name", otlpServiceName)}}
+	scope := otlpScope{Name: "github.com/qaisjp/go-discord-irc/bridge"}
+
+	if ended := b.traces.Ended(); len(ended) > 0 {
+		spans := []otlpSpan{}
+		for _, trace := range ended {
+			spans = append(spans, otlpSpans(trace)...)
+		}
+		req := otlpTraces{ResourceSpans: []otlpResourceSpans{{
+			Resource:   resource,
+			ScopeSpans: []otlpScopeSpans{{Scope: scope, Spans: spans}},
+		}}}
+		if err := b.postOTLP("/v1/traces", req); err != nil {
+			log.WithFields(log.Fields{"error": err, "traces": len(ended)}).Warnln("could not export traces")
+		}
+	}
+
+	if metrics := otlpGauges(time.Now()); len(metrics) > 0 {
+		req := otlpMetrics{ResourceMetrics: []otlpResourceMetrics{{
+			Resource:     resource,
+			ScopeMetrics: []otlpScopeMetrics{{Scope: scope, Metrics: metrics}},
+		}}}
+		if err := b.postOTLP("/v1/metrics", req); err != nil {
+			log.WithField("error", err).Warnln("could not export metrics")
+		}
+	}
+}
+
+// postOTLP sends an export request to the collector.
+func (b *Bridge) postOTLP(path string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return errors.Wrap(err, "could not encode export request")
+	}
+
+	req, err := http.NewRequest("POST", strings.TrimRight(b.Config.OTLPEndpoint, "/")+path, bytes.NewReader(data))
+	if err != nil {
+		return errors.Wrap(err, "could not make export request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range b.Config.OTLPHeaders {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := otlpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "could not reach the otlp collector")
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("otlp collector responded with %s", resp.Status)
+	}
+	return nil
+}
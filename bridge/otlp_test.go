@@ -0,0 +1,87 @@
+package bridge
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateOTLP(t *testing.T) {
+	assert.NoError(t, validateOTLP(&Config{}))
+	assert.NoError(t, validateOTLP(&Config{OTLPEndpoint: "https://otel.example.com"}))
+	assert.Error(t, validateOTLP(&Config{OTLPEndpoint: "localhost:4318"}))
+}
+
+func TestExportOTLP(t *testing.T) {
+	var mu sync.Mutex
+	var traces otlpTraces
+	var metrics otlpMetrics
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, "secret", r.Header.Get("X-Api-Key"))
+		switch r.URL.Path {
+		case "/v1/traces":
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&traces))
+		case "/v1/metrics":
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&metrics))
+		default:
+			t.Errorf("unexpected export to %s", r.URL.Path)
+		}
+	}))
+	defer collector.Close()
+
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.OTLPEndpoint = collector.URL + "/"
+		conf.OTLPHeaders = map[string]string{"x-api-key": "secret"}
+	})
+	defer tb.Close()
+
+	unmappedMetrics.Add("irc #elsewhere", 1)
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :hello discord")
+	waitFor(t, "relayed to discord", func() bool {
+		_, ok := tb.discord.Find("hello discord" + relayMarker)
+		return ok
+	})
+	waitFor(t, "trace ended", func() bool {
+		tb.Bridge.traces.mu.Lock()
+		defer tb.Bridge.traces.mu.Unlock()
+		return len(tb.Bridge.traces.ended) == 1
+	})
+	tb.Bridge.exportOTLP()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if assert.Len(t, traces.ResourceSpans, 1) {
+		spans := traces.ResourceSpans[0].ScopeSpans[0].Spans
+		names := []string{}
+		for _, span := range spans {
+			names = append(names, span.Name)
+			assert.Equal(t, spans[0].TraceID, span.TraceID)
+			assert.Len(t, span.TraceID, 32)
+		}
+		assert.Equal(t, []string{"relay from irc", "format", "send"}, names)
+		assert.Equal(t, spans[0].SpanID, spans[2].ParentSpanID)
+		assert.Equal(t, "i1", *spans[0].Attributes[0].Value.StringValue)
+		assert.Empty(t, spans[1].Attributes, "message content isn't exported")
+	}
+
+	found := false
+	if assert.Len(t, metrics.ResourceMetrics, 1) {
+		for _, metric := range metrics.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+			for _, point := range metric.Gauge.DataPoints {
+				if metric.Name == "bridge.unmapped_messages" && *point.Attributes[0].Value.StringValue == "irc #elsewhere" {
+					found = point.AsInt != ""
+				}
+			}
+		}
+	}
+	assert.True(t, found, "unmapped message count exported")
+
+	// Traces are only exported once
+	assert.Empty(t, tb.Bridge.traces.Ended())
+}
@@ -0,0 +1,116 @@
+package bridge
+
+import (
+	"expvar"
+	"fmt"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+// outboxBucket is the store bucket holding messages that could not be sent to Discord
+const outboxBucket = "outbox"
+
+var (
+	// webhookRetries is how many times a transient webhook error is retried
+	// before the message is moved to the outbox
+	webhookRetries = 4
+
+	// webhookBackoff is the wait before the first retry. It doubles each time.
+	webhookBackoff = time.Second
+
+	// outboxInterval is how often messages in the outbox are retried
+	outboxInterval = time.Minute
+
+	// outboxLimit is the most messages kept in the outbox. Any more are dropped.
+	outboxLimit = 1000
+)
+
+// failedSends counts messages that were never relayed to Discord, keyed by error category
+var failedSends = expvar.NewMap("failed_sends")
+
+// transmit sends a message to Discord, retrying transient errors with exponential backoff.
+func (b *Bridge) transmit(channel, username, avatar, content string, embeds []*discordgo.MessageEmbed) (*discordgo.Message, error) {
+	backoff := webhookBackoff
+	for attempt := 0; ; attempt++ {
+		sent, err := b.discord.getTransmitter().Message(channel, username, avatar, content, embeds...)
+		if err == nil {
+			b.statusResolved("webhooks", "Relaying to Discord has recovered.")
+			return sent, nil
+		}
+
+		category := b.handleError(err, log.Fields{
+			"msg.channel":  channel,
+			"msg.username": username,
+			"msg.avatar":   avatar,
+			"msg.content":  content,
+			"attempt":      attempt + 1,
+		}, "could not transmit message to discord")
+		if category != errTransient || attempt >= webhookRetries {
+			return nil, err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// sendFailed handles a message that could not be relayed to Discord.
+// Messages that failed because of transient errors are kept in the outbox
+// to be tried again later, and everything else is counted and dropped.
+func (b *Bridge) sendFailed(msg IRCMessage, err error) {
+	category := categorise(err)
+	if category == errTransient && msg.Probe == "" {
+		if len(b.store.Keys(outboxBucket)) < outboxLimit {
+			key := fmt.Sprintf("%020d", time.Now().UnixNano())
+			putErr := b.store.Put(outboxBucket, key, msg)
+			if putErr == nil {
+				log.WithField("channel", msg.IRCChannel).Warnln("Moved a message that could not be relayed to Discord to the outbox.")
+				b.traces.Step(msg.TraceID, traceQueue, "moved to the outbox: %s", failureReason(err))
+				return
+			}
+			log.WithField("error", putErr).Warnln("could not add message to the outbox")
+		} else {
+			log.WithField("channel", msg.IRCChannel).Warnln("The outbox is full, dropping a message that could not be relayed to Discord.")
+			b.statusProblem("outbox", "The outbox is full, so messages that can't be relayed to Discord are being dropped.")
+		}
+	} else if msg.Probe == "" {
+		b.statusProblem("webhooks", "Relaying to Discord is failing: "+failureReason(err))
+	}
+
+	b.traces.Step(msg.TraceID, traceSend, "failed: %s", failureReason(err))
+	failedSends.Add(category.String(), 1)
+	b.relayFailedToDiscord(msg, err)
+}
+
+// flushOutbox relays the messages in the outbox again, oldest first.
+func (b *Bridge) flushOutbox() {
+	keys := b.store.Keys(outboxBucket)
+	if len(keys) == 0 {
+		return
+	}
+
+	msgs := make([]IRCMessage, 0, len(keys))
+	for _, key := range keys {
+		var msg IRCMessage
+		if _, err := b.store.Get(outboxBucket, key, &msg); err != nil {
+			log.WithFields(log.Fields{"error": err, "key": key}).Warnln("could not read message from the outbox")
+		} else {
+			msgs = append(msgs, msg)
+		}
+		if err := b.store.Delete(outboxBucket, key); err != nil {
+			log.WithField("error", err).Warnln("could not remove message from the outbox")
+		}
+	}
+
+	log.WithField("count", len(msgs)).Infoln("Relaying messages from the outbox.")
+	b.statusResolved("outbox", "The outbox has been emptied.")
+
+	// The loop relays these, so they can't be sent from the loop itself
+	go func() {
+		for _, msg := range msgs {
+			b.discordMessagesChan <- msg
+		}
+	}()
+}
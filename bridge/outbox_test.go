@@ -0,0 +1,58 @@
+package bridge
+
+import (
+	"expvar"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutbox(t *testing.T) {
+	defer func(backoff time.Duration) { webhookBackoff = backoff }(webhookBackoff)
+	webhookBackoff = time.Millisecond
+
+	tb := newTestBridge(t, nil)
+	defer tb.Close()
+
+	// Messages that can't be sent because Discord is down are kept
+	tb.discord.RefuseWebhooks(http.StatusServiceUnavailable, "Service Unavailable")
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :are you there")
+	waitFor(t, "message in the outbox", func() bool {
+		return len(tb.Bridge.store.Keys(outboxBucket)) == 1
+	})
+	_, ok := tb.discord.Find("are you there" + relayMarker)
+	assert.False(t, ok)
+	assert.Contains(t, tb.Bridge.traces.Recent()[0], "moved to the outbox: Service Unavailable")
+
+	// and sent once it's back
+	tb.discord.RefuseWebhooks(0, "")
+	tb.Bridge.flushOutbox()
+	waitFor(t, "message on discord", func() bool {
+		_, ok := tb.discord.Find("are you there" + relayMarker)
+		return ok
+	})
+	assert.Empty(t, tb.Bridge.store.Keys(outboxBucket))
+}
+
+func TestOutboxPermanentFailure(t *testing.T) {
+	tb := newTestBridge(t, nil)
+	defer tb.Close()
+
+	count := func() int64 {
+		if v, ok := failedSends.Get("permission").(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+	before := count()
+
+	// Permission errors aren't retried
+	tb.discord.RefuseWebhooks(http.StatusForbidden, "Missing Permissions")
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :hello")
+	waitFor(t, "failed send to be counted", func() bool {
+		return count() == before+1
+	})
+	assert.Empty(t, tb.Bridge.store.Keys(outboxBucket))
+}
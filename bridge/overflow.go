@@ -0,0 +1,274 @@
+package bridge
+
+import (
+	"bufio"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// overflowMetrics counts messages for IRC that puppets couldn't send straight away,
+// under "queued", those of them written to disk, under "spilled", and those dropped.
+var overflowMetrics = expvar.NewMap("overflow")
+
+// The overflow limits used if they aren't set
+const (
+	defaultOverflowLimit    = 100
+	defaultOverflowMaxBytes = 1 << 20
+)
+
+func validateOverflow(opts *Config) error {
+	if opts.OverflowLimit < 0 || opts.OverflowMaxBytes < 0 {
+		return errors.New("overflow_limit and overflow_max_bytes must not be negative")
+	}
+	if opts.OverflowLimit == 0 {
+		opts.OverflowLimit = defaultOverflowLimit
+	}
+	if opts.OverflowMaxBytes == 0 {
+		opts.OverflowMaxBytes = defaultOverflowMaxBytes
+	}
+	if opts.OverflowPath == "" {
+		return nil
+	}
+	if err := os.MkdirAll(opts.OverflowPath, 0700); err != nil {
+		return errors.Wrap(err, "could not create overflow_path")
+	}
+	return nil
+}
+
+// overflowQueue holds the messages a puppet hasn't been able to send yet, in order, when
+// Discord produces them faster than the IRC server takes them. The first OverflowLimit
+// are kept in memory and the rest are spilled to a file in OverflowPath, up to
+// OverflowMaxBytes. Messages that don't fit are dropped, and once the queue is empty a
+// summary is sent to each channel that lost messages.
+type overflowQueue struct {
+	mu       sync.Mutex
+	memory   []IRCMessage
+	dropped  map[string]int // by channel
+	draining bool
+
+	limit    int
+	maxBytes int64
+
+	// The spill file holds JSON messages, one per line, read from offset onwards
+	path    string
+	file    *os.File
+	offset  int64
+	size    int64
+	spilled int
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newOverflowQueue(conf *Config, discordID string) *overflowQueue {
+	q := &overflowQueue{
+		dropped:  make(map[string]int),
+		limit:    conf.OverflowLimit,
+		maxBytes: conf.OverflowMaxBytes,
+		done:     make(chan struct{}),
+	}
+	if conf.OverflowPath != "" {
+		q.path = filepath.Join(conf.OverflowPath, discordID+".queue")
+	}
+	return q
+}
+
+// Draining returns true while queued messages are being sent. New messages must be
+// queued behind them to stay in order.
+func (q *overflowQueue) Draining() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.draining
+}
+
+// Push queues a message, returning true if the queue was idle and needs draining.
+func (q *overflowQueue) Push(msg IRCMessage) (start bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	overflowMetrics.Add("queued", 1)
+	switch {
+	case len(q.memory) < q.limit && q.spilled == 0:
+		q.memory = append(q.memory, msg)
+	case q.path != "" && q.spill(msg):
+		overflowMetrics.Add("spilled", 1)
+	default:
+		overflowMetrics.Add("dropped", 1)
+		q.dropped[msg.IRCChannel]++
+	}
+
+	start = !q.draining
+	q.draining = true
+	return start
+}
+
+// spill appends a message to the spill file, returning false if it doesn't fit.
+func (q *overflowQueue) spill(msg IRCMessage) bool {
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return false
+	}
+	line = append(line, '\n')
+
+	if q.size+int64(len(line)) > q.maxBytes {
+		q.compact()
+		if q.size+int64(len(line)) > q.maxBytes {
+			return false
+		}
+	}
+
+	if q.file == nil {
+		q.file, err = os.OpenFile(q.path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			log.WithError(err).WithField("path", q.path).Warnln("Could not create overflow file, dropping messages instead.")
+			q.path = ""
+			return false
+		}
+	}
+	if _, err := q.file.WriteAt(line, q.size); err != nil {
+		log.WithError(err).WithField("path", q.path).Warnln("Could not write to overflow file.")
+		return false
+	}
+	q.size += int64(len(line))
+	q.spilled++
+	return true
+}
+
+// compact moves the messages not read yet to the start of the spill file.
+func (q *overflowQueue) compact() {
+	if q.file == nil || q.offset == 0 {
+		return
+	}
+	rest := make([]byte, q.size-q.offset)
+	if _, err := q.file.ReadAt(rest, q.offset); err != nil && err != io.EOF {
+		return
+	}
+	if _, err := q.file.WriteAt(rest, 0); err != nil {
+		return
+	}
+	q.file.Truncate(int64(len(rest)))
+	q.size = int64(len(rest))
+	q.offset = 0
+}
+
+// unspill moves up to limit messages from the spill file back into memory.
+func (q *overflowQueue) unspill() {
+	if q.spilled == 0 {
+		return
+	}
+	r := bufio.NewReader(io.NewSectionReader(q.file, q.offset, q.size-q.offset))
+	for len(q.memory) < q.limit && q.spilled > 0 {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			// The file is broken, so count what remains as dropped
+			log.WithError(err).WithField("path", q.path).Warnln("Could not read overflow file.")
+			q.dropped[""] += q.spilled
+			q.spilled = 0
+			break
+		}
+		q.offset += int64(len(line))
+		q.spilled--
+
+		var msg IRCMessage
+		if err := json.Unmarshal(line, &msg); err != nil {
+			continue
+		}
+		q.memory = append(q.memory, msg)
+	}
+	if q.spilled == 0 {
+		q.file.Truncate(0)
+		q.offset, q.size = 0, 0
+	}
+}
+
+// Pop returns the next message to send. When there are no more, it returns a summary
+// for each channel that lost messages, then false once the queue is idle again.
+func (q *overflowQueue) Pop() (IRCMessage, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.memory) == 0 {
+		q.unspill()
+	}
+	if len(q.memory) > 0 {
+		msg := q.memory[0]
+		q.memory = q.memory[1:]
+		return msg, true
+	}
+
+	channels := make([]string, 0, len(q.dropped))
+	for channel := range q.dropped {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	for _, channel := range channels {
+		count := q.dropped[channel]
+		delete(q.dropped, channel)
+		if channel == "" {
+			continue
+		}
+		noun := "messages"
+		if count == 1 {
+			noun = "message"
+		}
+		return IRCMessage{
+			IRCChannel: channel,
+			Message:    fmt.Sprintf("[%d %s dropped due to overload]", count, noun),
+		}, true
+	}
+
+	q.draining = false
+	return IRCMessage{}, false
+}
+
+// drain sends queued messages to a puppet's connection until the queue is empty.
+func (q *overflowQueue) drain(messages chan<- IRCMessage) {
+	defer q.wg.Done()
+	for {
+		msg, ok := q.Pop()
+		if !ok {
+			return
+		}
+		select {
+		case messages <- msg:
+		case <-q.done:
+			return
+		}
+	}
+}
+
+// Start drains the queue in the background.
+func (q *overflowQueue) Start(messages chan<- IRCMessage) {
+	q.wg.Add(1)
+	go q.drain(messages)
+}
+
+// Close stops draining the queue, waiting until nothing is being sent, and deletes the spill file.
+func (q *overflowQueue) Close() {
+	close(q.done)
+	q.wg.Wait()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.file != nil {
+		q.file.Close()
+		os.Remove(q.path)
+		q.file = nil
+	}
+}
+
+// queue holds a message back until the IRC server takes the ones before it.
+func (i *ircConnection) queue(msg IRCMessage) {
+	if i.overflow.Push(msg) {
+		i.overflow.Start(i.messages)
+	}
+}
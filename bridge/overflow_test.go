@@ -0,0 +1,82 @@
+package bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOverflowQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "overflow")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Room for 2 messages in memory and 3 on disk
+	line, _ := json.Marshal(IRCMessage{IRCChannel: "#a", Message: "0"})
+	q := newOverflowQueue(&Config{OverflowLimit: 2, OverflowPath: dir, OverflowMaxBytes: 3 * int64(len(line)+1)}, "1234")
+	assert.True(t, q.Push(IRCMessage{IRCChannel: "#a", Message: "0"}))
+	for n := 1; n < 8; n++ {
+		assert.False(t, q.Push(IRCMessage{IRCChannel: "#a", Message: fmt.Sprint(n)}))
+	}
+	assert.False(t, q.Push(IRCMessage{IRCChannel: "#b", Message: "lost"}))
+	assert.Equal(t, 2, len(q.memory))
+	assert.Equal(t, 3, q.spilled)
+	assert.FileExists(t, filepath.Join(dir, "1234.queue"))
+
+	// Messages come out in order, then what was dropped from each channel
+	var sent []string
+	for {
+		msg, ok := q.Pop()
+		if !ok {
+			break
+		}
+		sent = append(sent, msg.IRCChannel+" "+msg.Message)
+	}
+	assert.Equal(t, []string{
+		"#a 0", "#a 1", "#a 2", "#a 3", "#a 4",
+		"#a [3 messages dropped due to overload]",
+		"#b [1 message dropped due to overload]",
+	}, sent)
+	assert.False(t, q.Draining())
+
+	// Once drained, it starts again with the file empty
+	assert.True(t, q.Push(IRCMessage{IRCChannel: "#a", Message: "again"}))
+	assert.EqualValues(t, 0, q.size)
+
+	q.Close()
+	_, err = os.Stat(filepath.Join(dir, "1234.queue"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestOverflowQueueMemoryOnly(t *testing.T) {
+	q := newOverflowQueue(&Config{OverflowLimit: 1}, "1234")
+	q.Push(IRCMessage{IRCChannel: "#a", Message: "kept"})
+	q.Push(IRCMessage{IRCChannel: "#a", Message: "lost"})
+
+	msg, _ := q.Pop()
+	assert.Equal(t, "kept", msg.Message)
+	msg, _ = q.Pop()
+	assert.Equal(t, "[1 message dropped due to overload]", msg.Message)
+	_, ok := q.Pop()
+	assert.False(t, ok)
+}
+
+func TestOverflowDefaults(t *testing.T) {
+	conf := &Config{}
+	assert.NoError(t, validateOverflow(conf))
+	assert.Equal(t, defaultOverflowLimit, conf.OverflowLimit)
+	assert.EqualValues(t, defaultOverflowMaxBytes, conf.OverflowMaxBytes)
+
+	// A message that can be sent straight away isn't dropped
+	q := newOverflowQueue(conf, "1234")
+	q.Push(IRCMessage{IRCChannel: "#a", Message: "kept"})
+	msg, _ := q.Pop()
+	assert.Equal(t, "kept", msg.Message)
+
+	assert.Error(t, validateOverflow(&Config{OverflowLimit: -1}))
+}
@@ -0,0 +1,43 @@
+package bridge
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// pasteClient uploads pastes. The timeout keeps a slow paste service from holding up relaying.
+var pasteClient = &http.Client{Timeout: 10 * time.Second}
+
+// paste uploads text to the paste service, returning its URL.
+//
+// The service must accept the text as the body of a POST request, and respond with the URL.
+func (b *Bridge) paste(text string) (string, error) {
+	if b.Config.PasteURL == "" {
+		return "", errors.New("no paste service is set up")
+	}
+
+	resp, err := pasteClient.Post(b.Config.PasteURL, "text/plain; charset=utf-8", strings.NewReader(text))
+	if err != nil {
+		return "", errors.Wrap(err, "could not upload paste")
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return "", errors.Wrap(err, "could not read paste service response")
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", errors.Errorf("paste service responded with %s", resp.Status)
+	}
+
+	url := strings.TrimSpace(string(body))
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return "", errors.Errorf("paste service responded with %q instead of a URL", url)
+	}
+	return url, nil
+}
@@ -0,0 +1,103 @@
+package bridge
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	irc "github.com/qaisjp/go-ircevent"
+)
+
+// pingTimeout is how long !ping waits for the IRC server to reply
+var pingTimeout = 5 * time.Second
+
+// measureLag sends a PING to the IRC server, and returns how long it took to reply.
+func (i *ircListener) measureLag() (time.Duration, error) {
+	token := strconv.FormatInt(time.Now().UnixNano(), 10)
+	reply := make(chan time.Duration, 1)
+
+	i.pingMu.Lock()
+	i.pings[token] = reply
+	i.pingMu.Unlock()
+
+	defer func() {
+		i.pingMu.Lock()
+		delete(i.pings, token)
+		i.pingMu.Unlock()
+	}()
+
+	i.SendRawf("PING %s", token)
+
+	select {
+	case lag := <-reply:
+		return lag, nil
+	case <-time.After(pingTimeout):
+		return 0, errors.New("no reply from the IRC server")
+	}
+}
+
+// OnPong completes a measureLag.
+func (i *ircListener) OnPong(e *irc.Event) {
+	token := e.Message()
+
+	i.pingMu.Lock()
+	reply, ok := i.pings[token]
+	i.pingMu.Unlock()
+	if !ok {
+		return
+	}
+
+	sent, err := strconv.ParseInt(token, 10, 64)
+	if err != nil {
+		return
+	}
+
+	select {
+	case reply <- time.Since(time.Unix(0, sent)):
+	default:
+	}
+}
+
+// pingReply is the reply to !ping, describing the round trip times to the IRC server and the Discord gateway.
+func (b *Bridge) pingReply() string {
+	ircLag := "no reply"
+	if lag, err := b.ircListener.measureLag(); err == nil {
+		ircLag = formatLag(lag)
+	}
+
+	gatewayLag := "unknown"
+	if !b.discord.LastHeartbeatAck.IsZero() && !b.discord.LastHeartbeatSent.IsZero() {
+		gatewayLag = formatLag(b.discord.HeartbeatLatency())
+	}
+
+	reply := fmt.Sprintf("Pong! IRC server: %s, Discord gateway: %s", ircLag, gatewayLag)
+	if summary := b.probes.Summary(); summary != "" {
+		reply += ". " + summary
+	}
+	return reply
+}
+
+func formatLag(lag time.Duration) string {
+	if lag < 0 {
+		lag = 0
+	}
+	return fmt.Sprintf("%dms", int64(lag/time.Millisecond))
+}
+
+func init() {
+	registerChatCommand(&chatCommand{
+		Name: "ping",
+		IRC: func(i *ircListener, e *irc.Event, args []string) {
+			// The PONG can't be received until this callback returns
+			channel := e.Arguments[0]
+			go func() {
+				i.Notice(channel, i.bridge.pingReply())
+			}()
+		},
+		Discord: func(d *discordBot, m *discordgo.Message, args []string) {
+			d.reply(m, d.bridge.pingReply())
+		},
+	})
+}
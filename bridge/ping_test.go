@@ -0,0 +1,37 @@
+package bridge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPingReply(t *testing.T) {
+	tb := newTestBridge(t, nil)
+	defer tb.Close()
+
+	lag, err := tb.ircListener.measureLag()
+	assert.NoError(t, err)
+	assert.True(t, lag < pingTimeout)
+
+	assert.Regexp(t, `^Pong! IRC server: \d+ms, Discord gateway: unknown$`, tb.Bridge.pingReply())
+
+	tb.Bridge.discord.LastHeartbeatSent = time.Now().Add(-time.Second)
+	tb.Bridge.discord.LastHeartbeatAck = tb.Bridge.discord.LastHeartbeatSent.Add(42 * time.Millisecond)
+	assert.Regexp(t, `^Pong! IRC server: \d+ms, Discord gateway: 42ms$`, tb.Bridge.pingReply())
+}
+
+func TestPingDisabled(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.Commands = map[string]CommandOptions{"ping": {Disabled: true}}
+	})
+	defer tb.Close()
+
+	bob := tb.discordMember("100", "bob", "")
+	tb.discordSay(bob, "!ping")
+	waitFor(t, "ping relayed", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<b\u200Bob#0001> !ping")
+	})
+	assert.Empty(t, tb.discord.Sent())
+}
@@ -0,0 +1,68 @@
+package bridge
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+// presenceCheckInterval is how often Discord users who have gone quiet are marked offline,
+// when PresenceFallback is set
+const presenceCheckInterval = time.Minute
+
+// recentActivity is when each Discord user last sent a message or typed, standing in for their
+// presence in guilds where the bot doesn't have the presence intent.
+type recentActivity struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// markActive counts a Discord user as online for PresenceFallback, bringing their puppet back
+// if they had gone quiet.
+func (d *discordBot) markActive(uid string) {
+	if d.bridge.Config.PresenceFallback <= 0 || d.bridge.Config.SimpleMode {
+		return
+	}
+
+	d.recent.mu.Lock()
+	if d.recent.seen == nil {
+		d.recent.seen = make(map[string]time.Time)
+	}
+	_, wasActive := d.recent.seen[uid]
+	d.recent.seen[uid] = time.Now()
+	d.recent.mu.Unlock()
+
+	if !wasActive {
+		log.WithField("id", uid).Debugln("PRESENCE inferred online")
+		d.handlePresenceUpdate(uid, discordgo.StatusOnline, true)
+	}
+}
+
+// isActive returns true if a Discord user has sent a message or typed in the last PresenceFallback.
+func (d *discordBot) isActive(uid string) bool {
+	d.recent.mu.Lock()
+	defer d.recent.mu.Unlock()
+	seen, ok := d.recent.seen[uid]
+	return ok && time.Since(seen) < d.bridge.Config.PresenceFallback
+}
+
+// expireActivity marks the Discord users who haven't sent a message or typed in the last
+// PresenceFallback offline, so their puppets leave IRC like they would if they went offline.
+func (d *discordBot) expireActivity() {
+	quiet := []string{}
+	d.recent.mu.Lock()
+	for uid, seen := range d.recent.seen {
+		if time.Since(seen) >= d.bridge.Config.PresenceFallback {
+			quiet = append(quiet, uid)
+			delete(d.recent.seen, uid)
+		}
+	}
+	d.recent.mu.Unlock()
+
+	for _, uid := range quiet {
+		log.WithField("id", uid).Debugln("PRESENCE inferred offline")
+		d.handlePresenceUpdate(uid, discordgo.StatusOffline, false)
+	}
+}
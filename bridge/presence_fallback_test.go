@@ -0,0 +1,44 @@
+package bridge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPresenceFallback(t *testing.T) {
+	defer func(d time.Duration) { cooldownDuration = d }(cooldownDuration)
+	cooldownDuration = 50 * time.Millisecond
+
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.PresenceFallback = time.Minute
+	})
+	defer tb.Close()
+	d := tb.Bridge.discord
+
+	assert.Zero(t, d.Identify.Intents&discordgo.IntentsGuildPresences, "the presence intent isn't asked for")
+
+	tb.discordMember("100", "bob", "")
+	d.OnTypingStart(d.Session, &discordgo.TypingStart{UserID: "100", ChannelID: testChannelID, GuildID: testGuildID})
+	nick := "bob" + tb.Config.Suffix
+	waitFor(t, "puppet to join when bob types", func() bool {
+		return tb.ircd.InChannel(testChannel, nick)
+	})
+	assert.True(t, d.isActive("100"))
+
+	// Active people stay online
+	d.expireActivity()
+	assert.True(t, d.isActive("100"))
+
+	d.recent.mu.Lock()
+	d.recent.seen["100"] = time.Now().Add(-2 * time.Minute)
+	d.recent.mu.Unlock()
+	d.expireActivity()
+	waitFor(t, "puppet to leave once bob goes quiet", func() bool {
+		return !tb.ircd.InChannel(testChannel, nick)
+	})
+	assert.True(t, tb.ircd.HasReceived(nick, "AWAY :offline on discord"))
+	assert.False(t, d.isActive("100"))
+}
@@ -0,0 +1,273 @@
+package bridge
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	irc "github.com/qaisjp/go-ircevent"
+)
+
+// whoisCacheTTL is how long a WHOIS reply is used for before the listener asks again
+const whoisCacheTTL = 10 * time.Minute
+
+// whoisTimeout is how long /bridge profile waits for the IRC server to answer a WHOIS.
+// Discord wants interactions to be answered within 3 seconds.
+var whoisTimeout = 2 * time.Second
+
+// pronounRole matches the names of pronoun roles, like "they/them"
+var pronounRole = regexp.MustCompile(`(?i)^[a-z]+(/[a-z]+)+$`)
+
+// whoisInfo is what a WHOIS reply said about an IRC user.
+type whoisInfo struct {
+	Nick     string
+	User     string
+	Host     string
+	RealName string
+	Server   string
+	Account  string
+	Away     string
+	Channels []string
+	Idle     time.Duration
+	SignedOn time.Time
+
+	// Missing is set if the server said there is no such nick
+	Missing bool
+	fetched time.Time
+}
+
+// whoisCache keeps the WHOIS replies received by the listener, keyed by lowercase nick.
+type whoisCache struct {
+	mu      sync.Mutex
+	entries map[string]*whoisInfo
+	pending map[string]*whoisInfo    // replies still being received
+	waiting map[string]chan struct{} // closed when the reply for a nick has ended
+}
+
+// setupWhois collects WHOIS replies sent to the listener.
+func (i *ircListener) setupWhois() {
+	for _, code := range []string{"301", "311", "312", "317", "318", "319", "330", "401"} {
+		i.AddCallback(code, i.OnWhoisReply)
+	}
+}
+
+// OnWhoisReply handles the numerics of a WHOIS reply, which all start "<me> <nick>".
+func (i *ircListener) OnWhoisReply(e *irc.Event) {
+	if len(e.Arguments) < 2 {
+		return
+	}
+	arg := func(n int) string {
+		if n < len(e.Arguments) {
+			return e.Arguments[n]
+		}
+		return ""
+	}
+	nick := e.Arguments[1]
+	key := strings.ToLower(nick)
+
+	c := &i.whois
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.pending == nil {
+		c.pending = make(map[string]*whoisInfo)
+		c.entries = make(map[string]*whoisInfo)
+	}
+
+	info, ok := c.pending[key]
+	if !ok {
+		// ERR_NOSUCHNICK is also the reply to messages sent to a nick that isn't there
+		if _, asked := c.waiting[key]; e.Code == "401" && !asked {
+			return
+		}
+		info = &whoisInfo{Nick: nick}
+		c.pending[key] = info
+	}
+
+	switch e.Code {
+	case "311": // RPL_WHOISUSER "<me> <nick> <user> <host> * :<realname>"
+		info.User, info.Host, info.RealName = arg(2), arg(3), e.Message()
+	case "312": // RPL_WHOISSERVER "<me> <nick> <server> :<info>"
+		info.Server = arg(2)
+	case "317": // RPL_WHOISIDLE "<me> <nick> <idle> <signon> :seconds idle, signon time"
+		if idle, err := strconv.Atoi(arg(2)); err == nil {
+			info.Idle = time.Duration(idle) * time.Second
+		}
+		if signon, err := strconv.ParseInt(arg(3), 10, 64); err == nil {
+			info.SignedOn = time.Unix(signon, 0)
+		}
+	case "319": // RPL_WHOISCHANNELS "<me> <nick> :<channels>"
+		info.Channels = append(info.Channels, strings.Fields(e.Message())...)
+	case "301": // RPL_AWAY "<me> <nick> :<message>"
+		info.Away = e.Message()
+	case "330": // RPL_WHOISACCOUNT "<me> <nick> <account> :is logged in as"
+		info.Account = arg(2)
+	case "401": // ERR_NOSUCHNICK, which comes before RPL_ENDOFWHOIS
+		info.Missing = true
+	case "318": // RPL_ENDOFWHOIS
+		info.fetched = time.Now()
+		c.entries[key] = info
+		delete(c.pending, key)
+		if done, ok := c.waiting[key]; ok {
+			close(done)
+			delete(c.waiting, key)
+		}
+	}
+}
+
+// whoisUser returns what the IRC server says about a nick. The reply is cached,
+// and only one WHOIS is sent for a nick at a time, so that the server isn't flooded.
+func (i *ircListener) whoisUser(nick string) (whoisInfo, bool) {
+	key := strings.ToLower(nick)
+	c := &i.whois
+
+	c.mu.Lock()
+	if info, ok := c.entries[key]; ok && time.Since(info.fetched) < whoisCacheTTL {
+		c.mu.Unlock()
+		return *info, true
+	}
+	if c.waiting == nil {
+		c.waiting = make(map[string]chan struct{})
+	}
+	done, asked := c.waiting[key]
+	if !asked {
+		done = make(chan struct{})
+		c.waiting[key] = done
+	}
+	c.mu.Unlock()
+
+	if !asked {
+		i.SendRawf("WHOIS %s", nick)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(whoisTimeout):
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if info, ok := c.entries[key]; ok {
+		return *info, true
+	}
+	return whoisInfo{}, false
+}
+
+// profileIRC describes an IRC user to Discord users, from what the IRC server says in WHOIS.
+func (b *Bridge) profileIRC(nick string) string {
+	if nick == "" || strings.ContainsAny(nick, " ,*?\r\n") {
+		return "That isn't an IRC nick."
+	}
+
+	info, ok := b.ircListener.whoisUser(nick)
+	if !ok {
+		// The listener still knows about the users in its channels
+		if user, ok := b.ircListener.users.Get(nick); ok && user.Host != "" {
+			return fmt.Sprintf("%s is %s!%s@%s. The IRC server didn't answer a WHOIS.", user.Nick, user.Nick, user.User, user.Host)
+		}
+		return "The IRC server didn't say who " + nick + " is."
+	}
+	if info.Missing {
+		return nick + " is not on IRC."
+	}
+
+	lines := []string{fmt.Sprintf("%s is %s!%s@%s", info.Nick, info.Nick, info.User, info.Host)}
+	if info.RealName != "" {
+		lines[0] += " (" + info.RealName + ")"
+	}
+	if info.Account != "" {
+		lines = append(lines, "Logged in as "+info.Account)
+	}
+	if len(info.Channels) > 0 {
+		lines = append(lines, "In "+strings.Join(info.Channels, " "))
+	}
+	if info.Server != "" {
+		lines = append(lines, "On "+info.Server)
+	}
+	if info.Away != "" {
+		lines = append(lines, "Away: "+info.Away)
+	}
+	if !info.SignedOn.IsZero() {
+		lines = append(lines, fmt.Sprintf("Idle %s, signed on %s", info.Idle, info.SignedOn.UTC().Format("2006-01-02 15:04 MST")))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// profileDiscord describes the Discord user behind an IRC nick to IRC users. It only uses
+// the cached guild state, so that IRC users can't make the bridge call the Discord API.
+func (b *Bridge) profileDiscord(nick string) string {
+	discordID := ""
+	if con := b.ircManager.connectionByNick(nick); con != nil {
+		discordID = con.Discord().ID
+	}
+	if discordID == "" {
+		account := ""
+		if user, ok := b.ircListener.users.Get(nick); ok {
+			account = user.Account
+		}
+		if link := b.linkByIRC(nick, account); link != nil {
+			discordID = link.DiscordID
+		}
+	}
+	if discordID == "" {
+		return nick + " is not a Discord user."
+	}
+
+	d := b.discord
+	member, err := d.State.Member(d.guildID, discordID)
+	if err != nil {
+		return nick + " is not in the Discord server."
+	}
+
+	pronouns := ""
+	roles := []string{}
+	for _, id := range member.Roles {
+		role, err := d.State.Role(d.guildID, id)
+		if err != nil {
+			continue
+		}
+		if pronouns == "" && pronounRole.MatchString(role.Name) {
+			pronouns = role.Name
+			continue
+		}
+		roles = append(roles, role.Name)
+	}
+
+	profile := fmt.Sprintf("%s is Discord user %s", nick, member.User.String())
+	if pronouns != "" {
+		profile += " (" + pronouns + ")"
+	}
+	profile += "."
+	if !member.JoinedAt.IsZero() {
+		profile += " Joined " + member.JoinedAt.UTC().Format("2006-01-02") + "."
+	}
+	if len(roles) > 0 {
+		profile += " Roles: " + strings.Join(roles, ", ") + "."
+	}
+	return profile
+}
+
+func init() {
+	registerChatCommand(&chatCommand{
+		Name: "profile",
+		IRC: func(i *ircListener, e *irc.Event, args []string) {
+			if len(args) == 0 {
+				i.Noticef(e.Nick, "Usage: %sprofile <nick>", i.bridge.Config.CommandPrefix)
+				return
+			}
+			i.Notice(e.Arguments[0], i.bridge.profileDiscord(strings.TrimRight(args[0], ":,")))
+		},
+	})
+}
+
+// handleProfile answers /bridge profile, which describes an IRC user.
+func (d *discordBot) handleProfile(options []*discordgo.ApplicationCommandInteractionDataOption) string {
+	if len(options) == 0 {
+		return "Usage: /bridge profile <IRC nick>"
+	}
+	return d.bridge.profileIRC(options[0].StringValue())
+}
@@ -0,0 +1,70 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProfileDiscord(t *testing.T) {
+	tb := newTestBridge(t, nil)
+	defer tb.Close()
+
+	state := tb.Bridge.discord.State
+	for _, role := range []*discordgo.Role{{ID: "10", Name: "Mods"}, {ID: "11", Name: "they/them"}, {ID: "12", Name: "Regulars"}} {
+		state.RoleAdd(testGuildID, role)
+	}
+	bob := &discordgo.User{ID: "100", Username: "bob", Discriminator: "0001"}
+	joined := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	state.MemberAdd(&discordgo.Member{GuildID: testGuildID, User: bob, Roles: []string{"10", "11", "12"}, JoinedAt: joined})
+	nick := tb.puppet(t, bob, "bob")
+
+	assert.Equal(t, nick+" is Discord user bob#0001 (they/them). Joined 2024-03-01. Roles: Mods, Regulars.", tb.Bridge.profileDiscord(nick))
+	assert.Equal(t, "alice is not a Discord user.", tb.Bridge.profileDiscord("alice"))
+
+	tb.ircd.Inject("alice!a@example.org", testChannel, "PRIVMSG "+testChannel+" :!profile "+nick)
+	waitFor(t, "profile on irc", func() bool {
+		return tb.ircd.HasReceived("listener", "NOTICE "+testChannel+" :"+nick+" is Discord user bob#0001 (they/them). Joined 2024-03-01. Roles: Mods, Regulars.")
+	})
+}
+
+func TestProfileIRC(t *testing.T) {
+	tb := newTestBridge(t, nil)
+	defer tb.Close()
+
+	done := make(chan string)
+	go func() { done <- tb.Bridge.profileIRC("alice") }()
+
+	waitFor(t, "whois to be sent", func() bool {
+		return tb.ircd.HasReceived("listener", "WHOIS alice")
+	})
+	tb.ircd.SendTo("listener", ":fake.ircd 311 listener alice ~a example.org * :Alice A")
+	tb.ircd.SendTo("listener", ":fake.ircd 319 listener alice :@#test #other")
+	tb.ircd.SendTo("listener", ":fake.ircd 330 listener alice alice :is logged in as")
+	tb.ircd.SendTo("listener", ":fake.ircd 317 listener alice 300 1709294400 :seconds idle, signon time")
+	tb.ircd.SendTo("listener", ":fake.ircd 318 listener alice :End of /WHOIS list.")
+
+	want := "alice is alice!~a@example.org (Alice A)\nLogged in as alice\nIn @#test #other\nIdle 5m0s, signed on 2024-03-01 12:00 UTC"
+	assert.Equal(t, want, <-done)
+
+	// The reply is cached
+	assert.Equal(t, want, tb.Bridge.profileIRC("Alice"))
+	whoises := 0
+	for _, line := range tb.ircd.Received("listener") {
+		if strings.HasPrefix(line, "WHOIS ") {
+			whoises++
+		}
+	}
+	assert.Equal(t, 1, whoises)
+
+	go func() { done <- tb.Bridge.profileIRC("nobody") }()
+	waitFor(t, "whois to be sent", func() bool {
+		return tb.ircd.HasReceived("listener", "WHOIS nobody")
+	})
+	tb.ircd.SendTo("listener", ":fake.ircd 401 listener nobody :No such nick/channel")
+	tb.ircd.SendTo("listener", ":fake.ircd 318 listener nobody :End of /WHOIS list.")
+	assert.Equal(t, "nobody is not on IRC.", <-done)
+}
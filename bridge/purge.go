@@ -0,0 +1,233 @@
+package bridge
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// A PurgeReport lists what was deleted about someone by PurgeDiscordUser or PurgeIRCAccount.
+type PurgeReport struct {
+	Subject string         // who the data was about, e.g. "Discord user 1234"
+	Deleted map[string]int // how many of each kind of data were deleted
+}
+
+func newPurgeReport(subject string) *PurgeReport {
+	return &PurgeReport{Subject: subject, Deleted: make(map[string]int)}
+}
+
+func (r *PurgeReport) add(kind string, n int) {
+	if n > 0 {
+		r.Deleted[kind] += n
+	}
+}
+
+// String describes the report in one line.
+func (r *PurgeReport) String() string {
+	if len(r.Deleted) == 0 {
+		return fmt.Sprintf("No data was stored about %s.", r.Subject)
+	}
+
+	kinds := make([]string, 0, len(r.Deleted))
+	for kind := range r.Deleted {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	for i, kind := range kinds {
+		kinds[i] = fmt.Sprintf("%s: %d", kind, r.Deleted[kind])
+	}
+	return fmt.Sprintf("Deleted data about %s. %s.", r.Subject, strings.Join(kinds, ", "))
+}
+
+// purgeKey deletes a key from the store, counting it in the report if it existed.
+func (b *Bridge) purgeKey(r *PurgeReport, kind, bucket, key string, v interface{}) error {
+	ok, err := b.store.Get(bucket, key, v)
+	if err != nil || !ok {
+		return err
+	}
+	if err := b.store.Delete(bucket, key); err != nil {
+		return err
+	}
+	r.add(kind, 1)
+	return nil
+}
+
+// PurgeDiscordUser deletes everything the bridge stores about a Discord user:
+// their identity link, karma, opt-out, pending link codes, relay statistics, and the messages
+// it remembers relaying for them. Their IRC puppet is disconnected. IRC users they ignored
+// stay ignored, but no longer record who ignored them.
+func (b *Bridge) PurgeDiscordUser(id string) (*PurgeReport, error) {
+	r := newPurgeReport("Discord user " + id)
+
+	if err := b.purgeKey(r, "identity links", linksBucket, id, &identityLink{}); err != nil {
+		return r, errors.Wrap(err, "could not delete identity link")
+	}
+	if err := b.purgeKey(r, "karma", karmaBucket, karmaKeyDiscord(id), &karmaScore{}); err != nil {
+		return r, errors.Wrap(err, "could not delete karma")
+	}
+	if err := b.purgeKey(r, "relay opt-outs", optOutBucket, karmaKeyDiscord(id), &optOut{}); err != nil {
+		return r, errors.Wrap(err, "could not delete relay opt-out")
+	}
+
+	for _, k := range b.store.Keys(ignoredBucket) {
+		var ignored ignoredUser
+		if ok, _ := b.store.Get(ignoredBucket, k, &ignored); ok && ignored.By == id {
+			ignored.By = ""
+			if err := b.store.Put(ignoredBucket, k, &ignored); err != nil {
+				return r, errors.Wrap(err, "could not forget who ignored an irc user")
+			}
+			r.add("ignores made", 1)
+		}
+	}
+
+	b.linkCodes.mu.Lock()
+	for code, pending := range b.linkCodes.codes {
+		if pending.DiscordID == id {
+			delete(b.linkCodes.codes, code)
+			r.add("link codes", 1)
+		}
+	}
+	b.linkCodes.mu.Unlock()
+
+	r.add("relayed messages", b.messages.Forget(func(msg *relayedMessage) bool {
+		return msg.DiscordAuthorID == id
+	}))
+	if b.stats.Forget(karmaKeyDiscord(id)) {
+		r.add("relay statistics", 1)
+	}
+
+	b.quietMu.Lock()
+	for channel, queued := range b.queuedToIRC {
+		kept := queued[:0]
+		for _, msg := range queued {
+			if msg.Author != nil && msg.Author.ID == id {
+				r.add("queued messages", 1)
+			} else {
+				kept = append(kept, msg)
+			}
+		}
+		b.queuedToIRC[channel] = kept
+	}
+	b.quietMu.Unlock()
+
+	if !b.Config.SimpleMode {
+		b.removeUserChan <- id
+	}
+
+	b.logPurge(r)
+	return r, nil
+}
+
+// PurgeIRCAccount deletes everything the bridge stores about an IRC services account:
+// identity links to it, karma, keyword notifications, opt-out, ignores, queued messages,
+// relay statistics, and the messages it remembers relaying for them.
+func (b *Bridge) PurgeIRCAccount(account string) (*PurgeReport, error) {
+	if account == "" {
+		return nil, errors.New("no account given")
+	}
+
+	r := newPurgeReport("IRC account " + account)
+	key := notifyKey("", account)
+
+	for _, id := range b.store.Keys(linksBucket) {
+		if link := b.linkByDiscord(id); link != nil && strings.EqualFold(link.IRCAccount, account) {
+			if err := b.purgeKey(r, "identity links", linksBucket, id, &identityLink{}); err != nil {
+				return r, errors.Wrap(err, "could not delete identity link")
+			}
+		}
+	}
+	if err := b.purgeKey(r, "karma", karmaBucket, key, &karmaScore{}); err != nil {
+		return r, errors.Wrap(err, "could not delete karma")
+	}
+	if err := b.purgeKey(r, "keyword notifications", notifyBucket, key, &notifySubscription{}); err != nil {
+		return r, errors.Wrap(err, "could not delete keyword notifications")
+	}
+	if err := b.purgeKey(r, "relay opt-outs", optOutBucket, key, &optOut{}); err != nil {
+		return r, errors.Wrap(err, "could not delete relay opt-out")
+	}
+
+	for _, k := range b.store.Keys(ignoredBucket) {
+		var ignored ignoredUser
+		if ok, _ := b.store.Get(ignoredBucket, k, &ignored); ok && strings.EqualFold(ignored.Account, account) {
+			if err := b.purgeKey(r, "ignores", ignoredBucket, k, &ignored); err != nil {
+				return r, errors.Wrap(err, "could not delete ignored irc user")
+			}
+		}
+	}
+
+	for _, k := range b.store.Keys(outboxBucket) {
+		var msg IRCMessage
+		if ok, _ := b.store.Get(outboxBucket, k, &msg); ok && strings.EqualFold(msg.Account, account) {
+			if err := b.purgeKey(r, "queued messages", outboxBucket, k, &msg); err != nil {
+				return r, errors.Wrap(err, "could not delete message from the outbox")
+			}
+		}
+	}
+
+	b.quietMu.Lock()
+	for channel, queued := range b.queuedToDiscord {
+		kept := queued[:0]
+		for _, msg := range queued {
+			if strings.EqualFold(msg.Account, account) {
+				r.add("queued messages", 1)
+			} else {
+				kept = append(kept, msg)
+			}
+		}
+		b.queuedToDiscord[channel] = kept
+	}
+	b.quietMu.Unlock()
+
+	r.add("relayed messages", b.messages.Forget(func(msg *relayedMessage) bool {
+		return strings.EqualFold(msg.IRCAccount, account)
+	}))
+	if b.stats.Forget(key) {
+		r.add("relay statistics", 1)
+	}
+
+	b.logPurge(r)
+	return r, nil
+}
+
+// handlePurge runs /bridge purge, returning the reports.
+func (d *discordBot) handlePurge(options []*discordgo.ApplicationCommandInteractionDataOption) string {
+	var reports []string
+	for _, opt := range options {
+		var r *PurgeReport
+		var err error
+		switch opt.Name {
+		case "discord_user":
+			r, err = d.bridge.PurgeDiscordUser(opt.UserValue(nil).ID)
+		case "irc_account":
+			r, err = d.bridge.PurgeIRCAccount(opt.StringValue())
+		default:
+			continue
+		}
+
+		if err != nil {
+			log.WithField("error", err).Errorln("could not purge stored data")
+			reports = append(reports, "Something went wrong purging data, check the logs.")
+		}
+		if r != nil {
+			reports = append(reports, r.String())
+		}
+	}
+
+	if len(reports) == 0 {
+		return "Give a Discord user or an IRC account to purge data about."
+	}
+	return strings.Join(reports, "\n")
+}
+
+func (b *Bridge) logPurge(r *PurgeReport) {
+	fields := log.Fields{"subject": r.Subject}
+	for kind, n := range r.Deleted {
+		fields[kind] = n
+	}
+	log.WithFields(fields).Infoln("Purged stored data.")
+}
@@ -0,0 +1,67 @@
+package bridge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPurgeDiscordUser(t *testing.T) {
+	tb := newTestBridge(t, nil)
+	defer tb.Close()
+	b := tb.Bridge
+
+	assert.NoError(t, b.saveLink(&identityLink{DiscordID: "100", IRCNick: "bob", IRCAccount: "bob", Linked: time.Now()}))
+	b.addKarma(karmaKeyDiscord("100"), "bob", 3)
+	b.addKarma(karmaKeyDiscord("200"), "carol", 1)
+	_, err := b.startLink("100")
+	assert.NoError(t, err)
+	b.messages.Add(&relayedMessage{DiscordChannel: testChannelID, DiscordID: "6000", DiscordAuthorID: "100", Content: "hello"})
+	b.messages.Add(&relayedMessage{DiscordChannel: testChannelID, DiscordID: "6001", DiscordAuthorID: "200", Content: "hi"})
+	assert.NoError(t, b.store.Put(ignoredBucket, "spam.example.com", &ignoredUser{Host: "spam.example.com", By: "100"}))
+
+	r, err := b.PurgeDiscordUser("100")
+	assert.NoError(t, err)
+	assert.Equal(t, "Deleted data about Discord user 100. identity links: 1, ignores made: 1, karma: 1, link codes: 1, relayed messages: 1.", r.String())
+
+	// The IRC user they ignored stays ignored
+	assert.True(t, b.isIgnored("spammer!s@spam.example.com"))
+	var ignored ignoredUser
+	_, err = b.store.Get(ignoredBucket, "spam.example.com", &ignored)
+	assert.NoError(t, err)
+	assert.Equal(t, "", ignored.By)
+
+	assert.Nil(t, b.linkByDiscord("100"))
+	assert.Nil(t, b.messages.ByDiscordID("6000"))
+	assert.NotNil(t, b.messages.ByDiscordID("6001"))
+	assert.Equal(t, 1, b.karma(karmaKeyDiscord("200")))
+
+	r, err = b.PurgeDiscordUser("100")
+	assert.NoError(t, err)
+	assert.Equal(t, "No data was stored about Discord user 100.", r.String())
+}
+
+func TestPurgeIRCAccount(t *testing.T) {
+	tb := newTestBridge(t, nil)
+	defer tb.Close()
+	b := tb.Bridge
+
+	key := notifyKey("", "Alice")
+	assert.NoError(t, b.saveLink(&identityLink{DiscordID: "100", IRCNick: "alice", IRCAccount: "alice"}))
+	assert.NoError(t, b.store.Put(notifyBucket, key, &notifySubscription{Nick: "alice", Account: "alice", Keywords: []string{"go"}}))
+	assert.NoError(t, b.setOptOut(key, "alice", true))
+	assert.NoError(t, b.store.Put(outboxBucket, "1", IRCMessage{Username: "alice", Account: "alice", Message: "hello"}))
+	b.messages.Add(&relayedMessage{DiscordID: "6000", IRCNick: "alice", IRCAccount: "alice"})
+	assert.NoError(t, b.store.Put(ignoredBucket, "alice.example.com", &ignoredUser{Host: "alice.example.com", Account: "alice"}))
+
+	r, err := b.PurgeIRCAccount("Alice")
+	assert.NoError(t, err)
+	assert.Equal(t, "Deleted data about IRC account Alice. identity links: 1, ignores: 1, keyword notifications: 1, queued messages: 1, relay opt-outs: 1, relayed messages: 1.", r.String())
+	assert.False(t, b.isIgnored("alice!a@alice.example.com"))
+	assert.Empty(t, b.store.Keys(outboxBucket))
+	assert.False(t, b.optedOut(key))
+
+	_, err = b.PurgeIRCAccount("")
+	assert.Error(t, err)
+}
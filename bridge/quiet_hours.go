@@ -0,0 +1,211 @@
+package bridge
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Directions quiet hours apply to, for ChannelOptions.QuietDirection
+const (
+	quietBoth      = "both"
+	quietToIRC     = "to_irc"
+	quietToDiscord = "to_discord"
+)
+
+// quietQueueLimit is the most messages queued per channel and direction during quiet hours.
+// The oldest messages are dropped first.
+var quietQueueLimit = 100
+
+// quietCheckInterval is how often queues are checked for quiet hours that have ended
+var quietCheckInterval = time.Minute
+
+// quietHours is a daily time range during which relaying is paused.
+type quietHours struct {
+	start, end int // minutes since midnight
+	loc        *time.Location
+	direction  string
+	queue      bool
+}
+
+// parseQuietHours parses the quiet hours of a mapping, or returns nil if it has none.
+func parseQuietHours(opts ChannelOptions) (*quietHours, error) {
+	if opts.QuietHours == "" {
+		return nil, nil
+	}
+
+	parts := strings.Split(opts.QuietHours, "-")
+	if len(parts) != 2 {
+		return nil, errors.Errorf("quiet hours %q should look like 22:00-07:00", opts.QuietHours)
+	}
+
+	q := &quietHours{
+		loc:       time.Local,
+		direction: opts.QuietDirection,
+		queue:     opts.QuietQueue,
+	}
+
+	for i, part := range parts {
+		t, err := time.Parse("15:04", strings.TrimSpace(part))
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid quiet hours %q", opts.QuietHours)
+		}
+		if i == 0 {
+			q.start = t.Hour()*60 + t.Minute()
+		} else {
+			q.end = t.Hour()*60 + t.Minute()
+		}
+	}
+	if q.start == q.end {
+		return nil, errors.Errorf("quiet hours %q start and end at the same time", opts.QuietHours)
+	}
+
+	if opts.QuietTimezone != "" {
+		loc, err := time.LoadLocation(opts.QuietTimezone)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid quiet hours timezone %q", opts.QuietTimezone)
+		}
+		q.loc = loc
+	}
+
+	switch q.direction {
+	case "":
+		q.direction = quietBoth
+	case quietBoth, quietToIRC, quietToDiscord:
+	default:
+		return nil, errors.Errorf("quiet hours direction %q should be both, to_irc or to_discord", q.direction)
+	}
+
+	return q, nil
+}
+
+// Active returns true if relaying in the given direction is paused at the given time.
+func (q *quietHours) Active(t time.Time, direction string) bool {
+	if q.direction != quietBoth && q.direction != direction {
+		return false
+	}
+
+	t = t.In(q.loc)
+	now := t.Hour()*60 + t.Minute()
+
+	// Ranges like 22:00-07:00 wrap around midnight
+	if q.start < q.end {
+		return now >= q.start && now < q.end
+	}
+	return now >= q.start || now < q.end
+}
+
+// stamp prefixes content with the time, so queued messages make sense when they are delivered.
+func (q *quietHours) stamp(t time.Time, content string) string {
+	return "[" + t.In(q.loc).Format("15:04") + "] " + content
+}
+
+// quietHoursFor returns the quiet hours of the mapping with the given IRC channel, if any.
+func (b *Bridge) quietHoursFor(ircChannel string) *quietHours {
+	return b.quiet[strings.ToLower(strings.Split(ircChannel, " ")[0])]
+}
+
+// holdToDiscord returns true if a message from IRC is held back by quiet hours.
+func (b *Bridge) holdToDiscord(msg IRCMessage) bool {
+	q := b.quietHoursFor(msg.IRCChannel)
+	if q == nil || !q.Active(time.Now(), quietToDiscord) {
+		return false
+	}
+
+	if q.queue {
+		msg.Message = q.stamp(time.Now(), msg.Message)
+		msg.Held = true
+
+		key := strings.ToLower(msg.IRCChannel)
+		b.quietMu.Lock()
+		b.queuedToDiscord[key] = append(b.queuedToDiscord[key], msg)
+		overflow := len(b.queuedToDiscord[key]) > quietQueueLimit
+		if overflow {
+			b.queuedToDiscord[key] = b.queuedToDiscord[key][1:]
+		}
+		b.quietMu.Unlock()
+
+		if overflow {
+			b.statusProblem("quiet:"+key, "Too many messages from "+msg.IRCChannel+" were queued during quiet hours, so the oldest are being dropped.")
+		}
+	}
+	return true
+}
+
+// holdToIRC returns true if a message from Discord is held back by quiet hours.
+func (b *Bridge) holdToIRC(ircChannel string, msg *DiscordMessage) bool {
+	q := b.quietHoursFor(ircChannel)
+	if q == nil || !q.Active(time.Now(), quietToIRC) {
+		return false
+	}
+
+	if q.queue {
+		queued := *msg
+		queued.Content = q.stamp(time.Now(), msg.Content)
+		queued.Held = true
+
+		key := strings.ToLower(strings.Split(ircChannel, " ")[0])
+		b.quietMu.Lock()
+		b.queuedToIRC[key] = append(b.queuedToIRC[key], &queued)
+		overflow := len(b.queuedToIRC[key]) > quietQueueLimit
+		if overflow {
+			b.queuedToIRC[key] = b.queuedToIRC[key][1:]
+		}
+		b.quietMu.Unlock()
+
+		if overflow {
+			b.statusProblem("quiet:"+key, "Too many messages to "+key+" were queued during quiet hours, so the oldest are being dropped.")
+		}
+	}
+	return true
+}
+
+// flushQuietQueues relays the messages queued for channels whose quiet hours have ended.
+func (b *Bridge) flushQuietQueues() {
+	now := time.Now()
+	toDiscord := []IRCMessage{}
+	toIRC := []*DiscordMessage{}
+
+	b.quietMu.Lock()
+	flushed := []string{}
+	for channel, queued := range b.queuedToDiscord {
+		if !b.quietHoursFor(channel).Active(now, quietToDiscord) {
+			toDiscord = append(toDiscord, queued...)
+			delete(b.queuedToDiscord, channel)
+			flushed = append(flushed, channel)
+		}
+	}
+	for channel, queued := range b.queuedToIRC {
+		if !b.quietHoursFor(channel).Active(now, quietToIRC) {
+			toIRC = append(toIRC, queued...)
+			delete(b.queuedToIRC, channel)
+			flushed = append(flushed, channel)
+		}
+	}
+	b.quietMu.Unlock()
+
+	for _, channel := range flushed {
+		b.statusResolved("quiet:"+channel, "")
+	}
+
+	if len(toDiscord) == 0 && len(toIRC) == 0 {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"toDiscord": len(toDiscord),
+		"toIRC":     len(toIRC),
+	}).Infoln("Quiet hours have ended, relaying queued messages.")
+
+	// The loop relays these, so they can't be sent from the loop itself
+	go func() {
+		for _, msg := range toDiscord {
+			b.discordMessagesChan <- msg
+		}
+		for _, msg := range toIRC {
+			b.discordMessageEventsChan <- msg
+		}
+	}()
+}
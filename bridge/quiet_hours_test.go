@@ -0,0 +1,91 @@
+package bridge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseQuietHours(t *testing.T) {
+	q, err := parseQuietHours(ChannelOptions{})
+	assert.NoError(t, err)
+	assert.Nil(t, q)
+
+	for _, bad := range []ChannelOptions{
+		{QuietHours: "22:00"},
+		{QuietHours: "22:00-25:00"},
+		{QuietHours: "07:00-07:00"},
+		{QuietHours: "22:00-07:00", QuietTimezone: "Mars/Olympus_Mons"},
+		{QuietHours: "22:00-07:00", QuietDirection: "sideways"},
+	} {
+		_, err := parseQuietHours(bad)
+		assert.Error(t, err, bad.QuietHours)
+	}
+}
+
+func TestQuietHoursActive(t *testing.T) {
+	at := func(clock string) time.Time {
+		parsed, _ := time.Parse("15:04", clock)
+		return time.Date(2026, 10, 14, parsed.Hour(), parsed.Minute(), 0, 0, time.UTC)
+	}
+
+	overnight, err := parseQuietHours(ChannelOptions{QuietHours: "22:00-07:00", QuietTimezone: "UTC"})
+	assert.NoError(t, err)
+	assert.True(t, overnight.Active(at("23:30"), quietToIRC))
+	assert.True(t, overnight.Active(at("06:59"), quietToDiscord))
+	assert.False(t, overnight.Active(at("07:00"), quietToIRC))
+	assert.False(t, overnight.Active(at("12:00"), quietToIRC))
+
+	lunch, err := parseQuietHours(ChannelOptions{QuietHours: "12:00-13:00", QuietTimezone: "UTC", QuietDirection: quietToIRC})
+	assert.NoError(t, err)
+	assert.True(t, lunch.Active(at("12:30"), quietToIRC))
+	assert.False(t, lunch.Active(at("12:30"), quietToDiscord))
+	assert.False(t, lunch.Active(at("11:59"), quietToIRC))
+}
+
+func TestQuietHoursQueue(t *testing.T) {
+	now := time.Now().UTC()
+	q, err := parseQuietHours(ChannelOptions{
+		QuietHours:    now.Add(-time.Hour).Format("15:04") + "-" + now.Add(time.Hour).Format("15:04"),
+		QuietTimezone: "UTC",
+		QuietQueue:    true,
+	})
+	assert.NoError(t, err)
+
+	b := &Bridge{
+		quiet:                    map[string]*quietHours{"#test": q},
+		queuedToDiscord:          make(map[string][]IRCMessage),
+		queuedToIRC:              make(map[string][]*DiscordMessage),
+		discordMessagesChan:      make(chan IRCMessage, 10),
+		discordMessageEventsChan: make(chan *DiscordMessage, 10),
+	}
+
+	msg := &DiscordMessage{Message: &discordgo.Message{ID: "1"}, Content: "good night"}
+	assert.True(t, b.holdToIRC("#Test", msg))
+	assert.True(t, b.holdToDiscord(IRCMessage{IRCChannel: "#test", Message: "night!"}))
+	assert.False(t, b.holdToIRC("#other", msg))
+	assert.Equal(t, "good night", msg.Content)
+
+	// Nothing is relayed until quiet hours end
+	b.flushQuietQueues()
+	assert.Len(t, b.queuedToIRC["#test"], 1)
+
+	q.start, q.end = q.end, q.end+1
+	b.flushQuietQueues()
+
+	stamp := "[" + time.Now().UTC().Format("15:04") + "] "
+	select {
+	case queued := <-b.discordMessageEventsChan:
+		assert.Equal(t, stamp+"good night", queued.Content)
+	case <-time.After(time.Second):
+		t.Fatal("queued message was not relayed to irc")
+	}
+	select {
+	case queued := <-b.discordMessagesChan:
+		assert.Equal(t, stamp+"night!", queued.Message)
+	case <-time.After(time.Second):
+		t.Fatal("queued message was not relayed to discord")
+	}
+}
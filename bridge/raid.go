@@ -0,0 +1,168 @@
+package bridge
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// raidAccountAge is how old an account, or guild membership, must be not to count as new
+	raidAccountAge = 7 * 24 * time.Hour
+
+	// raidRateInterval is the least time between relayed messages from the same person during a raid
+	raidRateInterval = 5 * time.Second
+)
+
+// raidLink matches links, which are removed from messages during a raid
+var raidLink = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+`)
+
+// raidDetector watches for lots of new Discord accounts joining or talking at once.
+//
+// While a raid is on, messages from new accounts are not relayed to IRC,
+// links are removed, and everyone is rate limited. The raid ends once
+// there has been no activity from new accounts for RaidCooldown.
+type raidDetector struct {
+	mu sync.Mutex
+
+	// seen is when each new account was last active, within RaidWindow
+	seen map[string]time.Time
+
+	// until is when the current raid ends, if there is one,
+	// and lastRelayed is when each person was last relayed during it
+	until       time.Time
+	timer       *time.Timer
+	lastRelayed map[string]time.Time
+}
+
+func newRaidDetector() *raidDetector {
+	return &raidDetector{
+		seen:        make(map[string]time.Time),
+		lastRelayed: make(map[string]time.Time),
+	}
+}
+
+// isNewAccount returns true if a Discord account, or its membership of the guild, is recent.
+func (d *discordBot) isNewAccount(user *discordgo.User, member *discordgo.Member) bool {
+	if created, err := discordgo.SnowflakeTimestamp(user.ID); err == nil && time.Since(created) < raidAccountAge {
+		return true
+	}
+
+	if member == nil {
+		member, _ = d.State.Member(d.guildID, user.ID)
+	}
+	return member != nil && !member.JoinedAt.IsZero() && time.Since(member.JoinedAt) < raidAccountAge
+}
+
+// raidActivity records a join or message from a Discord user, starting or extending a raid
+// if enough new accounts have been active within RaidWindow.
+func (b *Bridge) raidActivity(user *discordgo.User, member *discordgo.Member) {
+	if b.Config.RaidThreshold <= 0 || !b.discord.isNewAccount(user, member) {
+		return
+	}
+
+	r := b.raid
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.seen[user.ID] = now
+	for id, t := range r.seen {
+		if now.Sub(t) > b.Config.RaidWindow {
+			delete(r.seen, id)
+		}
+	}
+
+	if now.Before(r.until) {
+		r.until = now.Add(b.Config.RaidCooldown)
+		return
+	}
+	if len(r.seen) < b.Config.RaidThreshold {
+		return
+	}
+
+	r.until = now.Add(b.Config.RaidCooldown)
+	r.timer = time.AfterFunc(b.Config.RaidCooldown, b.checkRaidOver)
+
+	log.WithField("accounts", len(r.seen)).Warnln("Raid detected, relaying to IRC more strictly.")
+	go b.raidAlert(fmt.Sprintf("Possible raid: %d new Discord accounts were active within %s. New accounts are not relayed to IRC, links are removed and messages are rate limited until things calm down.", len(r.seen), b.Config.RaidWindow))
+}
+
+// checkRaidOver ends the raid if the cooldown has passed, or checks again when it will have.
+func (b *Bridge) checkRaidOver() {
+	r := b.raid
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if wait := time.Until(r.until); wait > 0 {
+		r.timer = time.AfterFunc(wait, b.checkRaidOver)
+		return
+	}
+
+	r.timer = nil
+	r.seen = make(map[string]time.Time)
+	r.lastRelayed = make(map[string]time.Time)
+
+	log.Infoln("Raid is over, relaying to IRC as normal.")
+	go b.raidAlert("The raid seems to be over, messages are relayed to IRC as normal again.")
+}
+
+// raidActive returns true if there is a raid on.
+func (b *Bridge) raidActive() bool {
+	b.raid.mu.Lock()
+	defer b.raid.mu.Unlock()
+	return time.Now().Before(b.raid.until)
+}
+
+// raidFilter applies the stricter relay policy to a message during a raid.
+// It returns false if the message should not be relayed, and removes links from the rest.
+func (b *Bridge) raidFilter(msg *DiscordMessage) bool {
+	b.raidActivity(msg.Author, msg.Member)
+	if !b.raidActive() {
+		return true
+	}
+
+	if b.discord.isNewAccount(msg.Author, msg.Member) {
+		log.WithField("author", msg.Author.ID).Debugln("Not relaying a message from a new account during a raid.")
+		return false
+	}
+
+	r := b.raid
+	r.mu.Lock()
+	last, ok := r.lastRelayed[msg.Author.ID]
+	limited := ok && time.Since(last) < raidRateInterval
+	if !limited {
+		r.lastRelayed[msg.Author.ID] = time.Now()
+	}
+	r.mu.Unlock()
+	if limited {
+		b.traces.Step(msg.TraceID, traceRateLimit, "rate limited during a raid")
+		log.WithField("author", msg.Author.ID).Debugln("Not relaying a message, rate limited during a raid.")
+		return false
+	}
+
+	msg.Content = raidLink.ReplaceAllString(msg.Content, "<link removed>")
+
+	// Links can be disguised, like "ｈttps://" or "www\u200B.example.com"
+	if raidLink.MatchString(normalizeContent(msg.Content)) {
+		log.WithField("author", msg.Author.ID).Debugln("Not relaying a message with a disguised link during a raid.")
+		return false
+	}
+	return true
+}
+
+// raidAlert tells moderators about a raid in the audit and report channels.
+func (b *Bridge) raidAlert(message string) {
+	if channel := b.Config.AuditIRCChannel; channel != "" {
+		b.ircListener.Notice(channel, "[Discord] "+message)
+	}
+	if channel := b.Config.ReportDiscordChannel; channel != "" {
+		if _, err := b.discord.ChannelMessageSend(channel, message); err != nil {
+			b.handleError(err, nil, "could not post raid alert to discord")
+		}
+	}
+}
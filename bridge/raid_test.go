@@ -0,0 +1,91 @@
+package bridge
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+// newSnowflake returns a Discord ID made now, like that of a brand new account.
+func newSnowflake(n int) string {
+	ms := time.Now().UnixNano()/int64(time.Millisecond) - 1420070400000
+	return strconv.FormatInt(ms<<22|int64(n), 10)
+}
+
+func TestRaidDetection(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.AuditIRCChannel = "#audit"
+		conf.RaidThreshold = 3
+		conf.RaidWindow = time.Minute
+		conf.RaidCooldown = 300 * time.Millisecond
+	})
+	defer tb.Close()
+	waitFor(t, "listener to join the audit channel", func() bool {
+		return tb.ircd.InChannel("#audit", "listener")
+	})
+
+	d := tb.Bridge.discord
+	bob := tb.discordMember("100", "bob", "")
+	carol := tb.discordMember("200", "carol", "")
+
+	var raiders []*discordgo.User
+	for i := 0; i < 3; i++ {
+		user := &discordgo.User{ID: newSnowflake(i), Username: fmt.Sprintf("raider%d", i)}
+		raiders = append(raiders, user)
+		d.onMemberJoin(d.Session, &discordgo.GuildMemberAdd{Member: &discordgo.Member{GuildID: testGuildID, User: user}})
+	}
+	assert.True(t, tb.Bridge.raidActive())
+	waitFor(t, "raid alert", func() bool {
+		for _, line := range tb.ircd.Received("listener") {
+			if line == "NOTICE #audit :[Discord] Possible raid: 3 new Discord accounts were active within 1m0s. New accounts are not relayed to IRC, links are removed and messages are rate limited until things calm down." {
+				return true
+			}
+		}
+		return false
+	})
+
+	// New accounts aren't relayed, links are removed and people are rate limited
+	tb.discordSay(raiders[0], "spam spam spam")
+	tb.discordSay(bob, "look at https://example.com/spam")
+	tb.discordSay(bob, "and again")
+	tb.discordSay(carol, "spam at www\u200B.example.com")
+	waitFor(t, "message without link", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<b\u200Bob#0001> look at <link removed>")
+	})
+
+	waitFor(t, "raid to end", func() bool {
+		return tb.ircd.HasReceived("listener", "NOTICE #audit :[Discord] The raid seems to be over, messages are relayed to IRC as normal again.")
+	})
+	assert.False(t, tb.Bridge.raidActive())
+
+	tb.discordSay(bob, "see https://example.com")
+	waitFor(t, "message with link", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<b\u200Bob#0001> see https://example.com")
+	})
+	for _, line := range tb.ircd.Received("listener") {
+		assert.NotContains(t, line, "spam spam")
+		assert.NotContains(t, line, "and again")
+		assert.NotContains(t, line, "spam at")
+	}
+}
+
+func TestRaidIgnoresOldAccounts(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.RaidThreshold = 2
+		conf.RaidWindow = time.Minute
+		conf.RaidCooldown = time.Minute
+	})
+	defer tb.Close()
+
+	for _, id := range []string{"100", "101", "102"} {
+		tb.discordSay(tb.discordMember(id, "user"+id, ""), "hello")
+	}
+	waitFor(t, "messages on irc", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<u\u200Bser102#0001> hello")
+	})
+	assert.False(t, tb.Bridge.raidActive())
+}
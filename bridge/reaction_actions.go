@@ -0,0 +1,186 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+// ignoredBucket is the store bucket for IRC users whose messages are no longer relayed,
+// keyed by lowercase host
+const ignoredBucket = "ignored"
+
+// Actions that can be triggered by reactions, for Config.ReactionActions
+const (
+	reactionQuiet  = "quiet"  // quiet the IRC user in the channel
+	reactionIgnore = "ignore" // stop relaying the IRC user
+)
+
+// ignoredUser is an IRC user whose messages are no longer relayed.
+type ignoredUser struct {
+	Host    string
+	Account string // services account of the IRC user, if known
+	By      string // Discord ID of the moderator
+	Time    time.Time
+}
+
+// reactionTarget finds the action for a reaction, and the relayed IRC message it acts on.
+// It returns ok as false if the reaction isn't an action a moderator can take on the message.
+func (d *discordBot) reactionTarget(s *discordgo.Session, r *discordgo.MessageReaction) (action string, msg *relayedMessage, host string, ok bool) {
+	action, ok = d.bridge.Config.ReactionActions[r.Emoji.Name]
+	if !ok || r.GuildID != d.guildID || s.State.User == nil || r.UserID == s.State.User.ID {
+		return "", nil, "", false
+	}
+
+	msg = d.bridge.messages.ByDiscordID(r.MessageID)
+	if msg == nil || msg.IRCNick == "" || msg.IRCHostmask == "" {
+		return "", nil, "", false
+	}
+
+	// Only moderators of the channel can act on IRC users
+	perms, err := d.State.UserChannelPermissions(r.UserID, r.ChannelID)
+	if err != nil || perms&discordgo.PermissionManageMessages == 0 {
+		return "", nil, "", false
+	}
+
+	_, _, host = parseHostmask(msg.IRCHostmask)
+	if host == "" {
+		return "", nil, "", false
+	}
+	return action, msg, host, true
+}
+
+// onReactionAdd lets Discord moderators act on the IRC sender of a relayed message by reacting to it.
+func (d *discordBot) onReactionAdd(s *discordgo.Session, r *discordgo.MessageReactionAdd) {
+	action, msg, host, ok := d.reactionTarget(s, r.MessageReaction)
+	if !ok {
+		return
+	}
+
+	moderator := d.memberName(r.UserID)
+	fields := log.Fields{
+		"action":    action,
+		"moderator": moderator,
+		"hostmask":  msg.IRCHostmask,
+		"channel":   msg.IRCChannel,
+	}
+
+	switch action {
+	case reactionQuiet:
+		d.bridge.ircListener.quiet(msg.IRCChannel, "*!*@"+host)
+	case reactionIgnore:
+		err := d.bridge.store.Put(ignoredBucket, strings.ToLower(host), &ignoredUser{
+			Host:    host,
+			Account: msg.IRCAccount,
+			By:      r.UserID,
+			Time:    time.Now(),
+		})
+		if err != nil {
+			d.bridge.handleError(err, fields, "could not save ignored irc user")
+			return
+		}
+	default:
+		log.WithFields(fields).Warnln("Unknown reaction action.")
+		return
+	}
+
+	log.WithFields(fields).Infoln("Discord moderator acted on an IRC user by reacting.")
+	if channel := d.bridge.Config.AuditIRCChannel; channel != "" {
+		d.bridge.ircListener.Notice(channel, fmt.Sprintf("[Discord] %s used %s on %s (%s) via a reaction", moderator, action, msg.IRCNick, msg.IRCHostmask))
+	}
+}
+
+// onReactionRemove relays the IRC user's messages again when a moderator takes back an ignore reaction.
+func (d *discordBot) onReactionRemove(s *discordgo.Session, r *discordgo.MessageReactionRemove) {
+	action, msg, host, ok := d.reactionTarget(s, r.MessageReaction)
+	if !ok || action != reactionIgnore {
+		return
+	}
+
+	moderator := d.memberName(r.UserID)
+	fields := log.Fields{
+		"moderator": moderator,
+		"hostmask":  msg.IRCHostmask,
+		"channel":   msg.IRCChannel,
+	}
+
+	removed, err := d.bridge.unignore(host)
+	if err != nil {
+		d.bridge.handleError(err, fields, "could not remove ignored irc user")
+		return
+	}
+	if !removed {
+		return
+	}
+
+	log.WithFields(fields).Infoln("Discord moderator stopped ignoring an IRC user by removing their reaction.")
+	if channel := d.bridge.Config.AuditIRCChannel; channel != "" {
+		d.bridge.ircListener.Notice(channel, fmt.Sprintf("[Discord] %s stopped ignoring %s (%s) via a reaction", moderator, msg.IRCNick, msg.IRCHostmask))
+	}
+}
+
+// handleUnignore runs /bridge unignore, relaying an ignored IRC user's messages again.
+func (d *discordBot) handleUnignore(moderator *discordgo.User, options []*discordgo.ApplicationCommandInteractionDataOption) string {
+	if len(options) == 0 {
+		return "Give the host of the IRC user to stop ignoring."
+	}
+
+	// Accept a whole hostmask as well as just the host
+	host := options[0].StringValue()
+	if _, _, h := parseHostmask(host); h != "" {
+		host = h
+	}
+
+	fields := log.Fields{"moderator": moderator.Username, "host": host}
+	removed, err := d.bridge.unignore(host)
+	if err != nil {
+		d.bridge.handleError(err, fields, "could not remove ignored irc user")
+		return "Something went wrong, check the logs."
+	}
+	if !removed {
+		return fmt.Sprintf("No IRC user with the host %s is ignored.", host)
+	}
+
+	log.WithFields(fields).Infoln("Discord moderator stopped ignoring an IRC user.")
+	if channel := d.bridge.Config.AuditIRCChannel; channel != "" {
+		d.bridge.ircListener.Notice(channel, fmt.Sprintf("[Discord] %s stopped ignoring *!*@%s", moderator.Username, host))
+	}
+	return fmt.Sprintf("Messages from %s are relayed again.", host)
+}
+
+// unignore relays the IRC user with this host again, returning false if they weren't ignored.
+func (b *Bridge) unignore(host string) (bool, error) {
+	key := strings.ToLower(host)
+	ok, err := b.store.Get(ignoredBucket, key, &ignoredUser{})
+	if err != nil || !ok {
+		return false, err
+	}
+	return true, b.store.Delete(ignoredBucket, key)
+}
+
+// quiet stops a hostmask from speaking in a channel, using the +q mode if the listener
+// is a channel operator, or ChanServ otherwise.
+func (i *ircListener) quiet(channel, mask string) {
+	if i.isChannelOp(channel, i.GetNick()) {
+		i.SendRawf("MODE %s +q %s", channel, mask)
+		return
+	}
+	i.Privmsgf("ChanServ", "QUIET %s %s", channel, mask)
+}
+
+// isIgnored returns true if a Discord moderator has stopped the IRC user with this hostmask being relayed.
+func (b *Bridge) isIgnored(hostmask string) bool {
+	_, _, host := parseHostmask(hostmask)
+	if host == "" {
+		return false
+	}
+
+	ok, err := b.store.Get(ignoredBucket, strings.ToLower(host), &ignoredUser{})
+	if err != nil {
+		log.WithField("error", err).Warnln("could not check if irc user is ignored")
+	}
+	return ok
+}
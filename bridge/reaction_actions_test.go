@@ -0,0 +1,88 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReactionActions(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.ReactionActions = map[string]string{"🔇": reactionQuiet, "❌": reactionIgnore}
+	})
+	defer tb.Close()
+
+	d := tb.Bridge.discord
+	guild, _ := d.State.Guild(testGuildID)
+	guild.Roles = append(guild.Roles,
+		&discordgo.Role{ID: testGuildID, Permissions: discordgo.PermissionViewChannel},
+		&discordgo.Role{ID: "4001", Permissions: discordgo.PermissionManageMessages},
+	)
+	tb.discordMember("100", "bob", "")
+	assert.NoError(t, d.State.MemberAdd(&discordgo.Member{GuildID: testGuildID, User: &discordgo.User{ID: "200", Username: "mod"}, Roles: []string{"4001"}}))
+
+	tb.ircd.Inject("alice!al@spam.example.com", testChannel, "PRIVMSG "+testChannel+" :buy my stuff")
+	var relayed *relayedMessage
+	waitFor(t, "message on discord", func() bool {
+		relayed = tb.messages.LatestFromIRC(testChannel, "buy my stuff")
+		return relayed != nil
+	})
+
+	react := func(userID, emoji string) {
+		d.onReactionAdd(d.Session, &discordgo.MessageReactionAdd{MessageReaction: &discordgo.MessageReaction{
+			UserID:    userID,
+			MessageID: relayed.DiscordID,
+			ChannelID: testChannelID,
+			GuildID:   testGuildID,
+			Emoji:     discordgo.Emoji{Name: emoji},
+		}})
+	}
+
+	// Members who aren't moderators can't act on IRC users
+	react("100", "🔇")
+	react("200", "👍")
+	react("200", "🔇")
+	waitFor(t, "quiet", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG ChanServ :QUIET "+testChannel+" *!*@spam.example.com")
+	})
+	count := 0
+	for _, line := range tb.ircd.Received("listener") {
+		if line == "PRIVMSG ChanServ :QUIET "+testChannel+" *!*@spam.example.com" {
+			count++
+		}
+	}
+	assert.Equal(t, 1, count)
+
+	assert.False(t, tb.isIgnored("alice!al@spam.example.com"))
+	react("200", "❌")
+	assert.True(t, tb.isIgnored("alice2!al@SPAM.example.com"))
+	assert.False(t, tb.isIgnored("carol!c@example.com"))
+
+	unreact := func(userID, emoji string) {
+		d.onReactionRemove(d.Session, &discordgo.MessageReactionRemove{MessageReaction: &discordgo.MessageReaction{
+			UserID:    userID,
+			MessageID: relayed.DiscordID,
+			ChannelID: testChannelID,
+			GuildID:   testGuildID,
+			Emoji:     discordgo.Emoji{Name: emoji},
+		}})
+	}
+
+	// Taking back the reaction undoes the ignore, but only for moderators
+	unreact("100", "❌")
+	assert.True(t, tb.isIgnored("alice!al@spam.example.com"))
+	unreact("200", "❌")
+	assert.False(t, tb.isIgnored("alice!al@spam.example.com"))
+
+	// and so does /bridge unignore
+	react("200", "❌")
+	assert.True(t, tb.isIgnored("alice!al@spam.example.com"))
+	mod := &discordgo.User{ID: "200", Username: "mod"}
+	host := func(value string) []*discordgo.ApplicationCommandInteractionDataOption {
+		return []*discordgo.ApplicationCommandInteractionDataOption{{Name: "host", Type: discordgo.ApplicationCommandOptionString, Value: value}}
+	}
+	assert.Equal(t, "Messages from spam.example.com are relayed again.", d.handleUnignore(mod, host("alice!al@spam.example.com")))
+	assert.False(t, tb.isIgnored("alice!al@spam.example.com"))
+	assert.Equal(t, "No IRC user with the host spam.example.com is ignored.", d.handleUnignore(mod, host("spam.example.com")))
+}
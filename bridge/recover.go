@@ -0,0 +1,55 @@
+package bridge
+
+import (
+	"fmt"
+	"reflect"
+	"runtime/debug"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// recoverPanic logs a panic instead of letting it take down the bridge.
+// It must be deferred.
+func (b *Bridge) recoverPanic(where string) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	stack := debug.Stack()
+
+	b.activity.Panicked()
+	log.WithFields(log.Fields{
+		"panic": fmt.Sprint(r),
+		"where": where,
+		"stack": string(stack),
+		"alert": true,
+	}).Errorln("recovered from panic")
+
+	if b.Config.PanicReporter != nil {
+		b.Config.PanicReporter(r, stack)
+	}
+}
+
+// addHandler registers a discordgo event handler, wrapped so that a panic
+// in the handler is recovered and logged, and so that standbys ignore the event.
+func (d *discordBot) addHandler(handler interface{}) func() {
+	return d.AddHandler(d.recoverHandler(d.leaderOnly(handler)))
+}
+
+// recoverHandler wraps a discordgo event handler with recoverPanic.
+// The returned handler has the same type as the original.
+func (d *discordBot) recoverHandler(handler interface{}) interface{} {
+	fn := reflect.ValueOf(handler)
+	name := fn.Type().String()
+	if fn.Type().NumIn() > 1 {
+		name = fn.Type().In(1).String()
+	}
+
+	wrapped := reflect.MakeFunc(fn.Type(), func(args []reflect.Value) []reflect.Value {
+		defer d.bridge.recoverPanic(name)
+		return fn.Call(args)
+	})
+
+	return wrapped.Interface()
+}
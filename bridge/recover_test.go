@@ -0,0 +1,32 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandlerPanicRecovered(t *testing.T) {
+	d := newFuzzDiscord(t)
+
+	var reported interface{}
+	d.bridge.Config.PanicReporter = func(r interface{}, stack []byte) {
+		reported = r
+		assert.NotEmpty(t, stack)
+	}
+
+	handler := d.recoverHandler(func(s *discordgo.Session, m *discordgo.MessageCreate) {
+		panic("boom")
+	})
+
+	// discordgo finds the event type from the handler's type, so it must not change
+	wrapped, ok := handler.(func(*discordgo.Session, *discordgo.MessageCreate))
+	assert.True(t, ok)
+
+	assert.NotPanics(t, func() {
+		wrapped(d.Session, &discordgo.MessageCreate{Message: &discordgo.Message{}})
+	})
+	assert.Equal(t, "boom", reported)
+	assert.Equal(t, 1, d.bridge.activity.panics)
+}
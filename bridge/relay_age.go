@@ -0,0 +1,169 @@
+package bridge
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	irc "github.com/qaisjp/go-ircevent"
+	log "github.com/sirupsen/logrus"
+)
+
+// What happens to messages older than the maximum relay age, for Config.LateMessages
+const (
+	lateSummarize = "summarize"
+	lateDrop      = "drop"
+)
+
+func validateMaxRelayAge(opts *Config) error {
+	if opts.MaxRelayAge < 0 {
+		return errors.New("max_relay_age must not be negative")
+	}
+	switch opts.LateMessages {
+	case "", lateSummarize, lateDrop:
+	default:
+		return errors.Errorf("unknown late_messages %q, must be %q or %q", opts.LateMessages, lateSummarize, lateDrop)
+	}
+	for channel, channelOpts := range opts.ChannelOptions {
+		if channelOpts.MaxRelayAge < 0 {
+			return errors.Errorf("channel options for %s: max_relay_age must not be negative", channel)
+		}
+	}
+	return nil
+}
+
+// lateMessages counts the messages that were too old to relay since the last summary,
+// keyed by the channel they would have been relayed to.
+type lateMessages struct {
+	mu        sync.Mutex
+	toDiscord map[string]int // keyed by Discord channel ID
+	toIRC     map[string]int // keyed by IRC channel
+}
+
+// serverTime returns when an IRC message was sent: its server-time tag, which bouncers
+// set on playback, or now if it doesn't have one.
+func serverTime(e *irc.Event) time.Time {
+	if t, err := time.Parse(time.RFC3339Nano, e.Tags["time"]); err == nil {
+		return t
+	}
+	return time.Now()
+}
+
+// discordSentAt returns when a Discord message was sent, or edited if it is an edit.
+func discordSentAt(m *discordgo.Message) time.Time {
+	if m == nil {
+		return time.Time{}
+	}
+	if m.EditedTimestamp != nil {
+		return *m.EditedTimestamp
+	}
+	return m.Timestamp
+}
+
+// maxRelayAge returns how old a message in a mapping can be and still be relayed, or 0 if there is no limit.
+func (b *Bridge) maxRelayAge(ircChannel string) time.Duration {
+	if age := b.channelOptions(ircChannel).MaxRelayAge; age > 0 {
+		return age
+	}
+	return b.Config.MaxRelayAge
+}
+
+// tooLate returns true if a message sent at the given time is too old to be relayed in a mapping.
+// Unless late messages are dropped, it is counted towards the next summary.
+func (b *Bridge) tooLate(ircChannel, to, channel string, sent time.Time) bool {
+	// Recordings are old by the time they are replayed
+	if sent.IsZero() || b.replay != nil {
+		return false
+	}
+	age := b.maxRelayAge(ircChannel)
+	if age == 0 || time.Since(sent) <= age {
+		return false
+	}
+
+	log.WithFields(log.Fields{
+		"to":      to,
+		"channel": channel,
+		"sent":    sent,
+	}).Debugln("Not relaying a message that is too old.")
+
+	if b.Config.LateMessages == lateDrop {
+		return true
+	}
+
+	b.late.mu.Lock()
+	defer b.late.mu.Unlock()
+	if to == "discord" {
+		if b.late.toDiscord == nil {
+			b.late.toDiscord = make(map[string]int)
+		}
+		b.late.toDiscord[channel]++
+	} else {
+		if b.late.toIRC == nil {
+			b.late.toIRC = make(map[string]int)
+		}
+		b.late.toIRC[channel]++
+	}
+	return true
+}
+
+// lateSummary returns the summary of the messages that were too old to relay to a channel,
+// like "12 messages during downtime, see Discord", and forgets them. It returns "" if there weren't any.
+func (b *Bridge) lateSummary(to, channel string) string {
+	b.late.mu.Lock()
+	defer b.late.mu.Unlock()
+
+	counts, other := b.late.toIRC, "Discord"
+	if to == "discord" {
+		counts, other = b.late.toDiscord, "IRC"
+	}
+	n := counts[channel]
+	if n == 0 {
+		return ""
+	}
+	delete(counts, channel)
+
+	log.WithFields(log.Fields{"to": to, "channel": channel, "count": n}).Infoln("Summarizing messages that were too old to relay.")
+	if n == 1 {
+		return "1 message during downtime, see " + other
+	}
+	return fmt.Sprintf("%d messages during downtime, see %s", n, other)
+}
+
+// summarizeLateToDiscord sends the summary of late messages to a Discord channel, if there is one.
+func (b *Bridge) summarizeLateToDiscord(channel string) {
+	if summary := b.lateSummary("discord", channel); summary != "" {
+		if _, err := b.discord.ChannelMessageSend(channel, "**[bridge]** "+summary); err != nil {
+			b.handleError(err, log.Fields{"channel": channel}, "could not summarize late messages")
+		}
+	}
+}
+
+// summarizeLateToIRC sends the summary of late messages to an IRC channel, if there is one.
+func (b *Bridge) summarizeLateToIRC(channel string) {
+	if summary := b.lateSummary("irc", channel); summary != "" {
+		b.ircListener.Notice(channel, "[bridge] "+summary)
+	}
+}
+
+// flushLateSummaries sends the summaries for channels that haven't had a message since their late messages.
+func (b *Bridge) flushLateSummaries() {
+	b.late.mu.Lock()
+	toDiscord := make([]string, 0, len(b.late.toDiscord))
+	for channel := range b.late.toDiscord {
+		toDiscord = append(toDiscord, channel)
+	}
+	toIRC := make([]string, 0, len(b.late.toIRC))
+	for channel := range b.late.toIRC {
+		toIRC = append(toIRC, channel)
+	}
+	b.late.mu.Unlock()
+
+	for _, channel := range toDiscord {
+		b.summarizeLateToDiscord(channel)
+	}
+	for _, channel := range toIRC {
+		b.summarizeLateToIRC(channel)
+	}
+}
@@ -0,0 +1,55 @@
+package bridge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaxRelayAge(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.MaxRelayAge = time.Hour
+	})
+	defer tb.Close()
+
+	// A bouncer playing back old messages
+	tb.ircd.SendTo("listener", "@time=2020-01-01T10:00:00.000Z :alice!a@host PRIVMSG %s :from the past", testChannel)
+	tb.ircd.SendTo("listener", "@time=2020-01-01T10:01:00.000Z :alice!a@host PRIVMSG %s :also old", testChannel)
+	tb.ircd.Inject("alice!a@host", testChannel, "PRIVMSG "+testChannel+" :back now")
+	waitFor(t, "fresh message", func() bool {
+		_, ok := tb.discord.Find("back now" + relayMarker)
+		return ok
+	})
+	_, ok := tb.discord.Find("**[bridge]** 2 messages during downtime, see IRC")
+	assert.True(t, ok)
+	_, ok = tb.discord.Find("from the past" + relayMarker)
+	assert.False(t, ok)
+
+	// Discord messages delivered late after a reconnect
+	bob := tb.discordMember("100", "bob", "")
+	tb.Bridge.discord.publishMessage(tb.Bridge.discord.Session, &discordgo.Message{
+		ID:        tb.discord.id(),
+		ChannelID: testChannelID,
+		GuildID:   testGuildID,
+		Author:    bob,
+		Content:   "stale",
+		Type:      discordgo.MessageTypeDefault,
+		Timestamp: time.Now().Add(-2 * time.Hour),
+	}, false)
+	tb.discordSay(bob, "fresh")
+	waitFor(t, "summary on irc", func() bool {
+		return tb.ircd.HasReceived("listener", "NOTICE "+testChannel+" :[bridge] 1 message during downtime, see Discord")
+	})
+	waitFor(t, "fresh message on irc", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<b\u200Bob#0001> fresh")
+	})
+	assert.False(t, tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<b\u200Bob#0001> stale"))
+}
+
+func TestValidateMaxRelayAge(t *testing.T) {
+	assert.NoError(t, validateMaxRelayAge(&Config{MaxRelayAge: time.Hour, LateMessages: lateDrop}))
+	assert.Error(t, validateMaxRelayAge(&Config{LateMessages: "replay"}))
+	assert.Error(t, validateMaxRelayAge(&Config{ChannelOptions: map[string]ChannelOptions{testChannel: {MaxRelayAge: -time.Minute}}}))
+}
@@ -0,0 +1,118 @@
+package bridge
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+)
+
+func validateRelayDelay(opts *Config) error {
+	for channel, channelOpts := range opts.ChannelOptions {
+		if channelOpts.RelayDelay < 0 {
+			return errors.Errorf("channel options for %s: relay_delay must not be negative", channel)
+		}
+	}
+	return nil
+}
+
+// delayedRelays are the Discord messages waiting out their channel's RelayDelay,
+// keyed by Discord message ID. A message with attachments has one for each.
+type delayedRelays struct {
+	mu      sync.Mutex
+	pending map[string][]*DiscordMessage
+}
+
+// delayToIRC returns true if a message from Discord is held back by the RelayDelay of the
+// IRC channel, so that it isn't relayed if it is deleted soon after being sent.
+// It is relayed once the delay is up. Edits made in the meantime change the
+// delayed message, rather than being relayed after it.
+func (b *Bridge) delayToIRC(ircChannel string, msg *DiscordMessage) bool {
+	delay := b.channelOptions(ircChannel).RelayDelay
+	if delay <= 0 || msg.Delayed || msg.Probe != "" {
+		return false
+	}
+
+	b.delayed.mu.Lock()
+	if b.delayed.pending == nil {
+		b.delayed.pending = make(map[string][]*DiscordMessage)
+	}
+	pending := b.delayed.pending[msg.ID]
+	if msg.IsEdit && len(pending) > 0 && !pending[0].IsEdit {
+		// The text of a message is published before its attachments
+		content := strings.TrimPrefix(msg.Content, editMarker)
+		if msg.IsAction {
+			content = strings.TrimPrefix(content, "/me ")
+		}
+		pending[0].Content = content
+		pending[0].IsAction = msg.IsAction
+		b.delayed.mu.Unlock()
+
+		b.traces.Step(pending[0].TraceID, traceQueue, "edited within the relay delay: %q", content)
+		b.traces.Step(msg.TraceID, traceFilter, "folded into the delayed message")
+		return true
+	}
+	b.delayed.pending[msg.ID] = append(pending, msg)
+	b.delayed.mu.Unlock()
+
+	time.AfterFunc(delay, func() {
+		b.releaseDelayed(msg)
+	})
+	return true
+}
+
+// releaseDelayed relays a delayed message, unless it was deleted in the meantime.
+func (b *Bridge) releaseDelayed(msg *DiscordMessage) {
+	b.delayed.mu.Lock()
+	pending := b.delayed.pending[msg.ID]
+	found := false
+	for i, m := range pending {
+		if m == msg {
+			pending = append(pending[:i], pending[i+1:]...)
+			found = true
+			break
+		}
+	}
+	if len(pending) == 0 {
+		delete(b.delayed.pending, msg.ID)
+	} else {
+		b.delayed.pending[msg.ID] = pending
+	}
+	b.delayed.mu.Unlock()
+
+	if !found {
+		return
+	}
+
+	msg.Delayed = true
+	select {
+	case b.discordMessageEventsChan <- msg:
+	case <-b.stopWatchdog:
+		// The loop isn't receiving any more
+		b.traces.Step(msg.TraceID, traceFilter, "dropped: the bridge closed during the relay delay")
+	}
+}
+
+// dropDelayed forgets the delayed messages for a Discord message that was deleted.
+func (b *Bridge) dropDelayed(messageID string) {
+	b.delayed.mu.Lock()
+	dropped := b.delayed.pending[messageID]
+	delete(b.delayed.pending, messageID)
+	b.delayed.mu.Unlock()
+
+	for _, msg := range dropped {
+		b.traces.Step(msg.TraceID, traceFilter, "dropped: deleted within the relay delay")
+	}
+}
+
+func (d *discordBot) onMessageDelete(s *discordgo.Session, m *discordgo.MessageDelete) {
+	d.bridge.dropDelayed(m.ID)
+}
+
+func (d *discordBot) onMessageDeleteBulk(s *discordgo.Session, m *discordgo.MessageDeleteBulk) {
+	for _, id := range m.Messages {
+		d.bridge.dropDelayed(id)
+	}
+}
@@ -0,0 +1,89 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelayDelay(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.ChannelOptions = map[string]ChannelOptions{
+			testChannel: {RelayDelay: 100 * time.Millisecond},
+		}
+	})
+	defer tb.Close()
+	d := tb.Bridge.discord
+
+	bob := tb.discordMember("100", "bob", "")
+	oops := tb.discordSay(bob, "oops, wrong channel")
+	waitFor(t, "message delayed", func() bool {
+		lines := tb.Bridge.traces.Lines(oops.ID)
+		return len(lines) > 0 && strings.Contains(lines[len(lines)-1], "delayed by 100ms")
+	})
+	d.onMessageDelete(d.Session, &discordgo.MessageDelete{Message: &discordgo.Message{ID: oops.ID, ChannelID: testChannelID}})
+
+	tb.discordSay(bob, "hello")
+	waitFor(t, "message relayed after the delay", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<b\u200Bob#0001> hello")
+	})
+	assert.False(t, tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<b\u200Bob#0001> oops, wrong channel"))
+
+	lines := tb.Bridge.traces.Lines(oops.ID)
+	assert.NotEmpty(t, lines)
+	assert.Contains(t, lines[len(lines)-1], "dropped: deleted within the relay delay")
+}
+
+func TestRelayDelayFoldsEdits(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.ChannelOptions = map[string]ChannelOptions{
+			testChannel: {RelayDelay: 100 * time.Millisecond},
+		}
+	})
+	defer tb.Close()
+
+	bob := tb.discordMember("100", "bob", "")
+	msg := tb.discordSay(bob, "helo")
+	tb.discordEdit(msg, "hello")
+	waitFor(t, "edited message relayed after the delay", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<b\u200Bob#0001> hello")
+	})
+
+	// Edits after the delay are still relayed as edits
+	tb.discordEdit(msg, "hello there")
+	waitFor(t, "later edit relayed", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<b\u200Bob#0001> [edit]: hello there")
+	})
+	assert.False(t, tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<b\u200Bob#0001> helo"))
+	assert.False(t, tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<b\u200Bob#0001> [edit]: hello"))
+}
+
+func TestRelayDelayClose(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.ChannelOptions = map[string]ChannelOptions{
+			testChannel: {RelayDelay: 50 * time.Millisecond},
+		}
+	})
+
+	bob := tb.discordMember("100", "bob", "")
+	msg := tb.discordSay(bob, "goodbye")
+	waitFor(t, "message delayed", func() bool {
+		lines := tb.Bridge.traces.Lines(msg.ID)
+		return len(lines) > 0 && strings.Contains(lines[len(lines)-1], "delayed by 50ms")
+	})
+	tb.Close()
+
+	// The delayed message isn't left waiting for a loop that has stopped
+	waitFor(t, "delayed message dropped", func() bool {
+		lines := tb.Bridge.traces.Lines(msg.ID)
+		return strings.Contains(lines[len(lines)-1], "dropped: the bridge closed during the relay delay")
+	})
+}
+
+func TestValidateRelayDelay(t *testing.T) {
+	assert.NoError(t, validateRelayDelay(&Config{ChannelOptions: map[string]ChannelOptions{testChannel: {RelayDelay: 3 * time.Second}}}))
+	assert.Error(t, validateRelayDelay(&Config{ChannelOptions: map[string]ChannelOptions{testChannel: {RelayDelay: -time.Second}}}))
+}
@@ -0,0 +1,64 @@
+package bridge
+
+import (
+	"strings"
+	"sync"
+)
+
+// relayOrder keeps the messages for each channel in the order they arrived,
+// while they are being got ready to relay (e.g. fetching link titles) at the same time.
+type relayOrder struct {
+	mu    sync.Mutex
+	lasts map[string]*relayPlace // the last place taken in each channel, keyed by lowercase channel
+}
+
+// A relayPlace is a message's place in its channel's queue.
+type relayPlace struct {
+	order   *relayOrder
+	channel string
+	before  chan struct{} // closed once the message before this one is done
+	self    chan struct{} // closed once this message is done
+	once    sync.Once
+}
+
+// next takes the next place in the channel's queue.
+// It must be called in the order the messages arrived.
+func (o *relayOrder) next(channel string) *relayPlace {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.lasts == nil {
+		o.lasts = make(map[string]*relayPlace)
+	}
+
+	channel = strings.ToLower(channel)
+	place := &relayPlace{order: o, channel: channel, self: make(chan struct{})}
+	if last, ok := o.lasts[channel]; ok {
+		place.before = last.self
+	} else {
+		place.before = make(chan struct{})
+		close(place.before)
+	}
+	o.lasts[channel] = place
+	return place
+}
+
+// wait returns once the messages before this one in the channel have been relayed or dropped.
+func (p *relayPlace) wait() {
+	<-p.before
+}
+
+// done gives up the place once the message has been relayed or dropped, letting the next one go.
+// It waits for the messages before this one, so it is safe to call for messages dropped early.
+func (p *relayPlace) done() {
+	p.once.Do(func() {
+		<-p.before
+		close(p.self)
+
+		p.order.mu.Lock()
+		if p.order.lasts[p.channel] == p {
+			delete(p.order.lasts, p.channel)
+		}
+		p.order.mu.Unlock()
+	})
+}
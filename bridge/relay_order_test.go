@@ -0,0 +1,48 @@
+package bridge
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelayOrder(t *testing.T) {
+	var order relayOrder
+	var mu sync.Mutex
+	var relayed []string
+	relay := func(place *relayPlace, content string) {
+		defer place.done()
+		place.wait()
+		mu.Lock()
+		relayed = append(relayed, content)
+		mu.Unlock()
+	}
+
+	first := order.next("#Chan")
+	second := order.next("#chan")
+	dropped := order.next("#chan")
+	other := order.next("#other")
+
+	// Other channels don't wait
+	relay(other, "other")
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		relay(second, "second")
+	}()
+	go func() {
+		defer wg.Done()
+		dropped.done()
+	}()
+	relay(first, "first")
+	wg.Wait()
+
+	assert.Equal(t, []string{"other", "first", "second"}, relayed)
+
+	// Nothing is kept once the channels are done
+	assert.Empty(t, order.lasts)
+	order.next("#chan").wait()
+}
@@ -0,0 +1,88 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+// relayPolicy decides how Discord messages are relayed to an IRC channel
+type relayPolicy int
+
+const (
+	// relayPuppets relays messages through each Discord user's puppet, as usual
+	relayPuppets relayPolicy = iota
+
+	// relayListener relays all messages through the listener, because
+	// puppets can't speak (+m) or join (+i) the channel
+	relayListener
+
+	// relayPaused drops messages, because not even the listener can speak in the channel
+	relayPaused
+)
+
+func (p relayPolicy) String() string {
+	switch p {
+	case relayListener:
+		return "relaying through the listener"
+	case relayPaused:
+		return "paused"
+	}
+	return "relaying normally"
+}
+
+// relayPolicy returns the current policy for an IRC channel
+func (b *Bridge) relayPolicy(channel string) relayPolicy {
+	b.policiesMu.Lock()
+	defer b.policiesMu.Unlock()
+	return b.policies[strings.ToLower(channel)]
+}
+
+// updateRelayPolicy recalculates the policy for an IRC channel from its modes,
+// and tells moderators in the audit and report channels if the policy has changed.
+func (b *Bridge) updateRelayPolicy(channel string) {
+	policy := b.ircListener.channelRelayPolicy(channel)
+
+	b.policiesMu.Lock()
+	old := b.policies[strings.ToLower(channel)]
+	b.policies[strings.ToLower(channel)] = policy
+	b.policiesMu.Unlock()
+
+	if old == policy {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"channel": channel,
+		"policy":  policy.String(),
+	}).Warnln("IRC channel relay policy changed")
+
+	mapping := b.GetMappingByIRC(channel)
+	if mapping == nil {
+		return
+	}
+
+	var notice string
+	switch policy {
+	case relayPuppets:
+		notice = fmt.Sprintf("Relaying to IRC channel %s has returned to normal.", channel)
+	case relayListener:
+		notice = fmt.Sprintf("IRC channel %s is moderated or invite only, so messages are now relayed by the bridge bot.", channel)
+	case relayPaused:
+		notice = fmt.Sprintf("IRC channel %s is moderated and the bridge can't speak there. Messages are not being relayed to IRC until this changes.", channel)
+	}
+
+	if audit := b.Config.AuditIRCChannel; audit != "" {
+		b.ircListener.Notice(audit, "[bridge] "+notice)
+	}
+	if report := b.Config.ReportDiscordChannel; report != "" {
+		if _, err := b.discord.ChannelMessageSendComplex(report, &discordgo.MessageSend{
+			Content:         "**[bridge]** " + sanitiseDiscordContent(fmt.Sprintf("%s (bridged with <#%s>)", notice, mapping.DiscordChannel)),
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		}); err != nil {
+			b.handleError(err, nil, "could not send relay policy notice")
+		}
+	}
+}
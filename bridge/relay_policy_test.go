@@ -0,0 +1,33 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelayPolicyNotice(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.AuditIRCChannel = "#audit"
+		conf.ReportDiscordChannel = "3000"
+	})
+	defer tb.Close()
+
+	tb.ircd.mu.Lock()
+	tb.ircd.broadcast(testChannel, "", ":ChanServ!ChanServ@services. MODE %s +i", testChannel)
+	tb.ircd.mu.Unlock()
+
+	notice := "IRC channel " + testChannel + " is moderated or invite only, so messages are now relayed by the bridge bot."
+	waitFor(t, "notice in the report channel", func() bool {
+		_, ok := tb.discord.Find("**[bridge]** " + notice + " (bridged with <#" + testChannelID + ">)" + relayMarker)
+		return ok
+	})
+	msg, _ := tb.discord.Find("**[bridge]** " + notice + " (bridged with <#" + testChannelID + ">)" + relayMarker)
+	assert.Equal(t, "3000", msg.ChannelID)
+	assert.True(t, tb.ircd.HasReceived("listener", "NOTICE #audit :[bridge] "+notice))
+
+	// Nothing is posted in the bridged channel itself
+	for _, sent := range tb.discord.Sent() {
+		assert.NotEqual(t, testChannelID, sent.ChannelID)
+	}
+}
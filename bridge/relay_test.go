@@ -0,0 +1,192 @@
+package bridge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelayIRCToDiscord(t *testing.T) {
+	tb := newTestBridge(t, nil)
+	defer tb.Close()
+
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :hello \x02world\x02")
+
+	waitFor(t, "message on discord", func() bool {
+		_, ok := tb.discord.Find("hello **world**" + relayMarker)
+		return ok
+	})
+
+	msg, _ := tb.discord.Find("hello **world**" + relayMarker)
+	assert.Equal(t, testChannelID, msg.ChannelID)
+	assert.Equal(t, "alice", msg.Author.Username)
+}
+
+func TestRelayDiscordToIRCViaListener(t *testing.T) {
+	tb := newTestBridge(t, nil)
+	defer tb.Close()
+
+	bob := tb.discordMember("100", "bob", "")
+	tb.discordSay(bob, "hi there")
+
+	waitFor(t, "message on irc", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<b\u200Bob#0001> hi there")
+	})
+}
+
+func TestRelayDiscordToIRCViaPuppet(t *testing.T) {
+	tb := newTestBridge(t, nil)
+	defer tb.Close()
+
+	bob := tb.discordMember("100", "bob", "")
+	nick := tb.puppet(t, bob, "bob")
+	assert.Equal(t, "bob_d", nick)
+
+	msg := tb.discordSay(bob, "hello from a puppet")
+	waitFor(t, "puppet message", func() bool {
+		return tb.ircd.HasReceived(nick, "PRIVMSG "+testChannel+" :hello from a puppet")
+	})
+
+	tb.discordEdit(msg, "hello from an edited puppet")
+	waitFor(t, "puppet edit", func() bool {
+		return tb.ircd.HasReceived(nick, "PRIVMSG "+testChannel+" :[edit]: hello from an edited puppet")
+	})
+
+	// Messages from our own puppets are not relayed back to Discord
+	for _, sent := range tb.discord.Sent() {
+		assert.NotContains(t, sent.Content, "puppet")
+	}
+}
+
+func TestRelayMentions(t *testing.T) {
+	tb := newTestBridge(t, nil)
+	defer tb.Close()
+
+	bob := tb.discordMember("100", "bob", "")
+	carol := tb.discordMember("200", "carol", "Caz")
+	nick := tb.puppet(t, carol, "Caz")
+
+	// Discord mentions of puppeted users become their IRC nick
+	tb.discordSay(bob, "hey <@200>", carol)
+	waitFor(t, "mention on irc", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<b\u200Bob#0001> hey "+nick)
+	})
+
+	// IRC mentions of puppets become Discord mentions
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :"+nick+": hi")
+	waitFor(t, "mention on discord", func() bool {
+		_, ok := tb.discord.Find("<@!200>: hi" + relayMarker)
+		return ok
+	})
+}
+
+func TestRelayEditWindow(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.EditWindow = time.Minute
+	})
+	defer tb.Close()
+
+	bob := tb.discordMember("100", "bob", "")
+
+	// Link previews update the message without editing it
+	msg := tb.discordSay(bob, "look at https://example.com")
+	unfurled := *msg
+	unfurled.Embeds = []*discordgo.MessageEmbed{{URL: "https://example.com"}}
+	tb.Bridge.discord.publishMessage(tb.Bridge.discord.Session, &unfurled, true)
+
+	// Edits of old messages are not relayed
+	old := tb.discordSayAt(time.Now().Add(-time.Hour), bob, "an old message")
+	tb.discordEdit(old, "an old message, edited")
+
+	tb.discordEdit(msg, "look at https://example.org")
+	waitFor(t, "edit on irc", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<b\u200Bob#0001> [edit]: look at https://example.org")
+	})
+
+	for _, line := range tb.ircd.Received("listener") {
+		assert.NotContains(t, line, "[edit]: look at https://example.com")
+		assert.NotContains(t, line, "an old message, edited")
+	}
+}
+
+func TestRelayIgnoresEmbedOnlyUpdates(t *testing.T) {
+	tb := newTestBridge(t, nil)
+	defer tb.Close()
+
+	bob := tb.discordMember("100", "bob", "")
+	d := tb.Bridge.discord
+
+	msg := &discordgo.Message{
+		ID:        "6000",
+		ChannelID: testChannelID,
+		GuildID:   testGuildID,
+		Author:    bob,
+		Content:   "see https://example.com",
+		Timestamp: time.Now(),
+	}
+	d.onMessageCreate(d.Session, &discordgo.MessageCreate{Message: msg})
+
+	now := time.Now()
+	unfurled := *msg
+	unfurled.EditedTimestamp = &now
+	unfurled.Embeds = []*discordgo.MessageEmbed{{URL: "https://example.com"}}
+	d.onMessageUpdate(d.Session, &discordgo.MessageUpdate{Message: &unfurled})
+
+	edited := unfurled
+	edited.Content = "see https://example.org"
+	d.onMessageUpdate(d.Session, &discordgo.MessageUpdate{Message: &edited})
+
+	waitFor(t, "edit on irc", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<b\u200Bob#0001> [edit]: see https://example.org")
+	})
+	assert.False(t, tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<b\u200Bob#0001> [edit]: see https://example.com"))
+}
+
+func TestRelayIRCActionsAndNotices(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.RelayIRCNotices = true
+	})
+	defer tb.Close()
+
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :\x01ACTION waves\x01")
+	tb.ircd.Inject("alice!al@example.com", testChannel, "NOTICE "+testChannel+" :the build is broken")
+	waitFor(t, "action and notice on discord", func() bool {
+		_, action := tb.discord.Find("_\\* waves_" + relayMarker)
+		_, notice := tb.discord.Find("> the build is broken" + relayMarker)
+		return action && notice
+	})
+
+	// Notices to the listener itself aren't relayed, or answered like commands
+	tb.ircd.Inject("NickServ!ns@services", testChannel, "NOTICE listener :You are now identified")
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :done")
+	waitFor(t, "later message on discord", func() bool {
+		_, ok := tb.discord.Find("done" + relayMarker)
+		return ok
+	})
+	for _, msg := range tb.discord.Sent() {
+		assert.NotContains(t, msg.Content, "identified")
+	}
+	for _, line := range tb.ircd.Received("listener") {
+		assert.NotContains(t, line, "NickServ")
+	}
+}
+
+func TestRelayNickChange(t *testing.T) {
+	// Without a suffix, puppets are told apart from IRC users by the puppets the bridge has
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.Suffix = ""
+	})
+	defer tb.Close()
+
+	// Markdown in the new nick is escaped, so it doesn't end the italics early
+	tb.ircd.Inject("a_b!al@example.com", testChannel, "NICK :c_d*")
+	waitFor(t, "nick change on discord", func() bool {
+		_, ok := tb.discord.Find(`_is now known as c\_d\*_` + relayMarker)
+		return ok
+	})
+
+	msg, _ := tb.discord.Find(`_is now known as c\_d\*_` + relayMarker)
+	assert.Equal(t, "a_b", msg.Author.Username)
+}
@@ -0,0 +1,239 @@
+package bridge
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	irc "github.com/qaisjp/go-ircevent"
+	log "github.com/sirupsen/logrus"
+)
+
+// replayIdle is how long a replay waits for the last messages to come out of the pipeline
+var replayIdle = 200 * time.Millisecond
+
+// replayBotID is the Discord user the bridge is while replaying
+const replayBotID = "0"
+
+// recordedDiscordEvents are the Discord events that are recorded, since they are what is relayed
+var recordedDiscordEvents = map[string]bool{
+	"MESSAGE_CREATE": true,
+	"MESSAGE_UPDATE": true,
+}
+
+// recordedIRCEvents are the IRC events that are recorded
+var recordedIRCEvents = []string{"PRIVMSG", "CTCP_ACTION", "NOTICE"}
+
+// A recordedEvent is a line of a recording: a Discord gateway event, or a line from the IRC server.
+type recordedEvent struct {
+	Time    time.Time       `json:"time"`
+	Discord string          `json:"discord,omitempty"` // the event type, like "MESSAGE_CREATE"
+	Data    json.RawMessage `json:"data,omitempty"`
+	IRC     string          `json:"irc,omitempty"`
+}
+
+// eventRecorder appends the events the bridge receives to a file, one JSON object per line.
+type eventRecorder struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+func newEventRecorder(path string) (*eventRecorder, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open event recording")
+	}
+	return &eventRecorder{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (r *eventRecorder) record(event recordedEvent) {
+	if r == nil {
+		return
+	}
+	event.Time = time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.enc.Encode(event); err != nil {
+		log.WithField("error", err).Warnln("could not record event")
+	}
+}
+
+func (r *eventRecorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
+
+// recordDiscord records a Discord event, if it is one that is recorded.
+func (d *discordBot) recordDiscord(s *discordgo.Session, e *discordgo.Event) {
+	if recordedDiscordEvents[e.Type] {
+		d.bridge.recorder.record(recordedEvent{Discord: e.Type, Data: e.RawData})
+	}
+}
+
+// recordIRC records a line the listener received.
+func (i *ircListener) recordIRC(e *irc.Event) {
+	i.bridge.recorder.record(recordedEvent{IRC: e.Raw})
+}
+
+// replayRelay writes out a message that would have been relayed while replaying.
+func (b *Bridge) replayRelay(to, channel, name, content string) {
+	b.replay.mu.Lock()
+	defer b.replay.mu.Unlock()
+	b.replay.last = time.Now()
+	fmt.Fprintf(b.replay.out, "to %s %s <%s> %s\n", to, channel, name, strings.TrimSuffix(content, relayMarker))
+}
+
+// replayOutput is where a replay writes what would have been relayed.
+type replayOutput struct {
+	mu   sync.Mutex
+	out  io.Writer
+	last time.Time // when something was last written
+}
+
+// Replay feeds events recorded with RecordEvents through the bridge, and writes what would
+// have been relayed to out, like "to irc #help <bob> hello".
+//
+// Nothing is connected to: the bridge runs in shadow mode, and Discord can't be asked about anything
+// that wasn't recorded, so mentions of unknown users and channels aren't replaced.
+func Replay(conf *Config, events io.Reader, out io.Writer) error {
+	conf.Shadow = true
+	conf.StorePath = ""
+	conf.HAMode = ""
+	conf.RecordEvents = ""
+	conf.WatchdogTimeout = 0
+
+	b, err := New(conf)
+	if err != nil {
+		return err
+	}
+	b.replay = &replayOutput{out: out, last: time.Now()}
+	b.discord.Client.Transport = shadowTransport{offline: true}
+	b.discord.State.User = &discordgo.User{ID: replayBotID, Username: conf.IRCListenerName, Bot: true}
+	if err := b.discord.State.GuildAdd(&discordgo.Guild{ID: conf.GuildID}); err != nil {
+		return errors.Wrap(err, "could not set up discord state")
+	}
+
+	scanner := bufio.NewScanner(events)
+	scanner.Buffer(nil, 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		var event recordedEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return errors.Wrapf(err, "line %d", n)
+		}
+		if err := b.replayEvent(event); err != nil {
+			return errors.Wrapf(err, "line %d", n)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(err, "could not read events")
+	}
+
+	// IRC messages are relayed from goroutines, so wait until nothing more comes out
+	for {
+		b.replay.mu.Lock()
+		idle := time.Since(b.replay.last)
+		b.replay.mu.Unlock()
+		if idle >= replayIdle {
+			break
+		}
+		time.Sleep(replayIdle - idle)
+	}
+	return nil
+}
+
+func (b *Bridge) replayEvent(event recordedEvent) error {
+	b.replay.mu.Lock()
+	b.replay.last = time.Now()
+	b.replay.mu.Unlock()
+
+	switch {
+	case event.Discord == "MESSAGE_CREATE":
+		var m discordgo.Message
+		if err := json.Unmarshal(event.Data, &m); err != nil {
+			return errors.Wrap(err, "could not decode discord message")
+		}
+		b.discord.onMessageCreate(b.discord.Session, &discordgo.MessageCreate{Message: &m})
+	case event.Discord == "MESSAGE_UPDATE":
+		var m discordgo.Message
+		if err := json.Unmarshal(event.Data, &m); err != nil {
+			return errors.Wrap(err, "could not decode discord message")
+		}
+		b.discord.onMessageUpdate(b.discord.Session, &discordgo.MessageUpdate{Message: &m})
+	case event.Discord != "":
+		log.WithField("type", event.Discord).Debugln("Not replaying discord event.")
+	case event.IRC != "":
+		e, err := parseIRCLine(event.IRC)
+		if err != nil {
+			return err
+		}
+		e.Connection = b.ircListener.Connection
+		b.ircListener.RunCallbacks(e)
+	}
+	return nil
+}
+
+// parseIRCLine parses a line from an IRC server, like go-ircevent does.
+func parseIRCLine(line string) (*irc.Event, error) {
+	line = strings.TrimRight(line, "\r\n")
+	e := &irc.Event{Raw: line}
+
+	if strings.HasPrefix(line, "@") {
+		i := strings.Index(line, " ")
+		if i < 0 {
+			return nil, errors.Errorf("malformed irc line %q", line)
+		}
+		e.Tags = make(map[string]string)
+		for _, tag := range strings.Split(line[1:i], ";") {
+			parts := strings.SplitN(tag, "=", 2)
+			if len(parts) == 1 {
+				e.Tags[parts[0]] = ""
+			} else {
+				e.Tags[parts[0]] = strings.NewReplacer(`\:`, ";", `\s`, " ", `\\`, `\`, `\r`, "\r", `\n`, "\n").Replace(parts[1])
+			}
+		}
+		line = line[i+1:]
+	}
+
+	if strings.HasPrefix(line, ":") {
+		i := strings.Index(line, " ")
+		if i < 0 {
+			return nil, errors.Errorf("malformed irc line %q", line)
+		}
+		e.Source = line[1:i]
+		line = line[i+1:]
+
+		// Servers don't have a nick
+		if strings.Contains(e.Source, "!") && strings.Contains(e.Source, "@") {
+			e.Nick, e.User, e.Host = parseHostmask(e.Source)
+		}
+	}
+
+	split := strings.SplitN(line, " :", 2)
+	args := strings.Split(split[0], " ")
+	e.Code = strings.ToUpper(args[0])
+	e.Arguments = args[1:]
+	if len(split) > 1 {
+		e.Arguments = append(e.Arguments, split[1])
+	}
+	if e.Code == "" {
+		return nil, errors.Errorf("malformed irc line %q", line)
+	}
+	return e, nil
+}
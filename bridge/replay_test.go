@@ -0,0 +1,87 @@
+package bridge
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordEvents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "record")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.jsonl")
+
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.RecordEvents = path
+	})
+	defer tb.Close()
+
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :hello discord")
+	tb.Bridge.discord.recordDiscord(tb.Bridge.discord.Session, &discordgo.Event{Type: "MESSAGE_CREATE", RawData: []byte(`{"id":"5"}`)})
+	tb.Bridge.discord.recordDiscord(tb.Bridge.discord.Session, &discordgo.Event{Type: "PRESENCE_UPDATE", RawData: []byte(`{}`)})
+
+	waitFor(t, "events to be recorded", func() bool {
+		recorded, _ := ioutil.ReadFile(path)
+		return strings.Contains(string(recorded), `"irc":":alice!al@example.com PRIVMSG #test :hello discord"`) &&
+			strings.Contains(string(recorded), `"discord":"MESSAGE_CREATE","data":{"id":"5"}`)
+	})
+	recorded, _ := ioutil.ReadFile(path)
+	assert.NotContains(t, string(recorded), "PRESENCE_UPDATE")
+}
+
+func TestReplay(t *testing.T) {
+	events := strings.Join([]string{
+		`{"discord":"MESSAGE_CREATE","data":{"id":"5","channel_id":"2000","guild_id":"1000","author":{"id":"100","username":"bob","discriminator":"0"},"content":"hello **irc**","type":0}}`,
+		`{"discord":"MESSAGE_CREATE","data":{"id":"6","channel_id":"2999","guild_id":"1000","author":{"id":"100","username":"bob","discriminator":"0"},"content":"not bridged","type":0}}`,
+		`{"irc":":alice!al@example.com PRIVMSG #test :\u0002bold\u0002 hello"}`,
+		`{"irc":":alice!al@example.com PRIVMSG #test :\u0001ACTION waves\u0001"}`,
+		``,
+	}, "\n")
+
+	var out bytes.Buffer
+	err := Replay(&Config{
+		DiscordBotToken: "token",
+		GuildID:         testGuildID,
+		ChannelMappings: map[string]string{testChannel: testChannelID},
+		IRCServer:       "irc.example.com:6697",
+		IRCListenerName: "listener",
+		WebhookPrefix:   "test",
+		Suffix:          "_d",
+		Separator:       "_",
+	}, strings.NewReader(events), &out)
+	assert.NoError(t, err)
+
+	// IRC messages are relayed concurrently, so they can come out in any order
+	assert.ElementsMatch(t, []string{
+		"to irc #test <bob> hello \x02irc\x02",
+		"to discord #test <alice> **bold** hello",
+		"to discord #test <alice> _\\* waves_",
+	}, strings.Split(strings.TrimSpace(out.String()), "\n"))
+
+	err = Replay(&Config{IRCServer: "irc.example.com:6697", IRCListenerName: "listener", WebhookPrefix: "test"}, strings.NewReader("not json"), &out)
+	assert.Error(t, err)
+}
+
+func TestParseIRCLine(t *testing.T) {
+	e, err := parseIRCLine("@msgid=abc;+draft/reply=x\\sy :alice!al@example.com PRIVMSG #test :hello there\r\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "PRIVMSG", e.Code)
+	assert.Equal(t, "alice", e.Nick)
+	assert.Equal(t, []string{"#test", "hello there"}, e.Arguments)
+	assert.Equal(t, map[string]string{"msgid": "abc", "+draft/reply": "x y"}, e.Tags)
+
+	e, err = parseIRCLine(":irc.example.com NOTICE * :Looking up your hostname")
+	assert.NoError(t, err)
+	assert.Equal(t, "", e.Nick)
+	assert.Equal(t, "irc.example.com", e.Source)
+
+	_, err = parseIRCLine(":irc.example.com")
+	assert.Error(t, err)
+}
@@ -0,0 +1,101 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	irc "github.com/qaisjp/go-ircevent"
+)
+
+// reportThreadArchive is how long report threads stay open without activity, in minutes
+var reportThreadArchive = 1440
+
+// handleReport reports a message relayed from Discord to the Discord moderators.
+//
+// The command takes the form "!report [nick:] <reason>". If a nick is given,
+// their most recent message is reported, otherwise the most recent message
+// relayed from Discord to the channel is reported.
+func (i *ircListener) handleReport(e *irc.Event, fields []string) {
+	channel := e.Arguments[0]
+
+	author := ""
+	if len(fields) > 0 && strings.HasSuffix(fields[0], ":") {
+		author = i.discordAuthor(strings.TrimSuffix(fields[0], ":"))
+		fields = fields[1:]
+	}
+
+	reason := strings.Join(fields, " ")
+	if reason == "" {
+		i.Noticef(e.Nick, "Usage: %sreport [nick:] <reason>", i.bridge.Config.CommandPrefix)
+		return
+	}
+
+	mapping := i.bridge.GetMappingByIRC(channel)
+	if mapping == nil {
+		return
+	}
+
+	msg := i.bridge.messages.LatestFromDiscord(mapping.DiscordChannel, author)
+	if msg == nil {
+		i.Notice(e.Nick, "Could not find a recently relayed Discord message to report.")
+		return
+	}
+
+	if err := i.bridge.discord.postReport(msg, e.Nick, channel, reason); err != nil {
+		i.bridge.handleError(err, nil, "could not post report to discord")
+		i.Notice(e.Nick, "Sorry, your report could not be sent to the Discord moderators.")
+		return
+	}
+
+	i.Notice(e.Nick, "Thanks, your report has been sent to the Discord moderators.")
+}
+
+// discordAuthor returns the Discord user ID of a puppet, or the nick itself,
+// which could be the username of a Discord user relayed through the listener.
+func (i *ircListener) discordAuthor(nick string) string {
+	if con := i.bridge.ircManager.connectionByNick(nick); con != nil {
+		return con.Discord().ID
+	}
+	return nick
+}
+
+// postReport posts a report of a Discord message to the moderators' channel,
+// and opens a thread for discussing it if configured.
+func (d *discordBot) postReport(msg *relayedMessage, reporter, ircChannel, reason string) error {
+	conf := d.bridge.Config
+
+	link := fmt.Sprintf("https://discord.com/channels/%s/%s/%s", d.guildID, msg.DiscordChannel, msg.DiscordID)
+	content := fmt.Sprintf(
+		"**Report** from IRC user %s in %s: %s\nMessage by <@%s>: %s\n> %s",
+		reporter, ircChannel, reason,
+		msg.DiscordAuthorID, link,
+		TruncateString(300, strings.Replace(msg.Content, "\n", " ", -1)),
+	)
+
+	sent, err := d.ChannelMessageSendComplex(conf.ReportDiscordChannel, &discordgo.MessageSend{
+		Content: sanitiseDiscordContent(content),
+		// Don't ping the reported user
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	})
+	if err != nil {
+		return err
+	}
+
+	if conf.ReportThreads {
+		name := TruncateString(90, "Report: "+msg.DiscordAuthor+" — "+reason)
+		if _, err := d.MessageThreadStart(sent.ChannelID, sent.ID, name, reportThreadArchive); err != nil {
+			d.bridge.handleError(err, nil, "could not open thread for report")
+		}
+	}
+
+	return nil
+}
+
+func init() {
+	registerChatCommand(&chatCommand{
+		Name:    "report",
+		Enabled: func(b *Bridge) bool { return b.Config.ReportDiscordChannel != "" },
+		IRC:     (*ircListener).handleReport,
+	})
+}
@@ -0,0 +1,68 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReport(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.ReportDiscordChannel = "2100"
+		conf.ReportThreads = true
+	})
+	defer tb.Close()
+
+	d := tb.Bridge.discord
+	say := func(author *discordgo.User, content string) {
+		d.onMessageCreate(d.Session, &discordgo.MessageCreate{Message: &discordgo.Message{
+			ID:        tb.discord.id(),
+			ChannelID: testChannelID,
+			GuildID:   testGuildID,
+			Author:    author,
+			Content:   content,
+			Timestamp: time.Now(),
+		}})
+	}
+	bob := tb.discordMember("100", "bob", "")
+	carol := tb.discordMember("200", "carol", "")
+	say(bob, "something awful")
+	say(carol, "something nice")
+
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :!report bob: this is spam")
+	waitFor(t, "report", func() bool {
+		for _, msg := range tb.discord.Sent() {
+			if msg.ChannelID == "2100" && strings.HasPrefix(msg.Content, "**Report** from IRC user alice in "+testChannel+": this is spam\nMessage by <@100>: https://discord.com/channels/") {
+				return true
+			}
+		}
+		return false
+	})
+	waitFor(t, "reply to reporter", func() bool {
+		return tb.ircd.HasReceived("listener", "NOTICE alice :Thanks, your report has been sent to the Discord moderators.")
+	})
+
+	tb.discord.mu.Lock()
+	assert.Len(t, tb.discord.threads, 1)
+	assert.Equal(t, "Report: bob — this is spam", tb.discord.threads[0].Name)
+	tb.discord.mu.Unlock()
+
+	// Without a nick, the most recent message is reported
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :!report rude")
+	waitFor(t, "second report", func() bool {
+		for _, msg := range tb.discord.Sent() {
+			if strings.Contains(msg.Content, "Message by <@200>") && strings.Contains(msg.Content, "> something nice") {
+				return true
+			}
+		}
+		return false
+	})
+
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :!report")
+	waitFor(t, "usage", func() bool {
+		return tb.ircd.HasReceived("listener", "NOTICE alice :Usage: !report [nick:] <reason>")
+	})
+}
@@ -0,0 +1,95 @@
+package bridge
+
+import (
+	"expvar"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// retentionDefault is the key in Config.Retention for datasets without their own retention
+const retentionDefault = "default"
+
+// retentionMessages is the dataset of relayed messages remembered in memory
+const retentionMessages = "messages"
+
+// retentionDatasets are the datasets retention can be configured for: the store buckets,
+// and the relayed messages remembered in memory
+var retentionDatasets = map[string]bool{
+	digestBucket:      true,
+	ignoredBucket:     true,
+	karmaBucket:       true,
+	linksBucket:       true,
+	notifyBucket:      true,
+	optOutBucket:      true,
+	outboxBucket:      true,
+	retentionMessages: true,
+}
+
+// retentionInterval is how often the janitor expires old data
+var retentionInterval = time.Hour
+
+// storeMetrics is the number of entries in each dataset,
+// and the size of the store on disk under "bytes"
+var storeMetrics = expvar.NewMap("store")
+
+// validateRetention checks the datasets in the retention config.
+func validateRetention(retention map[string]time.Duration) error {
+	for dataset, ttl := range retention {
+		if dataset != retentionDefault && !retentionDatasets[dataset] {
+			return errors.Errorf("unknown dataset %q in retention", dataset)
+		}
+		if ttl < 0 {
+			return errors.Errorf("retention of %s can't be negative", dataset)
+		}
+	}
+	return nil
+}
+
+// retention returns how long data in a dataset is kept. Zero means forever.
+func (b *Bridge) retention(dataset string) time.Duration {
+	if ttl, ok := b.Config.Retention[dataset]; ok {
+		return ttl
+	}
+	return b.Config.Retention[retentionDefault]
+}
+
+// expireData removes data older than its retention, and updates the store metrics.
+// Stored data expires once it hasn't been written to for its retention.
+func (b *Bridge) expireData() {
+	for _, bucket := range b.store.Buckets() {
+		if ttl := b.retention(bucket); ttl > 0 {
+			expired, err := b.store.Expire(bucket, ttl)
+			if err != nil {
+				log.WithFields(log.Fields{"error": err, "bucket": bucket}).Warnln("could not expire old data")
+			} else if expired > 0 {
+				log.WithFields(log.Fields{"bucket": bucket, "expired": expired}).Infoln("Expired old data from the store.")
+			}
+		}
+	}
+
+	if ttl := b.retention(retentionMessages); ttl > 0 {
+		b.messages.Forget(func(msg *relayedMessage) bool {
+			return time.Since(msg.Time) > ttl
+		})
+	}
+
+	b.updateStoreMetrics()
+}
+
+func (b *Bridge) updateStoreMetrics() {
+	for _, bucket := range b.store.Buckets() {
+		n := new(expvar.Int)
+		n.Set(int64(b.store.Len(bucket)))
+		storeMetrics.Set(bucket, n)
+	}
+
+	messages := new(expvar.Int)
+	messages.Set(int64(b.messages.Len()))
+	storeMetrics.Set(retentionMessages, messages)
+
+	size := new(expvar.Int)
+	size.Set(int64(b.store.Size()))
+	storeMetrics.Set("bytes", size)
+}
@@ -0,0 +1,38 @@
+package bridge
+
+import (
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetention(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.Retention = map[string]time.Duration{
+			retentionDefault:  time.Hour,
+			retentionMessages: time.Minute,
+			linksBucket:       0,
+		}
+	})
+	defer tb.Close()
+	b := tb.Bridge
+
+	assert.Equal(t, time.Hour, b.retention(karmaBucket))
+	assert.Equal(t, time.Duration(0), b.retention(linksBucket))
+
+	b.messages.Add(&relayedMessage{DiscordID: "6000", Time: time.Now().Add(-time.Hour)})
+	b.messages.Add(&relayedMessage{DiscordID: "6001", Time: time.Now()})
+	b.expireData()
+
+	assert.Nil(t, b.messages.ByDiscordID("6000"))
+	assert.NotNil(t, b.messages.ByDiscordID("6001"))
+	assert.Equal(t, "1", storeMetrics.Get(retentionMessages).(*expvar.Int).String())
+}
+
+func TestValidateRetention(t *testing.T) {
+	assert.NoError(t, validateRetention(map[string]time.Duration{"default": time.Hour, "karma": 0}))
+	assert.Error(t, validateRetention(map[string]time.Duration{"logs": time.Hour}))
+	assert.Error(t, validateRetention(map[string]time.Duration{"karma": -time.Hour}))
+}
@@ -0,0 +1,46 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	irc "github.com/qaisjp/go-ircevent"
+)
+
+// handleSeen tells an IRC user whether and when a message relayed from their channel
+// was posted on Discord, with a link to it.
+//
+// The command takes the form "!seen <fragment>", and the most recent message
+// containing the fragment is looked up.
+func (i *ircListener) handleSeen(e *irc.Event, args []string) {
+	channel := e.Arguments[0]
+	fragment := strings.Join(args, " ")
+	if fragment == "" {
+		i.Notice(e.Nick, "Usage: seen <part of the message>")
+		return
+	}
+
+	msg := i.bridge.messages.LatestFromIRC(channel, fragment)
+	if msg == nil {
+		i.Noticef(e.Nick, "None of the last %d relayed messages match that, so it may not have reached Discord.", messageMapLimit)
+		return
+	}
+
+	link := fmt.Sprintf("https://discord.com/channels/%s/%s/%s", i.bridge.discord.guildID, msg.DiscordChannel, msg.DiscordID)
+	i.Noticef(e.Nick, "%s's message \"%s\" was posted on Discord at %s (%s ago): %s",
+		msg.IRCNick,
+		TruncateString(100, strings.Replace(msg.Content, "\n", " ", -1)),
+		msg.Time.UTC().Format("15:04:05 MST"),
+		time.Since(msg.Time).Round(time.Second),
+		link,
+	)
+}
+
+func init() {
+	registerChatCommand(&chatCommand{
+		Name:    "seen",
+		Enabled: func(b *Bridge) bool { return b.Config.AllowIRCSeen },
+		IRC:     (*ircListener).handleSeen,
+	})
+}
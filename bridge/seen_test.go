@@ -0,0 +1,41 @@
+package bridge
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeen(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.AllowIRCSeen = true
+	})
+	defer tb.Close()
+
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :the meeting moved to 5pm")
+	var msg *relayedMessage
+	waitFor(t, "message remembered", func() bool {
+		msg = tb.Bridge.messages.LatestFromIRC(testChannel, "meeting")
+		return msg != nil
+	})
+
+	reply := func() string {
+		for _, line := range tb.ircd.Received("listener") {
+			if strings.HasPrefix(line, "NOTICE bob :") {
+				return line
+			}
+		}
+		return ""
+	}
+	tb.ircd.Inject("bob!bo@example.com", testChannel, "PRIVMSG "+testChannel+" :!seen MEETING moved")
+	waitFor(t, "seen reply", func() bool { return reply() != "" })
+	link := "https://discord.com/channels/" + testGuildID + "/" + testChannelID + "/" + msg.DiscordID
+	assert.Regexp(t, `^NOTICE bob :alice's message "the meeting moved to 5pm" was posted on Discord at \d\d:\d\d:\d\d UTC \(\d+s ago\): `+regexp.QuoteMeta(link)+`$`, reply())
+
+	tb.ircd.Inject("bob!bo@example.com", testChannel, "PRIVMSG "+testChannel+" :!seen cancelled")
+	waitFor(t, "not seen reply", func() bool {
+		return tb.ircd.HasReceived("listener", "NOTICE bob :None of the last 500 relayed messages match that, so it may not have reached Discord.")
+	})
+}
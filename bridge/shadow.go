@@ -0,0 +1,130 @@
+package bridge
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// shadowed logs something the bridge would have done if it wasn't in shadow mode.
+func shadowed(fields log.Fields, what string) {
+	log.WithFields(fields).Infoln("Shadow mode: would have " + what + ".")
+}
+
+// shadowTransport stops the Discord session changing anything in shadow mode.
+// Reads are sent, and everything else is logged instead.
+//
+// Discord would usually respond with what was created or changed, so the request
+// is echoed back, which is enough for the callers to carry on.
+type shadowTransport struct {
+	next http.RoundTripper
+
+	// offline refuses reads too, for replays
+	offline bool
+}
+
+func (t shadowTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.offline && (req.Method == "GET" || req.Method == "HEAD") {
+		return nil, errors.New("replaying offline")
+	}
+	if req.Method == "GET" || req.Method == "HEAD" {
+		next := t.next
+		if next == nil {
+			next = http.DefaultTransport
+		}
+		return next.RoundTrip(req)
+	}
+
+	var body []byte
+	if req.Body != nil {
+		body, _ = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+	}
+	shadowed(log.Fields{"method": req.Method, "path": req.URL.Path}, "sent a request to Discord")
+
+	if !bytes.HasPrefix(body, []byte("{")) && !bytes.HasPrefix(body, []byte("[")) {
+		body = []byte("{}")
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       ioutil.NopCloser(bytes.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+// shadowReadOnly are the IRC commands the listener still sends in shadow mode,
+// since they only ask the server something, or are needed to see the channels.
+var shadowReadOnly = map[string]bool{
+	"CAP":   true,
+	"JOIN":  true,
+	"NAMES": true,
+	"PING":  true,
+	"PONG":  true,
+	"WHO":   true,
+	"WHOIS": true,
+}
+
+// shadowIRC returns true, and logs the line, if the listener shouldn't send it because the bridge is in shadow mode.
+func (i *ircListener) shadowIRC(line string) bool {
+	if !i.bridge.Config.Shadow {
+		return false
+	}
+
+	// Replays aren't connected to anything
+	if i.bridge.replay != nil {
+		return true
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return false
+	}
+	command := strings.ToUpper(fields[0])
+
+	// MODE and TOPIC with only a target ask what they are
+	if shadowReadOnly[command] || ((command == "MODE" || command == "TOPIC") && len(fields) == 2) {
+		return false
+	}
+
+	shadowed(log.Fields{"line": line}, "sent to IRC")
+	return true
+}
+
+// SendRaw sends a line to the IRC server, unless the bridge is in shadow mode.
+// Everything else the listener sends goes through the methods below, so they are held back too.
+func (i *ircListener) SendRaw(message string) {
+	if !i.shadowIRC(message) {
+		i.Connection.SendRaw(message)
+	}
+}
+
+func (i *ircListener) SendRawf(format string, a ...interface{}) {
+	i.SendRaw(fmt.Sprintf(format, a...))
+}
+
+func (i *ircListener) Privmsg(target, message string) {
+	if !i.shadowIRC("PRIVMSG " + target + " :" + message) {
+		i.Connection.Privmsg(target, message)
+	}
+}
+
+func (i *ircListener) Privmsgf(target, format string, a ...interface{}) {
+	i.Privmsg(target, fmt.Sprintf(format, a...))
+}
+
+func (i *ircListener) Notice(target, message string) {
+	if !i.shadowIRC("NOTICE " + target + " :" + message) {
+		i.Connection.Notice(target, message)
+	}
+}
+
+func (i *ircListener) Noticef(target, format string, a ...interface{}) {
+	i.Notice(target, fmt.Sprintf(format, a...))
+}
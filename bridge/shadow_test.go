@@ -0,0 +1,61 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShadow(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.Shadow = true
+	})
+	defer tb.Close()
+	assert.True(t, tb.Config.SimpleMode)
+
+	// The harness replaces the Discord client that shadow mode wraps
+	tb.Bridge.discord.Client.Transport = shadowTransport{next: tb.discord}
+
+	hook := logtest.NewGlobal()
+	log.SetLevel(log.InfoLevel)
+	defer log.SetLevel(log.WarnLevel)
+	shadowLogs := func(what string) int {
+		n := 0
+		for _, entry := range hook.AllEntries() {
+			if entry.Message == "Shadow mode: would have "+what+"." {
+				n++
+			}
+		}
+		return n
+	}
+
+	bob := tb.discordMember("100", "bob", "")
+	tb.discordSay(bob, "hello irc")
+	tb.discordSay(bob, "!ping")
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :hello discord")
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :!ping")
+	tb.ircd.SendTo("listener", ":alice!al@example.com PRIVMSG listener :help")
+
+	waitFor(t, "relays to be logged", func() bool {
+		return shadowLogs("relayed to IRC") == 1 && shadowLogs("relayed to Discord") == 1 &&
+			shadowLogs("run a command") == 2 && shadowLogs("answered a private message") == 1
+	})
+
+	for _, line := range tb.ircd.Received("listener") {
+		assert.False(t, strings.HasPrefix(line, "PRIVMSG") || strings.HasPrefix(line, "NOTICE"), line)
+	}
+	for _, msg := range tb.discord.Sent() {
+		assert.NotContains(t, msg.Content, "hello discord")
+		assert.NotContains(t, msg.Content, "pong")
+	}
+
+	// Anything else sent to Discord is logged instead
+	_, err := tb.Bridge.discord.ChannelMessageSend(testChannelID, "not sent")
+	assert.NoError(t, err)
+	_, ok := tb.discord.Find("not sent")
+	assert.False(t, ok)
+	assert.Equal(t, 1, shadowLogs("sent a request to Discord"))
+}
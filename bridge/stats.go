@@ -0,0 +1,177 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	irc "github.com/qaisjp/go-ircevent"
+)
+
+// statsWindow is how far back relay statistics go for !stats.
+const statsWindow = 7 * 24 * time.Hour
+
+// relayCounts are what happened to someone's messages in an hour.
+type relayCounts struct {
+	Relayed int
+	Dropped int // by filters, like roles required in the channel or opting out
+	Limited int // dropped or cut short by a rate limit
+	Failed  int
+}
+
+func (c *relayCounts) add(o relayCounts) {
+	c.Relayed += o.Relayed
+	c.Dropped += o.Dropped
+	c.Limited += o.Limited
+	c.Failed += o.Failed
+}
+
+// relayStats counts what happened to each person's messages over the last week,
+// by hour, keyed by the same key as karma, so linked identities share statistics.
+// Statistics are kept in memory, so they start again when the bridge does.
+type relayStats struct {
+	mu    sync.Mutex
+	users map[string]*userStats
+}
+
+type userStats struct {
+	hours      map[int64]*relayCounts // keyed by Unix time divided by an hour
+	lastDrop   string
+	lastDropAt time.Time
+}
+
+// record counts the outcome of a message once its trace has ended.
+func (s *relayStats) record(sender string, trace *messageTrace, now time.Time) {
+	if sender == "" {
+		return
+	}
+
+	var counts relayCounts
+	limited := false
+	for _, step := range trace.Steps {
+		limited = limited || step.Stage == traceRateLimit
+	}
+	last := trace.Steps[len(trace.Steps)-1]
+	switch {
+	case limited:
+		counts.Limited++
+		if last.Stage == traceSend {
+			counts.Relayed++
+		}
+	case last.Stage == traceSend && strings.HasPrefix(last.Detail, "failed"):
+		counts.Failed++
+	case last.Stage == traceSend:
+		counts.Relayed++
+	case strings.HasPrefix(last.Detail, "dropped"):
+		counts.Dropped++
+	default:
+		// Bridge commands and the like aren't messages
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.users == nil {
+		s.users = make(map[string]*userStats)
+	}
+	user, ok := s.users[sender]
+	if !ok {
+		user = &userStats{hours: make(map[int64]*relayCounts)}
+		s.users[sender] = user
+	}
+
+	hour := now.Unix() / int64(time.Hour/time.Second)
+	for h := range user.hours {
+		if hour-h >= int64(statsWindow/time.Hour) {
+			delete(user.hours, h)
+		}
+	}
+	if user.hours[hour] == nil {
+		user.hours[hour] = &relayCounts{}
+	}
+	user.hours[hour].add(counts)
+
+	if counts.Dropped > 0 || (counts.Limited > 0 && counts.Relayed == 0) {
+		user.lastDrop = last.Detail
+		user.lastDropAt = now
+	}
+}
+
+// Since returns the counts for someone's messages since a time, up to statsWindow ago,
+// and why the last of their messages that wasn't relayed was dropped.
+func (s *relayStats) Since(sender string, since time.Time) (counts relayCounts, lastDrop string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[sender]
+	if !ok {
+		return counts, ""
+	}
+	from := since.Unix() / int64(time.Hour/time.Second)
+	for h, c := range user.hours {
+		if h >= from {
+			counts.add(*c)
+		}
+	}
+	if user.lastDropAt.After(since) {
+		lastDrop = user.lastDrop
+	}
+	return counts, lastDrop
+}
+
+// Forget deletes someone's statistics, returning true if there were any.
+func (s *relayStats) Forget(sender string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.users[sender]
+	delete(s.users, sender)
+	return ok
+}
+
+// statsLines describes what happened to someone's messages over the last day and week.
+// Only "me" may be given, as people can only see their own statistics.
+func (b *Bridge) statsLines(sender string, args []string) []string {
+	if len(args) > 0 && !(len(args) == 1 && strings.EqualFold(args[0], "me")) {
+		return []string{"You can only see statistics for your own messages, with \"stats me\"."}
+	}
+
+	now := time.Now()
+	day, _ := b.stats.Since(sender, now.Add(-24*time.Hour))
+	week, lastDrop := b.stats.Since(sender, now.Add(-statsWindow))
+
+	if week == (relayCounts{}) {
+		return []string{"None of your messages have come through the bridge in the last week, or since it started."}
+	}
+
+	describe := func(c relayCounts) string {
+		s := fmt.Sprintf("%d relayed, %d dropped by filters, %d rate limited", c.Relayed, c.Dropped, c.Limited)
+		if c.Failed > 0 {
+			s += fmt.Sprintf(", %d failed to send", c.Failed)
+		}
+		return s
+	}
+	lines := []string{
+		"Your messages in the last day: " + describe(day) + ".",
+		"In the last week: " + describe(week) + ".",
+	}
+	if lastDrop != "" {
+		lines = append(lines, "The last one that wasn't relayed: "+strings.TrimPrefix(lastDrop, "dropped: ")+".")
+	}
+	return lines
+}
+
+func init() {
+	registerChatCommand(&chatCommand{
+		Name: "stats",
+		IRC: func(i *ircListener, e *irc.Event, args []string) {
+			for _, line := range i.bridge.statsLines(i.bridge.karmaKeyIRC(e.Nick, i.account(e)), args) {
+				i.Notice(e.Nick, line)
+			}
+		},
+		Discord: func(d *discordBot, m *discordgo.Message, args []string) {
+			d.reply(m, strings.Join(d.bridge.statsLines(karmaKeyDiscord(m.Author.ID), args), "\n"))
+		},
+	})
+}
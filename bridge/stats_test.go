@@ -0,0 +1,65 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelayStats(t *testing.T) {
+	var stats relayStats
+	now := time.Now()
+	trace := func(steps ...traceStep) *messageTrace {
+		return &messageTrace{Steps: append([]traceStep{{Stage: traceReceive}}, steps...)}
+	}
+
+	stats.record("discord:100", trace(traceStep{Stage: traceSend, Detail: "sent to #test"}), now.Add(-3*24*time.Hour))
+	stats.record("discord:100", trace(traceStep{Stage: traceSend, Detail: "sent to #test"}), now)
+	stats.record("discord:100", trace(traceStep{Stage: traceFilter, Detail: "dropped: sent by a bot"}), now)
+	stats.record("discord:100", trace(traceStep{Stage: traceRateLimit}, traceStep{Stage: traceFilter, Detail: "dropped by the raid filter"}), now)
+	stats.record("discord:100", trace(traceStep{Stage: traceFilter, Detail: "ran as a bridge command"}), now)
+	stats.record("discord:100", trace(traceStep{Stage: traceSend, Detail: "sent to #test"}), now.Add(-8*24*time.Hour))
+
+	day, lastDrop := stats.Since("discord:100", now.Add(-24*time.Hour))
+	assert.Equal(t, relayCounts{Relayed: 1, Dropped: 1, Limited: 1}, day)
+	assert.Equal(t, "dropped by the raid filter", lastDrop)
+
+	week, _ := stats.Since("discord:100", now.Add(-statsWindow))
+	assert.Equal(t, relayCounts{Relayed: 2, Dropped: 1, Limited: 1}, week)
+
+	none, _ := stats.Since("discord:101", now.Add(-statsWindow))
+	assert.Equal(t, relayCounts{}, none)
+}
+
+func TestStatsCommand(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.ChannelOptions = map[string]ChannelOptions{
+			testChannel: {MaxLines: 1},
+		}
+	})
+	defer tb.Close()
+
+	bob := tb.discordMember("100", "bob", "")
+	tb.discordSay(bob, "hello")
+	tb.discordSay(bob, "one\ntwo")
+	waitFor(t, "messages relayed", func() bool {
+		return tb.ircd.HasReceived("listener", "PRIVMSG "+testChannel+" :<b\u200Bob#0001> one [message truncated]")
+	})
+
+	tb.discordSay(bob, "!stats me")
+	waitFor(t, "stats reply", func() bool {
+		for _, m := range tb.discord.Sent() {
+			if strings.HasPrefix(m.Content, "Your messages in the last day: 2 relayed, 0 dropped by filters, 1 rate limited.") {
+				return true
+			}
+		}
+		return false
+	})
+
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :!stats")
+	waitFor(t, "stats reply on irc", func() bool {
+		return tb.ircd.HasReceived("listener", "NOTICE alice :None of your messages have come through the bridge in the last week, or since it started.")
+	})
+}
@@ -0,0 +1,37 @@
+package bridge
+
+import (
+	"fmt"
+	"sort"
+)
+
+// statusLines returns a human readable summary of the state of the bridge.
+func (b *Bridge) statusLines() []string {
+	lines := []string{
+		fmt.Sprintf("Bridging %d channels, with %d Discord users connected to IRC.",
+			len(b.channelMappings()), len(b.ircManager.connections())),
+	}
+
+	b.policiesMu.Lock()
+	for channel, policy := range b.policies {
+		if policy != relayPuppets {
+			lines = append(lines, fmt.Sprintf("%s: %s", channel, policy))
+		}
+	}
+	b.policiesMu.Unlock()
+
+	degraded := []string{}
+	for _, con := range b.ircManager.connections() {
+		for channel, reason := range con.Degraded() {
+			degraded = append(degraded, fmt.Sprintf("%s can't speak in %s (%s)", con.Nick(), channel, reason))
+		}
+	}
+	sort.Strings(degraded)
+
+	if len(degraded) > 0 {
+		lines = append(lines, "Degraded, these messages are relayed through the listener:")
+		lines = append(lines, degraded...)
+	}
+
+	return lines
+}
@@ -0,0 +1,66 @@
+package bridge
+
+import (
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// statusAnnouncer remembers which problems have been announced in the IRC status channel,
+// so that each is announced once when it starts, and once when it is over.
+type statusAnnouncer struct {
+	mu       sync.Mutex
+	problems map[string]bool
+	stopped  bool // the bridge is shutting down, which isn't worth announcing
+}
+
+func (a *statusAnnouncer) stop() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.stopped = true
+}
+
+// change records whether a problem is happening, returning true if that is news.
+func (a *statusAnnouncer) change(key string, happening bool) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.stopped || a.problems[key] == happening {
+		return false
+	}
+	if a.problems == nil {
+		a.problems = make(map[string]bool)
+	}
+	a.problems[key] = happening
+	return true
+}
+
+// statusProblem announces a problem in the IRC status channel, unless it already has been.
+func (b *Bridge) statusProblem(key, message string) {
+	if b.announcer.change(key, true) {
+		b.statusNotice(message)
+	}
+}
+
+// statusResolved announces that a problem is over. Nothing is announced if the message is empty.
+func (b *Bridge) statusResolved(key, message string) {
+	if b.announcer.change(key, false) && message != "" {
+		b.statusNotice(message)
+	}
+}
+
+func (b *Bridge) statusNotice(message string) {
+	channel := b.Config.StatusIRCChannel
+	// The listener can't send anything until it is connected
+	if channel == "" || !b.isLeader() || !b.ircListener.Healthy() {
+		return
+	}
+	b.ircListener.Notice(channel, "[bridge] "+message)
+}
+
+func (d *discordBot) onGatewayDisconnect(s *discordgo.Session, e *discordgo.Disconnect) {
+	d.bridge.statusProblem("discord", "Disconnected from the Discord gateway. Messages from Discord are delayed until it reconnects.")
+}
+
+func (d *discordBot) onGatewayConnect(s *discordgo.Session, e *discordgo.Connect) {
+	d.bridge.statusResolved("discord", "Reconnected to the Discord gateway.")
+}
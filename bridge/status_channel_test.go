@@ -0,0 +1,49 @@
+package bridge
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusChannel(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.StatusIRCChannel = "#ops"
+	})
+	defer tb.Close()
+
+	waitFor(t, "listener to join the status channel", func() bool {
+		return tb.ircd.HasReceived("listener", "JOIN #ops")
+	})
+	waitFor(t, "listener to be connected", tb.ircListener.Healthy)
+
+	tb.discord.RefuseWebhooks(http.StatusForbidden, "Missing Permissions")
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :hello")
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :anyone?")
+	waitFor(t, "failure announced", func() bool {
+		return tb.ircd.HasReceived("listener", "NOTICE #ops :[bridge] Relaying to Discord is failing: Missing Permissions")
+	})
+
+	tb.discord.RefuseWebhooks(0, "")
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :hello again")
+	waitFor(t, "recovery announced", func() bool {
+		return tb.ircd.HasReceived("listener", "NOTICE #ops :[bridge] Relaying to Discord has recovered.")
+	})
+
+	// Each problem is announced once
+	n := 0
+	for _, line := range tb.ircd.Received("listener") {
+		if line == "NOTICE #ops :[bridge] Relaying to Discord is failing: Missing Permissions" {
+			n++
+		}
+	}
+	assert.Equal(t, 1, n)
+
+	tb.Bridge.discord.onGatewayDisconnect(nil, nil)
+	tb.Bridge.discord.onGatewayConnect(nil, nil)
+	waitFor(t, "gateway announced", func() bool {
+		return tb.ircd.HasReceived("listener", "NOTICE #ops :[bridge] Reconnected to the Discord gateway.")
+	})
+	assert.True(t, tb.ircd.HasReceived("listener", "NOTICE #ops :[bridge] Disconnected from the Discord gateway. Messages from Discord are delayed until it reconnects."))
+}
@@ -1,6 +1,8 @@
 package bridge
 
 import (
+	"time"
+
 	"github.com/bwmarrin/discordgo"
 )
 
@@ -10,14 +12,27 @@ type DiscordMessage struct {
 	Content  string
 	IsAction bool
 	PmTarget string // target username, for PMs
+	Probe    string // latency probe token, if this is a probe
+	Held     bool   // queued during quiet hours, so meant to be late
+	Delayed  bool   // has already waited out the channel's relay delay
+	IsEdit   bool   // is an edit of an earlier message, with editMarker in front
+	TraceID  string // correlation ID, for !trace
 }
 
 // IRCMessage is a chat message sent to Discord (from IRCListener)
 type IRCMessage struct {
 	IRCChannel string
 	Username   string
+	Hostmask   string // nick!user@host of the IRC user
 	Message    string
 	IsAction   bool
+	Away       bool      // is the IRC user marked as away?
+	Account    string    // services account of the IRC user, if known
+	MsgID      string    // IRCv3 msgid, if the server supports message-tags
+	Probe      string    // latency probe token, if this is a probe
+	Time       time.Time // when it was sent, from the server-time tag if the server supports it
+	Held       bool      // queued during quiet hours, so meant to be late
+	TraceID    string    // correlation ID, for !trace
 }
 
 // DiscordUser is information that IRC needs to know about a user
@@ -30,8 +45,91 @@ type DiscordUser struct {
 	Online        bool
 }
 
-// Mapping is a mapping between a Discord channel and an IRC channel (essentially a tuple).
-type Mapping struct {
-	DiscordChannel string
-	IRCChannel     string
+// ChannelOptions are per-mapping settings, keyed by IRC channel in the config.
+type ChannelOptions struct {
+	// DiscordRoles, if set, means only messages from Discord members
+	// with at least one of these role IDs are relayed to IRC.
+	DiscordRoles []string `mapstructure:"discord_roles"`
+
+	// IRCMinPrefix, if set, means only messages from IRC users with at least
+	// this channel prefix (e.g. "+" for voice, "@" for op) are relayed to Discord.
+	IRCMinPrefix string `mapstructure:"irc_min_prefix"`
+
+	// Key is the channel key (+k), if it isn't given in the mapping like "#channel key".
+	Key string `mapstructure:"key"`
+
+	// DenyWebhooks stops messages from third-party webhooks being relayed to IRC.
+	DenyWebhooks bool `mapstructure:"deny_webhooks"`
+
+	// AllowedWebhooks, if set, are the IDs or names of the only webhooks relayed to IRC.
+	AllowedWebhooks []string `mapstructure:"allowed_webhooks"`
+
+	// HideNickChanges stops IRC nick changes from being relayed to Discord.
+	HideNickChanges bool `mapstructure:"hide_nick_changes"`
+
+	// PinTopic mirrors the most recently pinned Discord message into the IRC topic.
+	PinTopic bool `mapstructure:"pin_topic"`
+
+	// TopicMarker, if set, mirrors Discord messages starting with it (e.g. "[topic]") into the IRC topic.
+	TopicMarker string `mapstructure:"topic_marker"`
+
+	// QuietHours, like "22:00-07:00", is when relaying is paused each day,
+	// in the QuietTimezone (the system timezone by default).
+	QuietHours    string `mapstructure:"quiet_hours"`
+	QuietTimezone string `mapstructure:"quiet_timezone"`
+
+	// QuietDirection is "both" (the default), "to_irc" or "to_discord".
+	QuietDirection string `mapstructure:"quiet_direction"`
+
+	// QuietQueue relays messages sent during quiet hours once they end, instead of dropping them.
+	QuietQueue bool `mapstructure:"quiet_queue"`
+
+	// NickColors colours the names of Discord users relayed by the listener:
+	// "role" uses their top role's colour, and "hash" a colour picked from their ID.
+	NickColors string `mapstructure:"nick_colors"`
+
+	// MaxLines and MaxCharsPerMinute, if set, limit how much of a Discord user's messages are
+	// relayed: lines per message, and characters per minute. The rest is replaced with a link
+	// to a paste of the whole message.
+	MaxLines          int `mapstructure:"max_lines"`
+	MaxCharsPerMinute int `mapstructure:"max_chars_per_minute"`
+
+	// SmartPresence keeps puppets in the channel when their Discord users go offline,
+	// instead of leaving after the cooldown. Only their away status changes.
+	SmartPresence bool `mapstructure:"smart_presence"`
+
+	// DropBots stops messages from Discord bot accounts being relayed to IRC.
+	DropBots bool `mapstructure:"drop_bots"`
+
+	// Language is the ISO 639-1 code of the language the channel is for, like "en".
+	// LanguagePolicy is what happens to messages in other languages: "warn" tells the sender,
+	// "tag" marks them with their language, like "[es]", and "redirect" relays them to the
+	// channel for their language instead.
+	Language       string `mapstructure:"language"`
+	LanguagePolicy string `mapstructure:"language_policy"`
+
+	// MaxRelayAge, if set, overrides Config.MaxRelayAge for the mapping.
+	MaxRelayAge time.Duration `mapstructure:"max_relay_age"`
+
+	// RelayDelay, if set, is how long Discord messages wait before being relayed to IRC,
+	// so that messages deleted straight away are never relayed.
+	RelayDelay time.Duration `mapstructure:"relay_delay"`
+
+	// ListenerNick, if set, is the nick messages are relayed to the channel with when the
+	// listener would relay them, like in simple mode, from its own IRC connection.
+	// ListenerIdent is its ident, "discord" by default.
+	ListenerNick  string `mapstructure:"listener_nick"`
+	ListenerIdent string `mapstructure:"listener_ident"`
+}
+
+// CommandOptions are settings for a bridge command, keyed by command name in the config.
+type CommandOptions struct {
+	// Disabled turns the command off.
+	Disabled bool `mapstructure:"disabled"`
+
+	// Channels, if set, are the only IRC channels (and their Discord channels) the command can be used in.
+	Channels []string `mapstructure:"channels"`
+
+	// Permission is who can use the command: "everyone", "moderator" or "admin".
+	Permission string `mapstructure:"permission"`
 }
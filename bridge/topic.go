@@ -0,0 +1,121 @@
+package bridge
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	irc "github.com/qaisjp/go-ircevent"
+)
+
+// defaultTopicLength is used if the IRC server doesn't advertise TOPICLEN
+var defaultTopicLength = 300
+
+// onChannelPinsUpdate mirrors the most recently pinned message into the IRC topic.
+func (d *discordBot) onChannelPinsUpdate(s *discordgo.Session, e *discordgo.ChannelPinsUpdate) {
+	mapping := d.bridge.GetMappingByDiscord(e.ChannelID)
+	if mapping == nil || !d.bridge.channelOptions(mapping.IRCChannel).PinTopic {
+		return
+	}
+
+	pinned, err := s.ChannelMessagesPinned(e.ChannelID)
+	if err != nil {
+		d.bridge.handleError(err, nil, "could not get pinned messages")
+		return
+	}
+
+	// The most recently pinned message is first
+	if len(pinned) == 0 {
+		return
+	}
+	d.bridge.ircListener.SetTopic(mapping.IRCChannel, d.ParseText(pinned[0]))
+}
+
+// topicFromMarker mirrors messages starting with the channel's topic marker into the IRC topic.
+func (d *discordBot) topicFromMarker(m *discordgo.Message) {
+	mapping := d.bridge.GetMappingByDiscord(m.ChannelID)
+	if mapping == nil {
+		return
+	}
+
+	marker := d.bridge.channelOptions(mapping.IRCChannel).TopicMarker
+	if marker == "" || !strings.HasPrefix(m.Content, marker) {
+		return
+	}
+
+	content := *m
+	content.Content = strings.TrimSpace(strings.TrimPrefix(m.Content, marker))
+	d.bridge.ircListener.SetTopic(mapping.IRCChannel, d.ParseText(&content))
+}
+
+// SetTopic sets the topic of an IRC channel, as a single line no longer than the server allows.
+func (i *ircListener) SetTopic(channel, topic string) {
+	channel = strings.Split(channel, " ")[0]
+	topic = strings.Join(strings.Fields(topic), " ")
+	if topic == "" {
+		return
+	}
+
+	i.topicMu.Lock()
+	topic = TruncateString(i.topicLength, topic)
+	unchanged := i.topics[strings.ToLower(channel)] == topic
+	i.topics[strings.ToLower(channel)] = topic
+	i.topicMu.Unlock()
+
+	if !unchanged {
+		i.SendRawf("TOPIC %s :%s", channel, topic)
+	}
+}
+
+// OnISupport handles RPL_ISUPPORT, to find out how long topics can be, and how channel modes work.
+func (i *ircListener) OnISupport(e *irc.Event) {
+	for _, token := range e.Arguments {
+		if token == "KNOCK" {
+			i.topicMu.Lock()
+			i.knock = true
+			i.topicMu.Unlock()
+			continue
+		}
+		if strings.HasPrefix(token, "PREFIX=") {
+			i.users.SetPrefix(strings.TrimPrefix(token, "PREFIX="))
+			continue
+		}
+		if strings.HasPrefix(token, "CHANMODES=") {
+			i.users.SetChanModes(strings.TrimPrefix(token, "CHANMODES="))
+			continue
+		}
+		if strings.HasPrefix(token, "BOT=") {
+			i.topicMu.Lock()
+			i.botMode = strings.TrimPrefix(token, "BOT=")
+			i.topicMu.Unlock()
+			continue
+		}
+		if !strings.HasPrefix(token, "TOPICLEN=") {
+			continue
+		}
+
+		length, err := strconv.Atoi(strings.TrimPrefix(token, "TOPICLEN="))
+		if err != nil || length <= 0 {
+			continue
+		}
+
+		i.topicMu.Lock()
+		i.topicLength = length
+		i.topicMu.Unlock()
+	}
+}
+
+// OnNotChannelOp handles ERR_CHANOPRIVSNEEDED, e.g. when the listener isn't allowed to set the topic.
+func (i *ircListener) OnNotChannelOp(e *irc.Event) {
+	if len(e.Arguments) < 2 {
+		return
+	}
+
+	i.topicMu.Lock()
+	delete(i.topics, strings.ToLower(e.Arguments[1]))
+	i.topicMu.Unlock()
+
+	err := withCategory(errors.Errorf("%s: %s", e.Arguments[1], e.Message()), errPermission)
+	i.bridge.handleError(err, nil, "listener is not a channel operator")
+}
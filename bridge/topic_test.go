@@ -0,0 +1,56 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPinTopic(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.ChannelOptions = map[string]ChannelOptions{
+			testChannel: {PinTopic: true, TopicMarker: "[topic]"},
+		}
+	})
+	defer tb.Close()
+
+	d := tb.Bridge.discord
+	bob := tb.discordMember("100", "bob", "")
+
+	tb.discord.mu.Lock()
+	for _, msg := range []*discordgo.Message{
+		{ID: "6001", ChannelID: testChannelID, Author: bob, Content: "old announcement"},
+		{ID: "6002", ChannelID: testChannelID, Author: bob, Content: "new\nannouncement"},
+	} {
+		tb.discord.messages[msg.ID] = msg
+		tb.discord.pins[msg.ID] = true
+	}
+	tb.discord.mu.Unlock()
+
+	d.onChannelPinsUpdate(d.Session, &discordgo.ChannelPinsUpdate{ChannelID: testChannelID, GuildID: testGuildID})
+	waitFor(t, "topic from pin", func() bool {
+		return tb.ircd.HasReceived("listener", "TOPIC "+testChannel+" :new announcement")
+	})
+
+	// The same topic isn't set twice
+	tb.ircListener.SetTopic(testChannel, "new announcement")
+	count := 0
+	for _, line := range tb.ircd.Received("listener") {
+		if line == "TOPIC "+testChannel+" :new announcement" {
+			count++
+		}
+	}
+	assert.Equal(t, 1, count)
+
+	tb.ircd.SendTo("listener", ":fake.ircd 005 listener TOPICLEN=12 :are supported by this server")
+	waitFor(t, "isupport", func() bool {
+		tb.ircListener.topicMu.Lock()
+		defer tb.ircListener.topicMu.Unlock()
+		return tb.ircListener.topicLength == 12
+	})
+	tb.discordSay(bob, "[topic] Meeting at five o'clock")
+	waitFor(t, "topic from marker", func() bool {
+		return tb.ircd.HasReceived("listener", "TOPIC "+testChannel+" :Meeting at …")
+	})
+}
@@ -0,0 +1,213 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	irc "github.com/qaisjp/go-ircevent"
+)
+
+// traceLimit is how many messages have their pipeline history kept for !trace
+const traceLimit = 1000
+
+// traceListLength is how many recent traces !trace lists without an ID
+const traceListLength = 5
+
+// traceStage is the part of the bridge a trace step happened in.
+type traceStage string
+
+const (
+	traceReceive   traceStage = "receive"
+	traceFilter    traceStage = "filter" // dropped, or handled without being relayed
+	traceFormat    traceStage = "format"
+	traceQueue     traceStage = "queue" // held for quiet hours or a relay delay, or moved to the outbox
+	traceRateLimit traceStage = "limit" // dropped or cut short by a rate limit
+	traceSend      traceStage = "send"
+)
+
+// ends is true if a message goes no further after a step in the stage.
+func (s traceStage) ends() bool {
+	return s == traceFilter || s == traceSend
+}
+
+// traceStep is something that happened to a message on its way through the bridge.
+type traceStep struct {
+	At     time.Time
+	Stage  traceStage
+	Detail string
+}
+
+// messageTrace is the history of a message, from being received to being sent or dropped.
+type messageTrace struct {
+	ID      string
+	From    string // "discord" or "irc"
+	Summary string // who sent it where, like "bob in #general"
+	Sender  string // karma key of the sender, for !stats
+	Steps   []traceStep
+	aliases []string
+	ended   bool
+}
+
+// traceBuffer keeps the traces of the most recent messages. Traces are found by their
+// correlation ID, like "d42" for the 42nd message from Discord, or by the ID the
+// message has on Discord or IRC (its msgid).
+type traceBuffer struct {
+	mu      sync.Mutex
+	next    int
+	ring    []*messageTrace
+	byID    map[string]*messageTrace
+	aliases map[string]string // Discord message ID or IRC msgid to correlation ID
+	count   map[string]int    // messages seen from each side, for IDs
+
+	// ended is the traces of messages that went no further since they were last taken,
+	// kept if export is set, for OTLP
+	export bool
+	ended  []messageTrace
+
+	// stats counts what happened to each sender's messages, once their traces end
+	stats *relayStats
+}
+
+// Start begins the trace of a message from "discord" or "irc", returning its correlation ID.
+// The sender is their karma key, and aliases are the IDs the message has on its side.
+func (t *traceBuffer) Start(from, sender, summary string, aliases ...string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.ring == nil {
+		t.ring = make([]*messageTrace, traceLimit)
+		t.byID = make(map[string]*messageTrace)
+		t.aliases = make(map[string]string)
+		t.count = make(map[string]int)
+	}
+
+	t.count[from]++
+	id := fmt.Sprintf("%c%d", from[0], t.count[from])
+
+	// Forget the oldest trace
+	if old := t.ring[t.next]; old != nil {
+		delete(t.byID, old.ID)
+		for _, alias := range old.aliases {
+			if t.aliases[alias] == old.ID {
+				delete(t.aliases, alias)
+			}
+		}
+	}
+
+	trace := &messageTrace{ID: id, From: from, Summary: summary, Sender: sender, Steps: []traceStep{{At: time.Now(), Stage: traceReceive, Detail: "received from " + from}}}
+	t.ring[t.next] = trace
+	t.next = (t.next + 1) % traceLimit
+	t.byID[id] = trace
+	for _, alias := range aliases {
+		if alias != "" {
+			t.aliases[alias] = id
+			trace.aliases = append(trace.aliases, alias)
+		}
+	}
+	return id
+}
+
+// Step records something that happened to a traced message. Messages without a
+// correlation ID, like latency probes, aren't traced.
+func (t *traceBuffer) Step(id string, stage traceStage, format string, args ...interface{}) {
+	if id == "" {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	trace, ok := t.byID[id]
+	if !ok {
+		return
+	}
+	trace.Steps = append(trace.Steps, traceStep{At: time.Now(), Stage: stage, Detail: fmt.Sprintf(format, args...)})
+
+	// Attachments are sent after the message they came with, but only the first send is exported
+	if stage.ends() && !trace.ended {
+		trace.ended = true
+		if t.stats != nil {
+			t.stats.record(trace.Sender, trace, time.Now())
+		}
+		if t.export && len(t.ended) < traceLimit {
+			ended := *trace
+			ended.Steps = append([]traceStep(nil), trace.Steps...)
+			t.ended = append(t.ended, ended)
+		}
+	}
+}
+
+// Ended takes the traces of messages that went no further, since it was last called.
+func (t *traceBuffer) Ended() []messageTrace {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ended := t.ended
+	t.ended = nil
+	return ended
+}
+
+// Lines describes the trace of a message, one step per line with its time since the message was received.
+func (t *traceBuffer) Lines(id string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if alias, ok := t.aliases[id]; ok {
+		id = alias
+	}
+	trace, ok := t.byID[strings.ToLower(id)]
+	if !ok {
+		return []string{"No trace for " + id + ". Only the last " + fmt.Sprint(traceLimit) + " messages are traced."}
+	}
+
+	start := trace.Steps[0].At
+	lines := []string{fmt.Sprintf("Trace %s: %s, at %s", trace.ID, trace.Summary, start.UTC().Format("15:04:05 MST"))}
+	for _, step := range trace.Steps {
+		lines = append(lines, fmt.Sprintf("+%s %s", step.At.Sub(start).Round(time.Millisecond), step.Detail))
+	}
+	return lines
+}
+
+// Recent lists the most recent traces, with how each ended.
+func (t *traceBuffer) Recent() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	lines := []string{}
+	for n := 1; n <= len(t.ring) && len(lines) < traceListLength; n++ {
+		trace := t.ring[(t.next-n+traceLimit)%traceLimit]
+		if trace == nil {
+			break
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s, %s", trace.ID, trace.Summary, trace.Steps[len(trace.Steps)-1].Detail))
+	}
+	if len(lines) == 0 {
+		return []string{"No messages have been traced yet."}
+	}
+	return lines
+}
+
+func init() {
+	registerChatCommand(&chatCommand{
+		Name:       "trace",
+		Permission: permissionAdmin,
+		IRC: func(i *ircListener, e *irc.Event, args []string) {
+			// Traces can be long, so they are sent privately
+			for _, line := range i.bridge.traceReply(args) {
+				i.Notice(e.Nick, line)
+			}
+		},
+		Discord: func(d *discordBot, m *discordgo.Message, args []string) {
+			d.reply(m, strings.Join(d.bridge.traceReply(args), "\n"))
+		},
+	})
+}
+
+// traceReply is the reply to !trace [id].
+func (b *Bridge) traceReply(args []string) []string {
+	if len(args) == 0 {
+		return b.traces.Recent()
+	}
+	return b.traces.Lines(args[0])
+}
@@ -0,0 +1,74 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTraceBuffer(t *testing.T) {
+	var traces traceBuffer
+	assert.Equal(t, []string{"No messages have been traced yet."}, traces.Recent())
+
+	id := traces.Start("irc", "irc:alice", "alice in #test", "msgid1")
+	assert.Equal(t, "i1", id)
+	traces.Step(id, traceFilter, "dropped: %s opted out", "alice")
+	traces.Step("", traceSend, "probes aren't traced")
+
+	lines := traces.Lines("msgid1")
+	assert.Len(t, lines, 3)
+	assert.True(t, strings.HasPrefix(lines[0], "Trace i1: alice in #test, at "))
+	assert.Equal(t, "+0s received from irc", lines[1])
+	assert.True(t, strings.HasSuffix(lines[2], " dropped: alice opted out"))
+	assert.Equal(t, lines, traces.Lines("I1"))
+	assert.Equal(t, []string{"i1: alice in #test, dropped: alice opted out"}, traces.Recent())
+
+	// The oldest traces are forgotten
+	for n := 0; n < traceLimit; n++ {
+		traces.Start("discord", "discord:100", "bob in #general")
+	}
+	assert.Equal(t, "No trace for msgid1. Only the last 1000 messages are traced.", traces.Lines("msgid1")[0])
+	assert.Len(t, traces.Recent(), traceListLength)
+	assert.True(t, strings.HasPrefix(traces.Recent()[0], "d1000: "))
+}
+
+func TestTraceCommand(t *testing.T) {
+	tb := newTestBridge(t, nil)
+	defer tb.Close()
+
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :hello discord")
+	waitFor(t, "relayed to discord", func() bool {
+		_, ok := tb.discord.Find("hello discord" + relayMarker)
+		return ok
+	})
+	waitFor(t, "sent traced", func() bool {
+		lines := tb.Bridge.traces.Lines("i1")
+		return strings.HasPrefix(lines[len(lines)-1], "+") && strings.Contains(lines[len(lines)-1], " sent to Discord as message ")
+	})
+
+	bob := tb.discordMember("100", "bob", "")
+	tb.discordSay(bob, "hello irc")
+	waitFor(t, "relayed to irc", func() bool {
+		lines := tb.Bridge.traces.Lines("d1")
+		return strings.Contains(lines[len(lines)-1], ` sent to `+testChannel+`: "hello irc`)
+	})
+
+	// Only ops can trace messages
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :!trace i1")
+	waitFor(t, "permission denied", func() bool {
+		return tb.ircd.HasReceived("listener", "NOTICE alice :You don't have permission to use !trace here.")
+	})
+
+	tb.ircd.Inject("ChanServ!cs@services", testChannel, "MODE "+testChannel+" +o alice")
+	waitFor(t, "alice opped", func() bool {
+		return tb.ircListener.isChannelOp(testChannel, "alice")
+	})
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :!trace i1")
+	waitFor(t, "trace", func() bool {
+		return tb.ircd.HasReceived("listener", `NOTICE alice :+0s received from irc`)
+	})
+	for _, line := range tb.ircd.Received("listener") {
+		assert.False(t, strings.HasPrefix(line, "NOTICE "+testChannel+" :Trace"), "traces are sent privately")
+	}
+}
@@ -0,0 +1,38 @@
+package bridge
+
+import (
+	"expvar"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// unmappedMetrics counts messages from channels that aren't bridged,
+// keyed by "discord:<channel ID>" or "irc:<channel>"
+var unmappedMetrics = expvar.NewMap("unmapped_messages")
+
+// unmappedChannels remembers which unbridged channels messages have come from,
+// so that the first message from each is logged more loudly.
+type unmappedChannels struct {
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+// unmappedMessage counts a message from a channel that isn't bridged.
+// side is "discord" or "irc".
+func (b *Bridge) unmappedMessage(side, channel string) {
+	key := side + ":" + channel
+	unmappedMetrics.Add(key, 1)
+
+	b.unmapped.mu.Lock()
+	first := !b.unmapped.seen[key]
+	b.unmapped.seen[key] = true
+	b.unmapped.mu.Unlock()
+
+	entry := log.WithFields(log.Fields{"side": side, "channel": channel})
+	if first {
+		entry.Infoln("Ignoring messages from a channel that isn't bridged. Is a mapping missing?")
+	} else {
+		entry.Debugln("Ignoring a message from a channel that isn't bridged.")
+	}
+}
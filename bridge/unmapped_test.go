@@ -0,0 +1,53 @@
+package bridge
+
+import (
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnmappedMessages(t *testing.T) {
+	tb := newTestBridge(t, func(conf *Config) {
+		conf.AuditIRCChannel = "#audit"
+	})
+	defer tb.Close()
+
+	count := func(key string) int64 {
+		if v, ok := unmappedMetrics.Get(key).(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+	before := count("discord:2999")
+
+	bob := tb.discordMember("100", "bob", "")
+	tb.Bridge.discord.publishMessage(tb.Bridge.discord.Session, &discordgo.Message{
+		ID:        tb.discord.id(),
+		ChannelID: "2999",
+		GuildID:   testGuildID,
+		Author:    bob,
+		Content:   "hello nobody",
+		Timestamp: time.Now(),
+	}, false)
+	assert.Equal(t, before+1, count("discord:2999"))
+
+	waitFor(t, "listener in audit channel", func() bool {
+		return tb.ircd.InChannel("#audit", "listener")
+	})
+	tb.ircd.Inject("alice!al@example.com", "#audit", "PRIVMSG #audit :hello staff")
+	waitFor(t, "unmapped irc message counted", func() bool {
+		return count("irc:#audit") > 0
+	})
+
+	tb.ircd.Inject("alice!al@example.com", testChannel, "PRIVMSG "+testChannel+" :hello everyone")
+	waitFor(t, "mapped message relayed", func() bool {
+		_, ok := tb.discord.Find("hello everyone" + relayMarker)
+		return ok
+	})
+	for _, msg := range tb.discord.Sent() {
+		assert.NotContains(t, msg.Content, "staff")
+	}
+}
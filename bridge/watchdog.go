@@ -0,0 +1,155 @@
+package bridge
+
+import (
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// A watchdog notices when a consumer has been stuck on a piece of work for too long.
+//
+// The consumer calls Busy when it starts working and Idle when it is done,
+// and onStall is called (once per stall) if it stays busy for longer than timeout.
+//
+// It is safe for concurrent use.
+type watchdog struct {
+	timeout time.Duration
+	onStall func(doing string, generation int)
+
+	mu         sync.Mutex
+	doing      string // what the consumer is doing, empty when idle
+	since      time.Time
+	stalled    bool
+	generation int
+}
+
+func newWatchdog(timeout time.Duration, onStall func(doing string, generation int)) *watchdog {
+	return &watchdog{
+		timeout: timeout,
+		onStall: onStall,
+	}
+}
+
+// Busy records that the consumer has started on something.
+func (w *watchdog) Busy(doing string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.doing = doing
+	w.since = time.Now()
+}
+
+// Idle records that the consumer has finished what it was doing.
+//
+// It returns false if the consumer has been replaced since it started, in which case it should stop.
+func (w *watchdog) Idle(generation int) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if generation != w.generation {
+		return false
+	}
+
+	w.doing = ""
+	w.stalled = false
+	return true
+}
+
+// Generation returns the generation of the current consumer.
+func (w *watchdog) Generation() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.generation
+}
+
+// check calls onStall if the consumer is stuck, and replaces it with a new generation.
+func (w *watchdog) check() {
+	w.mu.Lock()
+	if w.doing == "" || w.stalled || time.Since(w.since) < w.timeout {
+		w.mu.Unlock()
+		return
+	}
+
+	w.stalled = true
+	w.generation++
+	doing, generation := w.doing, w.generation
+	w.doing = ""
+	w.mu.Unlock()
+
+	w.onStall(doing, generation)
+}
+
+// run checks on the consumer until stop is closed.
+func (w *watchdog) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(w.timeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.check()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// What the bridge loop is doing, for the watchdog
+const (
+	loopToDiscord    = "relaying a message to discord"
+	loopToIRC        = "relaying a message to irc"
+	loopUpdatePuppet = "updating an irc puppet"
+	loopRemovePuppet = "removing an irc puppet"
+)
+
+// watchdogMetrics counts loop stalls by what the loop was doing, the stuck loop goroutines
+// left behind, under "abandoned", and what was restarted after a stall, under "restarts".
+var watchdogMetrics = expvar.NewMap("watchdog")
+
+// onLoopStall is called when the bridge loop has been stuck for too long.
+//
+// The stuck goroutine can't be interrupted, so it is abandoned, and a new loop
+// is started so that messages keep flowing. The old loop exits if it ever becomes unstuck.
+// What it was waiting on is restarted too, so that the new loop doesn't get stuck on it as well.
+func (b *Bridge) onLoopStall(doing string, generation int) {
+	log.WithFields(log.Fields{
+		"doing":   doing,
+		"timeout": b.Config.WatchdogTimeout,
+		"alert":   true,
+	}).Errorln("Bridge loop has stalled, restarting it.")
+	watchdogMetrics.Add(doing, 1)
+	watchdogMetrics.Add("abandoned", 1)
+
+	go b.restartStalled(doing)
+	go b.loop(generation)
+}
+
+// restartStalled restarts what the loop was waiting on when it stalled.
+func (b *Bridge) restartStalled(doing string) {
+	switch doing {
+	case loopToDiscord:
+		// Messages for Discord wait on its webhooks, which standbys don't have
+		if !b.isLeader() {
+			return
+		}
+		if err := b.discord.openTransmitter(); err != nil {
+			b.handleError(err, log.Fields{"doing": doing}, "could not reopen webhooks after the loop stalled")
+			return
+		}
+
+	case loopToIRC, loopUpdatePuppet, loopRemovePuppet:
+		// Messages for IRC wait on the listener, as puppets queue what they can't send.
+		// It reconnects when told its connection broke, once its current read ends.
+		select {
+		case b.ircListener.ErrorChan() <- errors.New("the bridge loop stalled " + doing):
+		default:
+		}
+
+	default:
+		return
+	}
+	watchdogMetrics.Add("restarts", 1)
+	log.WithField("doing", doing).Warnln("Restarted what the stalled bridge loop was waiting on.")
+}
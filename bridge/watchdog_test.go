@@ -0,0 +1,66 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchdog(t *testing.T) {
+	stalls := make(chan string, 10)
+	w := newWatchdog(time.Millisecond*20, func(doing string, generation int) {
+		assert.Equal(t, 1, generation)
+		stalls <- doing
+	})
+
+	// Quick work is fine
+	w.Busy("quick")
+	w.check()
+	assert.True(t, w.Idle(0))
+	time.Sleep(time.Millisecond * 30)
+	w.check()
+	assert.Len(t, stalls, 0)
+
+	// Stuck work is reported once, and the stuck consumer is told to stop
+	w.Busy("stuck")
+	time.Sleep(time.Millisecond * 30)
+	w.check()
+	w.check()
+	assert.Equal(t, "stuck", <-stalls)
+	assert.Len(t, stalls, 0)
+	assert.False(t, w.Idle(0))
+
+	// The replacement carries on as normal
+	assert.Equal(t, 1, w.Generation())
+	assert.True(t, w.Idle(1))
+}
+
+func TestLoopStallRestarts(t *testing.T) {
+	tb := newTestBridge(t, nil)
+	defer tb.Close()
+
+	joins := func() int {
+		n := 0
+		for _, line := range tb.ircd.Received("listener") {
+			if strings.HasPrefix(line, "JOIN "+testChannel) {
+				n++
+			}
+		}
+		return n
+	}
+
+	// A stall relaying to IRC reconnects the listener
+	assert.Equal(t, 1, joins())
+	tb.Bridge.restartStalled(loopToIRC)
+	tb.ircd.SendTo("listener", ":irc.example.com NOTICE listener :wakes up its read")
+	waitFor(t, "listener to reconnect", func() bool {
+		return joins() == 2
+	})
+
+	// and one relaying to Discord takes over the webhooks again
+	transmitter := tb.Bridge.discord.getTransmitter()
+	tb.Bridge.restartStalled(loopToDiscord)
+	assert.True(t, tb.Bridge.discord.getTransmitter() != transmitter)
+}
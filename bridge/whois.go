@@ -0,0 +1,64 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	irc "github.com/qaisjp/go-ircevent"
+)
+
+// whoisIRC describes who an IRC nick is on Discord.
+func (b *Bridge) whoisIRC(nick string) string {
+	if con := b.ircManager.connectionByNick(nick); con != nil {
+		return con.Nick() + " " + con.whoisLine() + "."
+	}
+
+	account := ""
+	if user, ok := b.ircListener.users.Get(nick); ok {
+		account = user.Account
+	}
+	if link := b.linkByIRC(nick, account); link != nil {
+		return fmt.Sprintf("%s is linked to Discord user %s.", nick, b.discord.memberName(link.DiscordID))
+	}
+
+	return fmt.Sprintf("%s is not linked to a Discord user.", nick)
+}
+
+// whoisDiscord describes who a Discord user is on IRC.
+func (b *Bridge) whoisDiscord(discordID string) string {
+	name := b.discord.memberName(discordID)
+
+	if con, ok := b.ircManager.connection(discordID); ok {
+		return fmt.Sprintf("%s is %s on IRC.", name, con.Nick())
+	}
+	if link := b.linkByDiscord(discordID); link != nil {
+		return fmt.Sprintf("%s is linked to IRC user %s.", name, link.IRCNick)
+	}
+
+	return fmt.Sprintf("%s is not on IRC.", name)
+}
+
+func init() {
+	registerChatCommand(&chatCommand{
+		Name: "whois",
+		IRC: func(i *ircListener, e *irc.Event, args []string) {
+			if len(args) == 0 {
+				i.Noticef(e.Nick, "Usage: %swhois <nick>", i.bridge.Config.CommandPrefix)
+				return
+			}
+			i.Notice(e.Arguments[0], i.bridge.whoisIRC(strings.TrimRight(args[0], ":,")))
+		},
+		Discord: func(d *discordBot, m *discordgo.Message, args []string) {
+			if len(args) == 0 {
+				d.reply(m, "Usage: "+d.bridge.Config.CommandPrefix+"whois <@user or IRC nick>")
+				return
+			}
+			if match := discordMention.FindStringSubmatch(args[0]); match != nil {
+				d.reply(m, d.bridge.whoisDiscord(match[1]))
+				return
+			}
+			d.reply(m, d.bridge.whoisIRC(args[0]))
+		},
+	})
+}
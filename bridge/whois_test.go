@@ -0,0 +1,28 @@
+package bridge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWhois(t *testing.T) {
+	tb := newTestBridge(t, nil)
+	defer tb.Close()
+
+	bob := tb.discordMember("100", "bob", "")
+	tb.discordMember("200", "carol", "")
+	tb.discordMember("300", "dave", "")
+	nick := tb.puppet(t, bob, "bob")
+
+	assert.NoError(t, tb.Bridge.saveLink(&identityLink{DiscordID: "200", IRCNick: "caz", Linked: time.Now()}))
+
+	assert.Equal(t, nick+" is Discord user bob#0001 (ID 100).", tb.Bridge.whoisIRC(nick))
+	assert.Equal(t, "caz is linked to Discord user carol.", tb.Bridge.whoisIRC("caz"))
+	assert.Equal(t, "alice is not linked to a Discord user.", tb.Bridge.whoisIRC("alice"))
+
+	assert.Equal(t, "bob is "+nick+" on IRC.", tb.Bridge.whoisDiscord("100"))
+	assert.Equal(t, "carol is linked to IRC user caz.", tb.Bridge.whoisDiscord("200"))
+	assert.Equal(t, "dave is not on IRC.", tb.Bridge.whoisDiscord("300"))
+}
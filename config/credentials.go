@@ -0,0 +1,129 @@
+package config
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+// CredentialsPassphraseEnv is the environment variable the credentials passphrase can be given in,
+// instead of a key file.
+const CredentialsPassphraseEnv = "DISCORD_IRC_PASSPHRASE"
+
+// credentialsMagic starts every credentials file, so that other files aren't mistaken for one
+const credentialsMagic = "go-discord-irc credentials 1\n"
+
+const (
+	saltSize  = 16
+	nonceSize = 24
+)
+
+// Keys are derived with scrypt, using the parameters it recommends for interactive logins
+const (
+	scryptN = 32768
+	scryptR = 8
+	scryptP = 1
+)
+
+// CredentialsPassphrase returns the passphrase credentials are encrypted with: the contents of the key file,
+// without trailing newlines, or if there isn't one, the CredentialsPassphraseEnv environment variable.
+func CredentialsPassphrase(keyFile string) ([]byte, error) {
+	if keyFile != "" {
+		key, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read credentials key file")
+		}
+		key = bytes.TrimRight(key, "\r\n")
+		if len(key) == 0 {
+			return nil, errors.New("credentials key file is empty")
+		}
+		return key, nil
+	}
+
+	if passphrase := os.Getenv(CredentialsPassphraseEnv); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+	return nil, errors.Errorf("credentials need credentials_key_file or %s to be set", CredentialsPassphraseEnv)
+}
+
+// SealCredentials encrypts settings, keyed like the config file, with NaCl secretbox
+// and a key derived from the passphrase.
+func SealCredentials(secrets map[string]interface{}, passphrase []byte) ([]byte, error) {
+	plain, err := json.Marshal(secrets)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not encode credentials")
+	}
+
+	var salt [saltSize]byte
+	var nonce [nonceSize]byte
+	if _, err := io.ReadFull(rand.Reader, salt[:]); err != nil {
+		return nil, errors.Wrap(err, "could not generate salt")
+	}
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, errors.Wrap(err, "could not generate nonce")
+	}
+
+	key, err := credentialsKey(passphrase, salt[:])
+	if err != nil {
+		return nil, err
+	}
+
+	out := append([]byte(credentialsMagic), salt[:]...)
+	out = append(out, nonce[:]...)
+	return secretbox.Seal(out, plain, &nonce, key), nil
+}
+
+// OpenCredentials decrypts credentials sealed by SealCredentials.
+func OpenCredentials(blob, passphrase []byte) (map[string]interface{}, error) {
+	if !bytes.HasPrefix(blob, []byte(credentialsMagic)) {
+		return nil, errors.New("not a credentials file")
+	}
+	blob = blob[len(credentialsMagic):]
+	if len(blob) < saltSize+nonceSize+secretbox.Overhead {
+		return nil, errors.New("credentials file is truncated")
+	}
+
+	salt := blob[:saltSize]
+	var nonce [nonceSize]byte
+	copy(nonce[:], blob[saltSize:saltSize+nonceSize])
+
+	key, err := credentialsKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	plain, ok := secretbox.Open(nil, blob[saltSize+nonceSize:], &nonce, key)
+	if !ok {
+		return nil, errors.New("could not decrypt credentials, is the passphrase right?")
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(plain, &decoded); err != nil {
+		return nil, errors.Wrap(err, "could not decode credentials")
+	}
+
+	// Config keys aren't case sensitive
+	secrets := make(map[string]interface{})
+	for key, value := range decoded {
+		secrets[strings.ToLower(key)] = value
+	}
+	return secrets, nil
+}
+
+func credentialsKey(passphrase, salt []byte) (*[32]byte, error) {
+	derived, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not derive credentials key")
+	}
+	var key [32]byte
+	copy(key[:], derived)
+	return &key, nil
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCredentials(t *testing.T) {
+	secrets := map[string]interface{}{
+		"discord_token":     "abc.def.ghi",
+		"NickServ_Identify": "password123",
+	}
+	blob, err := SealCredentials(secrets, []byte("correct horse"))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(blob), "abc.def.ghi")
+
+	opened, err := OpenCredentials(blob, []byte("correct horse"))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"discord_token":     "abc.def.ghi",
+		"nickserv_identify": "password123",
+	}, opened)
+
+	_, err = OpenCredentials(blob, []byte("battery staple"))
+	assert.Error(t, err)
+	_, err = OpenCredentials(blob[:len(credentialsMagic)+10], []byte("correct horse"))
+	assert.Error(t, err)
+	_, err = OpenCredentials([]byte("discord_token: abc.def.ghi"), []byte("correct horse"))
+	assert.Error(t, err)
+}
+
+func TestCredentialsPassphrase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	keyFile := filepath.Join(dir, "key")
+	assert.NoError(t, ioutil.WriteFile(keyFile, []byte("correct horse\n"), 0600))
+	passphrase, err := CredentialsPassphrase(keyFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "correct horse", string(passphrase))
+
+	defer os.Setenv(CredentialsPassphraseEnv, os.Getenv(CredentialsPassphraseEnv))
+	os.Setenv(CredentialsPassphraseEnv, "battery staple")
+	passphrase, err = CredentialsPassphrase("")
+	assert.NoError(t, err)
+	assert.Equal(t, "battery staple", string(passphrase))
+
+	os.Unsetenv(CredentialsPassphraseEnv)
+	_, err = CredentialsPassphrase("")
+	assert.Error(t, err)
+}
@@ -0,0 +1,90 @@
+// Package config upgrades configuration files written for older versions of the bridge.
+//
+// Config files say which version of the format they use with a top-level version field.
+// Files without one are version 0, from before the format was versioned.
+package config
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// Version is the current version of the config format.
+const Version = 1
+
+// A migration upgrades settings from the version before To.
+type migration struct {
+	To int
+
+	// Renames maps old keys to what they are called now
+	Renames map[string]string
+}
+
+// migrations are in order of version. Version 1 only added the version field.
+var migrations = []migration{
+	{To: 1},
+}
+
+// version returns the version of the settings, which is 0 if they don't have one.
+func version(settings map[string]interface{}) (int, error) {
+	switch v := settings["version"].(type) {
+	case nil:
+		return 0, nil
+	case int:
+		return v, nil
+	case int64:
+		return int(v), nil
+	case float64:
+		return int(v), nil
+	case string:
+		n, err := strconv.Atoi(v)
+		return n, errors.Wrapf(err, "invalid config version %q", v)
+	default:
+		return 0, errors.Errorf("invalid config version %v", v)
+	}
+}
+
+// Migrate upgrades settings, keyed like the config file, to the current version in place.
+// It returns a warning for each change, so that people know to save the upgraded settings.
+//
+// Settings from a newer version are an error, rather than being misread.
+func Migrate(settings map[string]interface{}) (warnings []string, err error) {
+	from, err := version(settings)
+	if err != nil {
+		return nil, err
+	}
+	if from > Version {
+		return nil, errors.Errorf("config is version %d, but this bridge only understands up to version %d", from, Version)
+	} else if from < 0 {
+		return nil, errors.Errorf("invalid config version %d", from)
+	}
+
+	for _, m := range migrations {
+		if m.To <= from {
+			continue
+		}
+
+		for old, renamed := range m.Renames {
+			value, ok := settings[old]
+			if !ok {
+				continue
+			}
+			delete(settings, old)
+
+			if _, ok := settings[renamed]; ok {
+				warnings = append(warnings, fmt.Sprintf("%s is ignored, it has been replaced by %s", old, renamed))
+				continue
+			}
+			settings[renamed] = value
+			warnings = append(warnings, fmt.Sprintf("%s has been renamed to %s", old, renamed))
+		}
+	}
+
+	if from < Version {
+		warnings = append(warnings, fmt.Sprintf("settings were upgraded from version %d to %d in memory", from, Version))
+	}
+	settings["version"] = Version
+	return warnings, nil
+}
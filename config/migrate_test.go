@@ -0,0 +1,54 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrate(t *testing.T) {
+	settings := map[string]interface{}{"irc_password": "secret"}
+	warnings, err := Migrate(settings)
+	assert.NoError(t, err)
+	assert.Len(t, warnings, 1)
+	assert.Equal(t, map[string]interface{}{
+		"version":      Version,
+		"irc_password": "secret",
+	}, settings)
+
+	// Current configs are left alone
+	warnings, err = Migrate(settings)
+	assert.NoError(t, err)
+	assert.Empty(t, warnings)
+
+	_, err = Migrate(map[string]interface{}{"version": Version + 1})
+	assert.Error(t, err)
+	_, err = Migrate(map[string]interface{}{"version": "one"})
+	assert.Error(t, err)
+}
+
+func TestMigrateRenames(t *testing.T) {
+	defer func(m []migration) { migrations = m }(migrations)
+	migrations = append(migrations, migration{
+		To: Version + 1,
+		Renames: map[string]string{
+			"old_name":   "new_name",
+			"old_server": "new_server",
+		},
+	})
+
+	settings := map[string]interface{}{
+		"version":    Version,
+		"old_name":   "kept",
+		"old_server": "old",
+		"new_server": "new",
+	}
+	warnings, err := Migrate(settings)
+	assert.NoError(t, err)
+	assert.Len(t, warnings, 2)
+	assert.Equal(t, map[string]interface{}{
+		"version":    Version,
+		"new_name":   "kept",
+		"new_server": "new",
+	}, settings)
+}
@@ -42,6 +42,14 @@ var Keys = map[rune]string{
 	CharUnderline: "underline",
 }
 
+// codes are all the formatting characters, for HasCodes
+var codes = string([]rune{CharBold, CharItalics, CharUnderline, CharStrikethrough, CharMonospace, CharColor, CharHex, CharReverseColor, CharReset})
+
+// HasCodes returns true if text has any formatting codes in it.
+func HasCodes(text string) bool {
+	return strings.ContainsAny(text, codes)
+}
+
 func StripCodes(text string) string {
 	return replacer.Replace(colorRegex.ReplaceAllString(text, ""))
 }
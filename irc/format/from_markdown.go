@@ -0,0 +1,179 @@
+package ircf
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// markdownCodes are the IRC codes for each Discord markdown delimiter
+var markdownCodes = map[string]rune{
+	"**": CharBold,
+	"__": CharUnderline,
+	"~~": CharStrikethrough,
+	"*":  CharItalics,
+	"_":  CharItalics,
+}
+
+// markdownEscapable are the characters Discord shows as typed after a backslash
+const markdownEscapable = "\\*_~`|>#-"
+
+// markdownToken is a run of text, or a delimiter that may start or end formatting.
+type markdownToken struct {
+	text     string
+	delim    bool
+	canOpen  bool
+	canClose bool
+	paired   bool
+}
+
+// MarkdownToIRC converts Discord's bold, italics, underline and strikethrough markdown to IRC
+// formatting codes. Delimiters are paired like Discord pairs them, so a lone asterisk or a
+// snake_case name is left as typed. Code, links and spoilers are left as typed too, and the
+// backslashes of escaped characters are dropped.
+func MarkdownToIRC(text string) string {
+	// Most messages have no markdown at all
+	if !strings.ContainsAny(text, "*_~\\") {
+		return text
+	}
+
+	tokens := tokenizeMarkdown(text)
+	pairMarkdown(tokens)
+
+	var b strings.Builder
+	b.Grow(len(text))
+	for _, token := range tokens {
+		if token.paired {
+			b.WriteRune(markdownCodes[token.text])
+		} else {
+			b.WriteString(token.text)
+		}
+	}
+	return b.String()
+}
+
+func tokenizeMarkdown(text string) []markdownToken {
+	var tokens []markdownToken
+	plain := 0 // where the current run of text started
+	flush := func(end int) {
+		if end > plain {
+			tokens = append(tokens, markdownToken{text: text[plain:end]})
+		}
+	}
+
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '\\' && i+1 < len(text) && strings.IndexByte(markdownEscapable, text[i+1]) != -1:
+			flush(i)
+			tokens = append(tokens, markdownToken{text: text[i+1 : i+2]})
+			i += 2
+			plain = i
+
+		case c == '`':
+			// Code is left as typed, unless it isn't closed
+			n := runLength(text, i)
+			end := strings.Index(text[i+n:], text[i:i+n])
+			if end == -1 {
+				i += n
+			} else {
+				i += n + end + n
+			}
+
+		case (c == 'h') && (strings.HasPrefix(text[i:], "http://") || strings.HasPrefix(text[i:], "https://")):
+			// Links often have underscores in them
+			end := strings.IndexFunc(text[i:], unicode.IsSpace)
+			if end == -1 {
+				end = len(text) - i
+			}
+			i += end
+
+		case c == '*' || c == '_' || c == '~':
+			flush(i)
+			n := runLength(text, i)
+			before, _ := utf8.DecodeLastRuneInString(text[:i])
+			after, _ := utf8.DecodeRuneInString(text[i+n:])
+
+			// A delimiter starts formatting if text follows it, and ends it if text is before it.
+			// Underscores only count outside words.
+			canOpen := i+n < len(text) && !unicode.IsSpace(after)
+			canClose := i > 0 && !unicode.IsSpace(before)
+			if c == '_' {
+				canOpen = canOpen && !isWordRune(before)
+				canClose = canClose && !isWordRune(after)
+			}
+
+			single, double := string(c), string([]byte{c, c})
+			var delims []string
+			switch {
+			case c == '~' && n == 2:
+				delims = []string{double}
+			case c == '~':
+			case n == 1:
+				delims = []string{single}
+			case n == 2:
+				delims = []string{double}
+			case n == 3 && canClose && !canOpen:
+				// Close the inner one first, like "***bold italics***"
+				delims = []string{single, double}
+			case n == 3:
+				delims = []string{double, single}
+			}
+
+			if delims == nil {
+				tokens = append(tokens, markdownToken{text: text[i : i+n]})
+			}
+			for _, delim := range delims {
+				tokens = append(tokens, markdownToken{text: delim, delim: true, canOpen: canOpen, canClose: canClose})
+			}
+			i += n
+			plain = i
+
+		default:
+			i++
+		}
+	}
+	flush(len(text))
+	return tokens
+}
+
+// pairMarkdown marks the delimiters that start and end formatting. Each closing delimiter
+// ends the latest unclosed one of its kind; any opened after it are left as typed.
+func pairMarkdown(tokens []markdownToken) {
+	var open []int
+	for i := range tokens {
+		token := &tokens[i]
+		if !token.delim {
+			continue
+		}
+		if token.canClose {
+			for j := len(open) - 1; j >= 0; j-- {
+				if tokens[open[j]].text == token.text {
+					tokens[open[j]].paired = true
+					token.paired = true
+					open = open[:j]
+					break
+				}
+			}
+			if token.paired {
+				continue
+			}
+		}
+		if token.canOpen {
+			open = append(open, i)
+		}
+	}
+}
+
+// runLength returns how many times the byte at i repeats from i.
+func runLength(text string, i int) int {
+	n := 1
+	for i+n < len(text) && text[i+n] == text[i] {
+		n++
+	}
+	return n
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
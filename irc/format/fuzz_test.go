@@ -0,0 +1,65 @@
+package ircf
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func FuzzMarkdown(f *testing.F) {
+	seeds := []string{
+		"",
+		"hello world",
+		"\x02bold\x02 and \x1ditalics\x1d",
+		"\x0306,06spoiler\x03",
+		"\x034red\x03 \x0312,1blue on black",
+		"\x03",
+		"\x03,",
+		"\x0399,99",
+		"\x16reverse\x16\x0f",
+		msg,
+		"ünïcödé \x02ボールド\x02 🔴",
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, text string) {
+		markdown := BlocksToMarkdown(Parse(text))
+
+		if utf8.ValidString(text) && !utf8.ValidString(markdown) {
+			t.Errorf("valid input %q produced invalid UTF-8 %q", text, markdown)
+		}
+
+		stripped := StripColor(text)
+		if strings.ContainsRune(stripped, CharColor) {
+			t.Errorf("StripColor(%q) = %q still contains colour codes", text, stripped)
+		}
+	})
+}
+
+func FuzzMarkdownToIRC(f *testing.F) {
+	seeds := []string{
+		"",
+		"**bold** *italics* __underline__ ~~strikethrough~~",
+		"***", "_*_*", "\\*", "`**`", "https://x_y", "ünïcödé *ボールド* 🔴",
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, text string) {
+		irc := MarkdownToIRC(text)
+
+		if utf8.ValidString(text) && !utf8.ValidString(irc) {
+			t.Errorf("valid input %q produced invalid UTF-8 %q", text, irc)
+		}
+
+		// Formatting is only ever added in pairs
+		for _, code := range []rune{CharBold, CharItalics, CharUnderline, CharStrikethrough} {
+			if strings.Count(irc, string(code))%2 != strings.Count(text, string(code))%2 {
+				t.Errorf("MarkdownToIRC(%q) = %q has unpaired %q", text, irc, code)
+			}
+		}
+	})
+}
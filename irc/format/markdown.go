@@ -1,9 +1,11 @@
 package ircf
 
+import "strings"
+
 // From https://github.com/reactiflux/discord-irc/blob/87a3458bdde48290960405f2bf0cf53b7ff17b5e/lib/formatting.js#L25
 
 func BlocksToMarkdown(blocks []Block) string {
-	mdText := ""
+	var mdText strings.Builder
 
 	for i := 0; i < len(blocks)+1; i++ {
 		// Default to unstyled blocks when index out of range
@@ -26,39 +28,39 @@ func BlocksToMarkdown(blocks []Block) string {
 
 		// Add start markers when style turns from false to true
 		if !prevItalic && italic {
-			mdText += "*"
+			mdText.WriteString("*")
 		}
 		if !prevBlock.Bold && block.Bold {
-			mdText += "**"
+			mdText.WriteString("**")
 		}
 		if !prevBlock.Underline && block.Underline {
-			mdText += "__"
+			mdText.WriteString("__")
 		}
 
 		// NOTE: non-standard discord spoilers
 		if !prevSpoiler && spoiler {
-			mdText += "||"
+			mdText.WriteString("||")
 		}
 
 		// Add end markers when style turns from true to false
 		// (and apply in reverse order to maintain nesting)
 		if prevBlock.Underline && !block.Underline {
-			mdText += "__"
+			mdText.WriteString("__")
 		}
 		if prevBlock.Bold && !block.Bold {
-			mdText += "**"
+			mdText.WriteString("**")
 		}
 		if prevItalic && !italic {
-			mdText += "*"
+			mdText.WriteString("*")
 		}
 
 		// NOTE: non-standard discord spoilers
 		if prevSpoiler && !spoiler {
-			mdText += "||"
+			mdText.WriteString("||")
 		}
 
-		mdText += block.Text
+		mdText.WriteString(block.Text)
 	}
 
-	return mdText
+	return mdText.String()
 }
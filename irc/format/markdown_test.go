@@ -44,3 +44,40 @@ func TestMarkdownSpoilers(t *testing.T) {
 	msgMarkdown := "In Game of Thrones, everyone|| dies||!"
 	assert.Equal(t, msgMarkdown, BlocksToMarkdown(Parse(msgIRC)))
 }
+
+func TestMarkdownToIRC(t *testing.T) {
+	cases := []struct {
+		Message  string
+		Input    string
+		Expected string
+	}{
+		{"plain", "hello world", "hello world"},
+		{"bold", "**text**", "\x02text\x02"},
+		{"italics", "*text*", "\x1dtext\x1d"},
+		{"underscore italics", "_text_", "\x1dtext\x1d"},
+		{"underline", "__text__", "\x1ftext\x1f"},
+		{"strikethrough", "~~text~~", "\x1etext\x1e"},
+		{"bold italics", "***text***", "\x02\x1dtext\x1d\x02"},
+		{"nested", "**bold __underline__**", "\x02bold \x1funderline\x1f\x02"},
+		{"sentence", "the meeting is *moved* to __Thursday__, ~~not Wednesday~~", "the meeting is \x1dmoved\x1d to \x1fThursday\x1f, \x1enot Wednesday\x1e"},
+		{"unclosed", "**not bold", "**not bold"},
+		{"lone asterisk", "5 * 3 = 15", "5 * 3 = 15"},
+		{"spaces inside", "** not bold **", "** not bold **"},
+		{"snake case", "call snake_case_name", "call snake_case_name"},
+		{"single tilde", "~approximately~", "~approximately~"},
+		{"escaped", `\*not italics\*`, "*not italics*"},
+		{"backslash", `C:\Users\bob`, `C:\Users\bob`},
+		{"inline code", "run `rm *_old_*` first", "run `rm *_old_*` first"},
+		{"code block", "```\n**kept**\n```", "```\n**kept**\n```"},
+		{"unclosed code", "`**bold**", "`\x02bold\x02"},
+		{"link", "see https://example.com/a_b_c and _this_", "see https://example.com/a_b_c and \x1dthis\x1d"},
+		{"spoiler", "||**secret**||", "||\x02secret\x02||"},
+		{"unicode", "*ünïcödé* 🔴", "\x1dünïcödé\x1d 🔴"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Message, func(t *testing.T) {
+			assert.Equal(t, c.Expected, MarkdownToIRC(c.Input))
+		})
+	}
+}
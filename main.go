@@ -1,17 +1,23 @@
 package main
 
 import (
+	_ "expvar" // metrics, at /debug/vars
 	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"reflect"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/pkg/errors"
 	"github.com/qaisjp/go-discord-irc/bridge"
+	"github.com/qaisjp/go-discord-irc/config"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
@@ -22,6 +28,9 @@ func main() {
 	debugMode := flag.Bool("debug", false, "Debug mode? (false = use value from settings)")
 	notls := flag.Bool("no-tls", false, "Avoids using TLS att all when connecting to IRC server ")
 	insecure := flag.Bool("insecure", false, "Skip TLS certificate verification? (INSECURE MODE) (false = use value from settings)")
+	migrate := flag.Bool("migrate-config", false, "Upgrade the config file to the current format, and exit")
+	shadow := flag.Bool("shadow", false, "Log what would be relayed instead of relaying it, and send nothing")
+	encrypt := flag.String("encrypt-credentials", "", "Encrypt the settings in this file into credentials_file, and exit")
 
 	flag.Parse()
 
@@ -30,9 +39,20 @@ func main() {
 		return
 	}
 
+	// go-discord-irc --config config.yml init
+	if flag.Arg(0) == "init" {
+		if err := setup(*config, os.Stdin, os.Stdout); err != nil {
+			log.Fatalln(errors.Wrap(err, "could not set up the bridge"))
+		}
+		return
+	}
+
 	if *simple {
 		log.Println("Running in simple mode.")
 	}
+	if *shadow {
+		log.Println("Running in shadow mode, nothing will be sent.")
+	}
 
 	viper := viper.New()
 	ext := filepath.Ext(*config)
@@ -54,14 +74,42 @@ func main() {
 		log.Fatalln(errors.Wrap(err, "could not read config"))
 	}
 
+	settings, err := migrateConfig(viper)
+	if err != nil {
+		log.Fatalln(errors.Wrap(err, "could not upgrade config"))
+	}
+	if *migrate {
+		if err := saveConfig(settings, *config); err != nil {
+			log.Fatalln(errors.Wrap(err, "could not save upgraded config"))
+		}
+		log.Infof("Saved the upgraded config to %s, and the old one to %s.bak", *config, *config)
+		return
+	}
+
+	if *encrypt != "" {
+		if err := encryptCredentials(viper, *encrypt); err != nil {
+			log.Fatalln(errors.Wrap(err, "could not encrypt credentials"))
+		}
+		log.Infof("Encrypted %s into %s. Delete %s once you have checked the bridge starts.", *encrypt, viper.GetString("credentials_file"), *encrypt)
+		return
+	}
+
+	if err := loadCredentials(viper); err != nil {
+		log.Fatalln(errors.Wrap(err, "could not load credentials"))
+	}
+
 	discordBotToken := viper.GetString("discord_token")             // Discord Bot User Token
 	channelMappings := viper.GetStringMapString("channel_mappings") // Discord:IRC mappings in format '#discord1:#irc1,#discord2:#irc2,...'
 	ircServer := viper.GetString("irc_server")                      // Server address to use, example `irc.freenode.net:7000`.
-	ircPassword := viper.GetString("irc_pass")                      // Optional password for connecting to the IRC server
+	ircPassword := viper.GetString("irc_password")                  // Optional password for connecting to the IRC server
 	guildID := viper.GetString("guild_id")                          // Guild to use
-	webIRCPass := viper.GetString("webirc_pass")                    // Password for WEBIRC
+	webIRCPass := viper.GetString("webirc_password")                // Password for WEBIRC
 	identify := viper.GetString("nickserv_identify")                // NickServ IDENTIFY for Listener
 	//
+	secondaryBotToken := viper.GetString("discord_token_secondary") // Token of another bot to fail over to
+	viper.SetDefault("discord_failover_after", "5m")
+	discordFailoverAfter := viper.GetDuration("discord_failover_after") // How long Discord must be unreachable to fail over
+	//
 	if !*debugMode {
 		*debugMode = viper.GetBool("debug")
 	}
@@ -86,9 +134,162 @@ func main() {
 	//
 	viper.SetDefault("webhook_limit", 2)
 	webhookLimit := viper.GetInt("webhook_limit")
+	//
+	puppetMetadata := viper.GetString("puppet_metadata") // How to describe puppets in WHOIS: "metadata" or "swhois"
+	//
+	services := viper.GetString("services")                  // Services package used to register puppets: "atheme" or "anope"
+	servicesAccount := viper.GetString("services_account")   // Account puppet nicks are grouped under
+	servicesPassword := viper.GetString("services_password") // Password of the services account
+	servicesVhost := viper.GetString("services_vhost")       // Vhost requested for each puppet, e.g. "discord/{id}"
+	//
+	operName := viper.GetString("oper_name")                      // Oper name for the listener
+	operPassword := viper.GetString("oper_password")              // Oper password for the listener
+	operKeyFile := viper.GetString("oper_key_file")               // RSA private key for CHALLENGE, instead of the password
+	operSnomask := viper.GetString("oper_snomask")                // Server notice mask to set once opered
+	operDiscordChannel := viper.GetString("oper_discord_channel") // Discord channel ID to relay server notices to
+	operPuppetHost := viper.GetString("oper_puppet_host")         // Host to give puppets, e.g. "{id}.discord.example.com"
+	//
+	auditIRCChannel := viper.GetString("audit_irc_channel")   // IRC channel to relay Discord moderation activity to
+	statusIRCChannel := viper.GetString("status_irc_channel") // IRC channel to announce bridge problems in
+	stageIRCChannel := viper.GetString("stage_irc_channel")   // Bridged IRC channel to announce Discord stages in
+	//
+	joinAnnounceIRCChannel := viper.GetString("join_announce_irc_channel") // IRC channel to announce new Discord members in
+	viper.SetDefault("join_announce_template", "* {name} joined the Discord (member #{count})")
+	joinAnnounceTemplate := viper.GetString("join_announce_template") // How new Discord members are announced
+	//
+	renameNotices := viper.GetBool("rename_notices") // Tell IRC when Discord members change their name
+	botMarker := viper.GetString("bot_marker")       // Marker for Discord bots on IRC, e.g. "[bot]"
+	//
+	raidThreshold := viper.GetInt("raid_threshold") // How many new Discord accounts active at once is a raid
+	viper.SetDefault("raid_window", "1m")
+	raidWindow := viper.GetDuration("raid_window") // Window new accounts are counted in
+	viper.SetDefault("raid_cooldown", "15m")
+	raidCooldown := viper.GetDuration("raid_cooldown") // How long without new accounts before a raid is over
+	//
+	requireScreening := viper.GetBool("require_screening") // Only relay Discord members who have completed membership screening
+	//
+	reportDiscordChannel := viper.GetString("report_discord_channel") // Discord channel ID for !report to post reports to
+	reportThreads := viper.GetBool("report_threads")                  // Open a thread on each report
+	//
+	reactionActions := viper.GetStringMapString("reaction_actions") // Emoji Discord moderators can react with to act on IRC users
+	//
+	puppetNickTemplate := viper.GetString("puppet_nick_template") // Template for puppet nicks, like "{nick}[d]"
+	puppetNickMaxLength := viper.GetInt("puppet_nick_max_length") // Longest a puppet nick can be before falling back
+	//
+	latencyProbeChannel := viper.GetString("latency_probe_channel") // Bridged IRC channel to send latency probes through
+	viper.SetDefault("latency_probe_interval", "5m")
+	latencyProbeInterval := viper.GetDuration("latency_probe_interval") // How often to send latency probes
+	//
+	healthCheckInterval := viper.GetDuration("health_check_interval") // How often to check that every mapping would relay
+	//
+	overflowLimit := viper.GetInt("overflow_limit")          // Messages each puppet holds in memory when the IRC server can't keep up
+	overflowPath := viper.GetString("overflow_path")         // Directory to spill messages beyond overflow_limit to
+	overflowMaxBytes := viper.GetInt64("overflow_max_bytes") // Largest each puppet's spill file can grow
+	//
+	discordEvents := viper.GetStringSlice("discord_events") // Classes of Discord events to process, if not all of them
+	//
+	presenceFallback := viper.GetDuration("presence_fallback") // Count Discord users online this long after they were active, without the presence intent
+	//
+	otlpEndpoint := viper.GetString("otlp_endpoint")        // OpenTelemetry collector to export spans and metrics to
+	otlpHeaders := viper.GetStringMapString("otlp_headers") // Headers to send the collector, like an API key
+	//
+	httpAddr := viper.GetString("http_addr") // Address to serve metrics (at /debug/vars) and avatars (at /avatars/) on
+	//
+	relayIRCNotices := viper.GetBool("relay_irc_notices")          // Relay NOTICEs sent to IRC channels, as quotes
+	pasteURL := viper.GetString("paste_url")                       // Paste service for the rest of truncated messages
+	dccHost := viper.GetString("dcc_host")                         // IP address to offer Discord attachments over DCC from
+	imageDescriptions := viper.GetBool("image_descriptions")       // Relay the alt text of images
+	captionURL := viper.GetString("caption_url")                   // Service describing images without alt text
+	linkTitlesToDiscord := viper.GetBool("link_titles_to_discord") // Add the titles of pages linked to from IRC
+	linkTitlesToIRC := viper.GetBool("link_titles_to_irc")         // Add the titles of pages linked to from Discord
+	linkTitleDomains := viper.GetStringSlice("link_title_domains") // Domains page titles are fetched from
+	formatter := viper.GetString("formatter")                      // Formatter converting between IRC formatting and markdown
+	canaryFormatter := viper.GetString("canary_formatter")         // Formatter to compare with, logging differences
+	stripIRCFormatting := viper.GetBool("strip_irc_formatting")    // Drop formatting from messages relayed to IRC
+	failureFeedback := viper.GetBool("failure_feedback")           // Tell people when their message could not be relayed
+	optOutMarker := viper.GetBool("opt_out_marker")                // Relay a marker in place of messages from people who opted out
+	//
+	viper.SetDefault("avatar_url", "https://api.dicebear.com/9.x/initials/png?seed={nick}&backgroundColor={color}")
+	avatarURL := viper.GetString("avatar_url") // Avatar for IRC users without a Discord avatar
+	//
+	viper.SetDefault("command_prefix", "!")
+	commandPrefix := viper.GetString("command_prefix") // Prefix for bridge commands like !karma
+	//
+	commands := map[string]bridge.CommandOptions{} // Where and by whom each bridge command can be used
+	if err := viper.UnmarshalKey("commands", &commands); err != nil {
+		log.Fatalln(errors.Wrap(err, "could not read commands"))
+	}
+	//
+	karma := viper.GetBool("karma") // Count nick++ on IRC, and @user++ and reactions on Discord
+	viper.SetDefault("karma_emoji", "👍")
+	karmaEmoji := viper.GetString("karma_emoji") // Reaction that gives karma on Discord
+	//
+	allowIRCPins := viper.GetBool("allow_irc_pins") // Allow IRC channel operators to pin messages using !pin
+	//
+	allowIRCSeen := viper.GetBool("allow_irc_seen") // Allow IRC users to look up relayed messages using !seen
+	//
+	viper.SetDefault("trusted_inviters", []string{"ChanServ"})
+	trustedInviters := viper.GetStringSlice("trusted_inviters") // Nicks whose invites to bridged channels are accepted
+	//
+	registerIRCChannels := viper.GetBool("register_irc_channels") // Register bridged IRC channels with ChanServ when the listener creates them
+	registerIRCModes := viper.GetString("register_irc_modes")     // Modes to set on channels the listener registers
+	//
+	provenanceFooter := viper.GetBool("provenance_footer") // Add the IRC hostmask to relayed messages in an embed footer
+	//
+	ignoredDiscordIDs := viper.GetStringSlice("ignore_discord_ids") // Other relay bots on Discord
+	ignoredIRCNicks := viper.GetStringSlice("ignore_irc_nicks")     // Other relay bots on IRC
+	//
+	digestInterval := viper.GetDuration("digest_interval")            // How often to post an activity digest, e.g. 24h
+	digestDiscordChannel := viper.GetString("digest_discord_channel") // Discord channel ID to post digests to
+	digestIRCChannel := viper.GetString("digest_irc_channel")         // IRC channel to post digests to
+	//
+	viper.SetDefault("dedup_window", "30s")
+	dedupWindow := viper.GetDuration("dedup_window") // Don't relay content back that was just relayed the other way
+	//
+	editWindow := viper.GetDuration("edit_window") // Only relay Discord edits made within this long of the message
+	//
+	viper.SetDefault("watchdog_timeout", "30s")
+	watchdogTimeout := viper.GetDuration("watchdog_timeout") // How long the bridge can be stuck before restarting
+	//
+	recordEvents := viper.GetString("record_events") // File to record the messages the bridge receives to, for replaying
+	viper.SetDefault("bot_status", true)
+	botStatus := viper.GetBool("bot_status")                             // Show the bridge's health in the bot's Discord status
+	operatorPrefix := viper.GetString("operator_prefix")                 // Prefix of operator commands, for emergency repairs
+	operatorDiscordIDs := viper.GetStringSlice("operator_discord_ids")   // Discord users allowed to use operator commands
+	operatorIRCAccounts := viper.GetStringSlice("operator_irc_accounts") // IRC services accounts allowed to use operator commands
+	maxRelayAge := viper.GetDuration("max_relay_age")                    // Don't relay messages that arrive later than this verbatim
+	lateMessages := viper.GetString("late_messages")                     // "summarize" or "drop" messages older than max_relay_age
+	autoResponseDiscord := viper.GetString("auto_response_discord")      // Reply to Discord DMs the bot can't do anything with
+	autoResponseIRC := viper.GetString("auto_response_irc")              // Reply to IRC PMs that aren't commands
+	//
+	storePath := viper.GetString("store_path") // File used to persist bridge state (identity links)
+	retention := map[string]time.Duration{}    // How long to keep each dataset, and the "default"
+	if err := viper.UnmarshalKey("retention", &retention); err != nil {
+		log.Fatalln(errors.Wrap(err, "could not read retention"))
+	}
+	//
+	hostname, _ := os.Hostname()
+	viper.SetDefault("ha_lease_ttl", "15s")
+	viper.SetDefault("ha_standby", "warm")
+	viper.SetDefault("ha_instance", fmt.Sprintf("%s-%d", hostname, os.Getpid()))
+	haMode := viper.GetString("ha_mode")            // "file" to run several instances, with one relaying at a time
+	haLeaseFile := viper.GetString("ha_lease_file") // Lease file on storage shared by every instance
+	haLeaseTTL := viper.GetDuration("ha_lease_ttl") // How long the lease lasts without being renewed
+	haStandby := viper.GetString("ha_standby")      // "warm" to stay connected to Discord while standing by, or "cold"
+	haInstance := viper.GetString("ha_instance")    // Name of this instance in the lease
+	//
+	channelOptions := map[string]bridge.ChannelOptions{} // Extra per-mapping settings, keyed by IRC channel
+	if err := viper.UnmarshalKey("channel_options", &channelOptions); err != nil {
+		log.Fatalln(errors.Wrap(err, "could not read channel_options"))
+	}
+	//
+	systemMessages := map[string]bool{} // Toggles for relaying each kind of Discord system message
+	if err := viper.UnmarshalKey("system_messages", &systemMessages); err != nil {
+		log.Fatalln(errors.Wrap(err, "could not read system_messages"))
+	}
 
 	if webIRCPass == "" {
-		log.Warnln("webirc_pass is empty")
+		log.Warnln("webirc_password is empty")
 	}
 
 	// Validate mappings
@@ -98,27 +299,130 @@ func main() {
 
 	SetLogDebug(*debugMode)
 
-	dib, err := bridge.New(&bridge.Config{
-		DiscordBotToken:    discordBotToken,
-		GuildID:            guildID,
-		IRCListenerName:    ircUsername,
-		IRCServer:          ircServer,
-		IRCServerPass:      ircPassword,
-		NickServIdentify:   identify,
-		WebIRCPass:         webIRCPass,
-		Debug:              *debugMode,
-		NoTLS:              *notls,
-		InsecureSkipVerify: *insecure,
-		Suffix:             suffix,
-		Separator:          separator,
-		SimpleMode:         *simple,
-		ChannelMappings:    channelMappings,
-		WebhookPrefix:      webhookPrefix,
-		WebhookLimit:       webhookLimit,
-	})
+	conf := &bridge.Config{
+		DiscordBotToken:      discordBotToken,
+		SecondaryBotToken:    secondaryBotToken,
+		DiscordFailoverAfter: discordFailoverAfter,
+		GuildID:              guildID,
+		IRCListenerName:      ircUsername,
+		IRCServer:            ircServer,
+		IRCServerPass:        ircPassword,
+		NickServIdentify:     identify,
+		WebIRCPass:           webIRCPass,
+		Debug:                *debugMode,
+		NoTLS:                *notls,
+		InsecureSkipVerify:   *insecure,
+		Suffix:               suffix,
+		Separator:            separator,
+		SimpleMode:           *simple,
+		Shadow:               *shadow,
+		PuppetMetadata:       puppetMetadata,
+		Services:             services,
+		ServicesAccount:      servicesAccount,
+		ServicesPassword:     servicesPassword,
+		ServicesVhost:        servicesVhost,
+		OperName:             operName,
+		OperPassword:         operPassword,
+		OperKeyFile:          operKeyFile,
+		OperSnomask:          operSnomask,
+		OperDiscordChannel:   operDiscordChannel,
+		OperPuppetHost:       operPuppetHost,
+		ChannelMappings:      channelMappings,
+		ChannelOptions:       channelOptions,
+		WebhookPrefix:        webhookPrefix,
+		WebhookLimit:         webhookLimit,
+		AllowIRCPins:         allowIRCPins,
+		AllowIRCSeen:         allowIRCSeen,
+		TrustedInviters:      trustedInviters,
+		RegisterIRCChannels:  registerIRCChannels,
+		RegisterIRCModes:     registerIRCModes,
+		AuditIRCChannel:      auditIRCChannel,
+		StatusIRCChannel:     statusIRCChannel,
+		StageIRCChannel:      stageIRCChannel,
+		JoinAnnounceChannel:  joinAnnounceIRCChannel,
+		JoinAnnounceTemplate: joinAnnounceTemplate,
+		RenameNotices:        renameNotices,
+		BotMarker:            botMarker,
+		RaidThreshold:        raidThreshold,
+		RaidWindow:           raidWindow,
+		RaidCooldown:         raidCooldown,
+		RequireScreening:     requireScreening,
+		ReportDiscordChannel: reportDiscordChannel,
+		ReportThreads:        reportThreads,
+		ReactionActions:      reactionActions,
+		RelayIRCNotices:      relayIRCNotices,
+		PasteURL:             pasteURL,
+		DCCHost:              dccHost,
+		ImageDescriptions:    imageDescriptions,
+		CaptionURL:           captionURL,
+		LinkTitlesToDiscord:  linkTitlesToDiscord,
+		LinkTitlesToIRC:      linkTitlesToIRC,
+		LinkTitleDomains:     linkTitleDomains,
+		Formatter:            formatter,
+		CanaryFormatter:      canaryFormatter,
+		StripIRCFormatting:   stripIRCFormatting,
+		FailureFeedback:      failureFeedback,
+		OptOutMarker:         optOutMarker,
+		AvatarURL:            avatarURL,
+		PuppetNickTemplate:   puppetNickTemplate,
+		PuppetNickMaxLength:  puppetNickMaxLength,
+		LatencyProbeChannel:  latencyProbeChannel,
+		LatencyProbeInterval: latencyProbeInterval,
+		HealthCheckInterval:  healthCheckInterval,
+		OverflowLimit:        overflowLimit,
+		OverflowPath:         overflowPath,
+		OverflowMaxBytes:     overflowMaxBytes,
+		DiscordEvents:        discordEvents,
+		PresenceFallback:     presenceFallback,
+		OTLPEndpoint:         otlpEndpoint,
+		OTLPHeaders:          otlpHeaders,
+		CommandPrefix:        commandPrefix,
+		Commands:             commands,
+		Karma:                karma,
+		KarmaEmoji:           karmaEmoji,
+		SystemMessages:       systemMessages,
+		StorePath:            storePath,
+		Retention:            retention,
+		HAMode:               haMode,
+		HALeaseFile:          haLeaseFile,
+		HALeaseTTL:           haLeaseTTL,
+		HAStandby:            haStandby,
+		HAInstance:           haInstance,
+		ProvenanceFooter:     provenanceFooter,
+		IgnoredDiscordIDs:    ignoredDiscordIDs,
+		IgnoredIRCNicks:      ignoredIRCNicks,
+		DedupWindow:          dedupWindow,
+		EditWindow:           editWindow,
+		WatchdogTimeout:      watchdogTimeout,
+		DigestInterval:       digestInterval,
+		DigestDiscordChannel: digestDiscordChannel,
+		DigestIRCChannel:     digestIRCChannel,
+		RecordEvents:         recordEvents,
+		BotStatus:            botStatus,
+		OperatorPrefix:       operatorPrefix,
+		OperatorDiscordIDs:   operatorDiscordIDs,
+		OperatorIRCAccounts:  operatorIRCAccounts,
+		MaxRelayAge:          maxRelayAge,
+		LateMessages:         lateMessages,
+		AutoResponseDiscord:  autoResponseDiscord,
+		AutoResponseIRC:      autoResponseIRC,
+	}
+
+	// go-discord-irc --config config.yml replay events.jsonl
+	if flag.Arg(0) == "replay" {
+		if err := replay(conf, flag.Arg(1)); err != nil {
+			log.Fatalln(errors.Wrap(err, "could not replay events"))
+		}
+		return
+	}
+
+	dib, err := bridge.New(conf)
 
 	if err != nil {
-		log.WithField("error", err).Fatalln("Go-Discord-IRC failed to initialise.")
+		log.WithFields(log.Fields{
+			"error":    err,
+			"category": bridge.ErrorCategory(err),
+		}).Fatalln("Go-Discord-IRC failed to initialise.")
 		return
 	}
 
@@ -129,10 +433,19 @@ func main() {
 	// Open the bot
 	err = dib.Open()
 	if err != nil {
-		log.WithField("error", err).Fatalln("Go-Discord-IRC failed to start.")
+		log.WithFields(log.Fields{
+			"error":    err,
+			"category": bridge.ErrorCategory(err),
+		}).Fatalln("Go-Discord-IRC failed to start.")
 		return
 	}
 
+	if httpAddr != "" {
+		go func() {
+			log.WithField("error", http.ListenAndServe(httpAddr, nil)).Errorln("http server stopped")
+		}()
+	}
+
 	// Inform the user that things are happening!
 	log.Infoln("Go-Discord-IRC is now running. Press Ctrl-C to exit.")
 
@@ -140,6 +453,11 @@ func main() {
 	viper.WatchConfig()
 	viper.OnConfigChange(func(e fsnotify.Event) {
 		log.Println("Configuration file has changed!")
+		if _, err := migrateConfig(viper); err != nil {
+			log.WithField("error", err).Errorln("could not upgrade config")
+			return
+		}
+
 		if newUsername := viper.GetString("irc_listener_name"); ircUsername != newUsername {
 			log.Printf("Changed irc_listener_name from '%s' to '%s'", ircUsername, newUsername)
 			// Listener name has changed
@@ -163,20 +481,179 @@ func main() {
 			}
 
 			if err := dib.SetChannelMappings(chans); err != nil {
-				log.WithField("error", err).Errorln("could not set channel mappings")
+				log.WithFields(log.Fields{
+					"error":    err,
+					"category": bridge.ErrorCategory(err),
+				}).Errorln("could not set channel mappings, keeping the old ones")
 			} else {
 				channelMappings = chans
 			}
 		}
 	})
 
-	// Watch for a shutdown signal
-	<-sc
+	// Watch for a shutdown signal, another instance taking over, or an error we can't continue after
+	lost := false
+	var failed error
+	select {
+	case <-sc:
+	case <-dib.LeadershipLost():
+		lost = true
+	case failed = <-dib.Failed():
+	}
 
 	log.Infoln("Shutting down Go-Discord-IRC...")
 
 	// Cleanly close down the bridge.
 	dib.Close()
+
+	if failed != nil {
+		log.WithFields(log.Fields{
+			"error":    failed,
+			"category": bridge.ErrorCategory(failed),
+		}).Fatalln("Go-Discord-IRC can't continue.")
+	}
+
+	// Exit with an error so that a supervisor restarts us as a standby
+	if lost {
+		os.Exit(1)
+	}
+}
+
+// migrateConfig upgrades the config to the current format in memory, warning about
+// anything that changed, and returns the upgraded settings.
+func migrateConfig(v *viper.Viper) (map[string]interface{}, error) {
+	settings := v.AllSettings()
+	warnings, err := config.Migrate(settings)
+	if err != nil {
+		return nil, err
+	}
+	for _, warning := range warnings {
+		log.Warnln(warning)
+	}
+	if len(warnings) > 0 {
+		log.Warnln("run with --migrate-config to save the upgraded config")
+	}
+	return settings, v.MergeConfigMap(settings)
+}
+
+// loadCredentials decrypts credentials_file, if it is set, and uses the settings in it
+// over the config file's.
+func loadCredentials(v *viper.Viper) error {
+	path := v.GetString("credentials_file")
+	if path == "" {
+		return nil
+	}
+
+	passphrase, err := config.CredentialsPassphrase(v.GetString("credentials_key_file"))
+	if err != nil {
+		return err
+	}
+	blob, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "could not read credentials file")
+	}
+	secrets, err := config.OpenCredentials(blob, passphrase)
+	if err != nil {
+		return err
+	}
+
+	// Credentials sealed by an older bridge may use old keys too
+	warnings, err := config.Migrate(secrets)
+	if err != nil {
+		return errors.Wrap(err, "could not upgrade credentials")
+	}
+	for _, warning := range warnings {
+		log.WithField("file", path).Warnln(warning)
+	}
+	if len(warnings) > 0 {
+		log.Warnln("run with --encrypt-credentials to save the upgraded credentials")
+	}
+	delete(secrets, "version")
+
+	for key, value := range secrets {
+		if v.InConfig(key) {
+			log.WithField("key", key).Warnln("setting is in both the config and credentials files, using the credentials file")
+		}
+		v.Set(key, value)
+	}
+	log.WithField("count", len(secrets)).Infoln("Loaded encrypted credentials.")
+	return nil
+}
+
+// encryptCredentials encrypts the settings in a plain config file into credentials_file.
+func encryptCredentials(v *viper.Viper, plainPath string) error {
+	path := v.GetString("credentials_file")
+	if path == "" {
+		return errors.New("credentials_file is not set")
+	}
+
+	passphrase, err := config.CredentialsPassphrase(v.GetString("credentials_key_file"))
+	if err != nil {
+		return err
+	}
+
+	plain := viper.New()
+	plain.SetConfigFile(plainPath)
+	if err := plain.ReadInConfig(); err != nil {
+		return errors.Wrap(err, "could not read settings to encrypt")
+	}
+
+	// They are sealed in the current format, with its version
+	settings := plain.AllSettings()
+	warnings, err := config.Migrate(settings)
+	if err != nil {
+		return errors.Wrap(err, "could not upgrade settings to encrypt")
+	}
+	for _, warning := range warnings {
+		log.WithField("file", plainPath).Infoln(warning)
+	}
+
+	blob, err := config.SealCredentials(settings, passphrase)
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(ioutil.WriteFile(path, blob, 0600), "could not write credentials file")
+}
+
+// replay feeds recorded events through the bridge, and prints what would have been relayed.
+func replay(conf *bridge.Config, path string) error {
+	if path == "" {
+		return errors.New("usage: go-discord-irc --config config.yml replay events.jsonl")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return bridge.Replay(conf, f, os.Stdout)
+}
+
+// saveConfig writes upgraded settings over the config file, keeping the old one as a backup.
+// The new file is written next to it first, so the config is never left half written.
+func saveConfig(settings map[string]interface{}, path string) error {
+	out := viper.New()
+	if err := out.MergeConfigMap(settings); err != nil {
+		return err
+	}
+
+	// Viper picks the format from the extension, so the temporary file keeps it
+	tmp := filepath.Join(filepath.Dir(path), ".migrating-"+filepath.Base(path))
+	if err := out.WriteConfigAs(tmp); err != nil {
+		os.Remove(tmp)
+		return errors.Wrap(err, "could not write upgraded config")
+	}
+
+	old, err := ioutil.ReadFile(path)
+	if err == nil {
+		err = ioutil.WriteFile(path+".bak", old, 0600)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return errors.Wrap(err, "could not back up config")
+	}
+	return errors.Wrap(os.Rename(tmp, path), "could not replace config")
 }
 
 func SetLogDebug(debug bool) {
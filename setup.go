@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	"github.com/qaisjp/go-discord-irc/bridge"
+	"github.com/qaisjp/go-discord-irc/config"
+	"github.com/spf13/viper"
+)
+
+// setupIRCTimeout is how long the IRC server has to say something when it is tested
+const setupIRCTimeout = 10 * time.Second
+
+// botPermissions are the permissions the bot is invited with
+const botPermissions = discordgo.PermissionViewChannel |
+	discordgo.PermissionSendMessages |
+	discordgo.PermissionManageMessages |
+	discordgo.PermissionEmbedLinks |
+	discordgo.PermissionReadMessageHistory |
+	discordgo.PermissionAddReactions |
+	discordgo.PermissionManageWebhooks |
+	discordgo.PermissionManageThreads |
+	discordgo.PermissionViewAuditLogs
+
+// prompter asks questions on a terminal.
+type prompter struct {
+	in  *bufio.Scanner
+	out io.Writer
+}
+
+// ask asks a question, returning the answer, or def if there isn't one.
+func (p *prompter) ask(question, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+
+	if !p.in.Scan() {
+		if err := p.in.Err(); err != nil {
+			return "", err
+		}
+		return "", io.ErrUnexpectedEOF
+	}
+	if answer := strings.TrimSpace(p.in.Text()); answer != "" {
+		return answer, nil
+	}
+	return def, nil
+}
+
+// yes asks a yes or no question.
+func (p *prompter) yes(question string, def bool) (bool, error) {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	answer, err := p.ask(question+" ("+hint+")", "")
+	if err != nil || answer == "" {
+		return def, err
+	}
+	return strings.HasPrefix(strings.ToLower(answer), "y"), nil
+}
+
+// setup walks through making a config file: adding the bot to a Discord server, picking the
+// channels to bridge, and checking the IRC server can be reached. It is run with
+// "go-discord-irc --config config.yml init".
+func setup(path string, in io.Reader, out io.Writer) error {
+	if filepath.Ext(path) == "" {
+		return errors.New("the config file needs an extension, like config.yml")
+	}
+	p := &prompter{in: bufio.NewScanner(in), out: out}
+
+	_, err := os.Stat(path)
+	exists := err == nil
+	if exists {
+		overwrite, err := p.yes(path+" already exists. Replace it? The old one is kept with .bak on the end", false)
+		if err != nil {
+			return err
+		}
+		if !overwrite {
+			return errors.New("not replacing the existing config")
+		}
+	}
+
+	settings := map[string]interface{}{"version": config.Version}
+
+	// Discord
+	fmt.Fprintln(out, "Make a Discord application with a bot at https://discord.com/developers/applications.")
+	fmt.Fprintln(out, "On its Bot tab, turn on the Presence, Server Members and Message Content intents.")
+	token, session, app, err := setupDiscordToken(p)
+	if err != nil {
+		return err
+	}
+	settings["discord_token"] = token
+
+	fmt.Fprintf(out, "\nAdd the bot to your server with this link:\nhttps://discord.com/oauth2/authorize?client_id=%s&scope=bot%%20applications.commands&permissions=%d\n\n", app.ID, botPermissions)
+	guild, err := setupGuild(p, session)
+	if err != nil {
+		return err
+	}
+	settings["guild_id"] = guild.ID
+
+	mappings, err := setupChannels(p, session, guild)
+	if err != nil {
+		return err
+	}
+	settings["channel_mappings"] = mappings
+
+	// IRC
+	server, noTLS, err := setupIRCServer(p)
+	if err != nil {
+		return err
+	}
+	settings["irc_server"] = server
+	settings["no_tls"] = noTLS
+
+	listener, err := p.ask("Nick of the bridge's IRC bot, which relays everything in simple mode", "discord")
+	if err != nil {
+		return err
+	}
+	settings["irc_listener_name"] = listener
+
+	webirc, err := p.ask("WEBIRC password, if the IRC network gave you one. Without it, run the bridge with --simple so that only the IRC bot connects", "")
+	if err != nil {
+		return err
+	}
+	if webirc != "" {
+		settings["webirc_password"] = webirc
+		suffix, err := p.ask("Suffix of the IRC nicks of Discord users", "~d")
+		if err != nil {
+			return err
+		}
+		settings["suffix"] = suffix
+	}
+
+	// Check it is a config the bridge understands
+	if _, err := config.Migrate(settings); err != nil {
+		return errors.Wrap(err, "the config is not valid")
+	}
+
+	if exists {
+		err = saveConfig(settings, path)
+	} else {
+		v := viper.New()
+		if err = v.MergeConfigMap(settings); err == nil {
+			err = v.WriteConfigAs(path)
+		}
+	}
+	if err != nil {
+		return errors.Wrap(err, "could not write config")
+	}
+
+	simple := ""
+	if webirc == "" {
+		simple = " --simple"
+	}
+	fmt.Fprintf(out, "\nWrote %s. Start the bridge with: go-discord-irc --config %s%s\n", path, path, simple)
+	fmt.Fprintln(out, "The token is in the file, so keep it private. See the README for the other settings.")
+	return nil
+}
+
+// setupDiscordToken asks for the bot token until Discord accepts one.
+func setupDiscordToken(p *prompter) (string, *discordgo.Session, *discordgo.Application, error) {
+	for {
+		token, err := p.ask("Bot token, from the Bot tab", "")
+		if err != nil {
+			return "", nil, nil, err
+		}
+		if token == "" {
+			continue
+		}
+
+		session, err := discordgo.New("Bot " + token)
+		if err != nil {
+			return "", nil, nil, errors.Wrap(err, "could not create discord session")
+		}
+		app, err := session.Application("@me")
+		if err == nil {
+			fmt.Fprintf(p.out, "That's the bot of %s.\n", app.Name)
+			return token, session, app, nil
+		}
+		fmt.Fprintf(p.out, "Could not check that token with Discord: %s\n", err)
+	}
+}
+
+// setupGuild asks which of the bot's servers to bridge.
+func setupGuild(p *prompter, session *discordgo.Session) (*discordgo.UserGuild, error) {
+	for {
+		if _, err := p.ask("Press enter once the bot is in your server", ""); err != nil {
+			return nil, err
+		}
+
+		guilds, err := session.UserGuilds(200, "", "", false)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not list the bot's servers")
+		}
+		if len(guilds) == 0 {
+			fmt.Fprintln(p.out, "The bot isn't in any servers yet.")
+			continue
+		}
+		if len(guilds) == 1 {
+			fmt.Fprintf(p.out, "Bridging %s.\n", guilds[0].Name)
+			return guilds[0], nil
+		}
+
+		for n, guild := range guilds {
+			fmt.Fprintf(p.out, "%d. %s\n", n+1, guild.Name)
+		}
+		for {
+			answer, err := p.ask("Which server should be bridged?", "1")
+			if err != nil {
+				return nil, err
+			}
+			if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(guilds) {
+				return guilds[n-1], nil
+			}
+		}
+	}
+}
+
+// setupChannels asks which IRC channel each of the server's text channels is bridged to.
+func setupChannels(p *prompter, session *discordgo.Session, guild *discordgo.UserGuild) (map[string]string, error) {
+	channels, err := session.GuildChannels(guild.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list the server's channels")
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i].Position < channels[j].Position })
+
+	fmt.Fprintln(p.out, "\nFor each channel, type the IRC channel to bridge it to, or leave it blank to not bridge it.")
+	for {
+		mappings := make(map[string]string)
+		for _, channel := range channels {
+			if channel.Type != discordgo.ChannelTypeGuildText && channel.Type != discordgo.ChannelTypeGuildNews {
+				continue
+			}
+
+			irc, err := p.ask("#"+channel.Name, "")
+			if err != nil {
+				return nil, err
+			}
+			if irc == "" {
+				continue
+			}
+			if !strings.HasPrefix(irc, "#") {
+				irc = "#" + irc
+			}
+			mappings[irc] = channel.ID
+		}
+
+		if len(mappings) == 0 {
+			fmt.Fprintln(p.out, "At least one channel needs to be bridged.")
+			continue
+		}
+		if err := bridge.CheckChannelMappings(mappings); err != nil {
+			fmt.Fprintf(p.out, "Those channels can't be bridged: %s\n", err)
+			continue
+		}
+		return mappings, nil
+	}
+}
+
+// setupIRCServer asks for the IRC server until it can be connected to, or the operator wants it anyway.
+func setupIRCServer(p *prompter) (server string, noTLS bool, err error) {
+	for {
+		server, err = p.ask("\nIRC server", "irc.libera.chat:6697")
+		if err != nil {
+			return "", false, err
+		}
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server += ":6697"
+		}
+
+		useTLS, err := p.yes("Connect with TLS?", true)
+		if err != nil {
+			return "", false, err
+		}
+
+		fmt.Fprintf(p.out, "Connecting to %s...\n", server)
+		greeting, err := testIRCServer(server, useTLS)
+		if err == nil {
+			fmt.Fprintf(p.out, "Connected. The server said: %s\n", greeting)
+			return server, !useTLS, nil
+		}
+
+		fmt.Fprintf(p.out, "Could not connect: %s\n", err)
+		anyway, err := p.yes("Use it anyway?", false)
+		if err != nil || anyway {
+			return server, !useTLS, err
+		}
+	}
+}
+
+// testIRCServer connects to an IRC server, and returns the first line it sends.
+// It pings the server, since some only say something once they have been sent something.
+func testIRCServer(server string, useTLS bool) (string, error) {
+	dialer := &net.Dialer{Timeout: setupIRCTimeout}
+
+	var conn net.Conn
+	var err error
+	if useTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", server, &tls.Config{})
+	} else {
+		conn, err = dialer.Dial("tcp", server)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(setupIRCTimeout)); err != nil {
+		return "", err
+	}
+	if _, err := io.WriteString(conn, "PING :go-discord-irc\r\n"); err != nil {
+		return "", err
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return "", errors.Wrap(err, "the server didn't say anything")
+	}
+	return strings.TrimSpace(line), nil
+}
@@ -0,0 +1,272 @@
+// Package store provides a small persistent key-value store for bridge state.
+//
+// Values are grouped into buckets and encoded as JSON. The whole store is
+// kept in memory and written to a single file after every change, which is
+// plenty for the amount of state a bridge keeps (identity links, subscriptions).
+//
+// The store remembers when each key was last written, so that old values can be expired.
+package store
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// updatedBucket is the reserved bucket the times keys were last written are saved in,
+// keyed by bucket name
+const updatedBucket = "_updated"
+
+// A Store is a persistent collection of buckets. It is safe for concurrent use.
+type Store struct {
+	mu   sync.Mutex
+	path string
+	size int // bytes written by the last save
+
+	buckets map[string]map[string]json.RawMessage
+	updated map[string]map[string]time.Time
+}
+
+// Open loads the store at the given path, creating it if it does not exist.
+//
+// If path is empty the store is kept in memory only.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload replaces the contents of the store with what is saved at its path,
+// for when another process may have written to it.
+func (s *Store) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load()
+}
+
+// load reads the store from its path. The store is left as it was if that fails.
+func (s *Store) load() error {
+	buckets := make(map[string]map[string]json.RawMessage)
+	updated := make(map[string]map[string]time.Time)
+
+	if s.path == "" {
+		s.buckets, s.updated = buckets, updated
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		s.buckets, s.updated = buckets, updated
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "could not read store")
+	}
+
+	if err := json.Unmarshal(data, &buckets); err != nil {
+		return errors.Wrap(err, "could not decode store")
+	}
+
+	for bucket, times := range buckets[updatedBucket] {
+		keys := make(map[string]time.Time)
+		if err := json.Unmarshal(times, &keys); err != nil {
+			return errors.Wrapf(err, "could not decode update times of %s", bucket)
+		}
+		updated[bucket] = keys
+	}
+	delete(buckets, updatedBucket)
+
+	s.buckets, s.updated = buckets, updated
+	s.size = len(data)
+
+	// Stores saved before update times were kept count as written now
+	now := time.Now()
+	for bucket, keys := range buckets {
+		for key := range keys {
+			if _, ok := s.updated[bucket][key]; !ok {
+				s.touch(bucket, key, now)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Get decodes the value for key in bucket into v.
+// Returns false if the key does not exist.
+func (s *Store) Get(bucket, key string, v interface{}) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.buckets[bucket][key]
+	if !ok {
+		return false, nil
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return true, errors.Wrapf(err, "could not decode %s/%s", bucket, key)
+	}
+	return true, nil
+}
+
+// Put sets the value for key in bucket, and saves the store.
+func (s *Store) Put(bucket, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrapf(err, "could not encode %s/%s", bucket, key)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.buckets[bucket]
+	if !ok {
+		b = make(map[string]json.RawMessage)
+		s.buckets[bucket] = b
+	}
+	b[key] = data
+	s.touch(bucket, key, time.Now())
+
+	return s.save()
+}
+
+// touch records when a key was written. The caller must hold the lock.
+func (s *Store) touch(bucket, key string, t time.Time) {
+	updated, ok := s.updated[bucket]
+	if !ok {
+		updated = make(map[string]time.Time)
+		s.updated[bucket] = updated
+	}
+	updated[key] = t
+}
+
+// Delete removes key from bucket, and saves the store.
+// Deleting a key that does not exist is not an error.
+func (s *Store) Delete(bucket, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.buckets[bucket][key]; !ok {
+		return nil
+	}
+	delete(s.buckets[bucket], key)
+	delete(s.updated[bucket], key)
+
+	return s.save()
+}
+
+// Expire removes the keys in bucket that were last written more than ttl ago,
+// and saves the store. It returns how many keys were removed.
+func (s *Store) Expire(bucket string, ttl time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expired := 0
+	for key, t := range s.updated[bucket] {
+		if time.Since(t) > ttl {
+			delete(s.buckets[bucket], key)
+			delete(s.updated[bucket], key)
+			expired++
+		}
+	}
+	if expired == 0 {
+		return 0, nil
+	}
+
+	return expired, s.save()
+}
+
+// Buckets returns the names of the buckets in the store, in sorted order.
+func (s *Store) Buckets() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, 0, len(s.buckets))
+	for name := range s.buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Len returns the number of keys in a bucket.
+func (s *Store) Len(bucket string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.buckets[bucket])
+}
+
+// Size returns the size of the store on disk, in bytes, when it was last saved.
+// It is 0 for stores kept in memory.
+func (s *Store) Size() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// Keys returns the keys in a bucket, in sorted order.
+func (s *Store) Keys(bucket string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, 0, len(s.buckets[bucket]))
+	for key := range s.buckets[bucket] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// save writes the store to disk. The caller must hold the lock.
+//
+// The store is written to a temporary file first so that
+// a crash mid-write does not corrupt the existing store.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	buckets := make(map[string]map[string]json.RawMessage, len(s.buckets)+1)
+	for name, b := range s.buckets {
+		buckets[name] = b
+	}
+	buckets[updatedBucket] = make(map[string]json.RawMessage, len(s.updated))
+	for name, updated := range s.updated {
+		times, err := json.Marshal(updated)
+		if err != nil {
+			return errors.Wrap(err, "could not encode update times")
+		}
+		buckets[updatedBucket][name] = times
+	}
+
+	data, err := json.Marshal(buckets)
+	if err != nil {
+		return errors.Wrap(err, "could not encode store")
+	}
+	s.size = len(data)
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "could not create temporary store file")
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "could not write store")
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "could not write store")
+	}
+
+	return errors.Wrap(os.Rename(tmp.Name(), s.path), "could not replace store")
+}
@@ -0,0 +1,96 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type value struct {
+	Name  string
+	Count int
+}
+
+func TestStorePersists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "store.json")
+
+	s, err := Open(path)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Put("things", "b", value{"bee", 2}))
+	assert.NoError(t, s.Put("things", "a", value{"ay", 1}))
+
+	s, err = Open(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, s.Keys("things"))
+
+	var v value
+	ok, err := s.Get("things", "b", &v)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	assert.Equal(t, value{"bee", 2}, v)
+
+	assert.NoError(t, s.Delete("things", "b"))
+	ok, _ = s.Get("things", "b", &v)
+	assert.False(t, ok)
+}
+
+func TestStoreInMemory(t *testing.T) {
+	s, err := Open("")
+	assert.NoError(t, err)
+	assert.NoError(t, s.Put("things", "a", 1))
+	assert.Equal(t, []string{"a"}, s.Keys("things"))
+	assert.Empty(t, s.Keys("missing"))
+}
+
+func TestStoreExpire(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "store.json")
+
+	s, err := Open(path)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Put("things", "old", 1))
+	s.updated["things"]["old"] = time.Now().Add(-time.Hour)
+	assert.NoError(t, s.Put("things", "new", 2))
+
+	// Update times are kept across restarts
+	s, err = Open(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"things"}, s.Buckets())
+	assert.NotZero(t, s.Size())
+
+	expired, err := s.Expire("things", time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, expired)
+	assert.Equal(t, []string{"new"}, s.Keys("things"))
+	assert.Equal(t, 1, s.Len("things"))
+}
+
+func TestStoreReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "store.json")
+
+	s, err := Open(path)
+	assert.NoError(t, err)
+	other, err := Open(path)
+	assert.NoError(t, err)
+
+	assert.NoError(t, other.Put("things", "a", value{"ay", 1}))
+	assert.Empty(t, s.Keys("things"))
+
+	assert.NoError(t, s.Reload())
+	assert.Equal(t, []string{"a"}, s.Keys("things"))
+}
@@ -8,6 +8,7 @@ package transmitter
 
 import (
 	"strings"
+	"sync"
 
 	"github.com/hashicorp/go-multierror"
 
@@ -21,6 +22,9 @@ type Transmitter struct {
 	guild   string
 	prefix  string
 
+	// mu guards webhook. It is held for a whole send, because the shared
+	// webhook is moved to the message's channel before it is executed.
+	mu      sync.Mutex
 	webhook *discordgo.Webhook
 }
 
@@ -63,6 +67,9 @@ func New(session *discordgo.Session, guild string, prefix string, limit int) (*T
 func (t *Transmitter) Close() error {
 	var result error
 
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
 	// Delete all the webhooks
 	if wh := t.webhook; wh != nil {
 		err := t.session.WebhookDelete(wh.ID)
@@ -74,15 +81,23 @@ func (t *Transmitter) Close() error {
 	return result
 }
 
-// Message transmits a message to the given channel with the given username, avatarURL, and content.
+// Message transmits a message to the given channel with the given username, avatarURL, content and optional embeds.
 //
-// Note that this function will wait until Discord responds with an answer.
-func (t *Transmitter) Message(channel string, username string, avatarURL string, content string) (err error) {
+// Note that this function will wait until Discord responds with an answer,
+// and returns the message that was created.
+func (t *Transmitter) Message(channel string, username string, avatarURL string, content string, embeds ...*discordgo.MessageEmbed) (msg *discordgo.Message, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.message(channel, username, avatarURL, content, embeds...)
+}
+
+// message is Message for callers holding t.mu.
+func (t *Transmitter) message(channel string, username string, avatarURL string, content string, embeds ...*discordgo.MessageEmbed) (msg *discordgo.Message, err error) {
 	// Create a webhook if there is no free webhook
 	if t.webhook == nil {
 		err = t.createWebhook(channel)
 		if err != nil {
-			return err // this error is already wrapped by us
+			return nil, err // this error is already wrapped by us
 		}
 	}
 
@@ -90,6 +105,7 @@ func (t *Transmitter) Message(channel string, username string, avatarURL string,
 		Username:  username,
 		AvatarURL: avatarURL,
 		Content:   content,
+		Embeds:    embeds,
 	}
 
 	wh := t.webhook
@@ -106,22 +122,50 @@ func (t *Transmitter) Message(channel string, username string, avatarURL string,
 		// If the webhook exists OR there was an error performing the check
 		// return the error to the caller
 		if exists || checkErr != nil {
-			return errors.Wrap(err, "could not edit existing webhook")
+			return nil, errors.Wrap(err, "could not edit existing webhook")
 		}
 
 		// Otherwise just try and send the message again
-		return t.Message(channel, username, avatarURL, content)
+		return t.message(channel, username, avatarURL, content, embeds...)
+	}
+
+	msg, err = t.session.WebhookExecute(wh.ID, wh.Token, true, &params)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not execute existing webhook")
+	}
+
+	return msg, nil
+}
+
+// Edit changes the content of a message previously sent by the given webhook.
+//
+// Messages sent by webhooks that have since been deleted can't be edited.
+func (t *Transmitter) Edit(webhookID string, messageID string, content string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	wh := t.webhook
+	if wh == nil || wh.ID != webhookID {
+		return errors.New("the webhook that sent this message no longer exists")
 	}
 
-	_, err = t.session.WebhookExecute(wh.ID, wh.Token, true, &params)
+	_, err := t.session.WebhookMessageEdit(wh.ID, wh.Token, messageID, &discordgo.WebhookEdit{
+		Content: &content,
+	})
 	if err != nil {
-		return errors.Wrap(err, "could not execute existing webhook")
+		return errors.Wrap(err, "could not edit webhook message")
 	}
 
 	return nil
 }
 
 func (t *Transmitter) GetID() string {
+	if t == nil {
+		return ""
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	if t.webhook == nil {
 		return ""
 	}
@@ -9,7 +9,7 @@ import (
 
 var webhookExpiry = time.Second * 30
 
-// createWebhook creates a webhook for a specific channel.
+// createWebhook creates a webhook for a specific channel. The caller must hold t.mu.
 func (t *Transmitter) createWebhook(channel string) error {
 	wh, err := t.session.WebhookCreate(channel, t.prefix+time.Now().Format(" 3:04:05PM"), "")
 
@@ -28,6 +28,7 @@ func (t *Transmitter) createWebhook(channel string) error {
 // 		- false is returned if Discord doesn't know.
 //		- true is returned if Discord does know it exists
 // If Discord returns an error, this function will return an error for the second argument.
+// The caller must hold t.mu.
 func (t *Transmitter) checkAndDeleteWebhook(channel string) (bool, error) {
 	wh := t.webhook
 